@@ -36,7 +36,7 @@ func AuthMiddleware(authFn AuthFunc) MiddlewareFunc {
 				return next(ctx, req)
 			}
 
-			level := authLevelFromEndpoint(ep)
+			level := ep.AuthLevel()
 			if level == AuthNone || level == "" {
 				return next(ctx, req)
 			}
@@ -60,17 +60,35 @@ func IdentityFromContext(ctx context.Context) (string, bool) {
 	return v, ok
 }
 
+// BearerTokenFromContext returns the bearer token carried in the incoming
+// "authorization" metadata, as sent by any transport.
+func BearerTokenFromContext(ctx context.Context) (string, bool) {
+	md, ok := FromIncomingContext(ctx)
+	if !ok {
+		return "", false
+	}
+	auth := md.Get("authorization")
+	if len(auth) < len("bearer ") || !strings.EqualFold(auth[:len("bearer ")], "bearer ") {
+		return "", false
+	}
+	token := strings.TrimSpace(auth[len("bearer "):])
+	return token, token != ""
+}
+
 // AuthLevelFromContext returns the required auth level from context.
 func AuthLevelFromContext(ctx context.Context) AuthLevel {
 	v, _ := ctx.Value(ctxKeyAuthLevel).(AuthLevel)
 	return v
 }
 
-func authLevelFromEndpoint(ep *Endpoint) AuthLevel {
-	if ep.Metadata == nil {
+// AuthLevel returns the authentication the endpoint requires, set by its
+// "auth" metadata, e.g. with the annotation "@talk auth=token". It is empty
+// if the endpoint has none.
+func (e *Endpoint) AuthLevel() AuthLevel {
+	if e.Metadata == nil {
 		return ""
 	}
-	v, ok := ep.Metadata["auth"]
+	v, ok := e.Metadata["auth"]
 	if !ok {
 		return ""
 	}
@@ -90,6 +108,10 @@ const (
 	ctxKeyIdentity ctxKey = iota
 	ctxKeyAuthLevel
 	ctxKeyEndpoint
+	ctxKeyOutgoingMetadata
+	ctxKeyIncomingMetadata
+	ctxKeyPeer
+	ctxKeySpan
 )
 
 // WithEndpointContext returns a new context carrying the endpoint.
@@ -203,6 +203,32 @@ func TestIdentityFromContext_NotSet(t *testing.T) {
 	}
 }
 
+func TestBearerTokenFromContext(t *testing.T) {
+	tests := []struct {
+		auth  string
+		token string
+		ok    bool
+	}{
+		{"Bearer abc", "abc", true},
+		{"bearer  abc ", "abc", true},
+		{"Basic dXNlcg==", "", false},
+		{"Bearer ", "", false},
+		{"", "", false},
+	}
+
+	for _, tt := range tests {
+		ctx := NewIncomingContext(context.Background(), MetadataPairs("authorization", tt.auth))
+		token, ok := BearerTokenFromContext(ctx)
+		if token != tt.token || ok != tt.ok {
+			t.Errorf("BearerTokenFromContext(%q) = %q, %v, want %q, %v", tt.auth, token, ok, tt.token, tt.ok)
+		}
+	}
+
+	if _, ok := BearerTokenFromContext(context.Background()); ok {
+		t.Error("expected ok=false without incoming metadata")
+	}
+}
+
 func TestAuthLevelFromContext_NotSet(t *testing.T) {
 	level := AuthLevelFromContext(context.Background())
 	if level != "" {
@@ -210,6 +236,24 @@ func TestAuthLevelFromContext_NotSet(t *testing.T) {
 	}
 }
 
+func TestEndpoint_AuthLevel(t *testing.T) {
+	tests := []struct {
+		metadata map[string]any
+		want     AuthLevel
+	}{
+		{nil, ""},
+		{map[string]any{"auth": "Admin"}, AuthAdmin},
+		{map[string]any{"auth": AuthToken}, AuthToken},
+		{map[string]any{"auth": true}, ""},
+	}
+	for _, tt := range tests {
+		ep := &Endpoint{Metadata: tt.metadata}
+		if got := ep.AuthLevel(); got != tt.want {
+			t.Errorf("AuthLevel() with %v = %q, want %q", tt.metadata, got, tt.want)
+		}
+	}
+}
+
 func TestParseAnnotation_Auth(t *testing.T) {
 	ann := parseAnnotation("@talk path=/admin method=POST auth=admin")
 	if ann == nil {
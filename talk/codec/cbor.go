@@ -0,0 +1,54 @@
+package codec
+
+import (
+	"io"
+	"reflect"
+
+	"github.com/fxamacker/cbor/v2"
+
+	"go.zoe.im/x"
+)
+
+const (
+	cborName        = "cbor"
+	cborContentType = "application/cbor"
+)
+
+// cborDecMode decodes maps into map[string]any, as the json codec does.
+var cborDecMode, _ = cbor.DecOptions{
+	DefaultMapType: reflect.TypeOf(map[string]any(nil)),
+}.DecMode()
+
+// cborCodec encodes CBOR (RFC 8949). Struct fields without a cbor tag are
+// named by their json tags.
+type cborCodec struct{}
+
+func (c *cborCodec) Name() string {
+	return cborName
+}
+
+func (c *cborCodec) ContentType() string {
+	return cborContentType
+}
+
+func (c *cborCodec) Marshal(v any) ([]byte, error) {
+	return cbor.Marshal(v)
+}
+
+func (c *cborCodec) Unmarshal(data []byte, v any) error {
+	return cborDecMode.Unmarshal(data, v)
+}
+
+func (c *cborCodec) NewEncoder(w io.Writer) Encoder {
+	return cbor.NewEncoder(w)
+}
+
+func (c *cborCodec) NewDecoder(r io.Reader) Decoder {
+	return cborDecMode.NewDecoder(r)
+}
+
+func init() {
+	Factory.Register(cborName, func(cfg x.TypedLazyConfig, opts ...CodecOption) (Codec, error) {
+		return &cborCodec{}, nil
+	}, cborContentType)
+}
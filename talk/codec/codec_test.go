@@ -1,7 +1,11 @@
 package codec
 
 import (
+	"bytes"
+	"io"
 	"testing"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
 )
 
 type testData struct {
@@ -69,3 +73,172 @@ func TestGet_Unknown(t *testing.T) {
 		t.Error("Get should return error for unknown codec")
 	}
 }
+
+func TestBinaryCodecs_MarshalUnmarshal(t *testing.T) {
+	for _, name := range []string{"msgpack", "cbor"} {
+		c := MustGet(name)
+		original := &testData{Name: "test", Value: 42}
+		data, err := c.Marshal(original)
+		if err != nil {
+			t.Fatalf("%s: Marshal failed: %v", name, err)
+		}
+
+		var decoded testData
+		if err := c.Unmarshal(data, &decoded); err != nil {
+			t.Fatalf("%s: Unmarshal failed: %v", name, err)
+		}
+		if decoded != *original {
+			t.Errorf("%s: decoded = %+v, want %+v", name, decoded, original)
+		}
+
+		// Field names follow the json tags.
+		var generic map[string]any
+		if err := c.Unmarshal(data, &generic); err != nil {
+			t.Fatalf("%s: Unmarshal into map failed: %v", name, err)
+		}
+		if generic["name"] != "test" {
+			t.Errorf("%s: generic = %v, want json field names", name, generic)
+		}
+	}
+}
+
+func TestProtoCodec(t *testing.T) {
+	c := MustGet("proto")
+	data, err := c.Marshal(wrapperspb.String("hello"))
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	var decoded *wrapperspb.StringValue
+	if err := c.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if decoded.GetValue() != "hello" {
+		t.Errorf("decoded = %v", decoded)
+	}
+
+	if _, err := c.Marshal(&testData{}); err == nil {
+		t.Error("Marshal should fail for non-proto values")
+	}
+}
+
+func TestForContentType(t *testing.T) {
+	tests := map[string]string{
+		"application/json; charset=utf-8": "json",
+		"application/msgpack":             "msgpack",
+		"application/x-msgpack":           "msgpack",
+		"application/cbor":                "cbor",
+		"application/x-protobuf":          "proto",
+	}
+	for contentType, want := range tests {
+		c, err := ForContentType(contentType)
+		if err != nil || c.Name() != want {
+			t.Errorf("ForContentType(%q) = %v, %v, want %s", contentType, c, err, want)
+		}
+	}
+	if _, err := ForContentType("text/plain"); err == nil {
+		t.Error("ForContentType should fail for unregistered types")
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	fallback := MustGet("json")
+	tests := map[string]string{
+		"":                            "json",
+		"*/*":                         "json",
+		"application/msgpack":         "msgpack",
+		"text/html, application/cbor": "cbor",
+		"application/json;q=0.5, application/msgpack": "msgpack",
+		"application/msgpack;q=0, */*;q=0.1":          "json",
+		"text/html":                                   "json",
+	}
+	for accept, want := range tests {
+		if got := Negotiate(accept, fallback); got.Name() != want {
+			t.Errorf("Negotiate(%q) = %s, want %s", accept, got.Name(), want)
+		}
+	}
+}
+
+func TestEncodeDecode_Stream(t *testing.T) {
+	for _, name := range []string{"json", "msgpack", "cbor", "proto"} {
+		c := MustGet(name)
+		_, streaming := c.(StreamCodec)
+		if streaming == (name == "proto") {
+			t.Errorf("%s: StreamCodec = %v", name, streaming)
+		}
+		if name == "proto" {
+			continue
+		}
+
+		var buf bytes.Buffer
+		if err := Encode(c, &buf, &testData{Name: "a", Value: 1}); err != nil {
+			t.Fatalf("%s: Encode failed: %v", name, err)
+		}
+		var decoded testData
+		if err := Decode(c, &buf, &decoded); err != nil || decoded.Name != "a" {
+			t.Errorf("%s: Decode = %+v, %v", name, decoded, err)
+		}
+		if err := Decode(c, &buf, &decoded); err != io.EOF {
+			t.Errorf("%s: Decode of empty stream = %v, want io.EOF", name, err)
+		}
+	}
+
+	// Codecs without streaming support go through Marshal/Unmarshal.
+	proto := MustGet("proto")
+	var buf bytes.Buffer
+	if err := Encode(proto, &buf, wrapperspb.String("hello")); err != nil {
+		t.Fatal(err)
+	}
+	var decoded wrapperspb.StringValue
+	if err := Decode(proto, &buf, &decoded); err != nil || decoded.GetValue() != "hello" {
+		t.Errorf("Decode = %v, %v", decoded.GetValue(), err)
+	}
+}
+
+func TestCompressors(t *testing.T) {
+	payload := bytes.Repeat([]byte("talk compresses payloads "), 100)
+	for _, name := range []string{"gzip", "zstd", "snappy"} {
+		c, err := GetCompressor(name)
+		if err != nil {
+			t.Fatalf("GetCompressor(%s) failed: %v", name, err)
+		}
+		if c.Name() != name {
+			t.Errorf("Name() = %q, want %q", c.Name(), name)
+		}
+
+		compressed, err := Compress(c, payload)
+		if err != nil {
+			t.Fatalf("%s: Compress failed: %v", name, err)
+		}
+		if len(compressed) >= len(payload) {
+			t.Errorf("%s: compressed %d bytes to %d", name, len(payload), len(compressed))
+		}
+		decompressed, err := Decompress(c, compressed)
+		if err != nil || !bytes.Equal(decompressed, payload) {
+			t.Errorf("%s: Decompress = %d bytes, %v", name, len(decompressed), err)
+		}
+		if _, err := DecompressLimit(c, compressed, len(payload)-1); err != ErrMessageTooLarge {
+			t.Errorf("%s: DecompressLimit below the payload size = %v, want ErrMessageTooLarge", name, err)
+		}
+	}
+}
+
+func TestAcceptsEncoding(t *testing.T) {
+	tests := []struct {
+		header, name string
+		want         bool
+	}{
+		{"gzip, deflate, br", "gzip", true},
+		{"deflate", "gzip", false},
+		{"", "gzip", false},
+		{"*", "zstd", true},
+		{"zstd;q=0, *", "zstd", false},
+		{"*;q=0, gzip", "gzip", true},
+		{"gzip;q=0.5", "gzip", true},
+	}
+	for _, tt := range tests {
+		if got := AcceptsEncoding(tt.header, tt.name); got != tt.want {
+			t.Errorf("AcceptsEncoding(%q, %q) = %v, want %v", tt.header, tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package codec
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"strconv"
+	"strings"
+
+	"go.zoe.im/x"
+	"go.zoe.im/x/factory"
+)
+
+// DefaultCompressionMinSize is the payload size, in bytes, below which
+// transports send payloads uncompressed unless configured otherwise:
+// compressing tiny messages costs more than it saves.
+const DefaultCompressionMinSize = 1024
+
+// DefaultCompressionMaxSize is the largest payload, in bytes, that
+// compressed payloads may expand to unless configured otherwise.
+const DefaultCompressionMaxSize = 32 << 20
+
+// ErrMessageTooLarge is returned when a payload decompresses to more than
+// the allowed size.
+var ErrMessageTooLarge = errors.New("codec: decompressed message too large")
+
+// Compressor compresses and decompresses payloads. Name is the token used
+// in Content-Encoding headers and by the gRPC encoding registry, whose
+// Compressor interface this matches.
+type Compressor interface {
+	Name() string
+	Compress(w io.Writer) (io.WriteCloser, error)
+	Decompress(r io.Reader) (io.Reader, error)
+}
+
+// CompressorOption configures compressor creation.
+type CompressorOption func(any)
+
+// CompressorFactory creates Compressor instances from configuration.
+var CompressorFactory = factory.NewFactory[Compressor, CompressorOption]()
+
+// GetCompressor returns a compressor by name using an empty config.
+func GetCompressor(name string) (Compressor, error) {
+	return CompressorFactory.Create(x.TypedLazyConfig{Type: name})
+}
+
+// CompressionConfig is embedded in transport configs to compress payloads,
+// e.g. {"compression": "gzip", "compression_min_size": 512}.
+type CompressionConfig struct {
+	// Compression names the compressor to use; empty disables compression.
+	Compression string `json:"compression,omitempty" yaml:"compression"`
+	// CompressionMinSize is the smallest payload, in bytes, that gets
+	// compressed. Zero means DefaultCompressionMinSize.
+	CompressionMinSize int `json:"compression_min_size,omitempty" yaml:"compression_min_size"`
+	// CompressionMaxSize is the largest size, in bytes, a received payload
+	// may decompress to. Zero means DefaultCompressionMaxSize.
+	CompressionMaxSize int `json:"compression_max_size,omitempty" yaml:"compression_max_size"`
+}
+
+// Compressor returns the configured compressor, or nil if compression is
+// disabled.
+func (c CompressionConfig) Compressor() (Compressor, error) {
+	if c.Compression == "" {
+		return nil, nil
+	}
+	return GetCompressor(c.Compression)
+}
+
+// MinSize returns the smallest payload size that gets compressed.
+func (c CompressionConfig) MinSize() int {
+	if c.CompressionMinSize > 0 {
+		return c.CompressionMinSize
+	}
+	return DefaultCompressionMinSize
+}
+
+// MaxSize returns the largest size a received payload may decompress to.
+func (c CompressionConfig) MaxSize() int {
+	if c.CompressionMaxSize > 0 {
+		return c.CompressionMaxSize
+	}
+	return DefaultCompressionMaxSize
+}
+
+// Compress compresses data with c.
+func Compress(c Compressor, data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := c.Compress(&buf)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decompress decompresses data with c, up to DefaultCompressionMaxSize
+// bytes.
+func Decompress(c Compressor, data []byte) ([]byte, error) {
+	return DecompressLimit(c, data, DefaultCompressionMaxSize)
+}
+
+// DecompressLimit decompresses data with c. It fails with
+// ErrMessageTooLarge if data expands to more than limit bytes.
+func DecompressLimit(c Compressor, data []byte, limit int) ([]byte, error) {
+	r, err := c.Decompress(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if closer, ok := r.(io.Closer); ok {
+		defer closer.Close()
+	}
+	out, err := io.ReadAll(io.LimitReader(r, int64(limit)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > limit {
+		return nil, ErrMessageTooLarge
+	}
+	return out, nil
+}
+
+// DecompressReader returns r decompressed with the compressor named by a
+// Content-Encoding value. Empty and "identity" encodings return r as is.
+func DecompressReader(encoding string, r io.Reader) (io.Reader, error) {
+	if encoding == "" || encoding == "identity" {
+		return r, nil
+	}
+	c, err := GetCompressor(encoding)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+	}
+	return c.Decompress(r)
+}
+
+// AcceptsEncoding reports whether an Accept-Encoding header value allows
+// the named content coding, honoring "*" and q=0.
+func AcceptsEncoding(acceptEncoding, name string) bool {
+	accepted := false
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil || (coding != name && coding != "*") {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if coding == name {
+			// An explicit entry wins over "*".
+			return q > 0
+		}
+		accepted = q > 0
+	}
+	return accepted
+}
+
+const gzipName = "gzip"
+
+type gzipCompressor struct{}
+
+func (c *gzipCompressor) Name() string {
+	return gzipName
+}
+
+func (c *gzipCompressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriter(w), nil
+}
+
+func (c *gzipCompressor) Decompress(r io.Reader) (io.Reader, error) {
+	return gzip.NewReader(r)
+}
+
+func init() {
+	CompressorFactory.Register(gzipName, func(cfg x.TypedLazyConfig, opts ...CompressorOption) (Compressor, error) {
+		return &gzipCompressor{}, nil
+	})
+}
@@ -2,6 +2,7 @@ package codec
 
 import (
 	"encoding/json"
+	"io"
 
 	"go.zoe.im/x"
 )
@@ -29,8 +30,16 @@ func (c *jsonCodec) Unmarshal(data []byte, v any) error {
 	return json.Unmarshal(data, v)
 }
 
+func (c *jsonCodec) NewEncoder(w io.Writer) Encoder {
+	return json.NewEncoder(w)
+}
+
+func (c *jsonCodec) NewDecoder(r io.Reader) Decoder {
+	return json.NewDecoder(r)
+}
+
 func init() {
 	Factory.Register(jsonName, func(cfg x.TypedLazyConfig, opts ...CodecOption) (Codec, error) {
 		return &jsonCodec{}, nil
-	})
+	}, jsonContentType)
 }
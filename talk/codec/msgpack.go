@@ -0,0 +1,57 @@
+package codec
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/vmihailenco/msgpack/v5"
+
+	"go.zoe.im/x"
+)
+
+const (
+	msgpackName        = "msgpack"
+	msgpackContentType = "application/msgpack"
+)
+
+// msgpackCodec encodes MessagePack. Struct fields are named by their json
+// tags, so the same types work with the json codec.
+type msgpackCodec struct{}
+
+func (c *msgpackCodec) Name() string {
+	return msgpackName
+}
+
+func (c *msgpackCodec) ContentType() string {
+	return msgpackContentType
+}
+
+func (c *msgpackCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := c.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *msgpackCodec) Unmarshal(data []byte, v any) error {
+	return c.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+func (c *msgpackCodec) NewEncoder(w io.Writer) Encoder {
+	enc := msgpack.NewEncoder(w)
+	enc.SetCustomStructTag("json")
+	return enc
+}
+
+func (c *msgpackCodec) NewDecoder(r io.Reader) Decoder {
+	dec := msgpack.NewDecoder(r)
+	dec.SetCustomStructTag("json")
+	return dec
+}
+
+func init() {
+	Factory.Register(msgpackName, func(cfg x.TypedLazyConfig, opts ...CodecOption) (Codec, error) {
+		return &msgpackCodec{}, nil
+	}, msgpackContentType, "application/x-msgpack")
+}
@@ -0,0 +1,81 @@
+package codec
+
+import (
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ForContentType returns the codec registered for the media type of a
+// Content-Type header value, e.g. "application/msgpack" or
+// "application/json; charset=utf-8". Codecs register their content types
+// as factory aliases.
+func ForContentType(contentType string) (Codec, error) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, err
+	}
+	return Get(mediaType)
+}
+
+// FromContentType is like ForContentType but returns fallback if
+// contentType is empty or names no registered codec.
+func FromContentType(contentType string, fallback Codec) Codec {
+	if contentType == "" {
+		return fallback
+	}
+	if c, err := ForContentType(contentType); err == nil {
+		return c
+	}
+	return fallback
+}
+
+// Negotiate picks the codec for a response from an Accept header value,
+// honoring q-values. It returns fallback if accept is empty, accepts any
+// type first, or names no registered codec.
+func Negotiate(accept string, fallback Codec) Codec {
+	for _, mediaType := range parseAccept(accept) {
+		if mediaType == "*/*" || mediaType == fallback.ContentType() {
+			return fallback
+		}
+		if c, err := Get(mediaType); err == nil {
+			return c
+		}
+	}
+	return fallback
+}
+
+// parseAccept returns the media types of an Accept header value, most
+// preferred first, leaving out those with q=0.
+func parseAccept(accept string) []string {
+	type entry struct {
+		mediaType string
+		q         float64
+	}
+	var entries []entry
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q > 0 {
+			entries = append(entries, entry{mediaType, q})
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].q > entries[j].q
+	})
+
+	types := make([]string, len(entries))
+	for i, e := range entries {
+		types[i] = e.mediaType
+	}
+	return types
+}
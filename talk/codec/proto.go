@@ -0,0 +1,57 @@
+package codec
+
+import (
+	"fmt"
+	"reflect"
+
+	"google.golang.org/protobuf/proto"
+
+	"go.zoe.im/x"
+)
+
+const (
+	protoName        = "proto"
+	protoContentType = "application/x-protobuf"
+)
+
+// protoCodec encodes protobuf messages. Values must implement proto.Message.
+type protoCodec struct{}
+
+func (c *protoCodec) Name() string {
+	return protoName
+}
+
+func (c *protoCodec) ContentType() string {
+	return protoContentType
+}
+
+func (c *protoCodec) Marshal(v any) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("codec/proto: %T does not implement proto.Message", v)
+	}
+	return proto.Marshal(m)
+}
+
+func (c *protoCodec) Unmarshal(data []byte, v any) error {
+	if m, ok := v.(proto.Message); ok {
+		return proto.Unmarshal(data, m)
+	}
+	// Allocate the message behind a **T, as json.Unmarshal would.
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr && rv.Elem().Kind() == reflect.Ptr {
+		if rv.Elem().IsNil() {
+			rv.Elem().Set(reflect.New(rv.Elem().Type().Elem()))
+		}
+		if m, ok := rv.Elem().Interface().(proto.Message); ok {
+			return proto.Unmarshal(data, m)
+		}
+	}
+	return fmt.Errorf("codec/proto: %T does not implement proto.Message", v)
+}
+
+func init() {
+	Factory.Register(protoName, func(cfg x.TypedLazyConfig, opts ...CodecOption) (Codec, error) {
+		return &protoCodec{}, nil
+	}, protoContentType, "application/protobuf")
+}
@@ -0,0 +1,32 @@
+package codec
+
+import (
+	"io"
+
+	"github.com/klauspost/compress/s2"
+
+	"go.zoe.im/x"
+)
+
+const snappyName = "snappy"
+
+// snappyCompressor compresses with the Snappy framing format.
+type snappyCompressor struct{}
+
+func (c *snappyCompressor) Name() string {
+	return snappyName
+}
+
+func (c *snappyCompressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	return s2.NewWriter(w, s2.WriterSnappyCompat(), s2.WriterConcurrency(1)), nil
+}
+
+func (c *snappyCompressor) Decompress(r io.Reader) (io.Reader, error) {
+	return s2.NewReader(r), nil
+}
+
+func init() {
+	CompressorFactory.Register(snappyName, func(cfg x.TypedLazyConfig, opts ...CompressorOption) (Compressor, error) {
+		return &snappyCompressor{}, nil
+	})
+}
@@ -0,0 +1,53 @@
+package codec
+
+import (
+	"io"
+)
+
+// Encoder writes encoded values to a stream.
+type Encoder interface {
+	Encode(v any) error
+}
+
+// Decoder reads encoded values from a stream.
+type Decoder interface {
+	Decode(v any) error
+}
+
+// StreamCodec is implemented by codecs that can encode to and decode from
+// streams directly, so that large payloads need not be buffered whole.
+// Transports use it when the codec provides it.
+type StreamCodec interface {
+	Codec
+	NewEncoder(w io.Writer) Encoder
+	NewDecoder(r io.Reader) Decoder
+}
+
+// Encode writes v to w, through an Encoder if c is a StreamCodec.
+func Encode(c Codec, w io.Writer, v any) error {
+	if sc, ok := c.(StreamCodec); ok {
+		return sc.NewEncoder(w).Encode(v)
+	}
+	data, err := c.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// Decode reads a single value from r into v, through a Decoder if c is a
+// StreamCodec. It returns io.EOF if r is empty.
+func Decode(c Codec, r io.Reader, v any) error {
+	if sc, ok := c.(StreamCodec); ok {
+		return sc.NewDecoder(r).Decode(v)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return io.EOF
+	}
+	return c.Unmarshal(data, v)
+}
@@ -0,0 +1,37 @@
+package codec
+
+import (
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+
+	"go.zoe.im/x"
+)
+
+const zstdName = "zstd"
+
+// zstdCompressor compresses with Zstandard. Encoders and decoders run
+// synchronously so that no goroutines outlive a payload.
+type zstdCompressor struct{}
+
+func (c *zstdCompressor) Name() string {
+	return zstdName
+}
+
+func (c *zstdCompressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+}
+
+func (c *zstdCompressor) Decompress(r io.Reader) (io.Reader, error) {
+	dec, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	return dec.IOReadCloser(), nil
+}
+
+func init() {
+	CompressorFactory.Register(zstdName, func(cfg x.TypedLazyConfig, opts ...CompressorOption) (Compressor, error) {
+		return &zstdCompressor{}, nil
+	})
+}
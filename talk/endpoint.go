@@ -42,6 +42,10 @@ type StreamEndpointFunc func(ctx context.Context, request any, stream Stream) er
 // It receives the next handler and returns a wrapped handler.
 type MiddlewareFunc func(next EndpointFunc) EndpointFunc
 
+// StreamMiddlewareFunc wraps a StreamEndpointFunc, the streaming counterpart
+// of MiddlewareFunc.
+type StreamMiddlewareFunc func(next StreamEndpointFunc) StreamEndpointFunc
+
 // Endpoint represents a service endpoint with its routing and handler information.
 type Endpoint struct {
 	Name          string             // Method name (e.g., "GetUser")
@@ -52,6 +56,8 @@ type Endpoint struct {
 	StreamMode    StreamMode         // Streaming behavior
 	Middleware    []MiddlewareFunc   // Middleware chain applied to Handler
 
+	StreamMiddleware []StreamMiddlewareFunc // Middleware chain applied to StreamHandler
+
 	// Type information for request/response
 	RequestType  reflect.Type
 	ResponseType reflect.Type
@@ -64,6 +70,23 @@ func (e *Endpoint) IsStreaming() bool {
 	return e.StreamMode != StreamNone
 }
 
+// IdempotentMetadataKey marks in Endpoint.Metadata, with true or "true",
+// the endpoints that can safely be called twice with the same request,
+// e.g. with the annotation "@talk idempotent=true". Clients may re-send
+// calls to them whose outcome is unknown.
+const IdempotentMetadataKey = "idempotent"
+
+// IsIdempotent returns true if the endpoint is marked idempotent.
+func (e *Endpoint) IsIdempotent() bool {
+	switch v := e.Metadata[IdempotentMetadataKey].(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true" || v == "1"
+	}
+	return false
+}
+
 // WrappedHandler returns the Handler with all middleware applied.
 // Middleware is applied in order: first middleware is outermost wrapper.
 func (e *Endpoint) WrappedHandler() EndpointFunc {
@@ -78,6 +101,19 @@ func (e *Endpoint) WrappedHandler() EndpointFunc {
 	return h
 }
 
+// WrappedStreamHandler returns the StreamHandler with all stream middleware
+// applied, in the same order as WrappedHandler.
+func (e *Endpoint) WrappedStreamHandler() StreamEndpointFunc {
+	if e.StreamHandler == nil || len(e.StreamMiddleware) == 0 {
+		return e.StreamHandler
+	}
+	h := e.StreamHandler
+	for i := len(e.StreamMiddleware) - 1; i >= 0; i-- {
+		h = e.StreamMiddleware[i](h)
+	}
+	return h
+}
+
 // Clone creates a copy of the endpoint.
 func (e *Endpoint) Clone() *Endpoint {
 	clone := *e
@@ -124,6 +160,13 @@ func WithMiddleware(mw ...MiddlewareFunc) EndpointOption {
 	}
 }
 
+// WithStreamMiddleware adds stream middleware functions to the endpoint.
+func WithStreamMiddleware(mw ...StreamMiddlewareFunc) EndpointOption {
+	return func(e *Endpoint) {
+		e.StreamMiddleware = append(e.StreamMiddleware, mw...)
+	}
+}
+
 // NewEndpoint creates a new endpoint with the given name and handler.
 func NewEndpoint(name string, handler EndpointFunc, opts ...EndpointOption) *Endpoint {
 	e := &Endpoint{
@@ -0,0 +1,318 @@
+package talk
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go.zoe.im/x"
+)
+
+// The detail types below are modeled after google.rpc error details. Each
+// of them implements error, so callers can extract them with errors.As:
+//
+//	var br talk.BadRequest
+//	if errors.As(err, &br) {
+//	    for _, fv := range br.FieldViolations { ... }
+//	}
+
+// RetryInfo is used as error details to tell the caller how long to wait
+// before retrying, e.g. when a rate limit is exceeded.
+type RetryInfo struct {
+	RetryDelay x.Duration `json:"retry_delay"`
+}
+
+func (d RetryInfo) Error() string {
+	return "retry after " + time.Duration(d.RetryDelay).String()
+}
+
+// BadRequest is used as error details to describe which request fields are
+// invalid and why.
+type BadRequest struct {
+	FieldViolations []FieldViolation `json:"field_violations"`
+}
+
+func (d BadRequest) Error() string {
+	msgs := make([]string, len(d.FieldViolations))
+	for i, fv := range d.FieldViolations {
+		msgs[i] = fv.Field + " " + fv.Description
+	}
+	return "invalid request: " + strings.Join(msgs, "; ")
+}
+
+// FieldViolation describes a single invalid request field.
+type FieldViolation struct {
+	// Field is the path of the field using JSON names, e.g. "items[0].name".
+	Field string `json:"field"`
+	// Rule is the validation rule that failed, e.g. "required" or "max".
+	Rule string `json:"rule,omitempty"`
+	// Description explains the violation in human-readable form.
+	Description string `json:"description"`
+}
+
+// QuotaFailure is used as error details to describe which quotas were
+// exhausted, typically together with ResourceExhausted.
+type QuotaFailure struct {
+	Violations []QuotaViolation `json:"violations"`
+}
+
+func (d QuotaFailure) Error() string {
+	msgs := make([]string, len(d.Violations))
+	for i, v := range d.Violations {
+		msgs[i] = v.Subject + ": " + v.Description
+	}
+	return "quota exceeded: " + strings.Join(msgs, "; ")
+}
+
+// QuotaViolation describes a single exhausted quota.
+type QuotaViolation struct {
+	// Subject is what the quota applies to, e.g. "user:42" or "project:demo".
+	Subject string `json:"subject"`
+	// Description explains which limit was hit.
+	Description string `json:"description"`
+}
+
+// DebugInfo is used as error details to carry server-side debugging
+// information. Only attach it when the caller is trusted.
+type DebugInfo struct {
+	StackEntries []string `json:"stack_entries,omitempty"`
+	Detail       string   `json:"detail,omitempty"`
+}
+
+func (d DebugInfo) Error() string {
+	if d.Detail == "" {
+		return "debug info"
+	}
+	return d.Detail
+}
+
+// ErrorDetailTypeKey is the JSON key that names the type of a serialized
+// error detail.
+const ErrorDetailTypeKey = "@type"
+
+var (
+	errorDetailsMu   sync.RWMutex
+	errorDetailTypes = map[string]reflect.Type{}
+	errorDetailNames = map[reflect.Type]string{}
+)
+
+func init() {
+	RegisterErrorDetail("talk.RetryInfo", RetryInfo{})
+	RegisterErrorDetail("talk.BadRequest", BadRequest{})
+	RegisterErrorDetail("talk.QuotaFailure", QuotaFailure{})
+	RegisterErrorDetail("talk.DebugInfo", DebugInfo{})
+}
+
+// RegisterErrorDetail registers the type of detail under name, so that
+// error details of that type are decoded back into it on the client side.
+// Details of unregistered types are still sent, but arrive as their generic
+// JSON form (map[string]any, []any, ...).
+//
+// Usage:
+//
+//	type ConflictInfo struct {
+//	    Resource string `json:"resource"`
+//	    Version  int    `json:"version"`
+//	}
+//
+//	func init() { talk.RegisterErrorDetail("myapp.ConflictInfo", ConflictInfo{}) }
+func RegisterErrorDetail(name string, detail any) {
+	t := reflect.TypeOf(detail)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	errorDetailsMu.Lock()
+	defer errorDetailsMu.Unlock()
+	errorDetailTypes[name] = t
+	errorDetailNames[t] = name
+}
+
+// MarshalErrorDetail encodes a single error detail as JSON. Details of
+// registered types carry their name under "@type": struct and map types
+// inline their fields next to it, other types are put under "value".
+func MarshalErrorDetail(detail any) ([]byte, error) {
+	data, err := json.Marshal(detail)
+	if err != nil {
+		return nil, err
+	}
+
+	t := reflect.TypeOf(detail)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	errorDetailsMu.RLock()
+	name, ok := errorDetailNames[t]
+	errorDetailsMu.RUnlock()
+	if !ok {
+		return data, nil
+	}
+
+	if !inlineErrorDetail(t) {
+		return json.Marshal(struct {
+			Type  string          `json:"@type"`
+			Value json.RawMessage `json:"value"`
+		}{name, data})
+	}
+	typeField, _ := json.Marshal(map[string]string{ErrorDetailTypeKey: name})
+	if bytes.Equal(data, []byte("{}")) || bytes.Equal(data, []byte("null")) {
+		return typeField, nil
+	}
+	// {"@type":"name"} + {"field":...} => {"@type":"name","field":...}
+	out := make([]byte, 0, len(typeField)+len(data))
+	out = append(out, typeField[:len(typeField)-1]...)
+	out = append(out, ',')
+	return append(out, data[1:]...), nil
+}
+
+// UnmarshalErrorDetail decodes a single error detail produced by
+// MarshalErrorDetail. Details of registered types are returned as values of
+// that type, anything else in its generic JSON form.
+func UnmarshalErrorDetail(data []byte) (any, error) {
+	var head struct {
+		Type string `json:"@type"`
+	}
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		if err := json.Unmarshal(data, &head); err != nil {
+			return nil, err
+		}
+	}
+
+	errorDetailsMu.RLock()
+	t, ok := errorDetailTypes[head.Type]
+	errorDetailsMu.RUnlock()
+	if !ok {
+		var v any
+		if err := json.Unmarshal(data, &v); err != nil {
+			return nil, err
+		}
+		return v, nil
+	}
+
+	ptr := reflect.New(t)
+	switch {
+	case t.Kind() == reflect.Map:
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return nil, err
+		}
+		delete(fields, ErrorDetailTypeKey)
+		data, _ = json.Marshal(fields)
+	case !inlineErrorDetail(t):
+		var wrapped struct {
+			Value json.RawMessage `json:"value"`
+		}
+		if err := json.Unmarshal(data, &wrapped); err != nil {
+			return nil, err
+		}
+		data = wrapped.Value
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, ptr.Interface()); err != nil {
+			return nil, err
+		}
+	}
+	return ptr.Elem().Interface(), nil
+}
+
+func inlineErrorDetail(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct || t.Kind() == reflect.Map
+}
+
+// ErrorDetail returns the first detail of type T attached to err, if err is
+// (or wraps) an *Error. Unlike errors.As it also works for detail types that
+// do not implement error.
+func ErrorDetail[T any](err error) (T, bool) {
+	var detail T
+	var e *Error
+	if !errors.As(err, &e) {
+		return detail, false
+	}
+	ok := e.As(&detail)
+	return detail, ok
+}
+
+// As implements the errors.As protocol for error details: it finds the first
+// of TypedDetails, then Details, assignable to *target, so
+// errors.As(err, &badRequest) works for both BadRequest and *BadRequest
+// targets.
+func (e *Error) As(target any) bool {
+	tv := reflect.ValueOf(target)
+	if tv.Kind() != reflect.Ptr || tv.IsNil() {
+		return false
+	}
+	want := tv.Type().Elem()
+
+	for _, d := range append(slices.Clip(e.TypedDetails), e.Details) {
+		if d == nil {
+			continue
+		}
+		dv := reflect.ValueOf(d)
+		switch {
+		case dv.Type().AssignableTo(want):
+			tv.Elem().Set(dv)
+			return true
+		case want.Kind() == reflect.Ptr && dv.Type().AssignableTo(want.Elem()):
+			ptr := reflect.New(want.Elem())
+			ptr.Elem().Set(dv)
+			tv.Elem().Set(ptr)
+			return true
+		case dv.Kind() == reflect.Ptr && !dv.IsNil() && dv.Elem().Type().AssignableTo(want):
+			tv.Elem().Set(dv.Elem())
+			return true
+		}
+	}
+	return false
+}
+
+// jsonError is the wire form of Error.
+type jsonError struct {
+	Code         ErrorCode         `json:"code"`
+	Message      string            `json:"message"`
+	Details      any               `json:"details,omitempty"`
+	TypedDetails []json.RawMessage `json:"typed_details,omitempty"`
+}
+
+// MarshalJSON encodes the error with each typed detail tagged by its
+// registered type name, see MarshalErrorDetail.
+func (e Error) MarshalJSON() ([]byte, error) {
+	out := jsonError{Code: e.Code, Message: e.Message, Details: e.Details}
+	for _, d := range e.TypedDetails {
+		data, err := MarshalErrorDetail(d)
+		if err != nil {
+			return nil, err
+		}
+		out.TypedDetails = append(out.TypedDetails, data)
+	}
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON decodes an error encoded by MarshalJSON, restoring typed
+// details of registered types. Details are decoded in their generic JSON
+// form.
+func (e *Error) UnmarshalJSON(data []byte) error {
+	var in struct {
+		Code         ErrorCode         `json:"code"`
+		Message      string            `json:"message"`
+		Details      any               `json:"details"`
+		TypedDetails []json.RawMessage `json:"typed_details"`
+	}
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+
+	e.Code, e.Message, e.Details, e.TypedDetails = in.Code, in.Message, in.Details, nil
+	for _, item := range in.TypedDetails {
+		d, err := UnmarshalErrorDetail(item)
+		if err != nil {
+			return err
+		}
+		e.TypedDetails = append(e.TypedDetails, d)
+	}
+	return nil
+}
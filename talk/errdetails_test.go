@@ -0,0 +1,133 @@
+package talk
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.zoe.im/x"
+)
+
+type conflictInfo struct {
+	Resource string `json:"resource"`
+	Version  int    `json:"version"`
+}
+
+type traceID string
+
+func init() {
+	RegisterErrorDetail("talk.test.ConflictInfo", conflictInfo{})
+	RegisterErrorDetail("talk.test.TraceID", traceID(""))
+}
+
+func roundTrip(t *testing.T, e *Error) *Error {
+	t.Helper()
+	data, err := json.Marshal(e)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	var out Error
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatalf("Unmarshal(%s): %v", data, err)
+	}
+	return &out
+}
+
+func TestErrorDetails_RoundTrip(t *testing.T) {
+	details := []any{
+		BadRequest{FieldViolations: []FieldViolation{{Field: "name", Rule: "required", Description: "is required"}}},
+		RetryInfo{RetryDelay: x.Duration(2 * time.Second)},
+		QuotaFailure{Violations: []QuotaViolation{{Subject: "user:42", Description: "100 requests per day"}}},
+		DebugInfo{StackEntries: []string{"main.go:10"}, Detail: "boom"},
+		conflictInfo{Resource: "user", Version: 3},
+		traceID("abc"),
+	}
+	got := roundTrip(t, NewErrorWithTypedDetails(InvalidArgument, "bad", details...))
+
+	if got.Code != InvalidArgument || got.Message != "bad" {
+		t.Errorf("got %v", got)
+	}
+	if !reflect.DeepEqual(got.TypedDetails, details) {
+		t.Errorf("TypedDetails = %#v, want %#v", got.TypedDetails, details)
+	}
+}
+
+func TestErrorDetails_Wire(t *testing.T) {
+	e := NewErrorWithTypedDetails(ResourceExhausted, "slow down",
+		RetryInfo{RetryDelay: x.Duration(time.Second)}, traceID("abc"))
+	e.Details = map[string]string{"field": "email"}
+	data, err := json.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"code":8,"message":"slow down","details":{"field":"email"},"typed_details":[{"@type":"talk.RetryInfo","retry_delay":"1s"},{"@type":"talk.test.TraceID","value":"abc"}]}`
+	if string(data) != want {
+		t.Errorf("got  %s\nwant %s", data, want)
+	}
+}
+
+func TestErrorDetails_Unregistered(t *testing.T) {
+	got := roundTrip(t, NewErrorWithTypedDetails(Internal, "x", map[string]string{"field": "email"}))
+	want := []any{map[string]any{"field": "email"}}
+	if !reflect.DeepEqual(got.TypedDetails, want) {
+		t.Errorf("TypedDetails = %#v, want %#v", got.TypedDetails, want)
+	}
+
+	// Free-form details come back in their generic JSON form.
+	got = roundTrip(t, NewErrorWithDetails(Internal, "x", map[string]string{"field": "email"}))
+	if !reflect.DeepEqual(got.Details, want[0]) || got.TypedDetails != nil {
+		t.Errorf("Details = %#v, TypedDetails = %#v", got.Details, got.TypedDetails)
+	}
+}
+
+func TestErrorDetails_ErrorsAs(t *testing.T) {
+	br := BadRequest{FieldViolations: []FieldViolation{{Field: "email", Description: "is required"}}}
+	err := fmt.Errorf("create user: %w", NewErrorWithTypedDetails(InvalidArgument, "invalid", br))
+
+	var value BadRequest
+	if !errors.As(err, &value) || value.FieldViolations[0].Field != "email" {
+		t.Errorf("errors.As(BadRequest) = %v", value)
+	}
+	var ptr *BadRequest
+	if !errors.As(err, &ptr) || ptr.FieldViolations[0].Field != "email" {
+		t.Errorf("errors.As(*BadRequest) = %v", ptr)
+	}
+	var retry RetryInfo
+	if errors.As(err, &retry) {
+		t.Error("errors.As(RetryInfo) should fail")
+	}
+	var talkErr *Error
+	if !errors.As(err, &talkErr) || talkErr.Code != InvalidArgument {
+		t.Error("errors.As(*Error) should still find the error itself")
+	}
+
+	if info, ok := ErrorDetail[conflictInfo](NewErrorWithTypedDetails(Aborted, "x", &conflictInfo{Version: 2})); !ok || info.Version != 2 {
+		t.Errorf("ErrorDetail = %v, %v", info, ok)
+	}
+	if info, ok := ErrorDetail[conflictInfo](NewErrorWithDetails(Aborted, "x", conflictInfo{Version: 3})); !ok || info.Version != 3 {
+		t.Errorf("ErrorDetail(Details) = %v, %v", info, ok)
+	}
+	if _, ok := ErrorDetail[conflictInfo](errors.New("plain")); ok {
+		t.Error("ErrorDetail on a plain error should fail")
+	}
+}
+
+func TestErrorDetails_Messages(t *testing.T) {
+	tests := []struct {
+		detail error
+		want   string
+	}{
+		{RetryInfo{RetryDelay: x.Duration(time.Second)}, "retry after 1s"},
+		{BadRequest{FieldViolations: []FieldViolation{{Field: "a", Description: "is required"}, {Field: "b", Description: "is bad"}}}, "invalid request: a is required; b is bad"},
+		{QuotaFailure{Violations: []QuotaViolation{{Subject: "user:1", Description: "daily limit"}}}, "quota exceeded: user:1: daily limit"},
+		{DebugInfo{Detail: "boom"}, "boom"},
+	}
+	for _, tt := range tests {
+		if got := tt.detail.Error(); got != tt.want {
+			t.Errorf("%T.Error() = %q, want %q", tt.detail, got, tt.want)
+		}
+	}
+}
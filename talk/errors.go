@@ -6,6 +6,7 @@ package talk
 import (
 	"fmt"
 	"net/http"
+	"time"
 )
 
 // ErrorCode represents a canonical error code that can be mapped
@@ -180,10 +181,18 @@ func (c ErrorCode) WSCloseCode() int {
 }
 
 // Error represents a Talk error with a code, message, and optional details.
+//
+// Details is a free-form payload sent as is, so clients get its generic
+// JSON form back. TypedDetails carry typed information about the failure,
+// such as BadRequest, RetryInfo, QuotaFailure or DebugInfo, or any value
+// registered with RegisterErrorDetail. Transports serialize them so that
+// the client side gets the same typed values back, which can be extracted
+// with errors.As or ErrorDetail.
 type Error struct {
-	Code    ErrorCode `json:"code"`
-	Message string    `json:"message"`
-	Details any       `json:"details,omitempty"`
+	Code         ErrorCode `json:"code"`
+	Message      string    `json:"message"`
+	Details      any       `json:"details,omitempty"`
+	TypedDetails []any     `json:"typed_details,omitempty"`
 }
 
 // Error implements the error interface.
@@ -234,6 +243,33 @@ func NewErrorWithDetails(code ErrorCode, message string, details any) *Error {
 	}
 }
 
+// NewErrorWithTypedDetails creates a new Error with the given code, message,
+// and typed details.
+func NewErrorWithTypedDetails(code ErrorCode, message string, details ...any) *Error {
+	return &Error{
+		Code:         code,
+		Message:      message,
+		TypedDetails: details,
+	}
+}
+
+// RetryAfter returns the retry delay carried in a RetryInfo detail, if any.
+// It also understands the JSON-decoded form of RetryInfo in Details.
+func (e *Error) RetryAfter() (time.Duration, bool) {
+	var info RetryInfo
+	if e.As(&info) {
+		return time.Duration(info.RetryDelay), true
+	}
+	if d, ok := e.Details.(map[string]any); ok {
+		if s, ok := d["retry_delay"].(string); ok {
+			if delay, err := time.ParseDuration(s); err == nil {
+				return delay, true
+			}
+		}
+	}
+	return 0, false
+}
+
 // FromHTTPStatus converts an HTTP status code to an ErrorCode.
 func FromHTTPStatus(status int) ErrorCode {
 	switch status {
@@ -345,13 +345,12 @@ func ExampleErrorHandling() {
 	fmt.Printf("gRPC Code: %d\n", err.GRPCCode())
 
 	// Create error with details
-	validationErr := talk.NewErrorWithDetails(
+	validationErr := talk.NewErrorWithTypedDetails(
 		talk.InvalidArgument,
 		"validation failed",
-		map[string]string{
-			"field":  "email",
-			"reason": "invalid format",
-		},
+		talk.BadRequest{FieldViolations: []talk.FieldViolation{
+			{Field: "email", Rule: "email", Description: "must be a valid email address"},
+		}},
 	)
 
 	fmt.Printf("Validation error: %s\n", validationErr.Error())
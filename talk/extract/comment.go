@@ -1,6 +1,11 @@
 package extract
 
 import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
 
@@ -88,3 +93,112 @@ func parseStreamMode(s string) talk.StreamMode {
 func HasAnnotation(comment string) bool {
 	return strings.Contains(comment, "@talk")
 }
+
+// TypeDoc holds the doc comments of a type declaration.
+type TypeDoc struct {
+	Doc    string
+	Fields map[string]string // doc or line comments of struct fields by name
+}
+
+// ParseTypeDocs parses the doc comments of the type declarations in the Go
+// files at paths, which may also be directories of non-test files, and
+// returns them by type name. @talk annotations are left out.
+func ParseTypeDocs(paths ...string) (map[string]*TypeDoc, error) {
+	var files []string
+	for _, p := range paths {
+		fi, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		if !fi.IsDir() {
+			files = append(files, p)
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(p, "*.go"))
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range matches {
+			if !strings.HasSuffix(m, "_test.go") {
+				files = append(files, m)
+			}
+		}
+	}
+
+	docs := make(map[string]*TypeDoc)
+	fset := token.NewFileSet()
+	for _, file := range files {
+		f, err := parser.ParseFile(fset, file, nil, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		for _, decl := range f.Decls {
+			genDecl, ok := decl.(*ast.GenDecl)
+			if !ok || genDecl.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range genDecl.Specs {
+				typeSpec := spec.(*ast.TypeSpec)
+				doc := typeSpec.Doc
+				if doc == nil && len(genDecl.Specs) == 1 {
+					doc = genDecl.Doc
+				}
+				td := &TypeDoc{
+					Doc:    commentText(doc),
+					Fields: make(map[string]string),
+				}
+				if st, ok := typeSpec.Type.(*ast.StructType); ok {
+					for _, field := range st.Fields.List {
+						text := commentText(field.Doc)
+						if text == "" {
+							text = commentText(field.Comment)
+						}
+						if text == "" {
+							continue
+						}
+						for _, name := range field.Names {
+							td.Fields[name.Name] = text
+						}
+						if len(field.Names) == 0 {
+							td.Fields[embeddedName(field.Type)] = text
+						}
+					}
+				}
+				docs[typeSpec.Name.Name] = td
+			}
+		}
+	}
+	return docs, nil
+}
+
+// commentText returns the text of a comment group without its @talk
+// annotations.
+func commentText(cg *ast.CommentGroup) string {
+	if cg == nil {
+		return ""
+	}
+	var lines []string
+	for _, line := range strings.Split(cg.Text(), "\n") {
+		if !HasAnnotation(line) {
+			lines = append(lines, line)
+		}
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// embeddedName returns the field name of an embedded type.
+func embeddedName(expr ast.Expr) string {
+	switch t := expr.(type) {
+	case *ast.StarExpr:
+		return embeddedName(t.X)
+	case *ast.SelectorExpr:
+		return t.Sel.Name
+	case *ast.IndexExpr:
+		return embeddedName(t.X)
+	case *ast.IndexListExpr:
+		return embeddedName(t.X)
+	case *ast.Ident:
+		return t.Name
+	}
+	return ""
+}
@@ -1,6 +1,8 @@
 package extract
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 
 	"go.zoe.im/x/talk"
@@ -172,3 +174,59 @@ func TestParseStreamMode(t *testing.T) {
 		})
 	}
 }
+
+func TestParseTypeDocs(t *testing.T) {
+	dir := t.TempDir()
+	src := `package types
+
+// User is a user.
+// @talk ignore
+type User struct {
+	// ID identifies the user.
+	ID   string
+	Name string // display name
+	Base
+}
+
+type (
+	// Status is the state of a user.
+	Status string
+	Plain  int
+)
+`
+	if err := os.WriteFile(filepath.Join(dir, "types.go"), []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "types_test.go"), []byte("package types\n\n// Test is ignored.\ntype Test int\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	docs, err := ParseTypeDocs(dir)
+	if err != nil {
+		t.Fatalf("ParseTypeDocs failed: %v", err)
+	}
+
+	user := docs["User"]
+	if user == nil || user.Doc != "User is a user." {
+		t.Fatalf("User doc = %+v", user)
+	}
+	if user.Fields["ID"] != "ID identifies the user." || user.Fields["Name"] != "display name" {
+		t.Errorf("User fields = %v", user.Fields)
+	}
+	if _, ok := user.Fields["Base"]; ok {
+		t.Errorf("undocumented field Base has a doc")
+	}
+	if docs["Status"] == nil || docs["Status"].Doc != "Status is the state of a user." {
+		t.Errorf("Status doc = %+v", docs["Status"])
+	}
+	if docs["Plain"] == nil || docs["Plain"].Doc != "" {
+		t.Errorf("Plain doc = %+v", docs["Plain"])
+	}
+	if _, ok := docs["Test"]; ok {
+		t.Error("test files should be skipped")
+	}
+
+	if _, err := ParseTypeDocs(filepath.Join(dir, "missing")); err == nil {
+		t.Error("expected an error for a missing path")
+	}
+}
@@ -64,6 +64,9 @@ func (e *ReflectExtractor) Extract(service any) ([]*talk.Endpoint, error) {
 		if e.opts.PathPrefix != "" {
 			endpoint.Path = e.opts.PathPrefix + endpoint.Path
 		}
+		if name := serviceTypeName(svcType); name != "" {
+			endpoint.Metadata[talk.ServiceMetadataKey] = name
+		}
 
 		endpoints = append(endpoints, endpoint)
 	}
@@ -71,6 +74,15 @@ func (e *ReflectExtractor) Extract(service any) ([]*talk.Endpoint, error) {
 	return endpoints, nil
 }
 
+// serviceTypeName returns the type name of a service, or "" for unnamed
+// types.
+func serviceTypeName(t reflect.Type) string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Name()
+}
+
 func (e *ReflectExtractor) extractMethod(svcValue reflect.Value, method reflect.Method, ann *Annotation) (*talk.Endpoint, bool) {
 	methodType := method.Type
 
@@ -128,6 +140,13 @@ func (e *ReflectExtractor) extractMethod(svcValue reflect.Value, method reflect.
 		Metadata:   make(map[string]any),
 	}
 
+	// Carry remaining annotation tags (auth, ratelimit, ...) for middleware.
+	if ann != nil {
+		for k, v := range ann.Tags {
+			endpoint.Metadata[k] = v
+		}
+	}
+
 	// Extract request/response types
 	if methodType.NumIn() > 2 {
 		reqType := methodType.In(2)
@@ -197,7 +197,7 @@ func (s *annotatedService) CustomPath(ctx context.Context) (*testUser, error) {
 func (s *annotatedService) TalkAnnotations() map[string]string {
 	return map[string]string{
 		"InternalMethod": "@talk skip",
-		"CustomPath":     "@talk path=/custom/endpoint method=PUT",
+		"CustomPath":     "@talk path=/custom/endpoint method=PUT ratelimit=10/s burst=5",
 	}
 }
 
@@ -224,6 +224,9 @@ func TestReflectExtractor_WithAnnotations(t *testing.T) {
 		if ep.Method != "PUT" {
 			t.Errorf("CustomPath method = %s, want PUT", ep.Method)
 		}
+		if ep.Metadata["ratelimit"] != "10/s" || ep.Metadata["burst"] != "5" {
+			t.Errorf("CustomPath metadata = %v, want ratelimit and burst tags", ep.Metadata)
+		}
 	} else {
 		t.Error("CustomPath endpoint not found")
 	}
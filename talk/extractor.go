@@ -13,6 +13,20 @@ var (
 	errorType   = reflect.TypeOf((*error)(nil)).Elem()
 )
 
+// ServiceMetadataKey holds in Endpoint.Metadata the type name of the
+// service an endpoint was extracted from, e.g. "UserService", so that
+// transports with named services, such as gRPC, can group endpoints.
+const ServiceMetadataKey = "talk.service"
+
+// serviceTypeName returns the type name of a service, or "" for unnamed
+// types.
+func serviceTypeName(t reflect.Type) string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Name()
+}
+
 // MethodAnnotations allows services to provide custom endpoint configuration.
 type MethodAnnotations interface {
 	TalkAnnotations() map[string]string
@@ -52,6 +66,9 @@ func (e *reflectExtractor) Extract(service any) ([]*Endpoint, error) {
 		if !ok {
 			continue
 		}
+		if name := serviceTypeName(svcType); name != "" {
+			endpoint.Metadata[ServiceMetadataKey] = name
+		}
 
 		endpoints = append(endpoints, endpoint)
 	}
@@ -98,6 +115,12 @@ func (e *reflectExtractor) extractMethod(svcValue reflect.Value, method reflect.
 		Metadata:   make(map[string]any),
 	}
 
+	if ann != nil {
+		for k, v := range ann.tags {
+			endpoint.Metadata[k] = v
+		}
+	}
+
 	if ann != nil && len(ann.middleware) > 0 {
 		endpoint.Metadata["middleware"] = ann.middleware
 	}
@@ -322,6 +345,7 @@ type annotation struct {
 	skip       bool
 	middleware []string
 	auth       string
+	tags       map[string]string // any other key=value pairs
 }
 
 var annotationRegex = regexp.MustCompile(`@talk\s*(.*)`)
@@ -333,7 +357,7 @@ func parseAnnotation(comment string) *annotation {
 		return nil
 	}
 
-	ann := &annotation{}
+	ann := &annotation{tags: make(map[string]string)}
 	content := strings.TrimSpace(match[1])
 
 	if content == "skip" || content == "ignore" || content == "-" {
@@ -364,6 +388,8 @@ func parseAnnotation(comment string) *annotation {
 					ann.middleware = append(ann.middleware, m)
 				}
 			}
+		default:
+			ann.tags[key] = value
 		}
 	}
 	return ann
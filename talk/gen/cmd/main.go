@@ -3,11 +3,20 @@
 // Usage:
 //
 //	//go:generate go run go.zoe.im/x/talk/gen/cmd -type=UserService
+//	//go:generate go run go.zoe.im/x/talk/gen/cmd -type=UserService -client
+//	//go:generate go run go.zoe.im/x/talk/gen/cmd -type=UserService -lang=ts
 //	//go:generate go run go.zoe.im/x/talk/gen/cmd -type=userService -annotations
 //
 // This will generate a file named <input>_talk.go containing endpoint
 // registration code for the specified interface.
 //
+// With -client flag, the file also contains New<Type>Client, a typed client
+// implementing the interface by calling the endpoints with a *talk.Client.
+//
+// With -lang=ts flag, it generates <input>_talk.ts instead, containing
+// TypeScript interfaces of the request and response types and a fetch
+// client of the HTTP endpoints, whose server streams are async iterators.
+//
 // With -annotations flag, it generates TalkAnnotations() method from
 // source code comments containing @talk directives.
 package main
@@ -26,6 +35,8 @@ func main() {
 		typeName    = flag.String("type", "", "type name to generate for")
 		outputFile  = flag.String("output", "", "output file name")
 		annotations = flag.Bool("annotations", false, "generate TalkAnnotations() from comments")
+		client      = flag.Bool("client", false, "also generate a typed client")
+		lang        = flag.String("lang", "go", "language of the generated code: go or ts")
 	)
 
 	flag.Parse()
@@ -71,6 +82,8 @@ func main() {
 	g := &gen.Generator{
 		TypeName:   *typeName,
 		OutputFile: *outputFile,
+		Client:     *client,
+		Lang:       *lang,
 	}
 
 	if err := g.Generate(sourceFile); err != nil {
@@ -78,10 +91,5 @@ func main() {
 		os.Exit(1)
 	}
 
-	outputName := *outputFile
-	if outputName == "" {
-		ext := filepath.Ext(sourceFile)
-		outputName = sourceFile[:len(sourceFile)-len(ext)] + "_talk" + ext
-	}
-	fmt.Printf("Generated %s\n", outputName)
+	fmt.Printf("Generated %s\n", g.OutputPath(sourceFile))
 }
@@ -7,8 +7,13 @@ import (
 	"go/ast"
 	"go/parser"
 	"go/token"
+	"go/types"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"text/template"
 
@@ -21,6 +26,12 @@ type Generator struct {
 	PackageName string
 	TypeName    string
 	OutputFile  string
+	// Client also generates New<TypeName>Client, a typed client
+	// implementing the interface on top of a *talk.Client.
+	Client bool
+	// Lang is the language of the generated code: "go", the default, or
+	// "ts" for TypeScript types and a fetch client of the HTTP endpoints.
+	Lang string
 }
 
 // MethodInfo holds parsed method information for code generation.
@@ -33,7 +44,13 @@ type MethodInfo struct {
 	ResponseType string
 	HasRequest   bool
 	HasResponse  bool
+	RequestElem  string // element type of a channel request
+	ResponseElem string // element type of a channel response
 	Comments     []string
+	Tags         map[string]string // extra annotation tags, emitted as Endpoint.Metadata
+
+	requestExpr  ast.Expr
+	responseExpr ast.Expr
 }
 
 // InterfaceInfo holds parsed interface information.
@@ -42,6 +59,19 @@ type InterfaceInfo struct {
 	TypeName    string
 	Methods     []MethodInfo
 	Imports     []string
+	Client      bool
+
+	// sourceImports maps the package names imported by the source file
+	// to their import specs.
+	sourceImports map[string]string
+	// sourceTypes are the type declarations of the source file, in order.
+	sourceTypes []sourceType
+}
+
+// sourceType is a type declaration of the source file.
+type sourceType struct {
+	spec *ast.TypeSpec
+	doc  *ast.CommentGroup
 }
 
 // Generate parses the source file and generates endpoint code.
@@ -51,18 +81,34 @@ func (g *Generator) Generate(sourceFile string) error {
 		return err
 	}
 
-	code, err := g.generateCode(info)
+	var code []byte
+	switch g.Lang {
+	case "", "go":
+		code, err = g.generateCode(info)
+	case "ts":
+		code, err = g.generateTypeScript(info)
+	default:
+		return fmt.Errorf("unsupported language: %s", g.Lang)
+	}
 	if err != nil {
 		return err
 	}
 
-	outputFile := g.OutputFile
-	if outputFile == "" {
-		ext := filepath.Ext(sourceFile)
-		outputFile = strings.TrimSuffix(sourceFile, ext) + "_talk" + ext
-	}
+	return os.WriteFile(g.OutputPath(sourceFile), code, 0644)
+}
 
-	return os.WriteFile(outputFile, code, 0644)
+// OutputPath returns the file Generate writes the code generated from
+// sourceFile to: OutputFile, or <source>_talk.go (.ts for TypeScript).
+func (g *Generator) OutputPath(sourceFile string) string {
+	if g.OutputFile != "" {
+		return g.OutputFile
+	}
+	ext := filepath.Ext(sourceFile)
+	outExt := ext
+	if g.Lang == "ts" {
+		outExt = ".ts"
+	}
+	return strings.TrimSuffix(sourceFile, ext) + "_talk" + outExt
 }
 
 func (g *Generator) parseInterface(sourceFile string) (*InterfaceInfo, error) {
@@ -73,8 +119,22 @@ func (g *Generator) parseInterface(sourceFile string) (*InterfaceInfo, error) {
 	}
 
 	info := &InterfaceInfo{
-		PackageName: f.Name.Name,
-		TypeName:    g.TypeName,
+		PackageName:   f.Name.Name,
+		TypeName:      g.TypeName,
+		Client:        g.Client,
+		sourceImports: make(map[string]string),
+	}
+
+	for _, spec := range f.Imports {
+		importPath, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			continue
+		}
+		name, imp := path.Base(importPath), spec.Path.Value
+		if spec.Name != nil {
+			name, imp = spec.Name.Name, spec.Name.Name+" "+imp
+		}
+		info.sourceImports[name] = imp
 	}
 
 	for _, decl := range f.Decls {
@@ -85,7 +145,17 @@ func (g *Generator) parseInterface(sourceFile string) (*InterfaceInfo, error) {
 
 		for _, spec := range genDecl.Specs {
 			typeSpec, ok := spec.(*ast.TypeSpec)
-			if !ok || typeSpec.Name.Name != g.TypeName {
+			if !ok {
+				continue
+			}
+
+			doc := typeSpec.Doc
+			if doc == nil && len(genDecl.Specs) == 1 {
+				doc = genDecl.Doc
+			}
+			info.sourceTypes = append(info.sourceTypes, sourceType{spec: typeSpec, doc: doc})
+
+			if typeSpec.Name.Name != g.TypeName {
 				continue
 			}
 
@@ -136,6 +206,9 @@ func (g *Generator) parseMethods(iface *ast.InterfaceType, comments []*ast.Comme
 			mi.Path = ann.Path
 			mi.HTTPMethod = ann.Method
 			mi.StreamMode = ann.StreamMode
+			if len(ann.Tags) > 0 {
+				mi.Tags = ann.Tags
+			}
 		}
 
 		// Derive from method name if not specified
@@ -152,6 +225,14 @@ func (g *Generator) parseMethods(iface *ast.InterfaceType, comments []*ast.Comme
 		// Parse request/response types
 		mi.RequestType, mi.HasRequest = parseRequestType(funcType)
 		mi.ResponseType, mi.HasResponse = parseResponseType(funcType)
+		if mi.HasRequest {
+			mi.requestExpr = funcType.Params.List[1].Type
+			mi.RequestElem = chanElem(mi.requestExpr)
+		}
+		if mi.HasResponse {
+			mi.responseExpr = funcType.Results.List[0].Type
+			mi.ResponseElem = chanElem(mi.responseExpr)
+		}
 
 		// Detect stream mode from signature if not specified
 		if mi.StreamMode == talk.StreamNone {
@@ -303,26 +384,53 @@ func detectStreamMode(funcType *ast.FuncType) talk.StreamMode {
 }
 
 func typeToString(expr ast.Expr) string {
-	switch t := expr.(type) {
-	case *ast.Ident:
-		return t.Name
-	case *ast.StarExpr:
-		return "*" + typeToString(t.X)
-	case *ast.SelectorExpr:
-		return typeToString(t.X) + "." + t.Sel.Name
-	case *ast.ArrayType:
-		return "[]" + typeToString(t.Elt)
-	case *ast.ChanType:
-		return "<-chan " + typeToString(t.Value)
-	case *ast.MapType:
-		return "map[" + typeToString(t.Key) + "]" + typeToString(t.Value)
-	default:
-		return "any"
+	return types.ExprString(expr)
+}
+
+// chanElem returns the element type of a channel type, or "".
+func chanElem(expr ast.Expr) string {
+	if t, ok := expr.(*ast.ChanType); ok {
+		return typeToString(t.Value)
+	}
+	return ""
+}
+
+// usedImports returns "context" and the imports of the source file
+// referred to by the types of the generated code, sorted.
+func (info *InterfaceInfo) usedImports() []string {
+	var typeNames []string
+	for _, m := range info.Methods {
+		if m.HasRequest {
+			typeNames = append(typeNames, m.RequestType)
+		}
+		if m.HasResponse && info.Client {
+			typeNames = append(typeNames, m.ResponseType)
+		}
+	}
+	code := strings.Join(typeNames, " ")
+
+	imports := []string{`"context"`}
+	for name, spec := range info.sourceImports {
+		if name == "context" || name == "_" || name == "." {
+			continue
+		}
+		if regexp.MustCompile(`\b` + regexp.QuoteMeta(name) + `\.`).MatchString(code) {
+			imports = append(imports, spec)
+		}
 	}
+	// Sort by path, as gofmt does.
+	sort.Slice(imports, func(i, j int) bool {
+		return imports[i][strings.Index(imports[i], `"`):] < imports[j][strings.Index(imports[j], `"`):]
+	})
+	return imports
 }
 
 func (g *Generator) generateCode(info *InterfaceInfo) ([]byte, error) {
-	tmpl, err := template.New("endpoints").Parse(endpointTemplate)
+	info.Imports = info.usedImports()
+
+	tmpl, err := template.New("endpoints").Funcs(template.FuncMap{
+		"streamMode": streamModeName,
+	}).Parse(endpointTemplate + clientTemplate)
 	if err != nil {
 		return nil, err
 	}
@@ -335,12 +443,28 @@ func (g *Generator) generateCode(info *InterfaceInfo) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+// streamModeName returns the name of the talk constant of m.
+func streamModeName(m talk.StreamMode) string {
+	switch m {
+	case talk.StreamClientSide:
+		return "talk.StreamClientSide"
+	case talk.StreamServerSide:
+		return "talk.StreamServerSide"
+	case talk.StreamBidirect:
+		return "talk.StreamBidirect"
+	default:
+		return "talk.StreamNone"
+	}
+}
+
 const endpointTemplate = `// Code generated by go.zoe.im/x/talk/gen. DO NOT EDIT.
 
 package {{.PackageName}}
 
 import (
-	"context"
+{{- range .Imports}}
+	{{.}}
+{{- end}}
 
 	"go.zoe.im/x/talk"
 )
@@ -353,13 +477,20 @@ func {{.TypeName}}Endpoints(svc {{.TypeName}}) []*talk.Endpoint {
 			Name:       "{{.Name}}",
 			Path:       "{{.Path}}",
 			Method:     "{{.HTTPMethod}}",
-			StreamMode: {{.StreamMode}},
+			StreamMode: {{streamMode .StreamMode}},
+{{- if .Tags}}
+			Metadata: map[string]any{
+{{- range $k, $v := .Tags}}
+				{{printf "%q" $k}}: {{printf "%q" $v}},
+{{- end}}
+			},
+{{- end}}
 			Handler: func(ctx context.Context, req any) (any, error) {
 {{- if .HasRequest}}
 				r, _ := req.({{.RequestType}})
-				return svc.{{.Name}}(ctx, r)
+				return {{if not .HasResponse}}nil, {{end}}svc.{{.Name}}(ctx, r)
 {{- else}}
-				return svc.{{.Name}}(ctx)
+				return {{if not .HasResponse}}nil, {{end}}svc.{{.Name}}(ctx)
 {{- end}}
 			},
 		},
@@ -367,3 +498,82 @@ func {{.TypeName}}Endpoints(svc {{.TypeName}}) []*talk.Endpoint {
 	}
 }
 `
+
+// clientTemplate generates a client whose methods call the endpoints of
+// the same names: channel requests are streamed to the server and channel
+// responses receive the messages streamed back. Methods with a channel
+// response get a <Name>Receiver variant returning the *talk.Receiver, whose
+// Err tells why the channel was closed.
+const clientTemplate = `{{if .Client}}
+// {{.TypeName}}Client implements {{.TypeName}} by calling its endpoints
+// with a talk.Client.
+type {{.TypeName}}Client struct {
+	client *talk.Client
+}
+
+var _ {{.TypeName}} = (*{{.TypeName}}Client)(nil)
+
+// New{{.TypeName}}Client returns a {{.TypeName}} calling the endpoints
+// served by the other end of client.
+func New{{.TypeName}}Client(client *talk.Client) *{{.TypeName}}Client {
+	return &{{.TypeName}}Client{client: client}
+}
+{{- range .Methods}}
+
+{{- if .ResponseElem}}
+
+func (c *{{$.TypeName}}Client) {{.Name}}(ctx context.Context{{if .HasRequest}}, req {{.RequestType}}{{end}}) ({{.ResponseType}}, error) {
+	r, err := c.{{.Name}}Receiver(ctx{{if .HasRequest}}, req{{end}})
+	if err != nil {
+		return nil, err
+	}
+	return r.C, nil
+}
+
+// {{.Name}}Receiver is {{.Name}}, reporting why the stream ended.
+func (c *{{$.TypeName}}Client) {{.Name}}Receiver(ctx context.Context{{if .HasRequest}}, req {{.RequestType}}{{end}}) (*talk.Receiver[{{.ResponseElem}}], error) {
+{{- if .RequestElem}}
+	stream, err := c.client.Stream(ctx, "{{.Name}}", nil)
+	if err != nil {
+		return nil, err
+	}
+	return talk.SendRecvAll[{{.RequestElem}}, {{.ResponseElem}}](ctx, stream, req), nil
+{{- else}}
+	stream, err := c.client.Stream(ctx, "{{.Name}}", {{if .HasRequest}}req{{else}}nil{{end}})
+	if err != nil {
+		return nil, err
+	}
+	return talk.RecvAll[{{.ResponseElem}}](ctx, stream), nil
+{{- end}}
+}
+{{- else}}
+
+func (c *{{$.TypeName}}Client) {{.Name}}(ctx context.Context{{if .HasRequest}}, req {{.RequestType}}{{end}}) {{if .HasResponse}}({{.ResponseType}}, error){{else}}error{{end}} {
+{{- if and .RequestElem .HasResponse}}
+	var resp {{.ResponseType}}
+	stream, err := c.client.Stream(ctx, "{{.Name}}", nil)
+	if err != nil {
+		return resp, err
+	}
+	defer stream.Close()
+	if err := talk.SendAll(ctx, stream, req); err != nil {
+		return resp, err
+	}
+	err = stream.Recv(&resp)
+	return resp, err
+{{- else if .RequestElem}}
+	stream, err := c.client.Stream(ctx, "{{.Name}}", nil)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	return talk.SendAll(ctx, stream, req)
+{{- else if .HasResponse}}
+	return talk.Invoke[{{.ResponseType}}](ctx, c.client, "{{.Name}}", {{if .HasRequest}}req{{else}}nil{{end}})
+{{- else}}
+	return c.client.Call(ctx, "{{.Name}}", {{if .HasRequest}}req{{else}}nil{{end}}, nil)
+{{- end}}
+}
+{{- end}}
+{{- end}}
+{{end}}`
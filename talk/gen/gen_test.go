@@ -1,6 +1,7 @@
 package gen
 
 import (
+	"go/format"
 	"os"
 	"path/filepath"
 	"strings"
@@ -26,7 +27,7 @@ type UserService interface {
 	// @talk path=/users/{id} method=GET
 	GetUser(ctx context.Context, id string) (*User, error)
 
-	// @talk path=/users method=POST
+	// @talk path=/users method=POST ratelimit=10/s
 	CreateUser(ctx context.Context, req *CreateUserRequest) (*User, error)
 
 	// ListUsers returns all users
@@ -82,6 +83,9 @@ type CreateUserRequest struct {
 		`Name:       "CreateUser"`,
 		`Path:       "/users"`,
 		`Method:     "POST"`,
+		`"ratelimit": "10/s",`,
+		"StreamMode: talk.StreamNone,",
+		"return nil, svc.DeleteUser(ctx, r)",
 		"DO NOT EDIT",
 	}
 
@@ -92,6 +96,199 @@ type CreateUserRequest struct {
 	}
 }
 
+func TestGenerator_Client(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	sourceFile := filepath.Join(tmpDir, "service.go")
+	sourceContent := `package testservice
+
+import (
+	"context"
+	"time"
+)
+
+type EventService interface {
+	GetEvent(ctx context.Context, id string) (*Event, error)
+	Ping(ctx context.Context) error
+	WatchEvents(ctx context.Context, since time.Time) (<-chan *Event, error)
+	Upload(ctx context.Context, in <-chan *Event) (int, error)
+	Chat(ctx context.Context, in <-chan string) (<-chan string, error)
+}
+
+type Event struct {
+	ID string
+}
+`
+	if err := os.WriteFile(sourceFile, []byte(sourceContent), 0644); err != nil {
+		t.Fatalf("failed to write source file: %v", err)
+	}
+
+	g := &Generator{
+		TypeName: "EventService",
+		Client:   true,
+	}
+	if err := g.Generate(sourceFile); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	output, err := os.ReadFile(filepath.Join(tmpDir, "service_talk.go"))
+	if err != nil {
+		t.Fatalf("failed to read output: %v", err)
+	}
+	if formatted, err := format.Source(output); err != nil {
+		t.Fatalf("output is not valid Go: %v", err)
+	} else if string(formatted) != string(output) {
+		t.Errorf("output is not gofmt-ed:\n%s", output)
+	}
+
+	outputStr := string(output)
+	checks := []string{
+		"\t\"time\"\n",
+		"func NewEventServiceClient(client *talk.Client) *EventServiceClient",
+		"var _ EventService = (*EventServiceClient)(nil)",
+		`return talk.Invoke[*Event](ctx, c.client, "GetEvent", req)`,
+		`return c.client.Call(ctx, "Ping", nil, nil)`,
+		`c.client.Stream(ctx, "WatchEvents", req)`,
+		"return talk.RecvAll[*Event](ctx, stream), nil",
+		"if err := talk.SendAll(ctx, stream, req); err != nil {",
+		"func (c *EventServiceClient) WatchEventsReceiver(ctx context.Context, req time.Time) (*talk.Receiver[*Event], error)",
+		"return talk.SendRecvAll[string, string](ctx, stream, req), nil",
+		"StreamMode: talk.StreamBidirect,",
+	}
+	for _, check := range checks {
+		if !strings.Contains(outputStr, check) {
+			t.Errorf("output missing: %q", check)
+		}
+	}
+}
+
+func TestGenerator_TypeScript(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	sourceFile := filepath.Join(tmpDir, "service.go")
+	// Struct tags are quoted with ' in the raw string.
+	sourceContent := strings.ReplaceAll(`package testservice
+
+import (
+	"context"
+	"time"
+)
+
+type UserService interface {
+	// GetUser returns a user.
+	// @talk path=/users/{id} method=GET
+	GetUser(ctx context.Context, id string) (*User, error)
+
+	// @talk path=/orgs/{org}/users method=GET
+	ListUsers(ctx context.Context, req *ListUsersRequest) ([]*User, error)
+
+	// @talk path=/users method=POST
+	CreateUser(ctx context.Context, req *CreateUserRequest) (*User, error)
+
+	// @talk path=/users/watch method=GET
+	WatchUsers(ctx context.Context) (<-chan *User, error)
+
+	// @talk path=/users/search method=POST
+	SearchUsers(ctx context.Context, req *CreateUserRequest) (<-chan *User, error)
+
+	Upload(ctx context.Context, in <-chan *User) (int, error)
+}
+
+// Base holds common fields.
+type Base struct {
+	CreatedAt time.Time 'json:"created_at"'
+}
+
+type User struct {
+	Base
+	ID     string            'json:"id"'
+	Name   string            'json:"name,omitempty"'
+	Labels map[string]string 'json:"labels"'
+	Boss   *User             'json:"boss"'
+	Status Status            'json:"status"'
+	Secret string            'json:"-"'
+	hidden string
+}
+
+type Status string
+
+type ListUsersRequest struct {
+	Org   string 'json:"org" path:"org"'
+	Limit int    'json:"limit,omitempty"'
+	Page  string 'json:"page_token" query:"page"'
+}
+
+type CreateUserRequest struct {
+	Name string 'json:"name"'
+	Dry  bool   'json:"dry" query:"dry"'
+}
+
+type Unused struct{}
+`, "'", "`")
+	if err := os.WriteFile(sourceFile, []byte(sourceContent), 0644); err != nil {
+		t.Fatalf("failed to write source file: %v", err)
+	}
+
+	g := &Generator{
+		TypeName: "UserService",
+		Lang:     "ts",
+	}
+	if err := g.Generate(sourceFile); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	output, err := os.ReadFile(filepath.Join(tmpDir, "service_talk.ts"))
+	if err != nil {
+		t.Fatalf("failed to read output: %v", err)
+	}
+
+	outputStr := string(output)
+	checks := []string{
+		"/**\n * Base holds common fields.\n */\nexport interface Base {\n  created_at: string;\n}",
+		"export interface User extends Base {",
+		"  name?: string;",
+		"  labels: Record<string, string>;",
+		"  boss: User | null;",
+		"export type Status = string;",
+		"  page_token: string;",
+		"export class UserServiceClient {",
+		"   * GetUser returns a user.\n   */\n  getUser(req: string, init?: RequestInit): Promise<User> {",
+		`return this.callEndpoint("GET", ` + "`/users/${encodeURIComponent(String(req))}`" + `, undefined, undefined, init);`,
+		`return this.callEndpoint("GET", ` + "`/orgs/${encodeURIComponent(String(req.org))}/users`" + `, { limit: req.limit, page: req.page_token }, undefined, init);`,
+		`return this.callEndpoint("POST", ` + "`/users`" + `, { dry: req.dry }, req, init);`,
+		"watchUsers(init?: RequestInit): AsyncGenerator<User> {",
+		`return this.streamEndpoint("GET", ` + "`/users/watch`" + `, undefined, undefined, init);`,
+		`return this.streamEndpoint("POST", ` + "`/users/search`" + `, { dry: req.dry }, req, init);`,
+		"// Upload is not generated: fetch cannot stream requests.",
+		"async function* readEvents<T>(resp: Response): AsyncGenerator<T> {",
+	}
+	for _, check := range checks {
+		if !strings.Contains(outputStr, check) {
+			t.Errorf("output missing: %q", check)
+		}
+	}
+
+	for _, absent := range []string{"Unused", "Secret", "hidden", "@talk"} {
+		if strings.Contains(outputStr, absent) {
+			t.Errorf("output contains %q", absent)
+		}
+	}
+}
+
+func TestLowerCamel(t *testing.T) {
+	tests := map[string]string{
+		"GetUser": "getUser",
+		"URLFor":  "urlFor",
+		"ID":      "id",
+		"get":     "get",
+	}
+	for input, expected := range tests {
+		if got := lowerCamel(input); got != expected {
+			t.Errorf("lowerCamel(%q) = %q, want %q", input, got, expected)
+		}
+	}
+}
+
 func TestDeriveMethodAndPath(t *testing.T) {
 	tests := []struct {
 		name   string
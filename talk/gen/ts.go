@@ -0,0 +1,606 @@
+package gen
+
+import (
+	"bytes"
+	"go/ast"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode"
+
+	"go.zoe.im/x/talk"
+)
+
+// tsFile is the data of tsTemplate.
+type tsFile struct {
+	TypeName string
+	Types    []tsDecl
+	Methods  []tsMethod
+
+	// types are the type declarations of the source file by name.
+	types map[string]*ast.TypeSpec
+}
+
+// tsDecl is the TypeScript declaration of a type of the source file: an
+// interface for structs, a type alias otherwise.
+type tsDecl struct {
+	Name    string
+	Doc     []string
+	Extends string
+	Fields  []tsField
+	Alias   string
+}
+
+type tsField struct {
+	Name     string
+	Type     string
+	Optional bool
+	Doc      []string
+}
+
+// tsMethod is a client method calling an endpoint over HTTP. Path is the
+// body of a template literal, Query the object literal of the query
+// parameters and Body the expression sent as JSON body, if any.
+type tsMethod struct {
+	Name       string
+	Endpoint   string
+	Doc        []string
+	Params     string
+	Result     string
+	HTTPMethod string
+	Path       string
+	Query      string
+	Body       string
+	Stream     bool
+	Skip       string
+}
+
+// generateTypeScript generates the TypeScript declarations of the types
+// used by the methods of info and a client calling them with fetch.
+func (g *Generator) generateTypeScript(info *InterfaceInfo) ([]byte, error) {
+	ts := &tsFile{
+		TypeName: info.TypeName,
+		types:    make(map[string]*ast.TypeSpec),
+	}
+	for _, st := range info.sourceTypes {
+		ts.types[st.spec.Name.Name] = st.spec
+	}
+
+	used := make(map[string]bool)
+	for _, m := range info.Methods {
+		ts.use(m.requestExpr, used)
+		ts.use(m.responseExpr, used)
+	}
+	for _, st := range info.sourceTypes {
+		if used[st.spec.Name.Name] {
+			ts.Types = append(ts.Types, ts.decl(st))
+		}
+	}
+
+	for _, m := range info.Methods {
+		ts.Methods = append(ts.Methods, ts.method(m))
+	}
+
+	tmpl, err := template.New("ts").Funcs(template.FuncMap{
+		"jsdoc": jsDoc,
+	}).Parse(tsTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, ts); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// use marks the source types referred to by expr, and by the types they
+// refer to, as used.
+func (ts *tsFile) use(expr ast.Expr, used map[string]bool) {
+	if expr == nil {
+		return
+	}
+	ast.Inspect(expr, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.SelectorExpr:
+			return false
+		case *ast.Ident:
+			if spec, ok := ts.types[n.Name]; ok && !used[n.Name] {
+				used[n.Name] = true
+				ts.use(spec.Type, used)
+			}
+		}
+		return true
+	})
+}
+
+func (ts *tsFile) decl(st sourceType) tsDecl {
+	d := tsDecl{
+		Name: st.spec.Name.Name,
+		Doc:  docLines(st.doc),
+	}
+	if s, ok := st.spec.Type.(*ast.StructType); ok {
+		var extends []string
+		d.Fields, extends = ts.fields(s)
+		d.Extends = strings.Join(extends, ", ")
+	} else {
+		d.Alias = ts.typeOf(st.spec.Type)
+	}
+	return d
+}
+
+// fields returns the fields of s as encoded by encoding/json, and the
+// source types embedded in s whose fields are promoted.
+func (ts *tsFile) fields(s *ast.StructType) (fields []tsField, extends []string) {
+	for _, f := range s.Fields.List {
+		name, opts := jsonTag(f.Tag)
+		if name == "-" && opts == "" {
+			continue
+		}
+
+		typ := ts.typeOf(f.Type)
+		if strings.Contains(","+opts+",", ",string,") && (typ == "number" || typ == "boolean") {
+			typ = "string"
+		}
+		optional := strings.Contains(","+opts+",", ",omitempty,") || strings.Contains(","+opts+",", ",omitzero,")
+		if _, ok := f.Type.(*ast.StarExpr); ok && !optional {
+			typ += " | null"
+		}
+
+		doc := docLines(f.Doc)
+		if doc == nil {
+			doc = docLines(f.Comment)
+		}
+
+		names := f.Names
+		if len(names) == 0 {
+			embedded := f.Type
+			if star, ok := embedded.(*ast.StarExpr); ok {
+				embedded = star.X
+			}
+			ident, ok := embedded.(*ast.Ident)
+			if !ok {
+				continue
+			}
+			if name == "" {
+				if _, ok := ts.types[ident.Name]; ok {
+					extends = append(extends, ident.Name)
+				}
+				continue
+			}
+			names = []*ast.Ident{ident}
+		}
+
+		for _, n := range names {
+			if !n.IsExported() && len(f.Names) > 0 {
+				continue
+			}
+			fieldName := name
+			if fieldName == "" {
+				fieldName = n.Name
+			}
+			fields = append(fields, tsField{
+				Name:     tsPropName(fieldName),
+				Type:     typ,
+				Optional: optional,
+				Doc:      doc,
+			})
+		}
+	}
+	return fields, extends
+}
+
+// typeOf returns the TypeScript type of the JSON encoding of expr.
+func (ts *tsFile) typeOf(expr ast.Expr) string {
+	switch t := expr.(type) {
+	case *ast.Ident:
+		switch t.Name {
+		case "bool":
+			return "boolean"
+		case "string":
+			return "string"
+		case "int", "int8", "int16", "int32", "int64",
+			"uint", "uint8", "uint16", "uint32", "uint64", "uintptr",
+			"float32", "float64", "byte", "rune":
+			return "number"
+		}
+		if _, ok := ts.types[t.Name]; ok {
+			return t.Name
+		}
+		return "unknown"
+	case *ast.StarExpr:
+		return ts.typeOf(t.X)
+	case *ast.ParenExpr:
+		return ts.typeOf(t.X)
+	case *ast.ChanType:
+		return ts.typeOf(t.Value)
+	case *ast.ArrayType:
+		if ident, ok := t.Elt.(*ast.Ident); ok && ident.Name == "byte" && t.Len == nil {
+			return "string" // base64
+		}
+		elem := ts.typeOf(t.Elt)
+		if strings.ContainsAny(elem, " |") {
+			elem = "(" + elem + ")"
+		}
+		return elem + "[]"
+	case *ast.MapType:
+		return "Record<string, " + ts.typeOf(t.Value) + ">"
+	case *ast.SelectorExpr:
+		switch typeToString(t) {
+		case "time.Time":
+			return "string"
+		case "time.Duration":
+			return "number"
+		}
+		return "unknown"
+	case *ast.StructType:
+		fields, _ := ts.fields(t)
+		var b strings.Builder
+		b.WriteString("{")
+		for _, f := range fields {
+			b.WriteString(" " + f.Name)
+			if f.Optional {
+				b.WriteString("?")
+			}
+			b.WriteString(": " + f.Type + ";")
+		}
+		b.WriteString(" }")
+		return b.String()
+	}
+	return "unknown"
+}
+
+// tsParamRegex matches the {param} patterns of endpoint paths.
+var tsParamRegex = regexp.MustCompile(`\{(\w+)\}`)
+
+// method returns the client method of m. As the HTTP transport does, path
+// parameters are filled from the request fields tagged path, or {id} from
+// a simple request, and query parameters from the fields tagged query or,
+// without a request body, from all the remaining ones.
+func (ts *tsFile) method(m MethodInfo) tsMethod {
+	tm := tsMethod{
+		Name:       lowerCamel(m.Name),
+		Endpoint:   m.Name,
+		HTTPMethod: m.HTTPMethod,
+		Query:      "undefined",
+		Body:       "undefined",
+	}
+	for _, c := range m.Comments {
+		line := strings.TrimSpace(strings.TrimPrefix(c, "//"))
+		if !strings.HasPrefix(line, "@talk") {
+			tm.Doc = append(tm.Doc, line)
+		}
+	}
+
+	switch m.StreamMode {
+	case talk.StreamClientSide, talk.StreamBidirect:
+		tm.Skip = "fetch cannot stream requests"
+		return tm
+	case talk.StreamServerSide:
+		tm.Stream = true
+	}
+
+	result := "void"
+	if m.HasResponse {
+		result = ts.typeOf(m.responseExpr)
+	}
+	if tm.Stream {
+		tm.Result = "AsyncGenerator<" + result + ">"
+	} else {
+		tm.Result = "Promise<" + result + ">"
+	}
+
+	if !m.HasRequest {
+		tm.Path = m.Path
+		return tm
+	}
+	tm.Params = "req: " + ts.typeOf(m.requestExpr) + ", "
+
+	var fields []*ast.Field
+	reqType := m.requestExpr
+	if star, ok := reqType.(*ast.StarExpr); ok {
+		reqType = star.X
+	}
+	if ident, ok := reqType.(*ast.Ident); ok {
+		if spec, ok := ts.types[ident.Name]; ok {
+			if s, ok := spec.Type.(*ast.StructType); ok {
+				fields = s.Fields.List
+			}
+		}
+	}
+
+	bodyless := tm.HTTPMethod == "GET" || tm.HTTPMethod == "DELETE" || tm.HTTPMethod == "HEAD" || tm.HTTPMethod == "OPTIONS"
+	if !bodyless {
+		tm.Body = "req"
+	}
+
+	bound := make(map[string]bool)
+	tm.Path = tsParamRegex.ReplaceAllStringFunc(m.Path, func(p string) string {
+		param := p[1 : len(p)-1]
+		if fields == nil {
+			if param == "id" && ts.typeOf(m.requestExpr) != "unknown" {
+				return "${encodeURIComponent(String(req))}"
+			}
+			return p
+		}
+		for _, f := range fields {
+			if len(f.Names) == 0 || tagOf(f.Tag, "path") != param {
+				continue
+			}
+			name, _ := jsonTag(f.Tag)
+			if name == "" {
+				name = f.Names[0].Name
+			}
+			bound[name] = true
+			return "${encodeURIComponent(String(req" + tsAccess(name) + "))}"
+		}
+		return p
+	})
+
+	var query []string
+	for _, f := range fields {
+		if len(f.Names) == 0 || !f.Names[0].IsExported() {
+			continue
+		}
+		name, _ := jsonTag(f.Tag)
+		if name == "" {
+			name = f.Names[0].Name
+		}
+		key := tagOf(f.Tag, "query")
+		if key == "" || key == "-" {
+			if !bodyless || bound[name] {
+				continue
+			}
+			key = name
+		}
+		if key == "" || key == "-" {
+			continue
+		}
+		query = append(query, tsPropName(key)+": req"+tsAccess(name))
+	}
+	if len(query) > 0 {
+		tm.Query = "{ " + strings.Join(query, ", ") + " }"
+	}
+	return tm
+}
+
+// jsonTag returns the name and options of the json tag of a field.
+func jsonTag(tag *ast.BasicLit) (name, opts string) {
+	name, opts, _ = strings.Cut(tagOf(tag, "json"), ",")
+	return name, opts
+}
+
+// tagOf returns the value of key in the tag of a field.
+func tagOf(tag *ast.BasicLit, key string) string {
+	if tag == nil {
+		return ""
+	}
+	s, err := strconv.Unquote(tag.Value)
+	if err != nil {
+		return ""
+	}
+	return reflect.StructTag(s).Get(key)
+}
+
+// docLines returns the text of the lines of a comment group.
+func docLines(doc *ast.CommentGroup) []string {
+	if doc == nil {
+		return nil
+	}
+	return strings.Split(strings.TrimSpace(doc.Text()), "\n")
+}
+
+// jsDoc returns lines as a doc comment indented by indent, or "".
+func jsDoc(lines []string, indent string) string {
+	if len(lines) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString(indent + "/**\n")
+	for _, line := range lines {
+		b.WriteString(strings.TrimRight(indent+" * "+line, " ") + "\n")
+	}
+	b.WriteString(indent + " */\n")
+	return b.String()
+}
+
+var tsIdentRegex = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+
+// tsPropName returns name as a property name of an object type or literal.
+func tsPropName(name string) string {
+	if tsIdentRegex.MatchString(name) {
+		return name
+	}
+	return strconv.Quote(name)
+}
+
+// tsAccess returns the property access of name.
+func tsAccess(name string) string {
+	if tsIdentRegex.MatchString(name) {
+		return "." + name
+	}
+	return "[" + strconv.Quote(name) + "]"
+}
+
+// lowerCamel lowers the leading upper case letters of name, but the last
+// one of an initialism followed by a word: GetUser -> getUser, URLFor ->
+// urlFor, ID -> id.
+func lowerCamel(name string) string {
+	runes := []rune(name)
+	for i := 0; i < len(runes) && unicode.IsUpper(runes[i]); i++ {
+		if i > 0 && i+1 < len(runes) && unicode.IsLower(runes[i+1]) {
+			break
+		}
+		runes[i] = unicode.ToLower(runes[i])
+	}
+	return string(runes)
+}
+
+// tsTemplate generates the declarations of the types, followed by the
+// client and the helpers it uses to call the endpoints and read the
+// server-sent events of streams.
+const tsTemplate = `// Code generated by go.zoe.im/x/talk/gen. DO NOT EDIT.
+{{range .Types}}
+{{jsdoc .Doc ""}}{{if .Alias}}export type {{.Name}} = {{.Alias}};
+{{else}}export interface {{.Name}}{{if .Extends}} extends {{.Extends}}{{end}} {
+{{range .Fields}}{{jsdoc .Doc "  "}}  {{.Name}}{{if .Optional}}?{{end}}: {{.Type}};
+{{end}}}
+{{end}}{{end}}
+/** TalkError is an error returned by an endpoint. */
+export class TalkError extends Error {
+  constructor(
+    readonly code: number,
+    message: string,
+    readonly details?: unknown,
+    readonly typedDetails?: unknown[],
+  ) {
+    super(message);
+    this.name = "TalkError";
+  }
+}
+
+export interface ClientOptions {
+  /** baseUrl is prepended to the paths of the endpoints. */
+  baseUrl?: string;
+  /** headers are sent with every request. */
+  headers?: Record<string, string>;
+  /** fetch replaces the global fetch, e.g. to add credentials. */
+  fetch?: typeof fetch;
+}
+
+/** {{.TypeName}}Client calls the endpoints of {{.TypeName}} over HTTP. */
+export class {{.TypeName}}Client {
+  constructor(private readonly options: ClientOptions = {}) {}
+{{range .Methods}}
+{{if .Skip}}  // {{.Endpoint}} is not generated: {{.Skip}}.
+{{else}}{{jsdoc .Doc "  "}}  {{.Name}}({{.Params}}init?: RequestInit): {{.Result}} {
+{{if .Stream}}    return this.streamEndpoint("{{.HTTPMethod}}", ` + "`{{.Path}}`" + `, {{.Query}}, {{.Body}}, init);
+{{else}}    return this.callEndpoint("{{.HTTPMethod}}", ` + "`{{.Path}}`" + `, {{.Query}}, {{.Body}}, init);
+{{end}}  }
+{{end}}{{end}}
+  private async callEndpoint<T>(
+    method: string,
+    path: string,
+    query: Record<string, unknown> | undefined,
+    body: unknown,
+    init?: RequestInit,
+  ): Promise<T> {
+    const resp = await this.fetchEndpoint(method, path, query, body, "application/json", init);
+    const text = await resp.text();
+    return (text ? JSON.parse(text) : undefined) as T;
+  }
+
+  private async *streamEndpoint<T>(
+    method: string,
+    path: string,
+    query: Record<string, unknown> | undefined,
+    body: unknown,
+    init?: RequestInit,
+  ): AsyncGenerator<T> {
+    const resp = await this.fetchEndpoint(method, path, query, body, "text/event-stream", init);
+    yield* readEvents<T>(resp);
+  }
+
+  private async fetchEndpoint(
+    method: string,
+    path: string,
+    query: Record<string, unknown> | undefined,
+    body: unknown,
+    accept: string,
+    init?: RequestInit,
+  ): Promise<Response> {
+    const params = new URLSearchParams();
+    for (const [key, value] of Object.entries(query ?? {})) {
+      if (value !== undefined && value !== null) {
+        params.append(key, String(value));
+      }
+    }
+    const search = params.toString();
+    const url = (this.options.baseUrl ?? "") + path + (search ? "?" + search : "");
+
+    const headers = new Headers(this.options.headers);
+    new Headers(init?.headers).forEach((value, key) => headers.set(key, value));
+    headers.set("Accept", accept);
+    if (body !== undefined) {
+      headers.set("Content-Type", "application/json");
+    }
+
+    const resp = await (this.options.fetch ?? fetch)(url, {
+      ...init,
+      method,
+      headers,
+      body: body === undefined ? undefined : JSON.stringify(body),
+    });
+    if (!resp.ok) {
+      throw await responseError(resp);
+    }
+    return resp;
+  }
+}
+
+/** responseError returns the error of a failed response. */
+async function responseError(resp: Response): Promise<TalkError> {
+  const text = await resp.text();
+  try {
+    const err = JSON.parse(text);
+    if (typeof err.code === "number") {
+      return new TalkError(err.code, err.message, err.details, err.typed_details);
+    }
+  } catch {
+    // not a talk error
+  }
+  return new TalkError(2, text || resp.statusText);
+}
+
+/**
+ * readEvents yields the data of the server-sent events of resp until the end
+ * event, and throws the error events as TalkError.
+ */
+async function* readEvents<T>(resp: Response): AsyncGenerator<T> {
+  if (!resp.body) {
+    return;
+  }
+  const reader = resp.body.pipeThrough(new TextDecoderStream()).getReader();
+  let buffer = "";
+  let event = "";
+  let data: string[] = [];
+  try {
+    for (;;) {
+      const { value, done } = await reader.read();
+      if (done) {
+        return;
+      }
+      buffer += value;
+      let i: number;
+      while ((i = buffer.indexOf("\n")) >= 0) {
+        const line = buffer.slice(0, i).replace(/\r$/, "");
+        buffer = buffer.slice(i + 1);
+        if (line === "") {
+          if (data.length > 0) {
+            const payload = JSON.parse(data.join("\n"));
+            if (event === "end") {
+              return;
+            }
+            if (event === "error") {
+              throw new TalkError(payload.code, payload.message, payload.details, payload.typed_details);
+            }
+            yield payload as T;
+          }
+          event = "";
+          data = [];
+        } else if (line.startsWith("data:")) {
+          data.push(line.slice(5).replace(/^ /, ""));
+        } else if (line.startsWith("event:")) {
+          event = line.slice(6).trim();
+        }
+      }
+    }
+  } finally {
+    await reader.cancel();
+  }
+}
+`
@@ -55,9 +55,11 @@ func (g *Group) Register(service any, opts ...RegisterOption) error {
 		combined = append(combined, g.middleware...)
 		combined = append(combined, ep.Middleware...)
 		ep.Middleware = combined
+		g.applyStreamMiddleware(ep)
 	}
 
 	g.server.endpoints = append(g.server.endpoints, endpoints...)
+	g.server.services = append(g.server.services, service)
 	return nil
 }
 
@@ -70,10 +72,22 @@ func (g *Group) RegisterEndpoints(endpoints ...*Endpoint) {
 		combined = append(combined, g.middleware...)
 		combined = append(combined, ep.Middleware...)
 		ep.Middleware = combined
+		g.applyStreamMiddleware(ep)
 	}
 	g.server.endpoints = append(g.server.endpoints, endpoints...)
 }
 
+// applyStreamMiddleware prepends the server's stream middleware to ep.
+func (g *Group) applyStreamMiddleware(ep *Endpoint) {
+	if len(g.server.streamMiddleware) == 0 {
+		return
+	}
+	combined := make([]StreamMiddlewareFunc, 0, len(g.server.streamMiddleware)+len(ep.StreamMiddleware))
+	combined = append(combined, g.server.streamMiddleware...)
+	combined = append(combined, ep.StreamMiddleware...)
+	ep.StreamMiddleware = combined
+}
+
 // Group creates a nested sub-group.
 func (g *Group) Group(prefix string, mw ...MiddlewareFunc) *Group {
 	combined := make([]MiddlewareFunc, 0, len(g.middleware)+len(mw))
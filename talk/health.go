@@ -0,0 +1,124 @@
+package talk
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"go.zoe.im/x"
+)
+
+// Paths of the endpoints registered by WithHealthEndpoints.
+const (
+	HealthPath    = "/healthz"
+	ReadyPath     = "/readyz"
+	EndpointsPath = "/_talk/endpoints"
+)
+
+// HealthMetadataKey marks the health endpoints in Endpoint.Metadata with
+// "liveness" or "readiness", so that transports with a native health
+// protocol, such as grpc.health.v1, can serve it from them.
+const HealthMetadataKey = "talk.health"
+
+// Serving states reported in HealthStatus.
+const (
+	HealthServing    = "SERVING"
+	HealthNotServing = "NOT_SERVING"
+)
+
+// HealthStatus is the response of the health endpoints. When the server is
+// unhealthy it is attached as a typed error detail to an Unavailable error
+// instead, so that ErrorDetail[HealthStatus] finds it after a round trip.
+type HealthStatus struct {
+	Status string `json:"status"`
+	// Checks maps each failing service to its error.
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+func (h HealthStatus) Error() string {
+	if len(h.Checks) == 0 {
+		return h.Status
+	}
+	names := make([]string, 0, len(h.Checks))
+	for name := range h.Checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	msgs := make([]string, len(names))
+	for i, name := range names {
+		msgs[i] = name + ": " + h.Checks[name]
+	}
+	return h.Status + ": " + strings.Join(msgs, "; ")
+}
+
+func init() {
+	RegisterErrorDetail("talk.HealthStatus", HealthStatus{})
+}
+
+// WithHealthEndpoints makes the server expose, next to its own endpoints:
+//
+//	GET /healthz          liveness: TryHealthy over all registered services
+//	GET /readyz           readiness: serving and healthy
+//	GET /_talk/endpoints  the registered endpoints and their schemas
+//
+// Unhealthy servers answer with Unavailable (503 over HTTP). The grpc
+// transport additionally serves the grpc.health.v1 service. The endpoints
+// bypass server middleware so that probes need no credentials.
+func WithHealthEndpoints() ServerOption {
+	return func(s *Server) {
+		s.health = true
+	}
+}
+
+// Healthy calls x.TryHealthy on every registered service and reports the
+// failing ones. It makes Server itself an x.HealthChecker.
+func (s *Server) Healthy(ctx context.Context) error {
+	checks := map[string]string{}
+	for _, svc := range s.services {
+		if err := x.TryHealthy(ctx, svc); err != nil {
+			checks[serviceName(svc)] = err.Error()
+		}
+	}
+	if len(checks) > 0 {
+		status := HealthStatus{Status: HealthNotServing, Checks: checks}
+		return NewErrorWithTypedDetails(Unavailable, status.Error(), status)
+	}
+	return nil
+}
+
+// Ready reports whether the server is serving and healthy.
+func (s *Server) Ready(ctx context.Context) error {
+	if !s.ready.Load() {
+		status := HealthStatus{Status: HealthNotServing}
+		return NewErrorWithTypedDetails(Unavailable, "server is not serving", status)
+	}
+	return s.Healthy(ctx)
+}
+
+// healthEndpoints returns the endpoints added by WithHealthEndpoints.
+func (s *Server) healthEndpoints() []*Endpoint {
+	check := func(fn func(context.Context) error) EndpointFunc {
+		return func(ctx context.Context, req any) (any, error) {
+			if err := fn(ctx); err != nil {
+				return nil, err
+			}
+			return &HealthStatus{Status: HealthServing}, nil
+		}
+	}
+
+	return []*Endpoint{
+		NewEndpoint("Healthz", check(s.Healthy),
+			WithPath(HealthPath), WithMethod(http.MethodGet), WithMetadata(HealthMetadataKey, "liveness")),
+		NewEndpoint("Readyz", check(s.Ready),
+			WithPath(ReadyPath), WithMethod(http.MethodGet), WithMetadata(HealthMetadataKey, "readiness")),
+		NewEndpoint("TalkEndpoints", func(ctx context.Context, req any) (any, error) {
+			return DescribeEndpoints(s.endpoints), nil
+		}, WithPath(EndpointsPath), WithMethod(http.MethodGet)),
+	}
+}
+
+func serviceName(svc any) string {
+	return strings.TrimPrefix(fmt.Sprintf("%T", svc), "*")
+}
@@ -0,0 +1,159 @@
+package talk
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type healthService struct {
+	err error
+}
+
+func (s *healthService) Healthy(ctx context.Context) error {
+	return s.err
+}
+
+// serveHealth serves server on a mock transport and returns the endpoints
+// the transport received, keyed by name. Serving stops when the test ends.
+func serveHealth(t *testing.T, server *Server) map[string]*Endpoint {
+	t.Helper()
+	got := make(chan []*Endpoint, 1)
+	server.transport = &mockTransport{
+		serveFunc: func(ctx context.Context, endpoints []*Endpoint) error {
+			got <- endpoints
+			<-ctx.Done()
+			return nil
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go server.Serve(ctx)
+
+	byName := map[string]*Endpoint{}
+	select {
+	case endpoints := <-got:
+		for _, ep := range endpoints {
+			byName[ep.Name] = ep
+		}
+	case <-time.After(time.Second):
+		t.Fatal("transport.Serve was not called")
+	}
+	return byName
+}
+
+func TestWithHealthEndpoints(t *testing.T) {
+	svc := &healthService{}
+	server := NewServer(nil, WithHealthEndpoints(), WithExtractor(&mockExtractor{
+		endpoints: []*Endpoint{NewEndpoint("Ping", nil)},
+	}))
+	if err := server.Register(svc); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := server.Ready(context.Background()); ToError(err).Code != Unavailable {
+		t.Errorf("Ready before Serve = %v, want Unavailable", err)
+	}
+
+	endpoints := serveHealth(t, server)
+	for name, path := range map[string]string{"Healthz": HealthPath, "Readyz": ReadyPath, "TalkEndpoints": EndpointsPath} {
+		ep, ok := endpoints[name]
+		if !ok || ep.Path != path || ep.Method != "GET" {
+			t.Errorf("%s endpoint = %+v, want GET %s", name, ep, path)
+		}
+	}
+	if len(server.Endpoints()) != 1 {
+		t.Errorf("health endpoints leaked into Endpoints(): %d", len(server.Endpoints()))
+	}
+
+	resp, err := endpoints["Readyz"].Handler(context.Background(), nil)
+	if err != nil || resp.(*HealthStatus).Status != HealthServing {
+		t.Errorf("Readyz = %v, %v, want SERVING", resp, err)
+	}
+
+	svc.err = errors.New("database down")
+	for _, name := range []string{"Healthz", "Readyz"} {
+		_, err := endpoints[name].Handler(context.Background(), nil)
+		var status HealthStatus
+		if ToError(err).Code != Unavailable || !errors.As(err, &status) {
+			t.Fatalf("%s = %v, want Unavailable with HealthStatus", name, err)
+		}
+		if status.Checks["talk.healthService"] != "database down" {
+			t.Errorf("%s checks = %v", name, status.Checks)
+		}
+
+		// The status survives the JSON round trip of HTTP transports.
+		data, _ := json.Marshal(ToError(err))
+		var decoded Error
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Fatal(err)
+		}
+		if got, ok := ErrorDetail[HealthStatus](&decoded); !ok || got.Checks["talk.healthService"] != "database down" {
+			t.Errorf("%s decoded detail = %+v, %v", name, got, ok)
+		}
+	}
+
+	resp, err = endpoints["TalkEndpoints"].Handler(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if infos := resp.([]EndpointInfo); len(infos) != 1 || infos[0].Name != "Ping" {
+		t.Errorf("TalkEndpoints = %+v, want only Ping", infos)
+	}
+}
+
+func TestWithHealthEndpoints_Disabled(t *testing.T) {
+	endpoints := serveHealth(t, NewServer(nil))
+	if len(endpoints) != 0 {
+		t.Errorf("endpoints = %v, want none without WithHealthEndpoints", endpoints)
+	}
+}
+
+type reflectNode struct {
+	Name     string         `json:"name"`
+	Children []*reflectNode `json:"children,omitempty"`
+	Created  time.Time      `json:"created"`
+	Labels   map[string]int `json:"labels"`
+	Data     []byte         `json:"data"`
+	Extra    any            `json:"extra"`
+	Skipped  string         `json:"-"`
+	internal string
+}
+
+func TestDescribeType(t *testing.T) {
+	schema := DescribeType(reflect.TypeOf(&reflectNode{}))
+
+	if schema.Type != "object" || schema.GoType != "talk.reflectNode" {
+		t.Fatalf("schema = %+v", schema)
+	}
+	props := schema.Properties
+	if len(props) != 6 {
+		t.Errorf("properties = %v, want 6", props)
+	}
+	if props["name"].Type != "string" {
+		t.Errorf("name = %+v", props["name"])
+	}
+	if c := props["children"]; c.Type != "array" || c.Items.GoType != "talk.reflectNode" || c.Items.Properties != nil {
+		t.Errorf("children = %+v, want array of a type reference", c)
+	}
+	if c := props["created"]; c.Type != "string" || c.Format != "date-time" {
+		t.Errorf("created = %+v", c)
+	}
+	if l := props["labels"]; l.Type != "object" || l.AdditionalProperties.Type != "integer" {
+		t.Errorf("labels = %+v", l)
+	}
+	if d := props["data"]; d.Type != "string" || d.Format != "byte" {
+		t.Errorf("data = %+v", d)
+	}
+	if e := props["extra"]; e.Type != "" {
+		t.Errorf("extra = %+v, want no type", e)
+	}
+
+	if DescribeType(nil) != nil {
+		t.Error("DescribeType(nil) should be nil")
+	}
+}
@@ -0,0 +1,239 @@
+package talk
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.zoe.im/x"
+)
+
+// errDraining is the cancellation cause of streams ended by Shutdown.
+var errDraining = errors.New("talk: server is shutting down")
+
+func shuttingDownError() *Error {
+	return NewError(Unavailable, "server is shutting down")
+}
+
+// initServices calls x.TryInit on the registered services in registration
+// order. If one fails, the services initialized before it are closed again.
+func (s *Server) initServices(ctx context.Context) error {
+	s.initMu.Lock()
+	defer s.initMu.Unlock()
+
+	s.inited = s.inited[:0]
+	for _, svc := range s.services {
+		if err := x.TryInit(ctx, svc); err != nil {
+			closeErr := s.closeInited(ctx)
+			return errors.Join(NewErrorf(Internal, "init %s: %v", serviceName(svc), err), closeErr)
+		}
+		s.inited = append(s.inited, svc)
+	}
+	return nil
+}
+
+// closeServices calls x.TryClose on the initialized services in reverse
+// order.
+func (s *Server) closeServices(ctx context.Context) error {
+	s.initMu.Lock()
+	defer s.initMu.Unlock()
+	return s.closeInited(ctx)
+}
+
+// closeInited is closeServices with s.initMu held.
+func (s *Server) closeInited(ctx context.Context) error {
+	var errs []error
+	for i := len(s.inited) - 1; i >= 0; i-- {
+		if err := x.TryClose(ctx, s.inited[i]); err != nil {
+			errs = append(errs, NewErrorf(Internal, "close %s: %v", serviceName(s.inited[i]), err))
+		}
+	}
+	s.inited = s.inited[:0]
+	return errors.Join(errs...)
+}
+
+// Run serves until SIGINT or SIGTERM, then shuts the server down, giving
+// in-flight calls up to timeout to finish. It is built on x.GraceRunner.
+func (s *Server) Run(timeout time.Duration) error {
+	var shutdownErr error
+	runner := x.NewGraceRunner()
+	runner.RegisterCleanup(func() {
+		shutdownErr = s.shutdownWithin(timeout)
+	})
+	err := runner.Run(func() error {
+		return s.Serve(runner.Context())
+	})
+	return errors.Join(err, shutdownErr)
+}
+
+// GraceCleanup returns a cleanup that shuts the server down within timeout,
+// for servers sharing an x.GraceRunner with other components:
+//
+//	runner.RegisterCleanup(server.GraceCleanup(10 * time.Second))
+//	runner.Run(func() error { return server.Serve(runner.Context()) })
+//
+// Shutdown errors are dropped; use Run or Shutdown to observe them.
+func (s *Server) GraceCleanup(timeout time.Duration) x.CleanupFunc {
+	return func() {
+		s.shutdownWithin(timeout)
+	}
+}
+
+func (s *Server) shutdownWithin(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return s.Shutdown(ctx)
+}
+
+// drainer tracks the calls in flight on a serving Server, so that Shutdown
+// can reject new calls, wait for unary calls and end open streams.
+type drainer struct {
+	mu       sync.Mutex
+	draining bool
+	unary    sync.WaitGroup
+	streams  sync.WaitGroup
+	cancels  map[int]func()
+	nextID   int
+}
+
+// reset makes the drainer accept calls again.
+func (d *drainer) reset() {
+	d.mu.Lock()
+	d.draining = false
+	d.mu.Unlock()
+}
+
+// wrap returns clones of endpoints with the draining middleware as their
+// outermost middleware.
+func (d *drainer) wrap(endpoints []*Endpoint) []*Endpoint {
+	wrapped := make([]*Endpoint, len(endpoints))
+	for i, ep := range endpoints {
+		ep = ep.Clone()
+		ep.Middleware = append([]MiddlewareFunc{d.middleware}, ep.Middleware...)
+		ep.StreamMiddleware = append([]StreamMiddlewareFunc{d.streamMiddleware}, ep.StreamMiddleware...)
+		wrapped[i] = ep
+	}
+	return wrapped
+}
+
+func (d *drainer) middleware(next EndpointFunc) EndpointFunc {
+	return func(ctx context.Context, req any) (any, error) {
+		d.mu.Lock()
+		if d.draining {
+			d.mu.Unlock()
+			return nil, shuttingDownError()
+		}
+		d.unary.Add(1)
+		d.mu.Unlock()
+		defer d.unary.Done()
+
+		return next(ctx, req)
+	}
+}
+
+func (d *drainer) streamMiddleware(next StreamEndpointFunc) StreamEndpointFunc {
+	return func(ctx context.Context, req any, stream Stream) error {
+		ctx, cancel := context.WithCancelCause(ctx)
+		defer cancel(nil)
+
+		d.mu.Lock()
+		if d.draining {
+			d.mu.Unlock()
+			return shuttingDownError()
+		}
+		if d.cancels == nil {
+			d.cancels = make(map[int]func())
+		}
+		id := d.nextID
+		d.nextID++
+		d.cancels[id] = func() {
+			cancel(errDraining)
+			if cs, ok := stream.(CancelableStream); ok {
+				cs.Cancel()
+			}
+		}
+		d.streams.Add(1)
+		d.mu.Unlock()
+
+		defer func() {
+			d.mu.Lock()
+			delete(d.cancels, id)
+			d.mu.Unlock()
+			d.streams.Done()
+		}()
+
+		err := next(ctx, req, withStreamContext(ctx, stream))
+		if errors.Is(context.Cause(ctx), errDraining) {
+			// Tell the peer why the stream ended, e.g. as an SSE error
+			// event or a gRPC status, so that it can reconnect elsewhere.
+			return shuttingDownError()
+		}
+		return err
+	}
+}
+
+// drain rejects new calls, waits for the unary calls in flight, then
+// cancels open streams and waits for their handlers to return. Cancelling a
+// CancelableStream also ends its pending Recv. drain gives up waiting when
+// ctx is done, but cancels the streams in any case.
+func (d *drainer) drain(ctx context.Context) error {
+	d.mu.Lock()
+	d.draining = true
+	d.mu.Unlock()
+
+	err := waitGroup(ctx, &d.unary)
+
+	d.mu.Lock()
+	for _, cancel := range d.cancels {
+		cancel()
+	}
+	d.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+	return waitGroup(ctx, &d.streams)
+}
+
+func waitGroup(ctx context.Context, wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// withStreamContext returns stream with its Context replaced by ctx,
+// keeping the ServerStream methods of the original stream.
+func withStreamContext(ctx context.Context, stream Stream) Stream {
+	base := &contextStream{Stream: stream, ctx: ctx}
+	if ss, ok := stream.(ServerStream); ok {
+		return &contextServerStream{contextStream: base, ss: ss}
+	}
+	return base
+}
+
+type contextStream struct {
+	Stream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
+
+type contextServerStream struct {
+	*contextStream
+	ss ServerStream
+}
+
+func (s *contextServerStream) SendHeader(metadata map[string]string) error {
+	return s.ss.SendHeader(metadata)
+}
@@ -0,0 +1,212 @@
+package talk
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type lifecycleService struct {
+	name    string
+	log     *[]string
+	initErr error
+}
+
+func (s *lifecycleService) Init(ctx context.Context) error {
+	*s.log = append(*s.log, "init "+s.name)
+	return s.initErr
+}
+
+func (s *lifecycleService) Close(ctx context.Context) error {
+	*s.log = append(*s.log, "close "+s.name)
+	return nil
+}
+
+func registerAll(t *testing.T, server *Server, services ...any) {
+	t.Helper()
+	for _, svc := range services {
+		if err := server.Register(svc); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestServer_Lifecycle(t *testing.T) {
+	var log []string
+	server := NewServer(&mockTransport{}, WithExtractor(&mockExtractor{}))
+	registerAll(t, server, &lifecycleService{name: "a", log: &log}, &lifecycleService{name: "b", log: &log})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	server.Serve(ctx)
+	if err := server.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+
+	want := []string{"init a", "init b", "close b", "close a"}
+	if len(log) != len(want) {
+		t.Fatalf("log = %v, want %v", log, want)
+	}
+	for i := range want {
+		if log[i] != want[i] {
+			t.Fatalf("log = %v, want %v", log, want)
+		}
+	}
+}
+
+func TestServer_LifecycleInitError(t *testing.T) {
+	var log []string
+	served := false
+	server := NewServer(&mockTransport{
+		serveFunc: func(ctx context.Context, endpoints []*Endpoint) error {
+			served = true
+			return nil
+		},
+	}, WithExtractor(&mockExtractor{}))
+	registerAll(t, server,
+		&lifecycleService{name: "a", log: &log},
+		&lifecycleService{name: "b", log: &log, initErr: errors.New("no database")})
+
+	err := server.Serve(context.Background())
+	if err == nil || served {
+		t.Fatalf("Serve = %v, served = %v; want init error before serving", err, served)
+	}
+	if len(log) != 3 || log[2] != "close a" {
+		t.Errorf("log = %v, want a closed after b failed", log)
+	}
+}
+
+func TestServer_ShutdownDrains(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 2)
+	server := NewServer(nil)
+	server.RegisterEndpoints(
+		NewEndpoint("Slow", func(ctx context.Context, req any) (any, error) {
+			started <- struct{}{}
+			<-release
+			return "done", nil
+		}),
+		NewStreamEndpoint("Watch", func(ctx context.Context, req any, stream Stream) error {
+			started <- struct{}{}
+			<-stream.Context().Done()
+			return stream.Context().Err()
+		}, StreamServerSide),
+	)
+	endpoints := serveHealth(t, server)
+
+	type result struct {
+		resp any
+		err  error
+	}
+	unary := make(chan result, 1)
+	go func() {
+		resp, err := endpoints["Slow"].WrappedHandler()(context.Background(), nil)
+		unary <- result{resp, err}
+	}()
+	streamErr := make(chan error, 1)
+	go func() {
+		serverSide, _ := NewChanStreamPair[int](context.Background(), 1)
+		streamErr <- endpoints["Watch"].WrappedStreamHandler()(context.Background(), nil, serverSide)
+	}()
+	<-started
+	<-started
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- server.Shutdown(context.Background()) }()
+
+	// New calls are rejected while draining.
+	time.Sleep(20 * time.Millisecond)
+	if _, err := endpoints["Slow"].WrappedHandler()(context.Background(), nil); ToError(err).Code != Unavailable {
+		t.Errorf("call while draining = %v, want Unavailable", err)
+	}
+	if err := server.Ready(context.Background()); err == nil {
+		t.Error("server is still ready while draining")
+	}
+	select {
+	case err := <-streamErr:
+		t.Fatalf("stream ended before unary calls finished: %v", err)
+	case <-shutdown:
+		t.Fatal("Shutdown returned with a call in flight")
+	default:
+	}
+
+	close(release)
+	if r := <-unary; r.err != nil || r.resp != "done" {
+		t.Errorf("in-flight call = %v, %v, want done", r.resp, r.err)
+	}
+	if err := <-streamErr; ToError(err).Code != Unavailable {
+		t.Errorf("stream ended with %v, want Unavailable", err)
+	}
+	if err := <-shutdown; err != nil {
+		t.Errorf("Shutdown failed: %v", err)
+	}
+}
+
+func TestServer_ShutdownWakesRecv(t *testing.T) {
+	started := make(chan struct{})
+	server := NewServer(nil)
+	server.RegisterEndpoints(NewStreamEndpoint("Upload", func(ctx context.Context, req any, stream Stream) error {
+		close(started)
+		var n int
+		return stream.Recv(&n)
+	}, StreamClientSide))
+	endpoints := serveHealth(t, server)
+
+	// The client never sends, so the handler stays blocked in Recv.
+	serverSide, _ := NewChanStreamPair[int](context.Background(), 1)
+	streamErr := make(chan error, 1)
+	go func() {
+		streamErr <- endpoints["Upload"].WrappedStreamHandler()(context.Background(), nil, serverSide)
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		t.Errorf("Shutdown failed: %v", err)
+	}
+	if err := <-streamErr; ToError(err).Code != Unavailable {
+		t.Errorf("stream ended with %v, want Unavailable", err)
+	}
+}
+
+func TestServer_ShutdownDeadline(t *testing.T) {
+	stuck := make(chan struct{})
+	defer close(stuck)
+	server := NewServer(nil)
+	server.RegisterEndpoints(
+		NewEndpoint("Stuck", func(ctx context.Context, req any) (any, error) {
+			<-stuck
+			return nil, nil
+		}),
+		NewStreamEndpoint("Upload", func(ctx context.Context, req any, stream Stream) error {
+			var n int
+			return stream.Recv(&n)
+		}, StreamClientSide),
+	)
+	endpoints := serveHealth(t, server)
+	go endpoints["Stuck"].WrappedHandler()(context.Background(), nil)
+	serverSide, _ := NewChanStreamPair[int](context.Background(), 1)
+	streamErr := make(chan error, 1)
+	go func() {
+		streamErr <- endpoints["Upload"].WrappedStreamHandler()(context.Background(), nil, serverSide)
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := server.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shutdown = %v, want deadline exceeded", err)
+	}
+
+	// Streams are ended even though the unary call outlived the deadline.
+	select {
+	case err := <-streamErr:
+		if ToError(err).Code != Unavailable {
+			t.Errorf("stream ended with %v, want Unavailable", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("stream was not cancelled")
+	}
+}
@@ -0,0 +1,126 @@
+package talk
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// Metadata carries request-scoped key/value pairs such as trace IDs, auth
+// tokens or tenant IDs alongside a call. Keys are case-insensitive and stored
+// in lower case, so "Authorization" and "authorization" refer to the same
+// entry regardless of the transport that carried them.
+//
+// Transports map metadata to their native representation: HTTP headers for
+// http and unix, gRPC metadata for grpc, and an envelope field for websocket.
+type Metadata map[string]string
+
+// NewMetadata creates Metadata from a map, normalizing keys to lower case.
+func NewMetadata(m map[string]string) Metadata {
+	md := make(Metadata, len(m))
+	for k, v := range m {
+		md.Set(k, v)
+	}
+	return md
+}
+
+// MetadataPairs creates Metadata from alternating key/value strings.
+// A trailing key without a value is ignored.
+func MetadataPairs(kv ...string) Metadata {
+	md := make(Metadata, len(kv)/2)
+	for i := 0; i+1 < len(kv); i += 2 {
+		md.Set(kv[i], kv[i+1])
+	}
+	return md
+}
+
+// MetadataFromHeader converts HTTP headers to Metadata. Multiple values of
+// the same header are joined with ", ".
+func MetadataFromHeader(h http.Header) Metadata {
+	md := make(Metadata, len(h))
+	for k, v := range h {
+		if len(v) > 0 {
+			md.Set(k, strings.Join(v, ", "))
+		}
+	}
+	return md
+}
+
+// Get returns the value for key, or "" if it is not set.
+func (md Metadata) Get(key string) string {
+	return md[strings.ToLower(key)]
+}
+
+// Set sets the value for key.
+func (md Metadata) Set(key, value string) {
+	md[strings.ToLower(key)] = value
+}
+
+// Delete removes key.
+func (md Metadata) Delete(key string) {
+	delete(md, strings.ToLower(key))
+}
+
+// Clone returns a copy of the metadata.
+func (md Metadata) Clone() Metadata {
+	out := make(Metadata, len(md))
+	for k, v := range md {
+		out[k] = v
+	}
+	return out
+}
+
+// SetHeader writes the metadata into HTTP headers, replacing existing values.
+func (md Metadata) SetHeader(h http.Header) {
+	for k, v := range md {
+		h.Set(k, v)
+	}
+}
+
+// NewOutgoingContext returns a context carrying md as the metadata sent with
+// calls made by a client. It replaces any outgoing metadata already present.
+func NewOutgoingContext(ctx context.Context, md Metadata) context.Context {
+	return context.WithValue(ctx, ctxKeyOutgoingMetadata, md)
+}
+
+// AppendToOutgoingContext returns a context whose outgoing metadata is the
+// existing metadata extended with the given key/value pairs.
+func AppendToOutgoingContext(ctx context.Context, kv ...string) context.Context {
+	md, _ := FromOutgoingContext(ctx)
+	out := md.Clone()
+	for k, v := range MetadataPairs(kv...) {
+		out[k] = v
+	}
+	return NewOutgoingContext(ctx, out)
+}
+
+// FromOutgoingContext returns the outgoing metadata set on ctx, if any.
+// Transports call this when sending a request.
+func FromOutgoingContext(ctx context.Context) (Metadata, bool) {
+	md, ok := ctx.Value(ctxKeyOutgoingMetadata).(Metadata)
+	return md, ok && md != nil
+}
+
+// NewIncomingContext returns a context carrying md as the metadata received
+// with a request. Transports call this before invoking an endpoint handler.
+func NewIncomingContext(ctx context.Context, md Metadata) context.Context {
+	return context.WithValue(ctx, ctxKeyIncomingMetadata, md)
+}
+
+// FromIncomingContext returns the metadata received with the current request.
+func FromIncomingContext(ctx context.Context) (Metadata, bool) {
+	md, ok := ctx.Value(ctxKeyIncomingMetadata).(Metadata)
+	return md, ok && md != nil
+}
+
+// NewPeerContext returns a context carrying the remote address of the caller.
+// Transports call this before invoking an endpoint handler.
+func NewPeerContext(ctx context.Context, addr string) context.Context {
+	return context.WithValue(ctx, ctxKeyPeer, addr)
+}
+
+// PeerFromContext returns the remote address of the caller, if known.
+func PeerFromContext(ctx context.Context) (string, bool) {
+	addr, ok := ctx.Value(ctxKeyPeer).(string)
+	return addr, ok && addr != ""
+}
@@ -0,0 +1,114 @@
+package talk
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestMetadata_CaseInsensitive(t *testing.T) {
+	md := NewMetadata(map[string]string{"X-Trace-ID": "abc"})
+	if got := md.Get("x-trace-id"); got != "abc" {
+		t.Errorf("Get = %q, want %q", got, "abc")
+	}
+
+	md.Set("Authorization", "Bearer t")
+	if got := md["authorization"]; got != "Bearer t" {
+		t.Errorf("stored key not lower-cased: %v", md)
+	}
+
+	md.Delete("AUTHORIZATION")
+	if _, ok := md["authorization"]; ok {
+		t.Error("Delete did not remove key")
+	}
+}
+
+func TestMetadataPairs(t *testing.T) {
+	md := MetadataPairs("A", "1", "b", "2", "dangling")
+	if len(md) != 2 || md.Get("a") != "1" || md.Get("B") != "2" {
+		t.Errorf("MetadataPairs = %v", md)
+	}
+}
+
+func TestMetadata_Header(t *testing.T) {
+	h := http.Header{}
+	h.Add("X-Tenant", "acme")
+	h.Add("Accept", "a")
+	h.Add("Accept", "b")
+
+	md := MetadataFromHeader(h)
+	if md.Get("x-tenant") != "acme" {
+		t.Errorf("x-tenant = %q, want acme", md.Get("x-tenant"))
+	}
+	if md.Get("accept") != "a, b" {
+		t.Errorf("accept = %q, want %q", md.Get("accept"), "a, b")
+	}
+
+	out := http.Header{}
+	md.SetHeader(out)
+	if out.Get("X-Tenant") != "acme" {
+		t.Errorf("SetHeader X-Tenant = %q, want acme", out.Get("X-Tenant"))
+	}
+}
+
+func TestOutgoingContext(t *testing.T) {
+	ctx := context.Background()
+	if _, ok := FromOutgoingContext(ctx); ok {
+		t.Fatal("expected no outgoing metadata")
+	}
+
+	ctx = NewOutgoingContext(ctx, MetadataPairs("a", "1"))
+	child := AppendToOutgoingContext(ctx, "b", "2")
+
+	md, ok := FromOutgoingContext(child)
+	if !ok || md.Get("a") != "1" || md.Get("b") != "2" {
+		t.Errorf("appended metadata = %v", md)
+	}
+
+	// The parent's metadata must not be modified by appending.
+	parent, _ := FromOutgoingContext(ctx)
+	if _, ok := parent["b"]; ok {
+		t.Error("AppendToOutgoingContext modified parent metadata")
+	}
+}
+
+func TestIncomingContext(t *testing.T) {
+	ctx := context.Background()
+	if _, ok := FromIncomingContext(ctx); ok {
+		t.Fatal("expected no incoming metadata")
+	}
+
+	ctx = NewIncomingContext(ctx, MetadataPairs("x-tenant", "acme"))
+	md, ok := FromIncomingContext(ctx)
+	if !ok || md.Get("X-Tenant") != "acme" {
+		t.Errorf("incoming metadata = %v", md)
+	}
+
+	// Incoming and outgoing metadata are independent.
+	if _, ok := FromOutgoingContext(ctx); ok {
+		t.Error("incoming metadata leaked into outgoing")
+	}
+}
+
+func TestClient_MiddlewareSetsMetadata(t *testing.T) {
+	var got Metadata
+	transport := &mockTransport{
+		invokeFunc: func(ctx context.Context, endpoint string, req any, resp any) error {
+			got, _ = FromOutgoingContext(ctx)
+			return nil
+		},
+	}
+
+	client := NewClient(transport, WithClientMiddleware(func(next ClientInvokeFunc) ClientInvokeFunc {
+		return func(ctx context.Context, call *ClientCall) error {
+			return next(AppendToOutgoingContext(ctx, "x-request-id", "r1"), call)
+		}
+	}))
+
+	if err := client.Call(context.Background(), "Ping", nil, nil); err != nil {
+		t.Fatalf("Call failed: %v", err)
+	}
+	if got.Get("x-request-id") != "r1" {
+		t.Errorf("x-request-id = %q, want r1", got.Get("x-request-id"))
+	}
+}
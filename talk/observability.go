@@ -0,0 +1,444 @@
+package talk
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.zoe.im/x/stat"
+)
+
+// TraceparentHeader is the metadata key carrying the W3C trace context.
+const TraceparentHeader = "traceparent"
+
+// TraceID identifies a trace, i.e. all spans of one logical request.
+type TraceID [16]byte
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+
+// IsValid reports whether id is non-zero.
+func (id TraceID) IsValid() bool { return id != TraceID{} }
+
+// SpanID identifies a single span within a trace.
+type SpanID [8]byte
+
+func (id SpanID) String() string { return hex.EncodeToString(id[:]) }
+
+// IsValid reports whether id is non-zero.
+func (id SpanID) IsValid() bool { return id != SpanID{} }
+
+// SpanContext is the part of a span that is propagated across process
+// boundaries.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// IsValid reports whether both the trace and span IDs are set.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Traceparent formats sc as a W3C traceparent header value, e.g.
+// "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01".
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// ParseTraceparent parses a W3C traceparent header value.
+func ParseTraceparent(s string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return sc, fmt.Errorf("invalid traceparent %q", s)
+	}
+	if parts[0] == "00" && len(parts) != 4 {
+		return sc, fmt.Errorf("invalid traceparent %q", s)
+	}
+	if len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, fmt.Errorf("invalid traceparent %q", s)
+	}
+
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return SpanContext{}, fmt.Errorf("invalid trace id %q", parts[1])
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return SpanContext{}, fmt.Errorf("invalid span id %q", parts[2])
+	}
+	var flags [1]byte
+	if _, err := hex.Decode(flags[:], []byte(parts[3])); err != nil {
+		return SpanContext{}, fmt.Errorf("invalid trace flags %q", parts[3])
+	}
+	sc.Sampled = flags[0]&1 == 1
+
+	if !sc.IsValid() {
+		return SpanContext{}, fmt.Errorf("invalid traceparent %q", s)
+	}
+	return sc, nil
+}
+
+// SpanKind tells whether a span was recorded by the serving or the calling side.
+type SpanKind int
+
+const (
+	SpanKindServer SpanKind = iota + 1
+	SpanKindClient
+)
+
+func (k SpanKind) String() string {
+	switch k {
+	case SpanKindServer:
+		return "server"
+	case SpanKindClient:
+		return "client"
+	default:
+		return "unknown"
+	}
+}
+
+// Span records the timing and outcome of a single call.
+type Span struct {
+	Name       string
+	Kind       SpanKind
+	Context    SpanContext
+	Parent     SpanContext // zero for root spans
+	Start      time.Time
+	End        time.Time
+	Code       ErrorCode // OK unless the call failed
+	Attributes map[string]string
+
+	// MessagesSent and MessagesReceived count stream messages; both are zero
+	// for unary calls.
+	MessagesSent     int64
+	MessagesReceived int64
+}
+
+// Duration returns how long the span lasted.
+func (s *Span) Duration() time.Duration {
+	return s.End.Sub(s.Start)
+}
+
+// SpanExporter receives every sampled span once it has ended.
+type SpanExporter func(span *Span)
+
+// ContextWithSpan returns a context carrying span. Spans of calls made with
+// the returned context become its children.
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, ctxKeySpan, span)
+}
+
+// SpanFromContext returns the span of the current call, if any.
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(ctxKeySpan).(*Span)
+	return span
+}
+
+// Observer records metrics and spans for calls handled by a Server and made
+// by a Client. For every endpoint it maintains, in a stat.Registry:
+//
+//	<side>.<endpoint>.requests          counter
+//	<side>.<endpoint>.errors.<CODE>     counter, e.g. errors.NOT_FOUND
+//	<side>.<endpoint>.in_flight         gauge
+//	<side>.<endpoint>.latency           timer
+//	<side>.<endpoint>.stream.sent       counter (streaming endpoints)
+//	<side>.<endpoint>.stream.received   counter (streaming endpoints)
+//
+// where side is "talk.server" or "talk.client". Spans are propagated with
+// the W3C "traceparent" request metadata, so a client span and the server
+// span it causes share a trace ID regardless of the transport.
+//
+// Usage:
+//
+//	obs := talk.NewObserver(talk.WithSpanExporter(func(s *talk.Span) { log.Println(s.Name, s.Duration()) }))
+//	server := talk.NewServer(transport, talk.WithServerObserver(obs))
+//	client := talk.NewClient(transport, talk.WithClientObserver(obs))
+type Observer struct {
+	registry *stat.Registry
+	exporter SpanExporter
+}
+
+// ObserverOption configures an Observer.
+type ObserverOption func(*Observer)
+
+// WithMetricsRegistry sets the registry metrics are recorded in. The
+// default is stat.Default().
+func WithMetricsRegistry(r *stat.Registry) ObserverOption {
+	return func(o *Observer) {
+		o.registry = r
+	}
+}
+
+// WithSpanExporter sets the function that receives finished spans. Without
+// an exporter spans are still created and propagated, but not recorded.
+func WithSpanExporter(fn SpanExporter) ObserverOption {
+	return func(o *Observer) {
+		o.exporter = fn
+	}
+}
+
+// NewObserver creates an Observer.
+func NewObserver(opts ...ObserverOption) *Observer {
+	o := &Observer{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.registry == nil {
+		o.registry = stat.Default()
+	}
+	return o
+}
+
+// WithServerObserver instruments all unary and streaming endpoints of a
+// server with o.
+func WithServerObserver(o *Observer) ServerOption {
+	return func(s *Server) {
+		s.middleware = append(s.middleware, o.Middleware())
+		s.streamMiddleware = append(s.streamMiddleware, o.StreamMiddleware())
+	}
+}
+
+// WithClientObserver instruments all calls made by a client with o.
+func WithClientObserver(o *Observer) ClientOption {
+	return func(c *Client) {
+		c.middleware = append(c.middleware, o.ClientMiddleware())
+	}
+}
+
+// Middleware returns a MiddlewareFunc that observes unary endpoints.
+func (o *Observer) Middleware() MiddlewareFunc {
+	return func(next EndpointFunc) EndpointFunc {
+		return func(ctx context.Context, req any) (any, error) {
+			ep := EndpointFromContext(ctx)
+			if ep == nil {
+				return next(ctx, req)
+			}
+
+			ctx, call := o.startServer(ctx, ep.Name)
+			resp, err := next(ctx, req)
+			call.finish(err)
+			return resp, err
+		}
+	}
+}
+
+// StreamMiddleware returns a StreamMiddlewareFunc that observes streaming
+// endpoints, including the number of messages sent and received.
+func (o *Observer) StreamMiddleware() StreamMiddlewareFunc {
+	return func(next StreamEndpointFunc) StreamEndpointFunc {
+		return func(ctx context.Context, req any, stream Stream) error {
+			ep := EndpointFromContext(ctx)
+			if ep == nil {
+				return next(ctx, req, stream)
+			}
+
+			ctx, call := o.startServer(ctx, ep.Name)
+			err := next(ctx, req, call.wrap(ctx, stream))
+			if errors.Is(err, io.EOF) {
+				call.finish(nil)
+			} else {
+				call.finish(err)
+			}
+			return err
+		}
+	}
+}
+
+// ClientMiddleware returns a ClientMiddlewareFunc that observes outgoing
+// calls and attaches the traceparent metadata. A streaming call is finished
+// when the stream is closed or Recv fails.
+func (o *Observer) ClientMiddleware() ClientMiddlewareFunc {
+	return func(next ClientInvokeFunc) ClientInvokeFunc {
+		return func(ctx context.Context, cc *ClientCall) error {
+			parent := SpanContext{}
+			if span := SpanFromContext(ctx); span != nil {
+				parent = span.Context
+			} else if md, ok := FromOutgoingContext(ctx); ok {
+				parent, _ = ParseTraceparent(md.Get(TraceparentHeader))
+			}
+
+			ctx, call := o.start(ctx, "talk.client", cc.Endpoint, SpanKindClient, parent)
+			ctx = AppendToOutgoingContext(ctx, TraceparentHeader, call.span.Context.Traceparent())
+
+			err := next(ctx, cc)
+			if err != nil || !cc.Streaming {
+				call.finish(err)
+				return err
+			}
+			cc.Stream = call.wrap(ctx, cc.Stream)
+			return nil
+		}
+	}
+}
+
+func (o *Observer) startServer(ctx context.Context, endpoint string) (context.Context, *observedCall) {
+	var parent SpanContext
+	if md, ok := FromIncomingContext(ctx); ok {
+		parent, _ = ParseTraceparent(md.Get(TraceparentHeader))
+	}
+	ctx, call := o.start(ctx, "talk.server", endpoint, SpanKindServer, parent)
+	if addr, ok := PeerFromContext(ctx); ok {
+		call.span.Attributes["peer"] = addr
+	}
+	return ctx, call
+}
+
+func (o *Observer) start(ctx context.Context, side, endpoint string, kind SpanKind, parent SpanContext) (context.Context, *observedCall) {
+	call := &observedCall{
+		observer: o,
+		prefix:   side + "." + endpoint + ".",
+		span: &Span{
+			Name:       endpoint,
+			Kind:       kind,
+			Parent:     parent,
+			Start:      time.Now(),
+			Attributes: map[string]string{"endpoint": endpoint},
+		},
+	}
+
+	sc := SpanContext{TraceID: parent.TraceID, Sampled: true}
+	if parent.IsValid() {
+		sc.Sampled = parent.Sampled
+	} else {
+		rand.Read(sc.TraceID[:])
+	}
+	rand.Read(sc.SpanID[:])
+	call.span.Context = sc
+
+	o.registry.Counter(call.prefix + "requests").Inc()
+	o.registry.Gauge(call.prefix + "in_flight").Inc()
+	return ContextWithSpan(ctx, call.span), call
+}
+
+// observedCall tracks a single call from start to finish.
+type observedCall struct {
+	observer *Observer
+	prefix   string
+	span     *Span
+	once     sync.Once
+	sent     int64
+	received int64
+}
+
+func (c *observedCall) finish(err error) {
+	c.once.Do(func() {
+		reg := c.observer.registry
+		c.span.End = time.Now()
+		reg.Gauge(c.prefix + "in_flight").Dec()
+		reg.Timer(c.prefix + "latency").Observe(c.span.Duration())
+
+		if err != nil {
+			c.span.Code = errorCode(err)
+			reg.Counter(c.prefix + "errors." + c.span.Code.String()).Inc()
+		}
+		c.span.MessagesSent = atomic.LoadInt64(&c.sent)
+		c.span.MessagesReceived = atomic.LoadInt64(&c.received)
+
+		if c.observer.exporter != nil && c.span.Context.Sampled {
+			c.observer.exporter(c.span)
+		}
+	})
+}
+
+func (c *observedCall) sentMessage() {
+	atomic.AddInt64(&c.sent, 1)
+	c.observer.registry.Counter(c.prefix + "stream.sent").Inc()
+}
+
+func (c *observedCall) receivedMessage() {
+	atomic.AddInt64(&c.received, 1)
+	c.observer.registry.Counter(c.prefix + "stream.received").Inc()
+}
+
+// wrap returns stream with message counting, keeping the ServerStream or
+// ClientStream methods of the original stream.
+func (c *observedCall) wrap(ctx context.Context, stream Stream) Stream {
+	base := &observedStream{Stream: stream, ctx: ctx, call: c}
+	switch s := stream.(type) {
+	case ClientStream:
+		return &observedClientStream{observedStream: base, cs: s}
+	case ServerStream:
+		return &observedServerStream{observedStream: base, ss: s}
+	}
+	return base
+}
+
+type observedStream struct {
+	Stream
+	ctx  context.Context
+	call *observedCall
+}
+
+func (s *observedStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *observedStream) Send(msg any) error {
+	err := s.Stream.Send(msg)
+	if err == nil {
+		s.call.sentMessage()
+	}
+	return err
+}
+
+func (s *observedStream) Recv(msg any) error {
+	err := s.Stream.Recv(msg)
+	switch {
+	case err == nil:
+		s.call.receivedMessage()
+	case s.call.span.Kind == SpanKindClient:
+		// The client side ends when the server is done sending.
+		if errors.Is(err, io.EOF) {
+			s.call.finish(nil)
+		} else {
+			s.call.finish(err)
+		}
+	}
+	return err
+}
+
+func (s *observedStream) Close() error {
+	err := s.Stream.Close()
+	if s.call.span.Kind == SpanKindClient {
+		s.call.finish(nil)
+	}
+	return err
+}
+
+type observedClientStream struct {
+	*observedStream
+	cs ClientStream
+}
+
+func (s *observedClientStream) CloseSend() error {
+	return s.cs.CloseSend()
+}
+
+func (s *observedClientStream) CloseAndRecv(resp any) error {
+	err := s.cs.CloseAndRecv(resp)
+	if err == nil {
+		s.call.receivedMessage()
+	}
+	s.call.finish(err)
+	return err
+}
+
+type observedServerStream struct {
+	*observedStream
+	ss ServerStream
+}
+
+func (s *observedServerStream) SendHeader(metadata map[string]string) error {
+	return s.ss.SendHeader(metadata)
+}
@@ -0,0 +1,193 @@
+package talk
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"go.zoe.im/x/stat"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	const header = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, err := ParseTraceparent(header)
+	if err != nil {
+		t.Fatalf("ParseTraceparent failed: %v", err)
+	}
+	if sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanID.String() != "00f067aa0ba902b7" || !sc.Sampled {
+		t.Errorf("got %+v", sc)
+	}
+	if got := sc.Traceparent(); got != header {
+		t.Errorf("Traceparent() = %q, want %q", got, header)
+	}
+
+	for _, bad := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473z-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+	} {
+		if _, err := ParseTraceparent(bad); err == nil {
+			t.Errorf("ParseTraceparent(%q) should fail", bad)
+		}
+	}
+}
+
+func TestObserver_Unary(t *testing.T) {
+	reg := stat.NewRegistry()
+	var spans []*Span
+	obs := NewObserver(WithMetricsRegistry(reg), WithSpanExporter(func(s *Span) { spans = append(spans, s) }))
+
+	parent, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ep := &Endpoint{Name: "GetUser"}
+	ctx := WithEndpointContext(context.Background(), ep)
+	ctx = NewIncomingContext(ctx, MetadataPairs(TraceparentHeader, parent.Traceparent()))
+
+	var inFlight int64
+	handler := obs.Middleware()(func(ctx context.Context, req any) (any, error) {
+		inFlight = reg.Gauge("talk.server.GetUser.in_flight").Value()
+		if SpanFromContext(ctx) == nil {
+			t.Error("span not in handler context")
+		}
+		return nil, NewError(NotFound, "no such user")
+	})
+	handler(ctx, nil)
+	handler(ctx, nil)
+
+	if inFlight != 1 {
+		t.Errorf("in_flight during call = %d, want 1", inFlight)
+	}
+	if got := reg.Counter("talk.server.GetUser.requests").Value(); got != 2 {
+		t.Errorf("requests = %d, want 2", got)
+	}
+	if got := reg.Counter("talk.server.GetUser.errors.NOT_FOUND").Value(); got != 2 {
+		t.Errorf("errors.NOT_FOUND = %d, want 2", got)
+	}
+	if got := reg.Gauge("talk.server.GetUser.in_flight").Value(); got != 0 {
+		t.Errorf("in_flight = %d, want 0", got)
+	}
+	if got := reg.Timer("talk.server.GetUser.latency").Count(); got != 2 {
+		t.Errorf("latency count = %d, want 2", got)
+	}
+
+	if len(spans) != 2 {
+		t.Fatalf("exported %d spans, want 2", len(spans))
+	}
+	span := spans[0]
+	if span.Kind != SpanKindServer || span.Code != NotFound || span.Name != "GetUser" {
+		t.Errorf("span = %+v", span)
+	}
+	if span.Context.TraceID != parent.TraceID || span.Parent != parent || span.Context.SpanID == parent.SpanID {
+		t.Errorf("span context %+v is not a child of %+v", span.Context, parent)
+	}
+}
+
+func TestObserver_ClientPropagation(t *testing.T) {
+	reg := stat.NewRegistry()
+	var spans []*Span
+	obs := NewObserver(WithMetricsRegistry(reg), WithSpanExporter(func(s *Span) { spans = append(spans, s) }))
+
+	var sent string
+	invoke := obs.ClientMiddleware()(func(ctx context.Context, call *ClientCall) error {
+		md, _ := FromOutgoingContext(ctx)
+		sent = md.Get(TraceparentHeader)
+		return nil
+	})
+
+	// Calls made while handling a request continue its trace.
+	server := &Span{Context: SpanContext{TraceID: TraceID{1}, SpanID: SpanID{2}, Sampled: true}}
+	if err := invoke(ContextWithSpan(context.Background(), server), &ClientCall{Endpoint: "Ping"}); err != nil {
+		t.Fatal(err)
+	}
+
+	sc, err := ParseTraceparent(sent)
+	if err != nil {
+		t.Fatalf("sent traceparent %q: %v", sent, err)
+	}
+	if len(spans) != 1 || spans[0].Context != sc {
+		t.Fatalf("sent %v, exported %v", sc, spans)
+	}
+	if sc.TraceID != server.Context.TraceID || spans[0].Parent != server.Context || spans[0].Kind != SpanKindClient {
+		t.Errorf("client span %+v is not a child of %+v", spans[0], server.Context)
+	}
+	if got := reg.Counter("talk.client.Ping.requests").Value(); got != 1 {
+		t.Errorf("requests = %d, want 1", got)
+	}
+}
+
+func TestObserver_Unsampled(t *testing.T) {
+	exported := 0
+	obs := NewObserver(WithMetricsRegistry(stat.NewRegistry()), WithSpanExporter(func(*Span) { exported++ }))
+
+	ctx := WithEndpointContext(context.Background(), &Endpoint{Name: "Ping"})
+	ctx = NewIncomingContext(ctx, MetadataPairs(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00"))
+	obs.Middleware()(func(ctx context.Context, req any) (any, error) { return nil, nil })(ctx, nil)
+
+	if exported != 0 {
+		t.Errorf("exported %d unsampled spans", exported)
+	}
+}
+
+func TestObserver_Stream(t *testing.T) {
+	reg := stat.NewRegistry()
+	var spans []*Span
+	obs := NewObserver(WithMetricsRegistry(reg), WithSpanExporter(func(s *Span) { spans = append(spans, s) }))
+
+	serverSide, clientSide := NewChanStreamPair[int](context.Background(), 4)
+	handler := obs.StreamMiddleware()(func(ctx context.Context, req any, stream Stream) error {
+		var n int
+		if err := stream.Recv(&n); err != nil {
+			return err
+		}
+		stream.Send(n + 1)
+		stream.Send(n + 2)
+		return nil
+	})
+
+	// Client side: wrap a stream the way ClientMiddleware does.
+	invoke := obs.ClientMiddleware()(func(ctx context.Context, call *ClientCall) error {
+		call.Stream = clientSide
+		return nil
+	})
+	call := &ClientCall{Endpoint: "Count", Streaming: true}
+	if err := invoke(context.Background(), call); err != nil {
+		t.Fatal(err)
+	}
+	call.Stream.Send(1)
+
+	ctx := WithEndpointContext(context.Background(), &Endpoint{Name: "Count"})
+	if err := handler(ctx, nil, serverSide); err != nil {
+		t.Fatalf("handler failed: %v", err)
+	}
+	serverSide.CloseSend()
+
+	for {
+		var n int
+		if err := call.Stream.Recv(&n); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	checks := map[string]int64{
+		"talk.server.Count.stream.received": 1,
+		"talk.server.Count.stream.sent":     2,
+		"talk.client.Count.stream.sent":     1,
+		"talk.client.Count.stream.received": 2,
+	}
+	for name, want := range checks {
+		if got := reg.Counter(name).Value(); got != want {
+			t.Errorf("%s = %d, want %d", name, got, want)
+		}
+	}
+	if got := reg.Gauge("talk.client.Count.in_flight").Value(); got != 0 {
+		t.Errorf("client in_flight = %d, want 0 after EOF", got)
+	}
+	if len(spans) != 2 || spans[0].MessagesSent != 2 || spans[1].MessagesReceived != 2 {
+		t.Errorf("spans = %+v", spans)
+	}
+}
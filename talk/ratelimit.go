@@ -0,0 +1,236 @@
+package talk
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.zoe.im/x"
+)
+
+// DefaultRateLimitIdleTimeout is how long an unused bucket is kept.
+const DefaultRateLimitIdleTimeout = 10 * time.Minute
+
+// RateLimitKeyFunc returns the key requests are bucketed by.
+type RateLimitKeyFunc func(ctx context.Context, req any) string
+
+// RateLimitOption configures RateLimitMiddleware.
+type RateLimitOption func(*rateLimiter)
+
+// WithRateLimitKeyFunc overrides how requests are bucketed. By default the
+// identity from IdentityFromContext is used, falling back to the peer host.
+func WithRateLimitKeyFunc(fn RateLimitKeyFunc) RateLimitOption {
+	return func(rl *rateLimiter) {
+		rl.keyFunc = fn
+	}
+}
+
+// WithDefaultRateLimit sets the limit for endpoints without a "ratelimit"
+// annotation, in the same format, e.g. "100/s". A burst <= 0 defaults to
+// the per-second rate.
+func WithDefaultRateLimit(limit string, burst int) RateLimitOption {
+	return func(rl *rateLimiter) {
+		rl.defaultLimit = limit
+		rl.defaultBurst = burst
+	}
+}
+
+// WithRateLimitIdleTimeout sets how long an unused bucket is kept before it
+// is dropped.
+func WithRateLimitIdleTimeout(d time.Duration) RateLimitOption {
+	return func(rl *rateLimiter) {
+		rl.idleTimeout = d
+	}
+}
+
+// RateLimitMiddleware creates a MiddlewareFunc that enforces the endpoint's
+// "ratelimit" and "burst" metadata, as set by annotations such as
+//
+//	// @talk ratelimit=100/s burst=20
+//
+// The rate is a number of requests per "s", "m" or "h" (per second if the
+// unit is omitted). Each endpoint and caller gets its own token bucket; the
+// caller is the authenticated identity, so the middleware must run after
+// AuthMiddleware, or the remote host for anonymous calls. Rejected requests
+// fail with ResourceExhausted carrying a RetryInfo, which HTTP transports
+// turn into 429 and a Retry-After header.
+//
+// Usage:
+//
+//	server := talk.NewServer(transport,
+//	    talk.WithServerMiddleware(talk.AuthMiddleware(myAuthFunc), talk.RateLimitMiddleware()),
+//	)
+func RateLimitMiddleware(opts ...RateLimitOption) MiddlewareFunc {
+	rl := &rateLimiter{
+		keyFunc:     defaultRateLimitKey,
+		idleTimeout: DefaultRateLimitIdleTimeout,
+		limits:      make(map[*Endpoint]rateLimit),
+		buckets:     make(map[rateLimitKey]*rateLimitBucket),
+		now:         time.Now,
+	}
+	for _, opt := range opts {
+		opt(rl)
+	}
+	rl.lastSweep = rl.now()
+
+	return func(next EndpointFunc) EndpointFunc {
+		return func(ctx context.Context, req any) (any, error) {
+			ep := EndpointFromContext(ctx)
+			if ep == nil {
+				return next(ctx, req)
+			}
+
+			limit, err := rl.limitFor(ep)
+			if err != nil {
+				return nil, err
+			}
+			if limit.qps <= 0 {
+				return next(ctx, req)
+			}
+
+			if !rl.bucket(ep.Name, rl.keyFunc(ctx, req), limit).TryAccept() {
+				retryAfter := time.Duration(float64(time.Second) / limit.qps)
+				return nil, NewErrorWithTypedDetails(ResourceExhausted,
+					"rate limit exceeded for "+ep.Name,
+					RetryInfo{RetryDelay: x.Duration(retryAfter)})
+			}
+
+			return next(ctx, req)
+		}
+	}
+}
+
+type rateLimit struct {
+	qps   float64
+	burst int
+}
+
+type rateLimitKey struct {
+	endpoint string
+	caller   string
+}
+
+type rateLimitBucket struct {
+	x.PassiveRateLimiter
+	lastUsed time.Time
+}
+
+type rateLimiter struct {
+	keyFunc      RateLimitKeyFunc
+	defaultLimit string
+	defaultBurst int
+	idleTimeout  time.Duration
+	now          func() time.Time
+
+	mu        sync.Mutex
+	limits    map[*Endpoint]rateLimit
+	buckets   map[rateLimitKey]*rateLimitBucket
+	lastSweep time.Time
+}
+
+// limitFor returns the parsed limit of ep, caching it per endpoint.
+func (rl *rateLimiter) limitFor(ep *Endpoint) (rateLimit, error) {
+	rl.mu.Lock()
+	limit, ok := rl.limits[ep]
+	rl.mu.Unlock()
+	if ok {
+		return limit, nil
+	}
+
+	spec, burst := rl.defaultLimit, rl.defaultBurst
+	if v, ok := ep.Metadata["ratelimit"]; ok {
+		spec, burst = fmt.Sprint(v), 0
+		if b, ok := ep.Metadata["burst"]; ok {
+			n, err := strconv.Atoi(fmt.Sprint(b))
+			if err != nil {
+				return rateLimit{}, NewErrorf(Internal, "invalid burst %q on %s", b, ep.Name)
+			}
+			burst = n
+		}
+	}
+
+	if spec != "" {
+		qps, err := parseRateLimit(spec)
+		if err != nil {
+			return rateLimit{}, NewErrorf(Internal, "invalid ratelimit on %s: %v", ep.Name, err)
+		}
+		limit.qps = qps
+		limit.burst = burst
+		if limit.burst <= 0 {
+			limit.burst = int(math.Max(1, math.Ceil(qps)))
+		}
+	}
+
+	rl.mu.Lock()
+	rl.limits[ep] = limit
+	rl.mu.Unlock()
+	return limit, nil
+}
+
+// bucket returns the token bucket for an endpoint and caller, creating it on
+// first use. Buckets idle for longer than the idle timeout are dropped.
+func (rl *rateLimiter) bucket(endpoint, caller string, limit rateLimit) x.PassiveRateLimiter {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := rl.now()
+	if rl.idleTimeout > 0 && now.Sub(rl.lastSweep) >= rl.idleTimeout {
+		for k, b := range rl.buckets {
+			if now.Sub(b.lastUsed) >= rl.idleTimeout {
+				delete(rl.buckets, k)
+			}
+		}
+		rl.lastSweep = now
+	}
+
+	key := rateLimitKey{endpoint: endpoint, caller: caller}
+	b, ok := rl.buckets[key]
+	if !ok {
+		b = &rateLimitBucket{
+			PassiveRateLimiter: x.NewTokenBucketPassiveRateLimiter(float32(limit.qps), limit.burst),
+		}
+		rl.buckets[key] = b
+	}
+	b.lastUsed = now
+	return b
+}
+
+func defaultRateLimitKey(ctx context.Context, req any) string {
+	if id, ok := IdentityFromContext(ctx); ok && id != "" {
+		return "id:" + id
+	}
+	if addr, ok := PeerFromContext(ctx); ok {
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			addr = host
+		}
+		return "addr:" + addr
+	}
+	return ""
+}
+
+// parseRateLimit parses "<n>[/s|/m|/h]" into requests per second.
+func parseRateLimit(s string) (float64, error) {
+	n, unit, _ := strings.Cut(strings.TrimSpace(s), "/")
+	count, err := strconv.ParseFloat(n, 64)
+	if err != nil || count < 0 {
+		return 0, fmt.Errorf("invalid rate %q", s)
+	}
+
+	var per time.Duration
+	switch strings.ToLower(unit) {
+	case "", "s", "sec", "second":
+		per = time.Second
+	case "m", "min", "minute":
+		per = time.Minute
+	case "h", "hour":
+		per = time.Hour
+	default:
+		return 0, fmt.Errorf("invalid rate unit %q", unit)
+	}
+	return count / per.Seconds(), nil
+}
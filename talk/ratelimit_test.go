@@ -0,0 +1,193 @@
+package talk
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+type rateLimitedService struct{}
+
+func (s *rateLimitedService) Ping(ctx context.Context) (string, error) {
+	return "pong", nil
+}
+
+func (s *rateLimitedService) TalkAnnotations() map[string]string {
+	return map[string]string{
+		"Ping": "@talk ratelimit=2/s burst=1 tenant=acme",
+	}
+}
+
+func TestExtractor_AnnotationTagsInMetadata(t *testing.T) {
+	endpoints, err := (&reflectExtractor{}).Extract(&rateLimitedService{})
+	if err != nil {
+		t.Fatalf("Extract failed: %v", err)
+	}
+	if len(endpoints) != 1 {
+		t.Fatalf("got %d endpoints, want 1", len(endpoints))
+	}
+
+	md := endpoints[0].Metadata
+	for k, want := range map[string]string{"ratelimit": "2/s", "burst": "1", "tenant": "acme"} {
+		if md[k] != want {
+			t.Errorf("Metadata[%q] = %v, want %q", k, md[k], want)
+		}
+	}
+}
+
+func TestParseRateLimit(t *testing.T) {
+	tests := []struct {
+		spec string
+		qps  float64
+		ok   bool
+	}{
+		{"100/s", 100, true},
+		{"100", 100, true},
+		{"60/m", 1, true},
+		{"3600/hour", 1, true},
+		{"1.5/s", 1.5, true},
+		{"abc/s", 0, false},
+		{"10/d", 0, false},
+		{"-1/s", 0, false},
+	}
+
+	for _, tt := range tests {
+		qps, err := parseRateLimit(tt.spec)
+		if (err == nil) != tt.ok {
+			t.Errorf("parseRateLimit(%q) error = %v, want ok=%v", tt.spec, err, tt.ok)
+			continue
+		}
+		if qps != tt.qps {
+			t.Errorf("parseRateLimit(%q) = %v, want %v", tt.spec, qps, tt.qps)
+		}
+	}
+}
+
+func rateLimitedHandler(mw MiddlewareFunc) EndpointFunc {
+	return mw(func(ctx context.Context, req any) (any, error) {
+		return "ok", nil
+	})
+}
+
+func TestRateLimitMiddleware_Rejects(t *testing.T) {
+	ep := &Endpoint{Name: "Ping", Metadata: map[string]any{"ratelimit": "1/m", "burst": "2"}}
+	handler := rateLimitedHandler(RateLimitMiddleware())
+	ctx := WithEndpointContext(NewPeerContext(context.Background(), "10.0.0.1:1234"), ep)
+
+	for i := 0; i < 2; i++ {
+		if _, err := handler(ctx, nil); err != nil {
+			t.Fatalf("request %d rejected: %v", i, err)
+		}
+	}
+
+	_, err := handler(ctx, nil)
+	e := ToError(err)
+	if e == nil || e.Code != ResourceExhausted {
+		t.Fatalf("error = %v, want ResourceExhausted", err)
+	}
+	if d, ok := e.RetryAfter(); !ok || d != time.Minute {
+		t.Errorf("RetryAfter = %v, %v, want 1m", d, ok)
+	}
+
+	// Same host on another port shares the bucket.
+	other := WithEndpointContext(NewPeerContext(context.Background(), "10.0.0.1:5678"), ep)
+	if _, err := handler(other, nil); ToError(err).Code != ResourceExhausted {
+		t.Errorf("same host error = %v, want ResourceExhausted", err)
+	}
+}
+
+func TestRateLimitMiddleware_KeysByIdentity(t *testing.T) {
+	ep := &Endpoint{Name: "Ping", Metadata: map[string]any{"ratelimit": "1/m"}}
+	handler := rateLimitedHandler(RateLimitMiddleware())
+
+	call := func(identity string) error {
+		ctx := WithEndpointContext(NewPeerContext(context.Background(), "10.0.0.1:1"), ep)
+		ctx = context.WithValue(ctx, ctxKeyIdentity, identity)
+		_, err := handler(ctx, nil)
+		return err
+	}
+
+	if err := call("alice"); err != nil {
+		t.Fatalf("alice rejected: %v", err)
+	}
+	if err := call("bob"); err != nil {
+		t.Fatalf("bob rejected: %v", err)
+	}
+	if err := call("alice"); ToError(err).Code != ResourceExhausted {
+		t.Errorf("second alice call error = %v, want ResourceExhausted", err)
+	}
+}
+
+func TestRateLimitMiddleware_DefaultAndUnlimited(t *testing.T) {
+	plain := &Endpoint{Name: "Plain"}
+
+	unlimited := rateLimitedHandler(RateLimitMiddleware())
+	ctx := WithEndpointContext(context.Background(), plain)
+	for i := 0; i < 10; i++ {
+		if _, err := unlimited(ctx, nil); err != nil {
+			t.Fatalf("unannotated endpoint limited: %v", err)
+		}
+	}
+
+	limited := rateLimitedHandler(RateLimitMiddleware(WithDefaultRateLimit("1/m", 1)))
+	limited(ctx, nil)
+	if _, err := limited(ctx, nil); ToError(err).Code != ResourceExhausted {
+		t.Errorf("default limit error = %v, want ResourceExhausted", err)
+	}
+
+	// Without an endpoint in context the middleware passes through.
+	if _, err := limited(context.Background(), nil); err != nil {
+		t.Errorf("no endpoint: %v", err)
+	}
+}
+
+func TestRateLimitMiddleware_InvalidSpec(t *testing.T) {
+	ep := &Endpoint{Name: "Bad", Metadata: map[string]any{"ratelimit": "fast"}}
+	handler := rateLimitedHandler(RateLimitMiddleware())
+
+	_, err := handler(WithEndpointContext(context.Background(), ep), nil)
+	if ToError(err).Code != Internal {
+		t.Errorf("error = %v, want Internal", err)
+	}
+}
+
+func TestRateLimitMiddleware_DropsIdleBuckets(t *testing.T) {
+	now := time.Unix(0, 0)
+	rl := &rateLimiter{
+		idleTimeout: time.Minute,
+		buckets:     make(map[rateLimitKey]*rateLimitBucket),
+		now:         func() time.Time { return now },
+	}
+	limit := rateLimit{qps: 1, burst: 1}
+
+	rl.bucket("Ping", "a", limit)
+	now = now.Add(time.Minute)
+	rl.bucket("Ping", "b", limit)
+
+	if _, ok := rl.buckets[rateLimitKey{"Ping", "a"}]; ok {
+		t.Error("idle bucket not dropped")
+	}
+	if _, ok := rl.buckets[rateLimitKey{"Ping", "b"}]; !ok {
+		t.Error("active bucket dropped")
+	}
+}
+
+func TestError_RetryAfter(t *testing.T) {
+	if _, ok := NewError(ResourceExhausted, "x").RetryAfter(); ok {
+		t.Error("expected no retry delay without details")
+	}
+
+	for _, data := range []string{
+		`{"code":8,"typed_details":[{"@type":"talk.RetryInfo","retry_delay":"2s"}]}`,
+		`{"code":8,"details":{"retry_delay":"2s"}}`,
+	} {
+		var decoded Error
+		if err := json.Unmarshal([]byte(data), &decoded); err != nil {
+			t.Fatal(err)
+		}
+		if d, ok := decoded.RetryAfter(); !ok || d != 2*time.Second {
+			t.Errorf("RetryAfter(%s) = %v, %v, want 2s", data, d, ok)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package talk
+
+import (
+	"io"
+	"reflect"
+)
+
+// RawContentType is the Content-Type of raw request and response bodies.
+const RawContentType = "application/octet-stream"
+
+var (
+	readerType     = reflect.TypeOf((*io.Reader)(nil)).Elem()
+	readCloserType = reflect.TypeOf((*io.ReadCloser)(nil)).Elem()
+)
+
+// IsRawBody reports whether t is io.Reader or io.ReadCloser. Endpoints with
+// such a request type get the request body as is, without decoding, from
+// body-carrying transports (http/std, http/gin, unix); responses that are an
+// io.Reader are likewise copied to the response body without encoding, and
+// closed afterwards if they are an io.Closer.
+func IsRawBody(t reflect.Type) bool {
+	return t == readerType || t == readCloserType
+}
@@ -0,0 +1,131 @@
+package talk
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// EndpointInfo describes an endpoint, as listed by the /_talk/endpoints
+// reflection endpoint.
+type EndpointInfo struct {
+	Name       string      `json:"name"`
+	Path       string      `json:"path"`
+	Method     string      `json:"method"`
+	StreamMode string      `json:"stream_mode"`
+	Request    *TypeSchema `json:"request,omitempty"`
+	Response   *TypeSchema `json:"response,omitempty"`
+}
+
+// TypeSchema is a JSON-Schema-like description of a request or response
+// type. A recursive occurrence of a struct only carries its type name.
+type TypeSchema struct {
+	Type                 string                 `json:"type,omitempty"`
+	GoType               string                 `json:"x-go-type,omitempty"`
+	Format               string                 `json:"format,omitempty"`
+	Properties           map[string]*TypeSchema `json:"properties,omitempty"`
+	Items                *TypeSchema            `json:"items,omitempty"`
+	AdditionalProperties *TypeSchema            `json:"additionalProperties,omitempty"`
+}
+
+// DescribeEndpoints returns the reflection info of endpoints.
+func DescribeEndpoints(endpoints []*Endpoint) []EndpointInfo {
+	infos := make([]EndpointInfo, 0, len(endpoints))
+	for _, ep := range endpoints {
+		infos = append(infos, EndpointInfo{
+			Name:       ep.Name,
+			Path:       ep.Path,
+			Method:     ep.Method,
+			StreamMode: ep.StreamMode.String(),
+			Request:    DescribeType(ep.RequestType),
+			Response:   DescribeType(ep.ResponseType),
+		})
+	}
+	return infos
+}
+
+// DescribeType returns the schema of t as it is encoded to JSON, or nil if
+// t is nil.
+func DescribeType(t reflect.Type) *TypeSchema {
+	if t == nil {
+		return nil
+	}
+	return describeType(t, map[reflect.Type]bool{})
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	durationType   = reflect.TypeOf(time.Duration(0))
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+func describeType(t reflect.Type, seen map[reflect.Type]bool) *TypeSchema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t {
+	case timeType:
+		return &TypeSchema{Type: "string", Format: "date-time", GoType: t.String()}
+	case durationType:
+		return &TypeSchema{Type: "integer", Format: "int64", GoType: t.String()}
+	case rawMessageType:
+		return &TypeSchema{Type: "object", GoType: t.String()}
+	}
+
+	schema := &TypeSchema{}
+	if t.Name() != "" && t.PkgPath() != "" {
+		schema.GoType = t.String()
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		schema.Type = "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		schema.Type = "integer"
+	case reflect.Float32, reflect.Float64:
+		schema.Type = "number"
+	case reflect.String:
+		schema.Type = "string"
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			schema.Type, schema.Format = "string", "byte"
+			break
+		}
+		schema.Type = "array"
+		schema.Items = describeType(t.Elem(), seen)
+	case reflect.Map:
+		schema.Type = "object"
+		schema.AdditionalProperties = describeType(t.Elem(), seen)
+	case reflect.Struct:
+		schema.Type = "object"
+		if seen[t] {
+			return schema
+		}
+		seen[t] = true
+		defer delete(seen, t)
+
+		schema.Properties = map[string]*TypeSchema{}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			name := f.Name
+			if tag := f.Tag.Get("json"); tag != "" {
+				tagName, _, _ := strings.Cut(tag, ",")
+				if tagName == "-" {
+					continue
+				}
+				if tagName != "" {
+					name = tagName
+				}
+			}
+			schema.Properties[name] = describeType(f.Type, seen)
+		}
+	}
+	// Interfaces and other kinds are left without a type: any value.
+	return schema
+}
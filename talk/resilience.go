@@ -0,0 +1,460 @@
+package talk
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.zoe.im/x"
+)
+
+// Default resilience settings, used when the corresponding config field is zero.
+const (
+	DefaultRetryMaxRetries         = 3
+	DefaultRetryInitialBackoff     = 100 * time.Millisecond
+	DefaultRetryMaxBackoff         = 5 * time.Second
+	DefaultCircuitFailureThreshold = 5
+	DefaultCircuitOpenTimeout      = 30 * time.Second
+	DefaultCircuitHalfOpenMaxCalls = 1
+)
+
+// DefaultRetryCodes are the error codes retried when RetryConfig.Codes is empty.
+var DefaultRetryCodes = []ErrorCode{Unavailable, DeadlineExceeded, ResourceExhausted}
+
+// ResilienceConfig configures the client middleware returned by
+// ResilienceMiddleware. It is usually loaded from YAML next to the transport
+// config:
+//
+//	resilience:
+//	  config:
+//	    timeout: 2s
+//	    timeouts:
+//	      SlowReport: 30s
+//	    retry:
+//	      max_retries: 3
+//	      backoff: exponential
+//	      initial: 100ms
+//	      max: 2s
+//	    circuit_breaker:
+//	      failure_threshold: 5
+//	      open_timeout: 30s
+type ResilienceConfig struct {
+	TimeoutConfig  `json:",inline" yaml:",inline"`
+	Retry          *RetryConfig          `json:"retry,omitempty" yaml:"retry"`
+	CircuitBreaker *CircuitBreakerConfig `json:"circuit_breaker,omitempty" yaml:"circuit_breaker"`
+}
+
+// TimeoutConfig configures per-endpoint call timeouts.
+type TimeoutConfig struct {
+	// Timeout applies to endpoints without an entry in Timeouts. Zero means no timeout.
+	Timeout x.Duration `json:"timeout,omitempty" yaml:"timeout"`
+	// Timeouts overrides Timeout per endpoint name.
+	Timeouts map[string]x.Duration `json:"timeouts,omitempty" yaml:"timeouts"`
+}
+
+// RetryConfig configures RetryMiddleware.
+type RetryConfig struct {
+	// MaxRetries is the number of retries after the first attempt.
+	MaxRetries uint64 `json:"max_retries,omitempty" yaml:"max_retries"`
+	// Backoff is one of "exponential" (default), "constant" or "fibonacci".
+	Backoff string `json:"backoff,omitempty" yaml:"backoff"`
+	// Initial is the first backoff interval (the only one for "constant").
+	Initial x.Duration `json:"initial,omitempty" yaml:"initial"`
+	// Max caps a single backoff interval.
+	Max x.Duration `json:"max,omitempty" yaml:"max"`
+	// Jitter adds up to ±Jitter to every interval.
+	Jitter x.Duration `json:"jitter,omitempty" yaml:"jitter"`
+	// Codes lists the retried error codes by name, e.g. "UNAVAILABLE".
+	// Defaults to DefaultRetryCodes.
+	Codes []string `json:"codes,omitempty" yaml:"codes"`
+
+	// NewBackoff, if set, replaces the backoff built from the fields above.
+	// It is called once per call because backoffs are stateful.
+	NewBackoff func() x.RetryBackoff `json:"-" yaml:"-"`
+}
+
+// CircuitBreakerConfig configures CircuitBreakerMiddleware.
+type CircuitBreakerConfig struct {
+	// FailureThreshold is the number of consecutive failures that opens the circuit.
+	FailureThreshold int `json:"failure_threshold,omitempty" yaml:"failure_threshold"`
+	// OpenTimeout is how long the circuit stays open before allowing probes.
+	OpenTimeout x.Duration `json:"open_timeout,omitempty" yaml:"open_timeout"`
+	// HalfOpenMaxCalls is the number of probe calls allowed while half-open;
+	// that many successes close the circuit again.
+	HalfOpenMaxCalls int `json:"half_open_max_calls,omitempty" yaml:"half_open_max_calls"`
+
+	// OnStateChange, if set, is called whenever an endpoint's circuit changes state.
+	OnStateChange func(endpoint string, from, to CircuitState) `json:"-" yaml:"-"`
+}
+
+// ResilienceMiddleware builds a client middleware from a ResilienceConfig
+// stored in cfg. Calls first pass the circuit breaker, are then retried as a
+// whole, and every attempt gets its own timeout. Sections left out of the
+// config are disabled.
+//
+// Usage:
+//
+//	mw, err := talk.ResilienceMiddleware(cfg.Resilience)
+//	client, err := talk.NewClientFromConfig(cfg.Client, talk.WithClientMiddleware(mw))
+func ResilienceMiddleware(cfg x.TypedLazyConfig) (ClientMiddlewareFunc, error) {
+	var rc ResilienceConfig
+	if len(cfg.Config) > 0 {
+		if err := cfg.Unmarshal(&rc); err != nil {
+			return nil, err
+		}
+	}
+
+	var chain []ClientMiddlewareFunc
+	if rc.CircuitBreaker != nil {
+		chain = append(chain, CircuitBreakerMiddleware(*rc.CircuitBreaker))
+	}
+	if rc.Retry != nil {
+		if _, err := parseErrorCodes(rc.Retry.Codes); err != nil {
+			return nil, err
+		}
+		chain = append(chain, RetryMiddleware(*rc.Retry))
+	}
+	if rc.Timeout > 0 || len(rc.Timeouts) > 0 {
+		chain = append(chain, TimeoutMiddleware(rc.TimeoutConfig))
+	}
+
+	return func(next ClientInvokeFunc) ClientInvokeFunc {
+		for i := len(chain) - 1; i >= 0; i-- {
+			next = chain[i](next)
+		}
+		return next
+	}, nil
+}
+
+// TimeoutMiddleware bounds every call with the timeout configured for its
+// endpoint. A call that runs out of time fails with DeadlineExceeded, even if
+// the transport reported the expiry differently. For streams the timeout
+// covers opening the stream only.
+func TimeoutMiddleware(cfg TimeoutConfig) ClientMiddlewareFunc {
+	return func(next ClientInvokeFunc) ClientInvokeFunc {
+		return func(ctx context.Context, call *ClientCall) error {
+			timeout := cfg.Timeout
+			if d, ok := cfg.Timeouts[call.Endpoint]; ok {
+				timeout = d
+			}
+			if timeout <= 0 {
+				return next(ctx, call)
+			}
+
+			callCtx := ctx
+			var cancel context.CancelFunc
+			if call.Streaming {
+				// The stream outlives this call, so its context must not be
+				// cancelled on return; only the open is bounded.
+				callCtx, cancel = context.WithCancel(ctx)
+				timer := time.AfterFunc(time.Duration(timeout), cancel)
+				defer timer.Stop()
+			} else {
+				callCtx, cancel = context.WithTimeout(ctx, time.Duration(timeout))
+				defer cancel()
+			}
+
+			err := next(callCtx, call)
+			if err != nil && ctx.Err() == nil && callCtx.Err() != nil {
+				return NewErrorf(DeadlineExceeded, "%s: timeout after %s", call.Endpoint, time.Duration(timeout))
+			}
+			return err
+		}
+	}
+}
+
+// RetryMiddleware retries calls that fail with one of the configured error
+// codes, waiting between attempts according to the configured backoff.
+// Invalid code names in cfg are ignored; ResilienceMiddleware reports them.
+func RetryMiddleware(cfg RetryConfig) ClientMiddlewareFunc {
+	codes, _ := parseErrorCodes(cfg.Codes)
+	if len(codes) == 0 {
+		codes = DefaultRetryCodes
+	}
+	retryable := make(map[ErrorCode]bool, len(codes))
+	for _, c := range codes {
+		retryable[c] = true
+	}
+
+	return func(next ClientInvokeFunc) ClientInvokeFunc {
+		return func(ctx context.Context, call *ClientCall) error {
+			return x.Retry(ctx, cfg.RetryBackoff(), func(ctx context.Context) error {
+				err := next(ctx, call)
+				if err != nil && retryable[errorCode(err)] {
+					return x.RetryableError(err)
+				}
+				return err
+			})
+		}
+	}
+}
+
+// RetryBackoff returns a new backoff for one retried call: the result of
+// NewBackoff if set, otherwise one built from the other fields.
+func (cfg RetryConfig) RetryBackoff() x.RetryBackoff {
+	if cfg.NewBackoff != nil {
+		return cfg.NewBackoff()
+	}
+	return cfg.backoff()
+}
+
+// IsRetryable reports whether err has one of the retried error codes.
+func (cfg RetryConfig) IsRetryable(err error) bool {
+	codes, _ := parseErrorCodes(cfg.Codes)
+	if len(codes) == 0 {
+		codes = DefaultRetryCodes
+	}
+	code := errorCode(err)
+	for _, c := range codes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+func (cfg RetryConfig) backoff() x.RetryBackoff {
+	initial := time.Duration(cfg.Initial)
+	if initial <= 0 {
+		initial = DefaultRetryInitialBackoff
+	}
+	max := time.Duration(cfg.Max)
+	if max <= 0 {
+		max = DefaultRetryMaxBackoff
+	}
+	maxRetries := cfg.MaxRetries
+	if maxRetries == 0 {
+		maxRetries = DefaultRetryMaxRetries
+	}
+
+	var b x.RetryBackoff
+	switch cfg.Backoff {
+	case "constant":
+		b = x.NewConstantBackoff(initial)
+	case "fibonacci":
+		b = x.NewFibonacciBackoff(initial, max)
+	default:
+		b = x.NewExponentialBackoff(initial, max)
+	}
+	if cfg.Jitter > 0 {
+		b = x.WithJitter(time.Duration(cfg.Jitter), b)
+	}
+	return x.WithMaxRetries(maxRetries, b)
+}
+
+// CircuitState is the state of an endpoint's circuit breaker.
+type CircuitState int
+
+const (
+	CircuitClosed   CircuitState = iota // Calls pass through
+	CircuitOpen                         // Calls fail fast with Unavailable
+	CircuitHalfOpen                     // A limited number of probe calls pass through
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// CircuitBreakerMiddleware keeps a circuit breaker per endpoint name. After
+// FailureThreshold consecutive failures the circuit opens and calls fail with
+// Unavailable without reaching the transport. Once OpenTimeout has passed, up
+// to HalfOpenMaxCalls probes are let through: a failing probe reopens the
+// circuit, enough successful probes close it.
+//
+// Only errors indicating an unhealthy server count as failures (Unknown,
+// DeadlineExceeded, ResourceExhausted, Internal, Unavailable, DataLoss);
+// application errors such as NotFound do not.
+func CircuitBreakerMiddleware(cfg CircuitBreakerConfig) ClientMiddlewareFunc {
+	return newCircuitBreakers(cfg).middleware
+}
+
+type circuitBreakers struct {
+	cfg      CircuitBreakerConfig
+	now      func() time.Time
+	mu       sync.Mutex
+	circuits map[string]*circuit
+}
+
+type circuit struct {
+	state     CircuitState
+	failures  int
+	openedAt  time.Time
+	probes    int
+	successes int
+}
+
+func newCircuitBreakers(cfg CircuitBreakerConfig) *circuitBreakers {
+	if cfg.FailureThreshold <= 0 {
+		cfg.FailureThreshold = DefaultCircuitFailureThreshold
+	}
+	if cfg.OpenTimeout <= 0 {
+		cfg.OpenTimeout = x.Duration(DefaultCircuitOpenTimeout)
+	}
+	if cfg.HalfOpenMaxCalls <= 0 {
+		cfg.HalfOpenMaxCalls = DefaultCircuitHalfOpenMaxCalls
+	}
+	return &circuitBreakers{
+		cfg:      cfg,
+		now:      time.Now,
+		circuits: make(map[string]*circuit),
+	}
+}
+
+func (b *circuitBreakers) middleware(next ClientInvokeFunc) ClientInvokeFunc {
+	return func(ctx context.Context, call *ClientCall) error {
+		if err := b.allow(call.Endpoint); err != nil {
+			return err
+		}
+		err := next(ctx, call)
+		b.record(call.Endpoint, err)
+		return err
+	}
+}
+
+// state returns the current state of the endpoint's circuit.
+func (b *circuitBreakers) state(endpoint string) CircuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c, ok := b.circuits[endpoint]; ok {
+		return c.state
+	}
+	return CircuitClosed
+}
+
+func (b *circuitBreakers) allow(endpoint string) error {
+	b.mu.Lock()
+	c, ok := b.circuits[endpoint]
+	if !ok {
+		c = &circuit{}
+		b.circuits[endpoint] = c
+	}
+
+	if c.state == CircuitOpen && b.now().Sub(c.openedAt) >= time.Duration(b.cfg.OpenTimeout) {
+		b.transition(endpoint, c, CircuitHalfOpen)
+	}
+
+	var err error
+	switch c.state {
+	case CircuitOpen:
+		err = NewErrorf(Unavailable, "circuit breaker open for %s", endpoint)
+	case CircuitHalfOpen:
+		if c.probes >= b.cfg.HalfOpenMaxCalls {
+			err = NewErrorf(Unavailable, "circuit breaker half-open for %s", endpoint)
+		} else {
+			c.probes++
+		}
+	}
+	b.mu.Unlock()
+	return err
+}
+
+func (b *circuitBreakers) record(endpoint string, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c := b.circuits[endpoint]
+	if errorCode(err) == Cancelled {
+		// The caller gave up; this says nothing about the server's health.
+		if c.state == CircuitHalfOpen && c.probes > 0 {
+			c.probes--
+		}
+		return
+	}
+
+	failed := isServerFailure(err)
+	switch c.state {
+	case CircuitClosed:
+		if !failed {
+			c.failures = 0
+			return
+		}
+		c.failures++
+		if c.failures >= b.cfg.FailureThreshold {
+			b.transition(endpoint, c, CircuitOpen)
+		}
+	case CircuitHalfOpen:
+		if failed {
+			b.transition(endpoint, c, CircuitOpen)
+			return
+		}
+		c.successes++
+		if c.successes >= b.cfg.HalfOpenMaxCalls {
+			b.transition(endpoint, c, CircuitClosed)
+		}
+	}
+}
+
+// transition moves c to state and resets its counters. Must hold b.mu.
+func (b *circuitBreakers) transition(endpoint string, c *circuit, to CircuitState) {
+	from := c.state
+	*c = circuit{state: to}
+	if to == CircuitOpen {
+		c.openedAt = b.now()
+	}
+	if b.cfg.OnStateChange != nil && from != to {
+		b.cfg.OnStateChange(endpoint, from, to)
+	}
+}
+
+// isServerFailure reports whether err indicates an unhealthy server rather
+// than a rejected request.
+func isServerFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	switch errorCode(err) {
+	case Unknown, DeadlineExceeded, ResourceExhausted, Internal, Unavailable, DataLoss:
+		return true
+	default:
+		return false
+	}
+}
+
+// errorCode returns the talk error code of err, mapping context errors to
+// their canonical codes.
+func errorCode(err error) ErrorCode {
+	switch {
+	case err == nil:
+		return OK
+	case errors.Is(err, context.DeadlineExceeded):
+		return DeadlineExceeded
+	case errors.Is(err, context.Canceled):
+		return Cancelled
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return Unknown
+}
+
+func parseErrorCodes(names []string) ([]ErrorCode, error) {
+	codes := make([]ErrorCode, 0, len(names))
+	for _, name := range names {
+		code, ok := parseErrorCode(name)
+		if !ok {
+			return nil, NewErrorf(InvalidArgument, "unknown error code %q", name)
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+func parseErrorCode(name string) (ErrorCode, bool) {
+	for c := OK; c <= Unauthenticated; c++ {
+		if c.String() == name {
+			return c, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,283 @@
+package talk
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"go.zoe.im/x"
+)
+
+func failingInvoke(calls *int, errs ...error) ClientInvokeFunc {
+	return func(ctx context.Context, call *ClientCall) error {
+		i := *calls
+		*calls++
+		if i < len(errs) {
+			return errs[i]
+		}
+		return nil
+	}
+}
+
+func TestRetryMiddleware_RetriesRetryableCodes(t *testing.T) {
+	calls := 0
+	mw := RetryMiddleware(RetryConfig{MaxRetries: 3, Backoff: "constant", Initial: x.Duration(time.Millisecond)})
+	invoke := mw(failingInvoke(&calls,
+		NewError(Unavailable, "down"),
+		NewError(ResourceExhausted, "busy"),
+	))
+
+	if err := invoke(context.Background(), &ClientCall{Endpoint: "Ping"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("calls = %d, want 3", calls)
+	}
+}
+
+func TestRetryMiddleware_StopsOnOtherCodes(t *testing.T) {
+	calls := 0
+	mw := RetryMiddleware(RetryConfig{Backoff: "constant", Initial: x.Duration(time.Millisecond)})
+	invoke := mw(failingInvoke(&calls, NewError(NotFound, "missing"), nil))
+
+	err := invoke(context.Background(), &ClientCall{Endpoint: "Get"})
+	if ToError(err).Code != NotFound {
+		t.Fatalf("error = %v, want NotFound", err)
+	}
+	if calls != 1 {
+		t.Errorf("calls = %d, want 1", calls)
+	}
+}
+
+func TestRetryMiddleware_GivesUp(t *testing.T) {
+	calls := 0
+	unavailable := NewError(Unavailable, "down")
+	mw := RetryMiddleware(RetryConfig{
+		NewBackoff: func() x.RetryBackoff {
+			return x.WithMaxRetries(2, x.NewConstantBackoff(time.Millisecond))
+		},
+	})
+	invoke := mw(failingInvoke(&calls, unavailable, unavailable, unavailable, unavailable))
+
+	err := invoke(context.Background(), &ClientCall{Endpoint: "Ping"})
+	if err != unavailable {
+		t.Fatalf("error = %v, want the last transport error", err)
+	}
+	if calls != 3 {
+		t.Errorf("calls = %d, want 3", calls)
+	}
+}
+
+func TestRetryMiddleware_CustomCodes(t *testing.T) {
+	calls := 0
+	mw := RetryMiddleware(RetryConfig{Codes: []string{"ABORTED"}, Backoff: "constant", Initial: x.Duration(time.Millisecond)})
+	invoke := mw(failingInvoke(&calls, NewError(Aborted, "conflict")))
+
+	if err := invoke(context.Background(), &ClientCall{Endpoint: "Update"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("calls = %d, want 2", calls)
+	}
+}
+
+func TestTimeoutMiddleware(t *testing.T) {
+	mw := TimeoutMiddleware(TimeoutConfig{
+		Timeout:  x.Duration(10 * time.Millisecond),
+		Timeouts: map[string]x.Duration{"Slow": x.Duration(time.Second)},
+	})
+
+	var deadline time.Duration
+	invoke := mw(func(ctx context.Context, call *ClientCall) error {
+		d, _ := ctx.Deadline()
+		deadline = time.Until(d)
+		if call.Endpoint == "Hang" {
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		return nil
+	})
+
+	if err := invoke(context.Background(), &ClientCall{Endpoint: "Slow"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if deadline < 500*time.Millisecond {
+		t.Errorf("per-endpoint timeout not applied, deadline in %s", deadline)
+	}
+
+	err := invoke(context.Background(), &ClientCall{Endpoint: "Hang"})
+	if ToError(err).Code != DeadlineExceeded {
+		t.Errorf("error = %v, want DeadlineExceeded", err)
+	}
+}
+
+func TestTimeoutMiddleware_StreamOutlivesOpen(t *testing.T) {
+	mw := TimeoutMiddleware(TimeoutConfig{Timeout: x.Duration(10 * time.Millisecond)})
+
+	var streamCtx context.Context
+	invoke := mw(func(ctx context.Context, call *ClientCall) error {
+		streamCtx = ctx
+		return nil
+	})
+
+	if err := invoke(context.Background(), &ClientCall{Endpoint: "Watch", Streaming: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if streamCtx.Err() != nil {
+		t.Errorf("stream context cancelled after open: %v", streamCtx.Err())
+	}
+}
+
+func TestCircuitBreaker_Transitions(t *testing.T) {
+	now := time.Unix(0, 0)
+	var changes []string
+	b := newCircuitBreakers(CircuitBreakerConfig{
+		FailureThreshold: 2,
+		OpenTimeout:      x.Duration(time.Second),
+		OnStateChange: func(endpoint string, from, to CircuitState) {
+			changes = append(changes, endpoint+":"+from.String()+"->"+to.String())
+		},
+	})
+	b.now = func() time.Time { return now }
+
+	var fail bool
+	calls := 0
+	invoke := b.middleware(func(ctx context.Context, call *ClientCall) error {
+		calls++
+		if fail {
+			return NewError(Unavailable, "down")
+		}
+		return nil
+	})
+	call := &ClientCall{Endpoint: "Ping"}
+
+	// Application errors do not count as failures.
+	notFound := b.middleware(func(ctx context.Context, call *ClientCall) error {
+		return NewError(NotFound, "missing")
+	})
+	for i := 0; i < 3; i++ {
+		notFound(context.Background(), call)
+	}
+	if b.state("Ping") != CircuitClosed {
+		t.Fatalf("state = %s, want closed", b.state("Ping"))
+	}
+
+	fail = true
+	invoke(context.Background(), call)
+	invoke(context.Background(), call)
+	if b.state("Ping") != CircuitOpen {
+		t.Fatalf("state = %s, want open", b.state("Ping"))
+	}
+
+	// Open circuits fail fast without calling the transport.
+	calls = 0
+	if err := invoke(context.Background(), call); ToError(err).Code != Unavailable {
+		t.Errorf("error = %v, want Unavailable", err)
+	}
+	if calls != 0 {
+		t.Errorf("transport called %d times while open", calls)
+	}
+
+	// Other endpoints are unaffected.
+	if b.state("Other") != CircuitClosed {
+		t.Errorf("Other state = %s, want closed", b.state("Other"))
+	}
+
+	// A failing probe reopens the circuit.
+	now = now.Add(time.Second)
+	invoke(context.Background(), call)
+	if b.state("Ping") != CircuitOpen {
+		t.Fatalf("state = %s, want open after failed probe", b.state("Ping"))
+	}
+
+	// A successful probe closes it.
+	now = now.Add(time.Second)
+	fail = false
+	if err := invoke(context.Background(), call); err != nil {
+		t.Fatalf("probe failed: %v", err)
+	}
+	if b.state("Ping") != CircuitClosed {
+		t.Fatalf("state = %s, want closed", b.state("Ping"))
+	}
+
+	want := []string{
+		"Ping:closed->open",
+		"Ping:open->half-open",
+		"Ping:half-open->open",
+		"Ping:open->half-open",
+		"Ping:half-open->closed",
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("changes = %v, want %v", changes, want)
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Errorf("changes[%d] = %q, want %q", i, changes[i], want[i])
+		}
+	}
+}
+
+func TestCircuitBreaker_HalfOpenLimitsProbes(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := newCircuitBreakers(CircuitBreakerConfig{FailureThreshold: 1, OpenTimeout: x.Duration(time.Second)})
+	b.now = func() time.Time { return now }
+
+	b.allow("Ping")
+	b.record("Ping", NewError(Internal, "boom"))
+
+	now = now.Add(time.Second)
+	if err := b.allow("Ping"); err != nil {
+		t.Fatalf("first probe rejected: %v", err)
+	}
+	if err := b.allow("Ping"); ToError(err).Code != Unavailable {
+		t.Errorf("second probe error = %v, want Unavailable", err)
+	}
+
+	// A cancelled probe frees its slot.
+	b.record("Ping", context.Canceled)
+	if err := b.allow("Ping"); err != nil {
+		t.Errorf("probe after cancellation rejected: %v", err)
+	}
+}
+
+func TestResilienceMiddleware_FromConfig(t *testing.T) {
+	cfg := x.TypedLazyConfig{
+		Config: json.RawMessage(`{
+			"timeout": "1s",
+			"retry": {"max_retries": 2, "backoff": "constant", "initial": "1ms"},
+			"circuit_breaker": {"failure_threshold": 1, "open_timeout": "1m"}
+		}`),
+	}
+
+	mw, err := ResilienceMiddleware(cfg)
+	if err != nil {
+		t.Fatalf("ResilienceMiddleware failed: %v", err)
+	}
+
+	calls := 0
+	unavailable := NewError(Unavailable, "down")
+	invoke := mw(failingInvoke(&calls, unavailable, unavailable, unavailable, unavailable))
+
+	// Retries run inside the breaker: one logical failure after 3 attempts.
+	if err := invoke(context.Background(), &ClientCall{Endpoint: "Ping"}); err != unavailable {
+		t.Fatalf("error = %v, want transport error", err)
+	}
+	if calls != 3 {
+		t.Errorf("calls = %d, want 3", calls)
+	}
+
+	// The breaker is now open.
+	err = invoke(context.Background(), &ClientCall{Endpoint: "Ping"})
+	if ToError(err).Code != Unavailable || calls != 3 {
+		t.Errorf("error = %v, calls = %d; want fast Unavailable", err, calls)
+	}
+}
+
+func TestResilienceMiddleware_InvalidCode(t *testing.T) {
+	cfg := x.TypedLazyConfig{Config: json.RawMessage(`{"retry": {"codes": ["NOPE"]}}`)}
+	if _, err := ResilienceMiddleware(cfg); err == nil {
+		t.Error("expected error for unknown retry code")
+	}
+}
@@ -3,6 +3,9 @@ package talk
 import (
 	"context"
 	"io"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // Stream provides bidirectional communication for streaming endpoints.
@@ -29,6 +32,15 @@ type ServerStream interface {
 	SendHeader(metadata map[string]string) error
 }
 
+// CancelableStream is implemented by server streams that can be ended
+// from the server side: Cancel cancels the stream's context and ends a
+// pending Send or Recv. Server.Shutdown cancels open streams this way, so
+// that handlers waiting for their peer return.
+type CancelableStream interface {
+	Stream
+	Cancel()
+}
+
 // ClientStream is used by clients to send requests and receive responses.
 type ClientStream interface {
 	Stream
@@ -38,6 +50,37 @@ type ClientStream interface {
 	CloseSend() error
 }
 
+// Event is a message with the server-sent event fields. Server handlers
+// send an Event to assign it an ID, which SSE clients present as
+// Last-Event-ID when they reconnect; other transports send it as a regular
+// message. SSE clients receiving into an *Event get the fields of the event
+// and its data decoded into Data, which may be preset to a pointer.
+type Event struct {
+	// ID identifies the event for resumption.
+	ID string `json:"id,omitempty"`
+	// Type is the event type; empty means "message".
+	Type string `json:"event,omitempty"`
+	// Retry asks clients to wait this long before reconnecting.
+	Retry time.Duration `json:"retry,omitempty"`
+	// Data is the event payload.
+	Data any `json:"data"`
+}
+
+// LastEventIDMetadataKey is the metadata key carrying the ID of the last
+// event a reconnecting stream client received.
+const LastEventIDMetadataKey = "last-event-id"
+
+// LastEventID returns the ID of the last event a reconnecting client
+// received, so that a stream handler can resume after it. It is empty for
+// new streams.
+func LastEventID(ctx context.Context) string {
+	md, ok := FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	return md.Get(LastEventIDMetadataKey)
+}
+
 // streamBase provides common stream functionality.
 type streamBase struct {
 	ctx    context.Context
@@ -48,13 +91,120 @@ func (s *streamBase) Context() context.Context {
 	return s.ctx
 }
 
+// Cancel cancels the stream's context.
+func (s *streamBase) Cancel() {
+	s.cancel()
+}
+
+// Receiver delivers the messages of a stream on C, which is closed once
+// the stream ends or its context is done. Err then tells why.
+type Receiver[T any] struct {
+	C <-chan T
+
+	mu   sync.Mutex
+	err  error
+	done bool
+}
+
+// Err returns the error the stream failed with once C is closed, or nil if
+// the stream ended with io.EOF.
+func (r *Receiver[T]) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// fail records err unless an error was recorded or C is closed already.
+func (r *Receiver[T]) fail(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err == nil && !r.done {
+		r.err = err
+	}
+}
+
+// RecvAll receives the messages of stream decoded as T on the C of the
+// returned Receiver until Recv fails or ctx is done. The stream is closed
+// along with C.
+func RecvAll[T any](ctx context.Context, stream Stream) *Receiver[T] {
+	ch := make(chan T)
+	r := &Receiver[T]{C: ch}
+	go func() {
+		defer func() {
+			stream.Close()
+			r.mu.Lock()
+			r.done = true
+			r.mu.Unlock()
+			close(ch)
+		}()
+		for {
+			var msg T
+			if err := stream.Recv(&msg); err != nil {
+				if err != io.EOF {
+					r.fail(err)
+				}
+				return
+			}
+			select {
+			case ch <- msg:
+			case <-ctx.Done():
+				r.fail(ctx.Err())
+				return
+			}
+		}
+	}()
+	return r
+}
+
+// SendRecvAll sends the values received from in on stream with SendAll
+// while receiving its messages with RecvAll. A failure to send closes the
+// stream and is reported by Err of the returned Receiver.
+func SendRecvAll[T, U any](ctx context.Context, stream Stream, in <-chan T) *Receiver[U] {
+	r := RecvAll[U](ctx, stream)
+	go func() {
+		if err := SendAll(ctx, stream, in); err != nil {
+			r.fail(err)
+			stream.Close()
+		}
+	}()
+	return r
+}
+
+// SendAll sends the values received from ch on stream until ch is closed,
+// then closes the send side of the stream. It fails with Unimplemented if
+// the stream has no CloseSend, since the peer would never see the end.
+func SendAll[T any](ctx context.Context, stream Stream, ch <-chan T) error {
+	for {
+		select {
+		case msg, ok := <-ch:
+			if !ok {
+				if cs, ok := stream.(interface{ CloseSend() error }); ok {
+					return cs.CloseSend()
+				}
+				return NewError(Unimplemented, "stream cannot close its send side")
+			}
+			if err := stream.Send(msg); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
 // ChanStream implements Stream using Go channels.
 // Useful for in-process communication and testing.
 type ChanStream[T any] struct {
 	streamBase
 	sendCh chan T
 	recvCh chan T
-	closed bool
+
+	// mu is held for reading by Send while it writes to sendCh, so that
+	// sendCh is only closed once no sender is left.
+	mu        sync.RWMutex
+	closed    atomic.Bool
+	sendDone  chan struct{}
+	closeOnce sync.Once
 }
 
 // NewChanStream creates a bidirectional channel-based stream.
@@ -64,27 +214,46 @@ func NewChanStream[T any](ctx context.Context, bufSize int) *ChanStream[T] {
 		streamBase: streamBase{ctx: ctx, cancel: cancel},
 		sendCh:     make(chan T, bufSize),
 		recvCh:     make(chan T, bufSize),
+		sendDone:   make(chan struct{}),
 	}
 }
 
+// NewChanStreamPair creates two channel-based streams wired back to back:
+// messages sent on one side are received on the other. Closing the send
+// side of either stream ends the peer's Recv with io.EOF.
+func NewChanStreamPair[T any](ctx context.Context, bufSize int) (*ChanStream[T], *ChanStream[T]) {
+	a := NewChanStream[T](ctx, bufSize)
+	b := NewChanStream[T](ctx, bufSize)
+	a.recvCh = b.sendCh
+	b.recvCh = a.sendCh
+	return a, b
+}
+
 func (s *ChanStream[T]) Send(msg any) error {
-	if s.closed {
-		return io.ErrClosedPipe
-	}
 	v, ok := msg.(T)
 	if !ok {
 		return NewError(InvalidArgument, "invalid message type")
 	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	select {
+	case <-s.sendDone:
+		return io.ErrClosedPipe
+	default:
+	}
 	select {
 	case s.sendCh <- v:
 		return nil
+	case <-s.sendDone:
+		return io.ErrClosedPipe
 	case <-s.ctx.Done():
 		return s.ctx.Err()
 	}
 }
 
 func (s *ChanStream[T]) Recv(msg any) error {
-	if s.closed {
+	if s.closed.Load() {
 		return io.EOF
 	}
 	select {
@@ -104,15 +273,28 @@ func (s *ChanStream[T]) Recv(msg any) error {
 }
 
 func (s *ChanStream[T]) Close() error {
-	if s.closed {
-		return nil
-	}
-	s.closed = true
+	s.closed.Store(true)
 	s.cancel()
-	close(s.sendCh)
+	s.closeSend()
+	return nil
+}
+
+// CloseSend closes the send side of the stream while keeping Recv usable.
+func (s *ChanStream[T]) CloseSend() error {
+	s.closeSend()
 	return nil
 }
 
+// closeSend wakes up pending senders and closes sendCh once they are gone.
+func (s *ChanStream[T]) closeSend() {
+	s.closeOnce.Do(func() {
+		close(s.sendDone)
+		s.mu.Lock()
+		close(s.sendCh)
+		s.mu.Unlock()
+	})
+}
+
 // SendChan returns the send channel for direct access.
 func (s *ChanStream[T]) SendChan() chan<- T {
 	return s.sendCh
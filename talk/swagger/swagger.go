@@ -2,11 +2,17 @@
 package swagger
 
 import (
+	"encoding"
 	"encoding/json"
 	"reflect"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
+	"go.zoe.im/x"
 	"go.zoe.im/x/talk"
+	"go.zoe.im/x/talk/extract"
 )
 
 // Config holds configuration for Swagger documentation.
@@ -27,6 +33,10 @@ type Config struct {
 	Host string `json:"host,omitempty" yaml:"host"`
 	// Schemes are the supported schemes (e.g., ["http", "https"]).
 	Schemes []string `json:"schemes,omitempty" yaml:"schemes"`
+	// Sources are Go files or directories whose doc comments describe the
+	// schemas of the types they declare. They are skipped if they cannot
+	// be parsed, e.g. when not deployed with the binary.
+	Sources []string `json:"sources,omitempty" yaml:"sources"`
 }
 
 // DefaultConfig returns a default swagger configuration.
@@ -77,13 +87,27 @@ type PathItem struct {
 
 // Operation represents an operation in an OpenAPI spec.
 type Operation struct {
-	Tags        []string            `json:"tags,omitempty"`
-	Summary     string              `json:"summary,omitempty"`
-	Description string              `json:"description,omitempty"`
-	OperationID string              `json:"operationId,omitempty"`
-	Parameters  []Parameter         `json:"parameters,omitempty"`
-	RequestBody *RequestBody        `json:"requestBody,omitempty"`
-	Responses   map[string]Response `json:"responses"`
+	Tags        []string              `json:"tags,omitempty"`
+	Summary     string                `json:"summary,omitempty"`
+	Description string                `json:"description,omitempty"`
+	OperationID string                `json:"operationId,omitempty"`
+	Parameters  []Parameter           `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]Response   `json:"responses"`
+	Security    []map[string][]string `json:"security,omitempty"`
+
+	// Stream describes streaming endpoints.
+	Stream *Stream `json:"x-talk-stream,omitempty"`
+}
+
+// Stream is the x-talk-stream extension of the operations of streaming
+// endpoints, describing what OpenAPI cannot: client-side and bidirectional
+// streams are exchanged over WebSocket, or full-duplex HTTP with
+// application/x-ndjson bodies, and server-side streams over SSE.
+type Stream struct {
+	Mode     string  `json:"mode"`               // server, client or bidirectional
+	Request  *Schema `json:"request,omitempty"`  // messages sent by the client
+	Response *Schema `json:"response,omitempty"` // messages sent by the server
 }
 
 // Parameter represents a parameter in an OpenAPI spec.
@@ -122,11 +146,30 @@ type Schema struct {
 	Items       *Schema            `json:"items,omitempty"`
 	Required    []string           `json:"required,omitempty"`
 	Ref         string             `json:"$ref,omitempty"`
+	AllOf       []*Schema          `json:"allOf,omitempty"`
+	Enum        []any              `json:"enum,omitempty"`
+	MinLength   *int               `json:"minLength,omitempty"`
+	MaxLength   *int               `json:"maxLength,omitempty"`
+	Minimum     *float64           `json:"minimum,omitempty"`
+	Maximum     *float64           `json:"maximum,omitempty"`
+	MinItems    *int               `json:"minItems,omitempty"`
+	MaxItems    *int               `json:"maxItems,omitempty"`
+
+	AdditionalProperties *Schema `json:"additionalProperties,omitempty"`
 }
 
 // Components represents the components section of an OpenAPI spec.
 type Components struct {
-	Schemas map[string]*Schema `json:"schemas,omitempty"`
+	Schemas         map[string]*Schema         `json:"schemas,omitempty"`
+	SecuritySchemes map[string]*SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+// SecurityScheme represents a security scheme in an OpenAPI spec.
+type SecurityScheme struct {
+	Type         string `json:"type"`
+	Description  string `json:"description,omitempty"`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
 }
 
 // Tag represents a tag in an OpenAPI spec.
@@ -135,18 +178,40 @@ type Tag struct {
 	Description string `json:"description,omitempty"`
 }
 
+// Enumer is implemented by types whose values are limited to a set, e.g.
+// named string types with constants. The values are the enum of the
+// schema of the type.
+type Enumer interface {
+	Enum() []any
+}
+
 // Generator generates OpenAPI specs from talk endpoints.
 type Generator struct {
-	config  Config
-	schemas map[string]*Schema
+	config   Config
+	schemas  map[string]*Schema
+	names    map[reflect.Type]string // component names of the types in schemas
+	docs     map[string]*extract.TypeDoc
+	security map[string]*SecurityScheme
 }
 
 // NewGenerator creates a new OpenAPI generator.
 func NewGenerator(cfg Config) *Generator {
-	return &Generator{
-		config:  cfg,
-		schemas: make(map[string]*Schema),
+	g := &Generator{
+		config:   cfg,
+		schemas:  make(map[string]*Schema),
+		names:    make(map[reflect.Type]string),
+		security: make(map[string]*SecurityScheme),
 	}
+	if len(cfg.Sources) > 0 {
+		g.docs, _ = extract.ParseTypeDocs(cfg.Sources...)
+	}
+	return g
+}
+
+// SetTypeDocs sets the doc comments describing the schemas of types by
+// type name, e.g. as returned by extract.ParseTypeDocs.
+func (g *Generator) SetTypeDocs(docs map[string]*extract.TypeDoc) {
+	g.docs = docs
 }
 
 // Generate generates an OpenAPI spec from the given endpoints.
@@ -188,10 +253,13 @@ func (g *Generator) Generate(endpoints []*talk.Endpoint) *OpenAPI {
 	}
 
 	// Add component schemas
-	if len(g.schemas) > 0 {
+	if len(g.schemas) > 0 || len(g.security) > 0 {
 		spec.Components = &Components{
 			Schemas: g.schemas,
 		}
+		if len(g.security) > 0 {
+			spec.Components.SecuritySchemes = g.security
+		}
 	}
 
 	return spec
@@ -226,8 +294,10 @@ func (g *Generator) addEndpoint(spec *OpenAPI, ep *talk.Endpoint) {
 	params := g.extractPathParams(ep.Path)
 	op.Parameters = append(op.Parameters, params...)
 
-	// Add request body for POST/PUT/PATCH
-	if ep.RequestType != nil && (ep.Method == "POST" || ep.Method == "PUT" || ep.Method == "PATCH") {
+	// Add request body for POST/PUT/PATCH. Server-side streams take a
+	// single request too; the others stream theirs, see addStream.
+	streamsRequest := ep.StreamMode == talk.StreamClientSide || ep.StreamMode == talk.StreamBidirect
+	if ep.RequestType != nil && !streamsRequest && (ep.Method == "POST" || ep.Method == "PUT" || ep.Method == "PATCH") {
 		schema := g.typeToSchema(ep.RequestType)
 		op.RequestBody = &RequestBody{
 			Required: true,
@@ -240,7 +310,7 @@ func (g *Generator) addEndpoint(spec *OpenAPI, ep *talk.Endpoint) {
 	}
 
 	// Add response schema
-	if ep.ResponseType != nil {
+	if ep.ResponseType != nil && !ep.IsStreaming() {
 		schema := g.typeToSchema(ep.ResponseType)
 		op.Responses["200"] = Response{
 			Description: "Successful response",
@@ -255,18 +325,12 @@ func (g *Generator) addEndpoint(spec *OpenAPI, ep *talk.Endpoint) {
 	// Handle streaming endpoints
 	if ep.IsStreaming() {
 		op.Description = "Streaming endpoint (" + ep.StreamMode.String() + ")"
-		if ep.StreamMode == talk.StreamServerSide {
-			op.Responses["200"] = Response{
-				Description: "Server-sent events stream",
-				Content: map[string]MediaType{
-					"text/event-stream": {
-						Schema: &Schema{Type: "string"},
-					},
-				},
-			}
-		}
+		g.addStream(op, ep)
 	}
 
+	g.addErrorResponses(op, ep)
+	g.addSecurity(op, ep)
+
 	// Add to path item
 	pathItem, exists := spec.Paths[path]
 	if !exists {
@@ -291,6 +355,202 @@ func (g *Generator) addEndpoint(spec *OpenAPI, ep *talk.Endpoint) {
 	spec.Paths[path] = pathItem
 }
 
+// addStream describes the messages of a streaming endpoint: server-side
+// streams respond with server-sent events, client-side and bidirectional
+// ones exchange JSON lines.
+func (g *Generator) addStream(op *Operation, ep *talk.Endpoint) {
+	op.Stream = &Stream{
+		Mode:     ep.StreamMode.String(),
+		Request:  g.typeToSchema(messageType(ep.RequestType)),
+		Response: g.typeToSchema(messageType(ep.ResponseType)),
+	}
+
+	if ep.StreamMode == talk.StreamServerSide {
+		op.Responses["200"] = Response{
+			Description: "Server-sent events stream",
+			Content: map[string]MediaType{
+				"text/event-stream": {
+					Schema: eventSchema(op.Stream.Response),
+				},
+			},
+		}
+		return
+	}
+
+	if op.Stream.Request != nil {
+		op.RequestBody = &RequestBody{
+			Description: "Stream of request messages, one JSON document per line",
+			Required:    true,
+			Content: map[string]MediaType{
+				"application/x-ndjson": {
+					Schema: op.Stream.Request,
+				},
+			},
+		}
+	}
+	resp := Response{
+		Description: "Stream of response messages, one JSON document per line",
+	}
+	if op.Stream.Response != nil {
+		resp.Content = map[string]MediaType{
+			"application/x-ndjson": {
+				Schema: op.Stream.Response,
+			},
+		}
+	}
+	op.Responses["200"] = resp
+}
+
+// messageType returns the type of the messages of a stream of t.
+func messageType(t reflect.Type) reflect.Type {
+	if t != nil && t.Kind() == reflect.Chan {
+		return t.Elem()
+	}
+	return t
+}
+
+// eventSchema returns the schema of the server-sent events carrying data.
+func eventSchema(data *Schema) *Schema {
+	if data == nil {
+		data = &Schema{}
+	}
+	return &Schema{
+		Type:        "object",
+		Description: "A server-sent event whose data is a JSON message. An \"end\" event ends the stream; an \"error\" event carrying an Error ends it with that error.",
+		Properties: map[string]*Schema{
+			"id":    {Type: "string", Description: "Event ID, sent back in Last-Event-ID to resume the stream"},
+			"event": {Type: "string", Description: "Event type, \"message\" if empty"},
+			"retry": {Type: "integer", Description: "Reconnection delay in milliseconds"},
+			"data":  data,
+		},
+		Required: []string{"data"},
+	}
+}
+
+// errorCodes are the codes of the errors endpoints return.
+var errorCodes = []talk.ErrorCode{
+	talk.Cancelled, talk.Unknown, talk.InvalidArgument, talk.DeadlineExceeded,
+	talk.NotFound, talk.AlreadyExists, talk.PermissionDenied, talk.ResourceExhausted,
+	talk.FailedPrecondition, talk.Aborted, talk.OutOfRange, talk.Unimplemented,
+	talk.Internal, talk.Unavailable, talk.DataLoss, talk.Unauthenticated,
+}
+
+// addErrorResponses adds the responses of the errors ep may return: the
+// HTTP statuses of the codes tied to its request, authentication and rate
+// limit, then any other error as default.
+func (g *Generator) addErrorResponses(op *Operation, ep *talk.Endpoint) {
+	var codes []talk.ErrorCode
+	if len(op.Parameters) > 0 || op.RequestBody != nil || ep.RequestType != nil {
+		codes = append(codes, talk.InvalidArgument)
+	}
+	if len(op.Parameters) > 0 {
+		codes = append(codes, talk.NotFound)
+	}
+	if level := ep.AuthLevel(); level != "" && level != talk.AuthNone {
+		codes = append(codes, talk.Unauthenticated, talk.PermissionDenied)
+	}
+	if _, ok := ep.Metadata["ratelimit"]; ok {
+		codes = append(codes, talk.ResourceExhausted)
+	}
+
+	ref := g.errorSchema()
+	for _, code := range codes {
+		status := strconv.Itoa(code.HTTPStatus())
+		op.Responses[status] = Response{
+			Description: errorDescription(code.HTTPStatus()),
+			Content: map[string]MediaType{
+				"application/json": {Schema: ref},
+			},
+		}
+	}
+	op.Responses["default"] = Response{
+		Description: "Error",
+		Content: map[string]MediaType{
+			"application/json": {Schema: ref},
+		},
+	}
+}
+
+// errorDescription lists the codes of the errors of an HTTP status.
+func errorDescription(status int) string {
+	var names []string
+	for _, code := range errorCodes {
+		if code.HTTPStatus() == status {
+			names = append(names, code.String())
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// errorSchema returns a reference to the schema of talk.Error, whose codes
+// are listed with their HTTP statuses.
+func (g *Generator) errorSchema() *Schema {
+	t := reflect.TypeOf(talk.Error{})
+	if name, ok := g.names[t]; ok {
+		return &Schema{Ref: "#/components/schemas/" + name}
+	}
+
+	var enum []any
+	var lines []string
+	for _, code := range errorCodes {
+		enum = append(enum, int(code))
+		lines = append(lines, strconv.Itoa(int(code))+": "+code.String()+" (HTTP "+strconv.Itoa(code.HTTPStatus())+")")
+	}
+
+	name := g.componentName(t)
+	g.names[t] = name
+	g.schemas[name] = &Schema{
+		Type:        "object",
+		Description: "An error returned by an endpoint.",
+		Properties: map[string]*Schema{
+			"code": {
+				Type:        "integer",
+				Format:      "int32",
+				Description: "Error code:\n" + strings.Join(lines, "\n"),
+				Enum:        enum,
+			},
+			"message": {Type: "string"},
+			"details": {Description: "Free-form details"},
+			"typed_details": {
+				Type:        "array",
+				Description: "Typed details, named by their @type",
+				Items: &Schema{
+					Type:       "object",
+					Properties: map[string]*Schema{"@type": {Type: "string"}},
+				},
+			},
+		},
+		Required: []string{"code", "message"},
+	}
+	return &Schema{Ref: "#/components/schemas/" + name}
+}
+
+// addSecurity requires the bearer token of the security scheme named
+// after the auth level of ep, if any.
+func (g *Generator) addSecurity(op *Operation, ep *talk.Endpoint) {
+	level := ep.AuthLevel()
+	if level == "" || level == talk.AuthNone {
+		return
+	}
+
+	name := string(level)
+	if _, ok := g.security[name]; !ok {
+		description := "Requires a " + name + " bearer token"
+		switch level {
+		case talk.AuthToken:
+			description = "Requires a valid bearer token"
+		case talk.AuthAdmin:
+			description = "Requires a bearer token with admin privileges"
+		}
+		g.security[name] = &SecurityScheme{
+			Type:        "http",
+			Scheme:      "bearer",
+			Description: description,
+		}
+	}
+	op.Security = append(op.Security, map[string][]string{name: {}})
+}
+
 func (g *Generator) normalizePath(path string) string {
 	// Convert {param} to OpenAPI style (already correct)
 	return path
@@ -327,69 +587,169 @@ func (g *Generator) extractPathParams(path string) []Parameter {
 	return params
 }
 
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	durationType      = reflect.TypeOf(time.Duration(0))
+	xDurationType     = reflect.TypeOf(x.Duration(0))
+	rawMessageType    = reflect.TypeOf(json.RawMessage{})
+	enumerType        = reflect.TypeOf((*Enumer)(nil)).Elem()
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// typeToSchema returns the schema of t, a reference to the component of
+// t for named structs and enums.
 func (g *Generator) typeToSchema(t reflect.Type) *Schema {
 	if t == nil {
 		return nil
 	}
 
 	// Handle pointer types
-	if t.Kind() == reflect.Ptr {
+	for t.Kind() == reflect.Ptr {
 		t = t.Elem()
 	}
 
+	if schema := knownSchema(t); schema != nil {
+		return schema
+	}
+
 	// Check if we already have this schema
-	typeName := t.Name()
-	if typeName != "" {
-		if _, exists := g.schemas[typeName]; exists {
-			return &Schema{Ref: "#/components/schemas/" + typeName}
-		}
+	if name, ok := g.names[t]; ok {
+		return &Schema{Ref: "#/components/schemas/" + name}
 	}
 
-	schema := g.buildSchema(t)
+	if t.Name() == "" || (t.Kind() != reflect.Struct && !implements(t, enumerType)) {
+		return g.buildSchema(t)
+	}
 
-	// Register named types as components
-	if typeName != "" && t.Kind() == reflect.Struct {
-		g.schemas[typeName] = schema
-		return &Schema{Ref: "#/components/schemas/" + typeName}
+	// Register named types as components before building them, so that
+	// recursive types refer to themselves.
+	name := g.componentName(t)
+	g.names[t] = name
+	schema := &Schema{}
+	g.schemas[name] = schema
+	*schema = *g.buildSchema(t)
+	if doc := g.typeDoc(t); doc != nil && doc.Doc != "" {
+		schema.Description = doc.Doc
 	}
 
-	return schema
+	return &Schema{Ref: "#/components/schemas/" + name}
+}
+
+// knownSchema returns the schema of the types with a custom JSON encoding,
+// or nil.
+func knownSchema(t reflect.Type) *Schema {
+	switch {
+	case t == timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case t == durationType:
+		return &Schema{Type: "integer", Format: "int64", Description: "Duration in nanoseconds"}
+	case t == xDurationType:
+		return &Schema{Type: "string", Format: "duration"}
+	case t == rawMessageType:
+		return &Schema{}
+	case implements(t, textMarshalerType) && !implements(t, jsonMarshalerType) && !implements(t, enumerType):
+		return &Schema{Type: "string"}
+	}
+	return nil
+}
+
+// implements reports whether t or *t implements iface.
+func implements(t, iface reflect.Type) bool {
+	return t.Implements(iface) || reflect.PointerTo(t).Implements(iface)
+}
+
+var (
+	// typeArgRegex matches the package qualifiers of type arguments.
+	typeArgRegex = regexp.MustCompile(`(?:[\w.\-]+/)*\w+\.`)
+	// nonAlnumRegex matches what cannot appear in component names.
+	nonAlnumRegex = regexp.MustCompile(`[^A-Za-z0-9]+`)
+)
+
+// componentName returns a unique component name for t: its name, with the
+// type arguments of generic types appended, e.g. Page_User for
+// Page[pkg.User].
+func (g *Generator) componentName(t reflect.Type) string {
+	base, args, generic := strings.Cut(t.Name(), "[")
+	if generic {
+		args = typeArgRegex.ReplaceAllString(args, "")
+		args = strings.Trim(nonAlnumRegex.ReplaceAllString(args, "_"), "_")
+		base += "_" + args
+	}
+
+	name := base
+	for i := 2; g.schemas[name] != nil; i++ {
+		name = base + strconv.Itoa(i)
+	}
+	return name
+}
+
+// typeDoc returns the doc comments of t, or nil.
+func (g *Generator) typeDoc(t reflect.Type) *extract.TypeDoc {
+	name, _, _ := strings.Cut(t.Name(), "[")
+	if name == "" {
+		return nil
+	}
+	return g.docs[name]
 }
 
 func (g *Generator) buildSchema(t reflect.Type) *Schema {
+	var schema *Schema
 	switch t.Kind() {
 	case reflect.Bool:
-		return &Schema{Type: "boolean"}
+		schema = &Schema{Type: "boolean"}
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32:
-		return &Schema{Type: "integer", Format: "int32"}
+		schema = &Schema{Type: "integer", Format: "int32"}
 	case reflect.Int64:
-		return &Schema{Type: "integer", Format: "int64"}
+		schema = &Schema{Type: "integer", Format: "int64"}
 	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
-		return &Schema{Type: "integer", Format: "int32"}
+		schema = &Schema{Type: "integer", Format: "int32"}
 	case reflect.Uint64:
-		return &Schema{Type: "integer", Format: "int64"}
+		schema = &Schema{Type: "integer", Format: "int64"}
 	case reflect.Float32:
-		return &Schema{Type: "number", Format: "float"}
+		schema = &Schema{Type: "number", Format: "float"}
 	case reflect.Float64:
-		return &Schema{Type: "number", Format: "double"}
+		schema = &Schema{Type: "number", Format: "double"}
 	case reflect.String:
-		return &Schema{Type: "string"}
+		schema = &Schema{Type: "string"}
 	case reflect.Slice, reflect.Array:
-		return &Schema{
+		if t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 {
+			// []byte is encoded in base64.
+			schema = &Schema{Type: "string", Format: "byte"}
+			break
+		}
+		schema = &Schema{
 			Type:  "array",
 			Items: g.typeToSchema(t.Elem()),
 		}
 	case reflect.Map:
-		return &Schema{
-			Type: "object",
+		schema = &Schema{
+			Type:                 "object",
+			AdditionalProperties: g.typeToSchema(t.Elem()),
 		}
 	case reflect.Struct:
-		return g.structToSchema(t)
+		schema = g.structToSchema(t)
 	case reflect.Interface:
-		return &Schema{Type: "object"}
+		schema = &Schema{}
 	default:
-		return &Schema{Type: "object"}
+		schema = &Schema{Type: "object"}
 	}
+
+	if values := enumValues(t); len(values) > 0 {
+		schema.Enum = values
+	}
+	return schema
+}
+
+// enumValues returns the values of t if it implements Enumer.
+func enumValues(t reflect.Type) []any {
+	if t.Implements(enumerType) {
+		return reflect.Zero(t).Interface().(Enumer).Enum()
+	}
+	if reflect.PointerTo(t).Implements(enumerType) {
+		return reflect.New(t).Interface().(Enumer).Enum()
+	}
+	return nil
 }
 
 func (g *Generator) structToSchema(t reflect.Type) *Schema {
@@ -398,36 +758,232 @@ func (g *Generator) structToSchema(t reflect.Type) *Schema {
 		Properties: make(map[string]*Schema),
 	}
 
-	for i := 0; i < t.NumField(); i++ {
-		field := t.Field(i)
+	for _, field := range jsonFields(t) {
+		rules := talk.ParseValidationTag(field.Tag.Get("validate"))
 
-		// Skip unexported fields
-		if !field.IsExported() {
+		fieldSchema := g.typeToSchema(field.Type)
+		if fieldSchema == nil {
 			continue
 		}
+		applyValidationRules(fieldSchema, field.Type, rules)
+		applyEnumTag(fieldSchema, field.Type, field.Tag.Get("enum"))
+
+		if doc := g.typeDoc(field.owner); doc != nil && doc.Fields[field.Name] != "" {
+			if fieldSchema.Ref != "" {
+				// $ref siblings are ignored, wrap it to describe the field.
+				fieldSchema = &Schema{AllOf: []*Schema{fieldSchema}}
+			}
+			fieldSchema.Description = doc.Fields[field.Name]
+		}
+		schema.Properties[field.jsonName] = fieldSchema
 
-		// Get JSON tag name
-		name := field.Name
-		jsonTag := field.Tag.Get("json")
-		if jsonTag != "" {
-			parts := strings.Split(jsonTag, ",")
-			if parts[0] != "" && parts[0] != "-" {
-				name = parts[0]
-			} else if parts[0] == "-" {
+		// Check for required fields
+		if !(field.opts["omitempty"] || field.opts["omitzero"]) || hasRule(rules, "required") {
+			schema.Required = append(schema.Required, field.jsonName)
+		}
+	}
+
+	return schema
+}
+
+// jsonField is a field of a struct as encoded by encoding/json.
+type jsonField struct {
+	reflect.StructField
+	jsonName string
+	opts     map[string]bool
+	owner    reflect.Type // struct declaring the field
+	depth    int          // embedding depth
+	tagged   bool
+}
+
+// jsonFields returns the fields of t encoded by encoding/json: the fields
+// of embedded structs are promoted, unless shadowed by shallower ones or
+// ambiguous.
+func jsonFields(t reflect.Type) []jsonField {
+	var all []jsonField
+	collectJSONFields(t, 0, map[reflect.Type]bool{}, &all)
+
+	byName := make(map[string][]jsonField)
+	var names []string
+	for _, f := range all {
+		if _, ok := byName[f.jsonName]; !ok {
+			names = append(names, f.jsonName)
+		}
+		byName[f.jsonName] = append(byName[f.jsonName], f)
+	}
+
+	var fields []jsonField
+	for _, name := range names {
+		if f, ok := dominantField(byName[name]); ok {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+func collectJSONFields(t reflect.Type, depth int, visiting map[reflect.Type]bool, fields *[]jsonField) {
+	visiting[t] = true
+	defer delete(visiting, t)
+
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+
+		if sf.Anonymous && name == "" {
+			et := sf.Type
+			for et.Kind() == reflect.Ptr {
+				et = et.Elem()
+			}
+			if et.Kind() == reflect.Struct && et != timeType {
+				if !visiting[et] {
+					collectJSONFields(et, depth+1, visiting, fields)
+				}
 				continue
 			}
 		}
+		if !sf.IsExported() {
+			continue
+		}
 
-		fieldSchema := g.typeToSchema(field.Type)
-		if fieldSchema != nil {
-			schema.Properties[name] = fieldSchema
+		f := jsonField{
+			StructField: sf,
+			jsonName:    name,
+			opts:        make(map[string]bool),
+			owner:       t,
+			depth:       depth,
+			tagged:      name != "",
+		}
+		if f.jsonName == "" {
+			f.jsonName = sf.Name
 		}
+		for _, opt := range strings.Split(opts, ",") {
+			f.opts[opt] = true
+		}
+		*fields = append(*fields, f)
+	}
+}
 
-		// Check for required fields
-		if !strings.Contains(jsonTag, "omitempty") {
-			schema.Required = append(schema.Required, name)
+// dominantField returns the field encoded among fields of the same name:
+// the shallowest one, or the only tagged one of the shallowest.
+func dominantField(fields []jsonField) (jsonField, bool) {
+	minDepth := fields[0].depth
+	for _, f := range fields {
+		minDepth = min(minDepth, f.depth)
+	}
+
+	var shallowest, tagged []jsonField
+	for _, f := range fields {
+		if f.depth != minDepth {
+			continue
+		}
+		shallowest = append(shallowest, f)
+		if f.tagged {
+			tagged = append(tagged, f)
 		}
 	}
 
-	return schema
+	switch {
+	case len(shallowest) == 1:
+		return shallowest[0], true
+	case len(tagged) == 1:
+		return tagged[0], true
+	}
+	return jsonField{}, false
+}
+
+// applyEnumTag sets the values of an `enum:"a,b"` tag as the enum of a
+// field schema.
+func applyEnumTag(schema *Schema, t reflect.Type, tag string) {
+	if tag == "" || schema.Ref != "" {
+		return
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	schema.Enum = nil
+	for _, opt := range strings.Split(tag, ",") {
+		schema.Enum = append(schema.Enum, enumValue(t, strings.TrimSpace(opt)))
+	}
+}
+
+// applyValidationRules reflects `validate` tag rules into a field schema.
+// Referenced schemas are left alone since $ref siblings are ignored.
+func applyValidationRules(schema *Schema, t reflect.Type, rules []talk.ValidationRule) {
+	if schema.Ref != "" {
+		return
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	for _, r := range rules {
+		switch r.Name {
+		case "email":
+			schema.Format = "email"
+		case "oneof":
+			for _, opt := range strings.Fields(r.Param) {
+				schema.Enum = append(schema.Enum, enumValue(t, opt))
+			}
+		case "min", "max", "len":
+			n, err := strconv.ParseFloat(r.Param, 64)
+			if err != nil {
+				continue
+			}
+			switch t.Kind() {
+			case reflect.String:
+				setBound(r.Name, &schema.MinLength, &schema.MaxLength, int(n))
+			case reflect.Slice, reflect.Array:
+				setBound(r.Name, &schema.MinItems, &schema.MaxItems, int(n))
+			default:
+				if isNumeric(t) {
+					setBound(r.Name, &schema.Minimum, &schema.Maximum, n)
+				}
+			}
+		}
+	}
+}
+
+func setBound[T any](rule string, min, max **T, n T) {
+	switch rule {
+	case "min":
+		*min = &n
+	case "max":
+		*max = &n
+	case "len":
+		*min, *max = &n, &n
+	}
+}
+
+// enumValue converts a oneof option to the JSON type of the field.
+func enumValue(t reflect.Type, opt string) any {
+	if isNumeric(t) {
+		if n, err := strconv.ParseFloat(opt, 64); err == nil {
+			return n
+		}
+	}
+	return opt
+}
+
+func isNumeric(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+func hasRule(rules []talk.ValidationRule, name string) bool {
+	for _, r := range rules {
+		if r.Name == name {
+			return true
+		}
+	}
+	return false
 }
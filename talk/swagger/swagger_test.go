@@ -2,9 +2,14 @@ package swagger
 
 import (
 	"encoding/json"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
+	"go.zoe.im/x"
 	"go.zoe.im/x/talk"
 )
 
@@ -145,3 +150,360 @@ func TestGenerator_StreamingEndpoint(t *testing.T) {
 		t.Error("expected text/event-stream content type for streaming endpoint")
 	}
 }
+
+func TestGenerator_ValidationRules(t *testing.T) {
+	type CreateAccountRequest struct {
+		Name  string   `json:"name,omitempty" validate:"required,min=1,max=64"`
+		Email string   `json:"email" validate:"email"`
+		Role  string   `json:"role,omitempty" validate:"omitempty,oneof=admin member"`
+		Level int      `json:"level,omitempty" validate:"min=1,max=10,oneof=1 5 10"`
+		Tags  []string `json:"tags,omitempty" validate:"max=3"`
+	}
+
+	gen := NewGenerator(Config{Title: "Test API"})
+	spec := gen.Generate([]*talk.Endpoint{
+		{
+			Name:        "CreateAccount",
+			Path:        "/accounts",
+			Method:      "POST",
+			RequestType: reflect.TypeOf(CreateAccountRequest{}),
+		},
+	})
+
+	schema := spec.Components.Schemas["CreateAccountRequest"]
+	if schema == nil {
+		t.Fatal("expected CreateAccountRequest component")
+	}
+
+	required := map[string]bool{}
+	for _, name := range schema.Required {
+		required[name] = true
+	}
+	if !required["name"] || !required["email"] || required["role"] {
+		t.Errorf("required = %v, want name and email only", schema.Required)
+	}
+
+	name := schema.Properties["name"]
+	if name.MinLength == nil || *name.MinLength != 1 || name.MaxLength == nil || *name.MaxLength != 64 {
+		t.Errorf("name length bounds = %v/%v, want 1/64", name.MinLength, name.MaxLength)
+	}
+	if schema.Properties["email"].Format != "email" {
+		t.Errorf("email format = %q, want email", schema.Properties["email"].Format)
+	}
+	if enum := schema.Properties["role"].Enum; len(enum) != 2 || enum[0] != "admin" || enum[1] != "member" {
+		t.Errorf("role enum = %v, want [admin member]", enum)
+	}
+
+	level := schema.Properties["level"]
+	if level.Minimum == nil || *level.Minimum != 1 || level.Maximum == nil || *level.Maximum != 10 {
+		t.Errorf("level bounds = %v/%v, want 1/10", level.Minimum, level.Maximum)
+	}
+	if len(level.Enum) != 3 || level.Enum[1] != float64(5) {
+		t.Errorf("level enum = %v, want numeric [1 5 10]", level.Enum)
+	}
+	if tags := schema.Properties["tags"]; tags.MaxItems == nil || *tags.MaxItems != 3 {
+		t.Errorf("tags maxItems = %v, want 3", tags.MaxItems)
+	}
+}
+
+type accountStatus string
+
+func (accountStatus) Enum() []any {
+	return []any{"active", "suspended"}
+}
+
+type auditInfo struct {
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedBy string    `json:"updated_by,omitempty"`
+}
+
+type account struct {
+	auditInfo
+	ID       string            `json:"id"`
+	Status   accountStatus     `json:"status"`
+	Plan     string            `json:"plan,omitzero" enum:"free,pro"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	Timeout  x.Duration        `json:"timeout"`
+	Interval time.Duration     `json:"interval"`
+	Extra    json.RawMessage   `json:"extra,omitempty"`
+	Avatar   []byte            `json:"avatar,omitempty"`
+	Parent   *account          `json:"parent,omitempty"`
+	Owner    *owner            `json:"owner"`
+}
+
+type owner struct {
+	Name string `json:"name"`
+}
+
+var ownerType = reflect.TypeOf(owner{})
+
+type page[T any] struct {
+	Items []T    `json:"items"`
+	Next  string `json:"next,omitempty"`
+}
+
+func TestGenerator_ComponentSchemas(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "types.go")
+	if err := os.WriteFile(src, []byte(`package swagger
+
+// account is an account.
+type account struct {
+	// ID identifies the account.
+	ID string
+	Owner *owner // owner of the account
+}
+
+// auditInfo records changes.
+type auditInfo struct {
+	UpdatedBy string // last editor
+}
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	gen := NewGenerator(Config{Title: "Test API", Sources: []string{src}})
+	spec := gen.Generate([]*talk.Endpoint{
+		{
+			Name:         "ListAccounts",
+			Path:         "/accounts",
+			Method:       "GET",
+			ResponseType: reflect.TypeOf(page[*account]{}),
+		},
+		{
+			Name:         "GetAccount",
+			Path:         "/accounts/{id}",
+			Method:       "GET",
+			ResponseType: reflect.TypeOf(&account{}),
+		},
+	})
+
+	schemas := spec.Components.Schemas
+	pageSchema := schemas["page_account"]
+	if pageSchema == nil {
+		t.Fatalf("expected page_account component, got %v", keys(schemas))
+	}
+	if ref := pageSchema.Properties["items"].Items.Ref; ref != "#/components/schemas/account" {
+		t.Errorf("page items = %q, want account reference", ref)
+	}
+	if ref := spec.Paths["/accounts/{id}"].Get.Responses["200"].Content["application/json"].Schema.Ref; ref != "#/components/schemas/account" {
+		t.Errorf("GetAccount response = %q, want account reference", ref)
+	}
+
+	acc := schemas["account"]
+	if acc == nil {
+		t.Fatal("expected account component")
+	}
+	if acc.Description != "account is an account." {
+		t.Errorf("account description = %q", acc.Description)
+	}
+	if acc.Properties["id"].Description != "ID identifies the account." {
+		t.Errorf("id description = %q", acc.Properties["id"].Description)
+	}
+	if o := acc.Properties["owner"]; len(o.AllOf) != 1 || o.AllOf[0].Ref != "#/components/schemas/owner" || o.Description != "owner of the account" {
+		t.Errorf("owner = %+v, want described owner reference", o)
+	}
+	if acc.Properties["parent"].Ref != "#/components/schemas/account" {
+		t.Errorf("parent = %+v, want recursive account reference", acc.Properties["parent"])
+	}
+
+	// Embedded fields are promoted.
+	if s := acc.Properties["created_at"]; s == nil || s.Type != "string" || s.Format != "date-time" {
+		t.Errorf("created_at = %+v, want date-time string", s)
+	}
+	if s := acc.Properties["updated_by"]; s == nil || s.Description != "last editor" {
+		t.Errorf("updated_by = %+v, want promoted and described", s)
+	}
+	if _, ok := acc.Properties["auditInfo"]; ok {
+		t.Error("embedded struct should not be a property")
+	}
+
+	required := map[string]bool{}
+	for _, name := range acc.Required {
+		required[name] = true
+	}
+	if !required["id"] || !required["created_at"] || required["plan"] || required["labels"] || required["updated_by"] {
+		t.Errorf("required = %v", acc.Required)
+	}
+
+	if ref := acc.Properties["status"].Ref; ref != "#/components/schemas/accountStatus" {
+		t.Errorf("status = %q, want accountStatus reference", ref)
+	}
+	if status := schemas["accountStatus"]; status == nil || status.Type != "string" || len(status.Enum) != 2 || status.Enum[0] != "active" {
+		t.Errorf("accountStatus = %+v, want string enum", status)
+	}
+	if enum := acc.Properties["plan"].Enum; len(enum) != 2 || enum[0] != "free" || enum[1] != "pro" {
+		t.Errorf("plan enum = %v, want [free pro]", enum)
+	}
+
+	if s := acc.Properties["labels"]; s.Type != "object" || s.AdditionalProperties == nil || s.AdditionalProperties.Type != "string" {
+		t.Errorf("labels = %+v, want string map", s)
+	}
+	if s := acc.Properties["timeout"]; s.Type != "string" || s.Format != "duration" {
+		t.Errorf("timeout = %+v, want duration string", s)
+	}
+	if s := acc.Properties["interval"]; s.Type != "integer" {
+		t.Errorf("interval = %+v, want integer", s)
+	}
+	if s := acc.Properties["extra"]; s.Type != "" || s.Ref != "" {
+		t.Errorf("extra = %+v, want any", s)
+	}
+	if s := acc.Properties["avatar"]; s.Type != "string" || s.Format != "byte" {
+		t.Errorf("avatar = %+v, want base64 string", s)
+	}
+}
+
+func TestGenerator_ComponentNames(t *testing.T) {
+	type owner struct {
+		ID int `json:"id"`
+	}
+
+	gen := NewGenerator(Config{})
+	first := gen.typeToSchema(reflect.TypeOf(struct{ A, B owner }{}).Field(0).Type)
+	second := gen.typeToSchema(reflect.TypeOf(struct{ O *owner }{}).Field(0).Type)
+	other := gen.typeToSchema(reflect.TypeOf(page[map[string]int]{}))
+
+	if first.Ref != second.Ref {
+		t.Errorf("same type refs = %q, %q", first.Ref, second.Ref)
+	}
+	if first.Ref != "#/components/schemas/owner" {
+		t.Errorf("ref = %q", first.Ref)
+	}
+	if other.Ref != "#/components/schemas/page_map_string_int" {
+		t.Errorf("generic ref = %q", other.Ref)
+	}
+
+	// Another type of the same name gets a distinct component.
+	if ref := gen.typeToSchema(ownerType).Ref; ref != "#/components/schemas/owner2" {
+		t.Errorf("package owner ref = %q, want owner2", ref)
+	}
+}
+
+func TestGenerator_StreamsErrorsSecurity(t *testing.T) {
+	type chatMessage struct {
+		Text string `json:"text"`
+	}
+
+	gen := NewGenerator(Config{Title: "Test API"})
+	spec := gen.Generate([]*talk.Endpoint{
+		{
+			Name:         "WatchMessages",
+			Path:         "/messages/watch",
+			Method:       "GET",
+			StreamMode:   talk.StreamServerSide,
+			ResponseType: reflect.TypeOf(chatMessage{}),
+			Metadata:     map[string]any{"auth": "token"},
+		},
+		{
+			Name:         "SearchMessages",
+			Path:         "/messages/search",
+			Method:       "POST",
+			StreamMode:   talk.StreamServerSide,
+			RequestType:  reflect.TypeOf(chatMessage{}),
+			ResponseType: reflect.TypeOf(chatMessage{}),
+		},
+		{
+			Name:         "Chat",
+			Path:         "/chat",
+			Method:       "POST",
+			StreamMode:   talk.StreamBidirect,
+			RequestType:  reflect.TypeOf((<-chan chatMessage)(nil)),
+			ResponseType: reflect.TypeOf(chatMessage{}),
+		},
+		{
+			Name:         "DeleteMessage",
+			Path:         "/messages/{id}",
+			Method:       "DELETE",
+			RequestType:  reflect.TypeOf(""),
+			ResponseType: reflect.TypeOf(chatMessage{}),
+			Metadata:     map[string]any{"auth": talk.AuthAdmin, "ratelimit": "10/s"},
+		},
+	})
+
+	msgRef := "#/components/schemas/chatMessage"
+	errRef := "#/components/schemas/Error"
+
+	watch := spec.Paths["/messages/watch"].Get
+	if watch.Stream == nil || watch.Stream.Mode != "server" || watch.Stream.Response.Ref != msgRef {
+		t.Errorf("watch stream = %+v", watch.Stream)
+	}
+	event := watch.Responses["200"].Content["text/event-stream"].Schema
+	if event == nil || event.Properties["data"].Ref != msgRef || event.Properties["id"] == nil {
+		t.Errorf("event schema = %+v", event)
+	}
+	if len(watch.Security) != 1 || watch.Security[0]["token"] == nil {
+		t.Errorf("watch security = %v, want token", watch.Security)
+	}
+	if _, ok := watch.Responses["401"]; !ok {
+		t.Error("expected 401 response for authenticated endpoint")
+	}
+
+	// A server-side stream takes a single JSON request.
+	search := spec.Paths["/messages/search"].Post
+	if search.RequestBody == nil || search.RequestBody.Content["application/json"].Schema.Ref != msgRef {
+		t.Errorf("search request body = %+v, want JSON chatMessage", search.RequestBody)
+	}
+
+	chat := spec.Paths["/chat"].Post
+	if chat.Stream == nil || chat.Stream.Mode != "bidirectional" || chat.Stream.Request.Ref != msgRef {
+		t.Errorf("chat stream = %+v", chat.Stream)
+	}
+	if chat.RequestBody == nil || chat.RequestBody.Content["application/x-ndjson"].Schema.Ref != msgRef {
+		t.Errorf("chat request body = %+v", chat.RequestBody)
+	}
+	if chat.Responses["200"].Content["application/x-ndjson"].Schema.Ref != msgRef {
+		t.Errorf("chat response = %+v", chat.Responses["200"])
+	}
+	if chat.Security != nil {
+		t.Errorf("chat security = %v, want none", chat.Security)
+	}
+
+	del := spec.Paths["/messages/{id}"].Delete
+	for status, want := range map[string]string{
+		"400":     "INVALID_ARGUMENT, OUT_OF_RANGE",
+		"401":     "UNAUTHENTICATED",
+		"403":     "PERMISSION_DENIED",
+		"404":     "NOT_FOUND",
+		"429":     "RESOURCE_EXHAUSTED",
+		"default": "Error",
+	} {
+		resp, ok := del.Responses[status]
+		if !ok {
+			t.Errorf("missing %s response", status)
+			continue
+		}
+		if resp.Description != want {
+			t.Errorf("%s description = %q, want %q", status, resp.Description, want)
+		}
+		if resp.Content["application/json"].Schema.Ref != errRef {
+			t.Errorf("%s schema = %+v, want Error reference", status, resp.Content["application/json"].Schema)
+		}
+	}
+	if len(del.Security) != 1 || del.Security[0]["admin"] == nil {
+		t.Errorf("delete security = %v, want admin", del.Security)
+	}
+
+	errSchema := spec.Components.Schemas["Error"]
+	if errSchema == nil || errSchema.Properties["code"] == nil || len(errSchema.Properties["code"].Enum) != 16 {
+		t.Fatalf("Error schema = %+v", errSchema)
+	}
+	schemes := spec.Components.SecuritySchemes
+	if len(schemes) != 2 || schemes["token"].Scheme != "bearer" || schemes["admin"].Type != "http" {
+		t.Errorf("security schemes = %+v", schemes)
+	}
+
+	data, err := json.Marshal(spec)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if !strings.Contains(string(data), `"x-talk-stream":{"mode":"bidirectional"`) {
+		t.Errorf("spec has no x-talk-stream extension: %s", data)
+	}
+}
+
+func keys(m map[string]*Schema) []string {
+	var names []string
+	for name := range m {
+		names = append(names, name)
+	}
+	return names
+}
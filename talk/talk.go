@@ -2,6 +2,10 @@ package talk
 
 import (
 	"context"
+	"errors"
+	"slices"
+	"sync"
+	"sync/atomic"
 
 	"go.zoe.im/x/talk/codec"
 )
@@ -31,6 +35,15 @@ type Server struct {
 	pathPrefix string
 	endpoints  []*Endpoint
 	middleware []MiddlewareFunc
+
+	streamMiddleware []StreamMiddlewareFunc
+
+	services []any // registered service implementations
+	initMu   sync.Mutex
+	inited   []any // services initialized by Serve, guarded by initMu
+	health   bool
+	ready    atomic.Bool
+	drain    drainer
 }
 
 // NewServer creates a new server with the given transport.
@@ -102,11 +115,17 @@ func (s *Server) Register(service any, opts ...RegisterOption) error {
 	// Apply server-level middleware to all endpoints
 	if len(s.middleware) > 0 {
 		for _, ep := range endpoints {
-			ep.Middleware = append(s.middleware, ep.Middleware...)
+			ep.Middleware = append(slices.Clip(s.middleware), ep.Middleware...)
+		}
+	}
+	if len(s.streamMiddleware) > 0 {
+		for _, ep := range endpoints {
+			ep.StreamMiddleware = append(slices.Clip(s.streamMiddleware), ep.StreamMiddleware...)
 		}
 	}
 
 	s.endpoints = append(s.endpoints, endpoints...)
+	s.services = append(s.services, service)
 	return nil
 }
 
@@ -138,22 +157,63 @@ func (s *Server) Endpoints() []*Endpoint {
 	return s.endpoints
 }
 
-// Serve starts the server and blocks until context is cancelled.
+// Serve calls x.TryInit on the registered services, then starts the server
+// and blocks until context is cancelled. Services are closed by Shutdown.
 func (s *Server) Serve(ctx context.Context) error {
-	return s.transport.Serve(ctx, s.endpoints)
+	if err := s.initServices(ctx); err != nil {
+		return err
+	}
+
+	s.drain.reset()
+	endpoints := s.drain.wrap(s.endpoints)
+	if s.health {
+		endpoints = append(endpoints, s.healthEndpoints()...)
+	}
+	s.ready.Store(true)
+	defer s.ready.Store(false)
+	return s.transport.Serve(ctx, endpoints)
 }
 
-// Shutdown gracefully stops the server.
+// Shutdown gracefully stops the server. It marks the server not ready,
+// answers new calls with Unavailable, waits for in-flight unary calls,
+// ends open streams with an Unavailable error and waits for their handlers,
+// all until ctx is done. It then shuts the transport down and calls
+// x.TryClose on the services in reverse registration order.
 func (s *Server) Shutdown(ctx context.Context) error {
-	return s.transport.Shutdown(ctx)
+	s.ready.Store(false)
+	errs := []error{
+		s.drain.drain(ctx),
+		s.transport.Shutdown(ctx),
+		s.closeServices(ctx),
+	}
+	return errors.Join(errs...)
 }
 
 // Client invokes remote endpoints.
 type Client struct {
-	transport Transport
-	codec     codec.Codec
+	transport  Transport
+	codec      codec.Codec
+	middleware []ClientMiddlewareFunc
+	invoke     ClientInvokeFunc
+}
+
+// ClientCall describes a single outgoing call as seen by client middleware.
+type ClientCall struct {
+	Endpoint  string // Endpoint name or path passed to Call/Stream
+	Request   any    // Request payload
+	Response  any    // Destination for the decoded response (unary calls only)
+	Streaming bool   // True for calls made through Client.Stream
+	Stream    Stream // Stream opened by the transport (streaming calls only)
 }
 
+// ClientInvokeFunc performs an outgoing call. For streaming calls it must
+// set call.Stream on success.
+type ClientInvokeFunc func(ctx context.Context, call *ClientCall) error
+
+// ClientMiddlewareFunc wraps a ClientInvokeFunc to add pre/post processing
+// logic to every call made by a Client, unary and streaming alike.
+type ClientMiddlewareFunc func(next ClientInvokeFunc) ClientInvokeFunc
+
 // NewClient creates a new client with the given transport.
 func NewClient(t Transport, opts ...ClientOption) *Client {
 	c := &Client{transport: t}
@@ -167,17 +227,56 @@ func NewClient(t Transport, opts ...ClientOption) *Client {
 		}
 	}
 
+	c.invoke = c.transportInvoke
+	// Apply in reverse so that middleware[0] is the outermost
+	for i := len(c.middleware) - 1; i >= 0; i-- {
+		c.invoke = c.middleware[i](c.invoke)
+	}
+
 	return c
 }
 
 // Call invokes an endpoint and decodes the response.
 func (c *Client) Call(ctx context.Context, endpoint string, req any, resp any) error {
-	return c.transport.Invoke(ctx, endpoint, req, resp)
+	return c.invoke(ctx, &ClientCall{
+		Endpoint: endpoint,
+		Request:  req,
+		Response: resp,
+	})
 }
 
 // Stream opens a streaming connection to an endpoint.
 func (c *Client) Stream(ctx context.Context, endpoint string, req any) (Stream, error) {
-	return c.transport.InvokeStream(ctx, endpoint, req)
+	call := &ClientCall{
+		Endpoint:  endpoint,
+		Request:   req,
+		Streaming: true,
+	}
+	if err := c.invoke(ctx, call); err != nil {
+		return nil, err
+	}
+	return call.Stream, nil
+}
+
+// Invoke calls endpoint with c and returns the response decoded as a Resp,
+// for typed clients such as the ones generated by talk-gen.
+func Invoke[Resp any](ctx context.Context, c *Client, endpoint string, req any) (Resp, error) {
+	var resp Resp
+	err := c.Call(ctx, endpoint, req, &resp)
+	return resp, err
+}
+
+// transportInvoke is the innermost ClientInvokeFunc that hands the call to the transport.
+func (c *Client) transportInvoke(ctx context.Context, call *ClientCall) error {
+	if call.Streaming {
+		stream, err := c.transport.InvokeStream(ctx, call.Endpoint, call.Request)
+		if err != nil {
+			return err
+		}
+		call.Stream = stream
+		return nil
+	}
+	return c.transport.Invoke(ctx, call.Endpoint, call.Request, call.Response)
 }
 
 // Close closes the client connection.
@@ -225,6 +324,14 @@ func WithServerMiddleware(mw ...MiddlewareFunc) ServerOption {
 	}
 }
 
+// WithServerStreamMiddleware adds stream middleware that will be applied to
+// all streaming endpoints.
+func WithServerStreamMiddleware(mw ...StreamMiddlewareFunc) ServerOption {
+	return func(s *Server) {
+		s.streamMiddleware = append(s.streamMiddleware, mw...)
+	}
+}
+
 // ClientOption configures a Client.
 type ClientOption func(*Client)
 
@@ -243,3 +350,12 @@ func WithClientCodecName(name string) ClientOption {
 		}
 	}
 }
+
+// WithClientMiddleware adds middleware that will be applied to every call
+// made by the client. Middleware is applied in order: the first middleware
+// is the outermost wrapper.
+func WithClientMiddleware(mw ...ClientMiddlewareFunc) ClientOption {
+	return func(cl *Client) {
+		cl.middleware = append(cl.middleware, mw...)
+	}
+}
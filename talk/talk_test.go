@@ -3,7 +3,10 @@ package talk
 import (
 	"context"
 	"io"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"go.zoe.im/x"
 )
@@ -257,6 +260,125 @@ func TestClient_Stream(t *testing.T) {
 	}
 }
 
+func TestInvoke(t *testing.T) {
+	transport := &mockTransport{
+		invokeFunc: func(ctx context.Context, endpoint string, req any, resp any) error {
+			*resp.(*int) = len(req.(string))
+			return nil
+		},
+	}
+
+	n, err := Invoke[int](context.Background(), NewClient(transport), "Len", "hello")
+	if err != nil || n != 5 {
+		t.Errorf("Invoke = %d, %v, want 5", n, err)
+	}
+}
+
+func TestClient_WithClientMiddleware(t *testing.T) {
+	type ctxKeyTest struct{}
+	var order []string
+	var gotValue any
+
+	transport := &mockTransport{
+		invokeFunc: func(ctx context.Context, endpoint string, req any, resp any) error {
+			order = append(order, "transport")
+			gotValue = ctx.Value(ctxKeyTest{})
+			return nil
+		},
+	}
+
+	mw := func(name string) ClientMiddlewareFunc {
+		return func(next ClientInvokeFunc) ClientInvokeFunc {
+			return func(ctx context.Context, call *ClientCall) error {
+				order = append(order, name+"-before")
+				if call.Endpoint != "Ping" || call.Streaming {
+					t.Errorf("unexpected call %+v", call)
+				}
+				err := next(context.WithValue(ctx, ctxKeyTest{}, name), call)
+				order = append(order, name+"-after")
+				return err
+			}
+		}
+	}
+
+	client := NewClient(transport, WithClientMiddleware(mw("first"), mw("second")))
+
+	if err := client.Call(context.Background(), "Ping", nil, nil); err != nil {
+		t.Fatalf("Call failed: %v", err)
+	}
+
+	expected := []string{"first-before", "second-before", "transport", "second-after", "first-after"}
+	if len(order) != len(expected) {
+		t.Fatalf("order = %v, want %v", order, expected)
+	}
+	for i := range expected {
+		if order[i] != expected[i] {
+			t.Errorf("order[%d] = %q, want %q", i, order[i], expected[i])
+		}
+	}
+	if gotValue != "second" {
+		t.Errorf("transport ctx value = %v, want %q", gotValue, "second")
+	}
+}
+
+func TestClient_WithClientMiddleware_Stream(t *testing.T) {
+	mockStream := NewChanStream[string](context.Background(), 10)
+
+	transport := &mockTransport{
+		invokeStreamFunc: func(ctx context.Context, endpoint string, req any) (Stream, error) {
+			return mockStream, nil
+		},
+	}
+
+	var sawStream Stream
+	mw := func(next ClientInvokeFunc) ClientInvokeFunc {
+		return func(ctx context.Context, call *ClientCall) error {
+			if !call.Streaming {
+				t.Error("expected streaming call")
+			}
+			err := next(ctx, call)
+			sawStream = call.Stream
+			return err
+		}
+	}
+
+	client := NewClient(transport, WithClientMiddleware(mw))
+
+	stream, err := client.Stream(context.Background(), "/events", nil)
+	if err != nil {
+		t.Fatalf("Stream failed: %v", err)
+	}
+	if stream != mockStream || sawStream != mockStream {
+		t.Error("middleware and caller should observe the transport stream")
+	}
+}
+
+func TestClient_WithClientMiddleware_ShortCircuit(t *testing.T) {
+	transport := &mockTransport{
+		invokeFunc: func(ctx context.Context, endpoint string, req any, resp any) error {
+			t.Error("transport should not be called")
+			return nil
+		},
+	}
+
+	deny := func(next ClientInvokeFunc) ClientInvokeFunc {
+		return func(ctx context.Context, call *ClientCall) error {
+			return NewError(PermissionDenied, "denied")
+		}
+	}
+
+	client := NewClient(transport, WithClientMiddleware(deny))
+
+	err := client.Call(context.Background(), "Ping", nil, nil)
+	if talkErr, ok := IsError(err); !ok || talkErr.Code != PermissionDenied {
+		t.Errorf("expected PermissionDenied, got %v", err)
+	}
+
+	if _, err := client.Stream(context.Background(), "/events", nil); err == nil {
+		t.Error("expected Stream to fail")
+	}
+}
+
 func TestClient_Close(t *testing.T) {
 	transport := &mockTransport{}
 	client := NewClient(transport)
@@ -290,6 +412,54 @@ func TestEndpoint_IsStreaming(t *testing.T) {
 	}
 }
 
+func TestEndpoint_IsIdempotent(t *testing.T) {
+	tests := []struct {
+		value    any
+		expected bool
+	}{
+		{nil, false},
+		{true, true},
+		{false, false},
+		{"true", true},
+		{"1", true},
+		{"no", false},
+	}
+
+	for _, tt := range tests {
+		ep := &Endpoint{}
+		if tt.value != nil {
+			ep.Metadata = map[string]any{IdempotentMetadataKey: tt.value}
+		}
+		if got := ep.IsIdempotent(); got != tt.expected {
+			t.Errorf("IsIdempotent() with %v = %v, want %v", tt.value, got, tt.expected)
+		}
+	}
+}
+
+func TestEndpoint_WrappedStreamHandler(t *testing.T) {
+	var order []string
+	mw := func(name string) StreamMiddlewareFunc {
+		return func(next StreamEndpointFunc) StreamEndpointFunc {
+			return func(ctx context.Context, req any, stream Stream) error {
+				order = append(order, name)
+				return next(ctx, req, stream)
+			}
+		}
+	}
+
+	ep := NewStreamEndpoint("Watch", func(ctx context.Context, req any, stream Stream) error {
+		order = append(order, "handler")
+		return nil
+	}, StreamServerSide, WithStreamMiddleware(mw("first"), mw("second")))
+
+	if err := ep.WrappedStreamHandler()(context.Background(), nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(order, ","); got != "first,second,handler" {
+		t.Errorf("order = %s, want first,second,handler", got)
+	}
+}
+
 func TestEndpoint_Clone(t *testing.T) {
 	original := &Endpoint{
 		Name:   "GetUser",
@@ -474,6 +644,110 @@ func TestChanStream_Close(t *testing.T) {
 	}
 }
 
+func TestChanStream_CloseWhileSending(t *testing.T) {
+	stream := NewChanStream[string](context.Background(), 0)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for stream.Send("hello") == nil {
+			}
+		}()
+	}
+	go func() {
+		for range stream.sendCh {
+		}
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	stream.Close()
+	wg.Wait()
+
+	if err := stream.Send("hello"); err != io.ErrClosedPipe {
+		t.Errorf("Send after close should return ErrClosedPipe, got %v", err)
+	}
+}
+
+func TestSendAll_RecvAll(t *testing.T) {
+	ctx := context.Background()
+	client, server := NewChanStreamPair[string](ctx, 1)
+
+	in := make(chan string, 2)
+	in <- "a"
+	in <- "b"
+	close(in)
+	go SendAll(ctx, client, in)
+
+	var got []string
+	r := RecvAll[string](ctx, server)
+	for msg := range r.C {
+		got = append(got, msg)
+	}
+	if strings.Join(got, ",") != "a,b" {
+		t.Errorf("RecvAll = %v, want [a b]", got)
+	}
+	if err := r.Err(); err != nil {
+		t.Errorf("Err = %v, want nil at the end of the stream", err)
+	}
+}
+
+// failingStream fails Recv with err and has no CloseSend.
+type failingStream struct {
+	Stream
+	err error
+}
+
+func (s failingStream) Recv(msg any) error { return s.err }
+func (s failingStream) Close() error       { return nil }
+
+func TestRecvAll_Error(t *testing.T) {
+	ctx := context.Background()
+	want := NewError(Unavailable, "connection lost")
+	r := RecvAll[string](ctx, failingStream{err: want})
+	for range r.C {
+	}
+	if err := r.Err(); err != want {
+		t.Errorf("Err = %v, want %v", err, want)
+	}
+}
+
+func TestSendAll_NoCloseSend(t *testing.T) {
+	ctx := context.Background()
+	in := make(chan string)
+	close(in)
+	err := SendAll(ctx, failingStream{err: io.EOF}, in)
+	if te, ok := IsError(err); !ok || te.Code != Unimplemented {
+		t.Errorf("SendAll = %v, want Unimplemented", err)
+	}
+
+	// SendRecvAll closes the stream and reports the failure.
+	r := SendRecvAll[string, string](ctx, &blockingStream{done: make(chan struct{})}, in)
+	for range r.C {
+	}
+	if te, ok := IsError(r.Err()); !ok || te.Code != Unimplemented {
+		t.Errorf("SendRecvAll Err = %v, want Unimplemented", r.Err())
+	}
+}
+
+// blockingStream blocks Recv until closed and has no CloseSend.
+type blockingStream struct {
+	Stream
+	done chan struct{}
+	once sync.Once
+}
+
+func (s *blockingStream) Recv(msg any) error {
+	<-s.done
+	return io.EOF
+}
+
+func (s *blockingStream) Close() error {
+	s.once.Do(func() { close(s.done) })
+	return nil
+}
+
 func TestChanStream_RecvAfterClose(t *testing.T) {
 	stream := NewChanStream[string](context.Background(), 1)
 	stream.Close()
@@ -574,6 +848,61 @@ func TestChanStream_ContextCancellation(t *testing.T) {
 	}
 }
 
+func TestChanStreamPair_SendRecv(t *testing.T) {
+	a, b := NewChanStreamPair[string](context.Background(), 1)
+
+	if err := a.Send("ping"); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	var msg string
+	if err := b.Recv(&msg); err != nil {
+		t.Fatalf("Recv failed: %v", err)
+	}
+	if msg != "ping" {
+		t.Errorf("msg = %q, want %q", msg, "ping")
+	}
+
+	if err := b.Send("pong"); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if err := a.Recv(&msg); err != nil {
+		t.Fatalf("Recv failed: %v", err)
+	}
+	if msg != "pong" {
+		t.Errorf("msg = %q, want %q", msg, "pong")
+	}
+}
+
+func TestChanStream_CloseSend(t *testing.T) {
+	a, b := NewChanStreamPair[string](context.Background(), 1)
+
+	if err := a.CloseSend(); err != nil {
+		t.Fatalf("CloseSend failed: %v", err)
+	}
+	if err := a.Send("hello"); err != io.ErrClosedPipe {
+		t.Errorf("Send after CloseSend should return ErrClosedPipe, got %v", err)
+	}
+
+	var msg string
+	if err := b.Recv(&msg); err != io.EOF {
+		t.Errorf("peer Recv after CloseSend should return EOF, got %v", err)
+	}
+
+	// The half-closed side can still receive
+	if err := b.Send("still open"); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if err := a.Recv(&msg); err != nil {
+		t.Fatalf("Recv after CloseSend failed: %v", err)
+	}
+
+	// Close after CloseSend must not panic
+	if err := a.Close(); err != nil {
+		t.Errorf("Close after CloseSend should not error: %v", err)
+	}
+}
+
 func TestNewServerFromConfig(t *testing.T) {
 	RegisterTransport("mock-server", &TransportCreators{
 		Server: func(cfg x.TypedLazyConfig) (Transport, error) {
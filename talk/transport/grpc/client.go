@@ -2,11 +2,15 @@ package grpc
 
 import (
 	"context"
+	"crypto/tls"
+	"strings"
 	"time"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 
 	"go.zoe.im/x"
@@ -16,9 +20,15 @@ import (
 
 // Client implements talk.Transport for gRPC client operations.
 type Client struct {
-	config ClientConfig
-	codec  codec.Codec
-	conn   *grpc.ClientConn
+	config     ClientConfig
+	codec      codec.Codec
+	compressor string
+	tlsConfig  *tls.Config
+	conn       *grpc.ClientConn
+
+	unaryInterceptors  []grpc.UnaryClientInterceptor
+	streamInterceptors []grpc.StreamClientInterceptor
+	dialOptions        []grpc.DialOption
 }
 
 // NewClient creates a new gRPC client transport.
@@ -36,23 +46,21 @@ func NewClient(cfg x.TypedLazyConfig, opts ...Option) (*Client, error) {
 	if c.codec == nil {
 		c.codec = codec.MustGet("json")
 	}
-
-	var dialOpts []grpc.DialOption
-
-	if c.config.Insecure {
-		dialOpts = append(dialOpts, grpc.WithInsecure())
-	} else if c.config.TLSCertFile != "" {
-		creds, err := credentials.NewClientTLSFromFile(c.config.TLSCertFile, "")
-		if err != nil {
+	if !c.config.Proto {
+		if err := checkCodec(c.codec); err != nil {
 			return nil, err
 		}
-		dialOpts = append(dialOpts, grpc.WithTransportCredentials(creds))
-	} else {
-		dialOpts = append(dialOpts, grpc.WithInsecure())
 	}
 
-	if c.config.WaitForReady {
-		dialOpts = append(dialOpts, grpc.WithDefaultCallOptions(grpc.WaitForReady(true)))
+	comp, err := compressorName(c.config.CompressionConfig)
+	if err != nil {
+		return nil, err
+	}
+	c.compressor = comp
+
+	dialOpts, err := c.buildDialOptions()
+	if err != nil {
+		return nil, err
 	}
 
 	conn, err := grpc.Dial(c.config.Addr, dialOpts...)
@@ -68,6 +76,52 @@ func (c *Client) SetCodec(cd codec.Codec) {
 	c.codec = cd
 }
 
+func (c *Client) setTLSConfig(cfg *tls.Config) {
+	c.tlsConfig = cfg
+}
+
+// buildDialOptions returns the options of the gRPC connection.
+func (c *Client) buildDialOptions() ([]grpc.DialOption, error) {
+	var dialOpts []grpc.DialOption
+
+	tlsConfig := c.tlsConfig
+	if tlsConfig == nil && !c.config.Insecure {
+		var err error
+		if tlsConfig, err = c.config.tlsConfig(false); err != nil {
+			return nil, err
+		}
+	}
+	if tlsConfig != nil {
+		if c.config.TLSServerName != "" {
+			tlsConfig = tlsConfig.Clone()
+			tlsConfig.ServerName = c.config.TLSServerName
+		}
+		dialOpts = append(dialOpts, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	} else {
+		dialOpts = append(dialOpts, grpc.WithInsecure())
+	}
+
+	if c.config.WaitForReady {
+		dialOpts = append(dialOpts, grpc.WithDefaultCallOptions(grpc.WaitForReady(true)))
+	}
+	if c.config.KeepaliveTime > 0 {
+		dialOpts = append(dialOpts, grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                time.Duration(c.config.KeepaliveTime),
+			Timeout:             time.Duration(c.config.KeepaliveTimeout),
+			PermitWithoutStream: c.config.KeepalivePermitWithoutStream,
+		}))
+	}
+
+	if len(c.unaryInterceptors) > 0 {
+		dialOpts = append(dialOpts, grpc.WithChainUnaryInterceptor(c.unaryInterceptors...))
+	}
+	if len(c.streamInterceptors) > 0 {
+		dialOpts = append(dialOpts, grpc.WithChainStreamInterceptor(c.streamInterceptors...))
+	}
+
+	return append(dialOpts, c.dialOptions...), nil
+}
+
 func (c *Client) String() string {
 	return "grpc/client"
 }
@@ -80,29 +134,66 @@ func (c *Client) Shutdown(ctx context.Context) error {
 	return nil
 }
 
-func (c *Client) Invoke(ctx context.Context, endpoint string, req any, resp any) error {
-	method := "/talk.Service/" + endpoint
+// method returns the full gRPC method of endpoint, which is either a
+// method of ClientConfig.Service or "<service>/<method>".
+func (c *Client) method(endpoint string) string {
+	if strings.HasPrefix(endpoint, "/") {
+		return endpoint
+	}
+	if strings.Contains(endpoint, "/") {
+		return "/" + endpoint
+	}
+	service := c.config.Service
+	if service == "" {
+		service = serviceFullName(c.config.Package, nil, false)
+	}
+	return "/" + service + "/" + endpoint
+}
+
+// messages returns the codec of the messages of a call.
+func (c *Client) messages() messageCodec {
+	if c.config.Proto {
+		return protoMessages{}
+	}
+	return rawMessages{codec: c.codec}
+}
 
+// callOptions returns the options of a call sending msg first.
+func (c *Client) callOptions(msg any, stream bool) []grpc.CallOption {
 	var callOpts []grpc.CallOption
+	if !c.config.Proto {
+		callOpts = append(callOpts, callCodec(c.codec))
+	}
+	if c.compressor != "" && (stream || messageSize(msg) >= c.config.MinSize()) {
+		callOpts = append(callOpts, grpc.UseCompressor(c.compressor))
+	}
+	return callOpts
+}
+
+func (c *Client) Invoke(ctx context.Context, endpoint string, req any, resp any) error {
 	if c.config.Timeout > 0 {
 		var cancel context.CancelFunc
 		ctx, cancel = context.WithTimeout(ctx, time.Duration(c.config.Timeout))
 		defer cancel()
 	}
 
-	reqData, err := c.codec.Marshal(req)
+	mc := c.messages()
+	in, err := mc.encode(req, nil)
 	if err != nil {
 		return talk.NewError(talk.InvalidArgument, "failed to encode request")
 	}
+	out, err := mc.newMessage(targetType(resp))
+	if err != nil {
+		return talk.NewError(talk.InvalidArgument, "failed to decode response: "+err.Error())
+	}
 
-	var respData []byte
-	err = c.conn.Invoke(ctx, method, reqData, &respData, callOpts...)
+	err = c.conn.Invoke(outgoingContext(ctx), c.method(endpoint), in, out, c.callOptions(in, false)...)
 	if err != nil {
-		return c.fromGRPCError(err)
+		return fromGRPCError(err)
 	}
 
-	if resp != nil && len(respData) > 0 {
-		if err := c.codec.Unmarshal(respData, resp); err != nil {
+	if resp != nil {
+		if err := mc.decode(out, resp); err != nil {
 			return talk.NewError(talk.Internal, "failed to decode response")
 		}
 	}
@@ -110,24 +201,32 @@ func (c *Client) Invoke(ctx context.Context, endpoint string, req any, resp any)
 	return nil
 }
 
+// InvokeStream opens a stream to endpoint. A non-nil req is sent as the
+// first message, which server streams take as their request.
 func (c *Client) InvokeStream(ctx context.Context, endpoint string, req any) (talk.Stream, error) {
-	method := "/talk.Service/" + endpoint
+	method := c.method(endpoint)
 
 	streamDesc := &grpc.StreamDesc{
-		StreamName:    endpoint,
+		StreamName:    method[strings.LastIndex(method, "/")+1:],
 		ServerStreams: true,
 		ClientStreams: true,
 	}
 
-	clientStream, err := c.conn.NewStream(ctx, streamDesc, method)
+	clientStream, err := c.conn.NewStream(outgoingContext(ctx), streamDesc, method, c.callOptions(nil, true)...)
 	if err != nil {
-		return nil, c.fromGRPCError(err)
+		return nil, fromGRPCError(err)
 	}
 
-	return &grpcClientStream{
+	stream := &grpcClientStream{
 		ClientStream: clientStream,
-		codec:        c.codec,
-	}, nil
+		messages:     c.messages(),
+	}
+	if req != nil {
+		if err := stream.Send(req); err != nil {
+			return nil, fromGRPCError(err)
+		}
+	}
+	return stream, nil
 }
 
 func (c *Client) Close() error {
@@ -137,22 +236,42 @@ func (c *Client) Close() error {
 	return nil
 }
 
-func (c *Client) fromGRPCError(err error) error {
+// fromGRPCError converts a gRPC status error to a talk error. Talk errors
+// are returned as is.
+func fromGRPCError(err error) error {
 	if err == nil {
 		return nil
 	}
+	if talkErr, ok := talk.IsError(err); ok {
+		return talkErr
+	}
 
 	st, ok := status.FromError(err)
 	if !ok {
 		return talk.NewError(talk.Unknown, err.Error())
 	}
 
-	return talk.NewError(talk.ErrorCode(st.Code()), st.Message())
+	return fromStatus(st)
+}
+
+// outgoingContext attaches the talk outgoing metadata as gRPC metadata,
+// replacing the gRPC metadata of the same keys.
+func outgoingContext(ctx context.Context) context.Context {
+	md, ok := talk.FromOutgoingContext(ctx)
+	if !ok {
+		return ctx
+	}
+	out, _ := metadata.FromOutgoingContext(ctx)
+	out = out.Copy()
+	for k, v := range md {
+		out.Set(k, v)
+	}
+	return metadata.NewOutgoingContext(ctx, out)
 }
 
 type grpcClientStream struct {
 	grpc.ClientStream
-	codec codec.Codec
+	messages messageCodec
 }
 
 func (s *grpcClientStream) Context() context.Context {
@@ -160,19 +279,22 @@ func (s *grpcClientStream) Context() context.Context {
 }
 
 func (s *grpcClientStream) Send(msg any) error {
-	data, err := s.codec.Marshal(msg)
+	m, err := s.messages.encode(msg, nil)
 	if err != nil {
 		return err
 	}
-	return s.ClientStream.SendMsg(data)
+	return s.ClientStream.SendMsg(m)
 }
 
 func (s *grpcClientStream) Recv(msg any) error {
-	var data []byte
-	if err := s.ClientStream.RecvMsg(&data); err != nil {
+	m, err := s.messages.newMessage(targetType(msg))
+	if err != nil {
+		return err
+	}
+	if err := s.ClientStream.RecvMsg(m); err != nil {
 		return err
 	}
-	return s.codec.Unmarshal(data, msg)
+	return s.messages.decode(m, msg)
 }
 
 func (s *grpcClientStream) Close() error {
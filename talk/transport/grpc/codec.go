@@ -0,0 +1,84 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+
+	"go.zoe.im/x/talk/codec"
+)
+
+// codecPrefix prefixes the gRPC content-subtype of talk codecs, e.g.
+// "application/grpc+talk-json", so that they do not replace the codecs other
+// packages register with gRPC under plain names like "json".
+const codecPrefix = "talk-"
+
+// rawCodec passes messages already encoded by a talk codec through gRPC.
+// It is registered under the prefixed name of each talk codec, so that the
+// content-subtype of a call tells the server how to decode it.
+type rawCodec struct {
+	name string
+}
+
+func (c rawCodec) Name() string {
+	return codecPrefix + c.name
+}
+
+func (c rawCodec) Marshal(v any) ([]byte, error) {
+	data, ok := v.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("grpc/%s: cannot marshal %T", c.name, v)
+	}
+	return data, nil
+}
+
+func (c rawCodec) Unmarshal(data []byte, v any) error {
+	p, ok := v.(*[]byte)
+	if !ok {
+		return fmt.Errorf("grpc/%s: cannot unmarshal into %T", c.name, v)
+	}
+	*p = append((*p)[:0], data...)
+	return nil
+}
+
+// checkCodec returns an error unless c can be sent over gRPC. The codecs
+// registered with codec when this package is initialized are registered
+// with gRPC; gRPC only allows registering codecs at init time, so codecs
+// added later cannot be used.
+func checkCodec(c codec.Codec) error {
+	if encoding.GetCodec(codecPrefix+c.Name()) == nil {
+		return fmt.Errorf("codec %q is not registered with gRPC", c.Name())
+	}
+	return nil
+}
+
+// callCodec returns the call option sending messages encoded by c.
+func callCodec(c codec.Codec) grpc.CallOption {
+	return grpc.CallContentSubtype(codecPrefix + c.Name())
+}
+
+// requestCodec returns the talk codec a call was encoded with, by its
+// content-subtype, or def.
+func requestCodec(ctx context.Context, def codec.Codec) codec.Codec {
+	st, ok := grpc.ServerTransportStreamFromContext(ctx).(interface{ ContentSubtype() string })
+	if !ok {
+		return def
+	}
+	name, ok := strings.CutPrefix(st.ContentSubtype(), codecPrefix)
+	if !ok || name == def.Name() {
+		return def
+	}
+	if c, err := codec.Get(name); err == nil {
+		return c
+	}
+	return def
+}
+
+func init() {
+	for _, name := range codec.Factory.List() {
+		encoding.RegisterCodec(rawCodec{name: name})
+	}
+}
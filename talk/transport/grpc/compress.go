@@ -0,0 +1,34 @@
+package grpc
+
+import (
+	"fmt"
+
+	"google.golang.org/grpc/encoding"
+
+	"go.zoe.im/x/talk/codec"
+)
+
+// compressorName returns the configured compressor name after checking that
+// gRPC knows it. The compressors registered with codec when this package is
+// initialized are registered with gRPC too; compressors added later must
+// also be registered with encoding.RegisterCompressor.
+func compressorName(cfg codec.CompressionConfig) (string, error) {
+	if _, err := cfg.Compressor(); err != nil {
+		return "", err
+	}
+	if cfg.Compression != "" && encoding.GetCompressor(cfg.Compression) == nil {
+		return "", fmt.Errorf("compressor %q is not registered with gRPC", cfg.Compression)
+	}
+	return cfg.Compression, nil
+}
+
+func init() {
+	for _, name := range codec.CompressorFactory.List() {
+		if encoding.GetCompressor(name) != nil {
+			continue
+		}
+		if c, err := codec.GetCompressor(name); err == nil {
+			encoding.RegisterCompressor(c)
+		}
+	}
+}
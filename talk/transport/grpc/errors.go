@@ -0,0 +1,143 @@
+package grpc
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	anypb "github.com/golang/protobuf/ptypes/any"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/status"
+
+	"go.zoe.im/x"
+	"go.zoe.im/x/talk"
+)
+
+// talkDetailTypeURL is the type URL of status details that carry a talk
+// error detail without a google.rpc equivalent, encoded as JSON by
+// talk.MarshalErrorDetail.
+const talkDetailTypeURL = "type.zoe.im/talk.ErrorDetail"
+
+// talkDetailsTypeURL is the type URL of the status detail that carries the
+// free-form talk.Error.Details, encoded as JSON.
+const talkDetailsTypeURL = "type.zoe.im/talk.Details"
+
+// toStatus converts a talk error to a gRPC status. The talk detail types map
+// to their google.rpc counterparts so that any gRPC client can read them;
+// note that google.rpc.BadRequest has no field for FieldViolation.Rule.
+func toStatus(err *talk.Error) *status.Status {
+	p := &spb.Status{
+		Code:    int32(err.GRPCCode()),
+		Message: err.Message,
+	}
+	for _, d := range err.TypedDetails {
+		if a, ok := toAny(d); ok {
+			p.Details = append(p.Details, a)
+		}
+	}
+	if err.Details != nil {
+		if data, jerr := json.Marshal(err.Details); jerr == nil {
+			p.Details = append(p.Details, &anypb.Any{TypeUrl: talkDetailsTypeURL, Value: data})
+		}
+	}
+	return status.FromProto(p)
+}
+
+func toAny(detail any) (*anypb.Any, bool) {
+	var msg proto.Message
+	switch d := detail.(type) {
+	case talk.RetryInfo:
+		msg = &errdetails.RetryInfo{RetryDelay: ptypes.DurationProto(time.Duration(d.RetryDelay))}
+	case talk.BadRequest:
+		br := &errdetails.BadRequest{}
+		for _, fv := range d.FieldViolations {
+			br.FieldViolations = append(br.FieldViolations, &errdetails.BadRequest_FieldViolation{
+				Field:       fv.Field,
+				Description: fv.Description,
+			})
+		}
+		msg = br
+	case talk.QuotaFailure:
+		qf := &errdetails.QuotaFailure{}
+		for _, v := range d.Violations {
+			qf.Violations = append(qf.Violations, &errdetails.QuotaFailure_Violation{
+				Subject:     v.Subject,
+				Description: v.Description,
+			})
+		}
+		msg = qf
+	case talk.DebugInfo:
+		msg = &errdetails.DebugInfo{StackEntries: d.StackEntries, Detail: d.Detail}
+	case proto.Message:
+		msg = d
+	}
+
+	if msg != nil {
+		a, err := ptypes.MarshalAny(msg)
+		return a, err == nil
+	}
+
+	data, err := talk.MarshalErrorDetail(detail)
+	if err != nil {
+		return nil, false
+	}
+	return &anypb.Any{TypeUrl: talkDetailTypeURL, Value: data}, true
+}
+
+// fromStatus converts a gRPC status back to a talk error, decoding the
+// details written by toStatus. Unknown proto details are kept as their
+// proto messages.
+func fromStatus(st *status.Status) *talk.Error {
+	e := talk.NewError(ErrorCodeFromGRPC(st.Code()), st.Message())
+	for _, a := range st.Proto().GetDetails() {
+		if a.GetTypeUrl() == talkDetailsTypeURL {
+			json.Unmarshal(a.GetValue(), &e.Details)
+			continue
+		}
+		if d, ok := fromAny(a); ok {
+			e.TypedDetails = append(e.TypedDetails, d)
+		}
+	}
+	return e
+}
+
+func fromAny(a *anypb.Any) (any, bool) {
+	if a.GetTypeUrl() == talkDetailTypeURL {
+		d, err := talk.UnmarshalErrorDetail(a.GetValue())
+		return d, err == nil
+	}
+
+	var dyn ptypes.DynamicAny
+	if err := ptypes.UnmarshalAny(a, &dyn); err != nil {
+		return nil, false
+	}
+
+	switch m := dyn.Message.(type) {
+	case *errdetails.RetryInfo:
+		delay, _ := ptypes.Duration(m.GetRetryDelay())
+		return talk.RetryInfo{RetryDelay: x.Duration(delay)}, true
+	case *errdetails.BadRequest:
+		var br talk.BadRequest
+		for _, fv := range m.GetFieldViolations() {
+			br.FieldViolations = append(br.FieldViolations, talk.FieldViolation{
+				Field:       fv.GetField(),
+				Description: fv.GetDescription(),
+			})
+		}
+		return br, true
+	case *errdetails.QuotaFailure:
+		var qf talk.QuotaFailure
+		for _, v := range m.GetViolations() {
+			qf.Violations = append(qf.Violations, talk.QuotaViolation{
+				Subject:     v.GetSubject(),
+				Description: v.GetDescription(),
+			})
+		}
+		return qf, true
+	case *errdetails.DebugInfo:
+		return talk.DebugInfo{StackEntries: m.GetStackEntries(), Detail: m.GetDetail()}, true
+	}
+	return dyn.Message, true
+}
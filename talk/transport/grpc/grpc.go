@@ -2,6 +2,12 @@
 package grpc
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+
+	"google.golang.org/grpc"
+
 	"go.zoe.im/x"
 	"go.zoe.im/x/factory"
 	"go.zoe.im/x/talk"
@@ -10,10 +16,33 @@ import (
 )
 
 type Config struct {
-	Addr        string `json:"addr" yaml:"addr"`
-	Insecure    bool   `json:"insecure,omitempty" yaml:"insecure"`
+	Addr     string `json:"addr" yaml:"addr"`
+	Insecure bool   `json:"insecure,omitempty" yaml:"insecure"`
+
+	// TLSCertFile and TLSKeyFile are the certificate presented to the
+	// peer: required on servers for TLS, and used by clients for mutual
+	// TLS. A client given TLSCertFile alone trusts it as the server
+	// certificate.
 	TLSCertFile string `json:"tls_cert_file,omitempty" yaml:"tls_cert_file"`
 	TLSKeyFile  string `json:"tls_key_file,omitempty" yaml:"tls_key_file"`
+	// TLSCAFile verifies the peer certificate: servers then require client
+	// certificates, and clients use it instead of the system roots.
+	TLSCAFile string `json:"tls_ca_file,omitempty" yaml:"tls_ca_file"`
+
+	// Proto serves and calls endpoints as protobuf services, one per
+	// service type, with messages derived from the endpoint types (see
+	// FileDescriptor), so that any gRPC client can call them. Otherwise
+	// all endpoints belong to "<package>.Service" and messages are encoded
+	// with the talk codec.
+	Proto bool `json:"proto,omitempty" yaml:"proto"`
+	// Package is the protobuf package of the services, DefaultPackage if
+	// empty.
+	Package string `json:"package,omitempty" yaml:"package"`
+
+	// Compression is used by clients for requests of at least
+	// CompressionMinSize bytes and for streams. Servers answer with the
+	// compressor the client used.
+	codec.CompressionConfig `json:",inline" yaml:",inline"`
 }
 
 type ServerConfig struct {
@@ -21,6 +50,28 @@ type ServerConfig struct {
 	MaxRecvMsgSize    int        `json:"max_recv_msg_size,omitempty" yaml:"max_recv_msg_size"`
 	MaxSendMsgSize    int        `json:"max_send_msg_size,omitempty" yaml:"max_send_msg_size"`
 	ConnectionTimeout x.Duration `json:"connection_timeout,omitempty" yaml:"connection_timeout"`
+
+	// KeepaliveTime is the interval of pings on idle connections; the
+	// connection is closed when a ping is not answered within
+	// KeepaliveTimeout.
+	KeepaliveTime    x.Duration `json:"keepalive_time,omitempty" yaml:"keepalive_time"`
+	KeepaliveTimeout x.Duration `json:"keepalive_timeout,omitempty" yaml:"keepalive_timeout"`
+	// KeepaliveMinTime is the shortest interval clients may ping at, and
+	// KeepalivePermitWithoutStream allows them to ping without active
+	// calls; clients breaking the policy are disconnected.
+	KeepaliveMinTime             x.Duration `json:"keepalive_min_time,omitempty" yaml:"keepalive_min_time"`
+	KeepalivePermitWithoutStream bool       `json:"keepalive_permit_without_stream,omitempty" yaml:"keepalive_permit_without_stream"`
+	// MaxConnectionIdle closes connections without calls for that long.
+	// MaxConnectionAge closes connections that old, letting their calls
+	// finish within MaxConnectionAgeGrace, so that clients spread over new
+	// servers.
+	MaxConnectionIdle     x.Duration `json:"max_connection_idle,omitempty" yaml:"max_connection_idle"`
+	MaxConnectionAge      x.Duration `json:"max_connection_age,omitempty" yaml:"max_connection_age"`
+	MaxConnectionAgeGrace x.Duration `json:"max_connection_age_grace,omitempty" yaml:"max_connection_age_grace"`
+
+	// DisableReflection turns off the server reflection service registered
+	// in proto mode.
+	DisableReflection bool `json:"disable_reflection,omitempty" yaml:"disable_reflection"`
 }
 
 type ClientConfig struct {
@@ -28,6 +79,60 @@ type ClientConfig struct {
 	Timeout      x.Duration `json:"timeout,omitempty" yaml:"timeout"`
 	MaxRetries   int        `json:"max_retries,omitempty" yaml:"max_retries"`
 	WaitForReady bool       `json:"wait_for_ready,omitempty" yaml:"wait_for_ready"`
+	// TLSServerName overrides the name verified in the server certificate.
+	TLSServerName string `json:"tls_server_name,omitempty" yaml:"tls_server_name"`
+
+	// KeepaliveTime is the interval of pings on idle connections; the
+	// connection is closed when a ping is not answered within
+	// KeepaliveTimeout. KeepalivePermitWithoutStream pings even without
+	// active calls. The server must permit the interval.
+	KeepaliveTime                x.Duration `json:"keepalive_time,omitempty" yaml:"keepalive_time"`
+	KeepaliveTimeout             x.Duration `json:"keepalive_timeout,omitempty" yaml:"keepalive_timeout"`
+	KeepalivePermitWithoutStream bool       `json:"keepalive_permit_without_stream,omitempty" yaml:"keepalive_permit_without_stream"`
+	// Service is the service of endpoints given by method name only,
+	// "<package>.Service" if empty. Endpoints may also be given as
+	// "<service>/<method>".
+	Service string `json:"service,omitempty" yaml:"service"`
+}
+
+// tlsConfig returns the TLS config of a server or client, or nil if TLS is
+// not configured.
+func (c Config) tlsConfig(server bool) (*tls.Config, error) {
+	if c.TLSCertFile == "" && c.TLSCAFile == "" {
+		return nil, nil
+	}
+
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	caFile := c.TLSCAFile
+	if !server && c.TLSKeyFile == "" && caFile == "" {
+		caFile = c.TLSCertFile
+	} else if c.TLSCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.TLSCertFile, c.TLSKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	if caFile != "" {
+		data, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, talk.NewError(talk.InvalidArgument, "no certificate found in "+caFile)
+		}
+		if server {
+			cfg.ClientCAs = pool
+			cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		} else {
+			cfg.RootCAs = pool
+		}
+	}
+	if server && len(cfg.Certificates) == 0 {
+		return nil, talk.NewError(talk.InvalidArgument, "TLS server requires tls_cert_file and tls_key_file")
+	}
+	return cfg, nil
 }
 
 type Option func(any)
@@ -40,6 +145,76 @@ func WithCodec(c codec.Codec) Option {
 	}
 }
 
+// WithTLSConfig sets the TLS config, replacing the one built from the
+// config files.
+func WithTLSConfig(cfg *tls.Config) Option {
+	return func(v any) {
+		if s, ok := v.(interface{ setTLSConfig(*tls.Config) }); ok {
+			s.setTLSConfig(cfg)
+		}
+	}
+}
+
+// WithUnaryInterceptor adds interceptors around the unary calls of a
+// server, the first outermost. They run around the talk middleware of the
+// endpoints; see UnaryServerInterceptor for the reverse.
+func WithUnaryInterceptor(i ...grpc.UnaryServerInterceptor) Option {
+	return func(v any) {
+		if s, ok := v.(*Server); ok {
+			s.unaryInterceptors = append(s.unaryInterceptors, i...)
+		}
+	}
+}
+
+// WithStreamInterceptor adds interceptors around the streams of a server,
+// the first outermost.
+func WithStreamInterceptor(i ...grpc.StreamServerInterceptor) Option {
+	return func(v any) {
+		if s, ok := v.(*Server); ok {
+			s.streamInterceptors = append(s.streamInterceptors, i...)
+		}
+	}
+}
+
+// WithUnaryClientInterceptor adds interceptors around the unary calls of a
+// client, the first outermost.
+func WithUnaryClientInterceptor(i ...grpc.UnaryClientInterceptor) Option {
+	return func(v any) {
+		if c, ok := v.(*Client); ok {
+			c.unaryInterceptors = append(c.unaryInterceptors, i...)
+		}
+	}
+}
+
+// WithStreamClientInterceptor adds interceptors around the streams of a
+// client, the first outermost.
+func WithStreamClientInterceptor(i ...grpc.StreamClientInterceptor) Option {
+	return func(v any) {
+		if c, ok := v.(*Client); ok {
+			c.streamInterceptors = append(c.streamInterceptors, i...)
+		}
+	}
+}
+
+// WithServerOptions passes options the config does not cover to
+// grpc.NewServer.
+func WithServerOptions(opts ...grpc.ServerOption) Option {
+	return func(v any) {
+		if s, ok := v.(*Server); ok {
+			s.serverOptions = append(s.serverOptions, opts...)
+		}
+	}
+}
+
+// WithDialOptions passes options the config does not cover to grpc.Dial.
+func WithDialOptions(opts ...grpc.DialOption) Option {
+	return func(v any) {
+		if c, ok := v.(*Client); ok {
+			c.dialOptions = append(c.dialOptions, opts...)
+		}
+	}
+}
+
 var serverFactory = factory.NewFactory[ServerTransport, Option]()
 
 var ServerFactory = struct {
@@ -1,12 +1,42 @@
 package grpc
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
 
 	"go.zoe.im/x"
 	"go.zoe.im/x/talk"
 	"go.zoe.im/x/talk/codec"
+	"go.zoe.im/x/talk/extract"
 )
 
 func TestNewServer(t *testing.T) {
@@ -98,6 +128,37 @@ func TestSetCodec(t *testing.T) {
 	}
 }
 
+type unregisteredCodec struct{ codec.Codec }
+
+func (unregisteredCodec) Name() string { return "unregistered" }
+
+func TestCodecRegistration(t *testing.T) {
+	for _, name := range codec.Factory.List() {
+		if encoding.GetCodec(codecPrefix+name) == nil {
+			t.Errorf("codec %q is not registered with gRPC", name)
+		}
+	}
+	// Plain subtypes are left to other packages.
+	if c := encoding.GetCodec("json"); c != nil {
+		if _, ok := c.(rawCodec); ok {
+			t.Error(`talk registered the "json" gRPC codec`)
+		}
+	}
+
+	cfg := x.TypedLazyConfig{Config: json.RawMessage(`{"addr": ":50051"}`)}
+	bad := WithCodec(unregisteredCodec{codec.MustGet("json")})
+	if _, err := NewServer(cfg, bad); err == nil {
+		t.Error("NewServer accepted a codec unknown to gRPC")
+	}
+	if _, err := NewClient(cfg, bad); err == nil {
+		t.Error("NewClient accepted a codec unknown to gRPC")
+	}
+	proto := x.TypedLazyConfig{Config: json.RawMessage(`{"addr": ":50051", "proto": true}`)}
+	if _, err := NewServer(proto, bad); err != nil {
+		t.Errorf("NewServer with proto: %v", err)
+	}
+}
+
 func TestServerBuildMethods(t *testing.T) {
 	cfg := x.TypedLazyConfig{
 		Config: json.RawMessage(`{"addr": ":50051"}`),
@@ -127,12 +188,12 @@ func TestServerBuildMethods(t *testing.T) {
 		server.endpoints[ep.Name] = ep
 	}
 
-	unaryMethods := server.buildUnaryMethods()
+	unaryMethods := server.buildUnaryMethods("talk.Service")
 	if len(unaryMethods) != 1 {
 		t.Errorf("expected 1 unary method, got %d", len(unaryMethods))
 	}
 
-	streamMethods := server.buildStreamMethods()
+	streamMethods := server.buildStreamMethods("talk.Service")
 	if len(streamMethods) != 1 {
 		t.Errorf("expected 1 stream method, got %d", len(streamMethods))
 	}
@@ -152,6 +213,66 @@ func TestErrorConversion(t *testing.T) {
 	}
 }
 
+func TestErrorDetailsMapping(t *testing.T) {
+	talkErr := talk.NewErrorWithTypedDetails(talk.InvalidArgument, "invalid request",
+		talk.BadRequest{FieldViolations: []talk.FieldViolation{{Field: "email", Description: "is required"}}},
+		talk.RetryInfo{RetryDelay: x.Duration(2 * time.Second)},
+		talk.QuotaFailure{Violations: []talk.QuotaViolation{{Subject: "user:1", Description: "daily limit"}}},
+		talk.DebugInfo{Detail: "boom"},
+		map[string]any{"hint": "retry later"},
+	)
+	talkErr.Details = map[string]any{"field": "email"}
+
+	// Round-trip through the wire form of the status.
+	st, ok := status.FromError(toStatus(talkErr).Err())
+	if !ok {
+		t.Fatal("expected a gRPC status")
+	}
+	got := fromStatus(st)
+
+	if got.Code != talk.InvalidArgument || got.Message != "invalid request" {
+		t.Errorf("got %v", got)
+	}
+	if !reflect.DeepEqual(got.TypedDetails, talkErr.TypedDetails) {
+		t.Errorf("TypedDetails = %#v, want %#v", got.TypedDetails, talkErr.TypedDetails)
+	}
+	if !reflect.DeepEqual(got.Details, talkErr.Details) {
+		t.Errorf("Details = %#v, want %#v", got.Details, talkErr.Details)
+	}
+
+	// Built-in details are readable by any gRPC client.
+	var br *errdetails.BadRequest
+	for _, d := range st.Details() {
+		if m, ok := d.(*errdetails.BadRequest); ok {
+			br = m
+		}
+	}
+	if br == nil || br.GetFieldViolations()[0].GetField() != "email" {
+		t.Errorf("status details = %v, want google.rpc.BadRequest", st.Details())
+	}
+}
+
+func TestMetadataMapping(t *testing.T) {
+	ctx := talk.NewOutgoingContext(context.Background(), talk.MetadataPairs("Authorization", "Bearer abc"))
+
+	out, ok := metadata.FromOutgoingContext(outgoingContext(ctx))
+	if !ok {
+		t.Fatal("expected gRPC outgoing metadata")
+	}
+
+	// Simulate the server side receiving what the client sent.
+	ep := &talk.Endpoint{Name: "Whoami"}
+	in := incomingContext(metadata.NewIncomingContext(context.Background(), out), ep)
+
+	token, ok := talk.BearerTokenFromContext(in)
+	if !ok || token != "abc" {
+		t.Errorf("BearerTokenFromContext = %q, %v, want abc, true", token, ok)
+	}
+	if talk.EndpointFromContext(in) != ep {
+		t.Error("endpoint not injected into context")
+	}
+}
+
 func TestFactoryRegistration(t *testing.T) {
 	cfg := x.TypedLazyConfig{
 		Type:   "default",
@@ -179,3 +300,466 @@ func TestFactoryRegistration(t *testing.T) {
 		t.Error("ClientFactory.Create returned nil")
 	}
 }
+
+type healthCheckedService struct {
+	healthy atomic.Bool
+}
+
+func (s *healthCheckedService) Ping(ctx context.Context) (string, error) {
+	return "pong", nil
+}
+
+func (s *healthCheckedService) Healthy(ctx context.Context) error {
+	if !s.healthy.Load() {
+		return errors.New("not ready yet")
+	}
+	return nil
+}
+
+func TestHealthService(t *testing.T) {
+	transport, err := NewServer(x.TypedLazyConfig{Config: json.RawMessage(`{"addr": "127.0.0.1:19556"}`)})
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+	svc := &healthCheckedService{}
+	server := talk.NewServer(transport, talk.WithHealthEndpoints())
+	if err := server.Register(svc); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.Serve(ctx)
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := grpc.Dial("127.0.0.1:19556", grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	client := healthpb.NewHealthClient(conn)
+
+	resp, err := client.Check(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil || resp.GetStatus() != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("Check = %v, %v, want NOT_SERVING", resp, err)
+	}
+
+	svc.healthy.Store(true)
+	resp, err = client.Check(ctx, &healthpb.HealthCheckRequest{Service: "talk.Service"})
+	if err != nil || resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("Check = %v, %v, want SERVING", resp, err)
+	}
+
+	_, err = client.Check(ctx, &healthpb.HealthCheckRequest{Service: "other.Service"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("Check(unknown service) = %v, want NotFound", err)
+	}
+}
+
+func TestCompression(t *testing.T) {
+	for _, name := range []string{"gzip", "zstd", "snappy"} {
+		if encoding.GetCompressor(name) == nil {
+			t.Errorf("compressor %q is not registered with gRPC", name)
+		}
+	}
+
+	client, err := NewClient(x.TypedLazyConfig{
+		Config: json.RawMessage(`{"addr": "localhost:50051", "insecure": true, "compression": "zstd"}`),
+	})
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	defer client.Close()
+	if client.compressor != "zstd" {
+		t.Errorf("compressor = %q, want zstd", client.compressor)
+	}
+
+	if _, err := NewServer(x.TypedLazyConfig{
+		Config: json.RawMessage(`{"addr": ":50051", "compression": "lz4"}`),
+	}); err == nil {
+		t.Error("NewServer with an unknown compressor should fail")
+	}
+}
+
+type HelloRequest struct {
+	Name  string   `json:"name"`
+	Langs []string `json:"langs,omitempty"`
+}
+
+type HelloReply struct {
+	Message string           `json:"message"`
+	At      time.Time        `json:"at"`
+	Counts  map[string]int64 `json:"counts,omitempty"`
+	Extra   *HelloRequest    `json:"extra,omitempty"`
+}
+
+type CountRequest struct {
+	From int `json:"from"`
+}
+
+type Greeter struct{}
+
+func (Greeter) SayHello(ctx context.Context, req HelloRequest) (*HelloReply, error) {
+	if req.Name == "" {
+		return nil, talk.NewError(talk.InvalidArgument, "name is required")
+	}
+	return &HelloReply{
+		Message: "hello " + req.Name,
+		At:      time.Unix(1700000000, 0).UTC(),
+		Counts:  map[string]int64{"langs": int64(len(req.Langs))},
+		Extra:   &req,
+	}, nil
+}
+
+func (Greeter) Count(ctx context.Context, req CountRequest) (<-chan int, error) {
+	ch := make(chan int)
+	go func() {
+		defer close(ch)
+		for i := req.From; i > 0; i-- {
+			ch <- i
+		}
+	}()
+	return ch, nil
+}
+
+// serveGreeter serves Greeter with the gRPC transport configured by cfg.
+func serveGreeter(t *testing.T, cfg string) {
+	t.Helper()
+	transport, err := NewServer(x.TypedLazyConfig{Config: json.RawMessage(cfg)})
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+	server := talk.NewServer(transport)
+	if err := server.Register(Greeter{}); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go server.Serve(ctx)
+	time.Sleep(100 * time.Millisecond)
+}
+
+func testGreeter(t *testing.T, client *talk.Client, sayHello, count string) {
+	t.Helper()
+	ctx := context.Background()
+
+	var reply HelloReply
+	if err := client.Call(ctx, sayHello, HelloRequest{Name: "talk", Langs: []string{"go", "ts"}}, &reply); err != nil {
+		t.Fatalf("Call failed: %v", err)
+	}
+	want := HelloReply{
+		Message: "hello talk",
+		At:      time.Unix(1700000000, 0).UTC(),
+		Counts:  map[string]int64{"langs": 2},
+		Extra:   &HelloRequest{Name: "talk", Langs: []string{"go", "ts"}},
+	}
+	if !reflect.DeepEqual(reply, want) {
+		t.Errorf("reply = %+v, want %+v", reply, want)
+	}
+
+	err := client.Call(ctx, sayHello, HelloRequest{}, &reply)
+	if talkErr, ok := talk.IsError(err); !ok || talkErr.Code != talk.InvalidArgument {
+		t.Errorf("Call without name = %v, want InvalidArgument", err)
+	}
+
+	stream, err := client.Stream(ctx, count, CountRequest{From: 3})
+	if err != nil {
+		t.Fatalf("Stream failed: %v", err)
+	}
+	defer stream.Close()
+	var got []int
+	for {
+		var n int
+		if err := stream.Recv(&n); err != nil {
+			break
+		}
+		got = append(got, n)
+	}
+	if !reflect.DeepEqual(got, []int{3, 2, 1}) {
+		t.Errorf("stream = %v, want [3 2 1]", got)
+	}
+}
+
+func TestInvoke(t *testing.T) {
+	serveGreeter(t, `{"addr": "127.0.0.1:19557"}`)
+
+	transport, err := NewClient(x.TypedLazyConfig{Config: json.RawMessage(`{"addr": "127.0.0.1:19557", "insecure": true}`)})
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	defer transport.Close()
+	testGreeter(t, talk.NewClient(transport), "SayHello", "Count")
+}
+
+func TestInvoke_Proto(t *testing.T) {
+	serveGreeter(t, `{"addr": "127.0.0.1:19558", "proto": true, "package": "acme.greet.v1"}`)
+
+	transport, err := NewClient(x.TypedLazyConfig{Config: json.RawMessage(`{
+		"addr": "127.0.0.1:19558",
+		"insecure": true,
+		"proto": true,
+		"package": "acme.greet.v1",
+		"service": "acme.greet.v1.Greeter"
+	}`)})
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	defer transport.Close()
+	testGreeter(t, talk.NewClient(transport), "SayHello", "/acme.greet.v1.Greeter/Count")
+}
+
+func TestFileDescriptor(t *testing.T) {
+	endpoints, err := extract.FromService(Greeter{})
+	if err != nil {
+		t.Fatalf("FromService failed: %v", err)
+	}
+	fd, err := FileDescriptor("acme.greet.v1", endpoints)
+	if err != nil {
+		t.Fatalf("FileDescriptor failed: %v", err)
+	}
+	if fd.GetName() != "acme/greet/v1.proto" {
+		t.Errorf("name = %q, want acme/greet/v1.proto", fd.GetName())
+	}
+
+	var b strings.Builder
+	if err := WriteProto(&b, fd); err != nil {
+		t.Fatalf("WriteProto failed: %v", err)
+	}
+	for _, want := range []string{
+		"package acme.greet.v1;",
+		`import "google/protobuf/timestamp.proto";`,
+		"message HelloRequest {\n  string name = 1;\n  repeated string langs = 2;\n}",
+		"  google.protobuf.Timestamp at = 2;",
+		"  map<string, int64> counts = 3;",
+		"  HelloRequest extra = 4;",
+		"service Greeter {",
+		"  rpc SayHello(HelloRequest) returns (HelloReply);",
+		"  rpc Count(CountRequest) returns (stream google.protobuf.Int64Value);",
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("proto source does not contain %q:\n%s", want, b.String())
+		}
+	}
+}
+
+func TestReflection(t *testing.T) {
+	serveGreeter(t, `{"addr": "127.0.0.1:19559", "proto": true, "package": "acme.greet.v1"}`)
+
+	conn, err := grpc.Dial("127.0.0.1:19559", grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	ctx := context.Background()
+	stream, err := rpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+	if err != nil {
+		t.Fatalf("ServerReflectionInfo failed: %v", err)
+	}
+	defer stream.CloseSend()
+
+	if err := stream.Send(&rpb.ServerReflectionRequest{
+		MessageRequest: &rpb.ServerReflectionRequest_ListServices{},
+	}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	resp, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("Recv failed: %v", err)
+	}
+	var services []string
+	for _, s := range resp.GetListServicesResponse().GetService() {
+		services = append(services, s.GetName())
+	}
+	if want := []string{"acme.greet.v1.Greeter", "grpc.reflection.v1alpha.ServerReflection"}; !reflect.DeepEqual(services, want) {
+		t.Errorf("services = %v, want %v", services, want)
+	}
+
+	// Call SayHello the way a client without the Go types would: from the
+	// descriptors served by reflection.
+	if err := stream.Send(&rpb.ServerReflectionRequest{
+		MessageRequest: &rpb.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: "acme.greet.v1.Greeter"},
+	}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if resp, err = stream.Recv(); err != nil {
+		t.Fatalf("Recv failed: %v", err)
+	}
+	set := &descriptorpb.FileDescriptorSet{}
+	for _, data := range resp.GetFileDescriptorResponse().GetFileDescriptorProto() {
+		fdp := &descriptorpb.FileDescriptorProto{}
+		if err := proto.Unmarshal(data, fdp); err != nil {
+			t.Fatalf("Unmarshal failed: %v", err)
+		}
+		set.File = append(set.File, fdp)
+	}
+	files, err := protodesc.NewFiles(set)
+	if err != nil {
+		t.Fatalf("NewFiles failed: %v", err)
+	}
+	d, err := files.FindDescriptorByName("acme.greet.v1.Greeter.SayHello")
+	if err != nil {
+		t.Fatalf("FindDescriptorByName failed: %v", err)
+	}
+	method := d.(protoreflect.MethodDescriptor)
+
+	req := dynamicpb.NewMessage(method.Input())
+	req.Set(method.Input().Fields().ByName("name"), protoreflect.ValueOfString("grpcurl"))
+	reply := dynamicpb.NewMessage(method.Output())
+	if err := conn.Invoke(ctx, "/acme.greet.v1.Greeter/SayHello", req, reply); err != nil {
+		t.Fatalf("Invoke failed: %v", err)
+	}
+	if got := reply.Get(method.Output().Fields().ByName("message")).String(); got != "hello grpcurl" {
+		t.Errorf("message = %q, want %q", got, "hello grpcurl")
+	}
+}
+
+func TestInterceptors(t *testing.T) {
+	var methods []string
+	record := func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		methods = append(methods, info.FullMethod)
+		return handler(ctx, req)
+	}
+	auth := func(next talk.EndpointFunc) talk.EndpointFunc {
+		return func(ctx context.Context, req any) (any, error) {
+			if md, _ := talk.FromIncomingContext(ctx); md.Get("x-token") != "secret" {
+				return nil, talk.NewError(talk.Unauthenticated, "missing token")
+			}
+			return next(ctx, req)
+		}
+	}
+	var streams atomic.Int32
+	countStreams := func(next talk.StreamEndpointFunc) talk.StreamEndpointFunc {
+		return func(ctx context.Context, req any, stream talk.Stream) error {
+			streams.Add(1)
+			return next(ctx, req, stream)
+		}
+	}
+
+	transport, err := NewServer(x.TypedLazyConfig{Config: json.RawMessage(`{"addr": "127.0.0.1:19560"}`)},
+		WithUnaryInterceptor(record, UnaryServerInterceptor(auth)),
+		WithStreamInterceptor(StreamServerInterceptor(countStreams)))
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+	server := talk.NewServer(transport, talk.WithHealthEndpoints())
+	if err := server.Register(Greeter{}); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.Serve(ctx)
+	time.Sleep(100 * time.Millisecond)
+
+	token := func(next talk.ClientInvokeFunc) talk.ClientInvokeFunc {
+		return func(ctx context.Context, call *talk.ClientCall) error {
+			return next(talk.AppendToOutgoingContext(ctx, "x-token", "secret"), call)
+		}
+	}
+	ct, err := NewClient(x.TypedLazyConfig{Config: json.RawMessage(`{"addr": "127.0.0.1:19560", "insecure": true}`)},
+		WithUnaryClientInterceptor(UnaryClientInterceptor(token)),
+		WithStreamClientInterceptor(StreamClientInterceptor(token)))
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	defer ct.Close()
+	testGreeter(t, talk.NewClient(ct), "SayHello", "Count")
+
+	if len(methods) == 0 || methods[0] != "/talk.Service/SayHello" {
+		t.Errorf("intercepted methods = %v, want /talk.Service/SayHello first", methods)
+	}
+	if streams.Load() != 1 {
+		t.Errorf("intercepted streams = %d, want 1", streams.Load())
+	}
+
+	// The middleware also guards the health service.
+	conn, err := grpc.Dial("127.0.0.1:19560", grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	_, err = healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Check without token = %v, want Unauthenticated", err)
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	certFile, keyFile := writeCert(t)
+	serveGreeter(t, `{
+		"addr": "127.0.0.1:19561",
+		"tls_cert_file": "`+certFile+`",
+		"tls_key_file": "`+keyFile+`",
+		"tls_ca_file": "`+certFile+`",
+		"keepalive_time": "1m",
+		"keepalive_min_time": "10s",
+		"max_connection_age": "1h"
+	}`)
+
+	call := func(cfg string) error {
+		ct, err := NewClient(x.TypedLazyConfig{Config: json.RawMessage(cfg)})
+		if err != nil {
+			t.Fatalf("NewClient failed: %v", err)
+		}
+		defer ct.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		var reply HelloReply
+		return ct.Invoke(ctx, "SayHello", HelloRequest{Name: "tls"}, &reply)
+	}
+
+	if err := call(`{
+		"addr": "127.0.0.1:19561",
+		"tls_cert_file": "` + certFile + `",
+		"tls_key_file": "` + keyFile + `",
+		"tls_ca_file": "` + certFile + `",
+		"tls_server_name": "localhost",
+		"keepalive_time": "30s"
+	}`); err != nil {
+		t.Errorf("Invoke with client certificate failed: %v", err)
+	}
+	if err := call(`{"addr": "127.0.0.1:19561", "tls_ca_file": "` + certFile + `", "tls_server_name": "localhost"}`); err == nil {
+		t.Error("Invoke without client certificate should fail")
+	}
+}
+
+// writeCert writes a self-signed certificate for localhost, valid for
+// servers and clients, and its key.
+func writeCert(t *testing.T) (certFile, keyFile string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate failed: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalECPrivateKey failed: %v", err)
+	}
+
+	dir := t.TempDir()
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	return certFile, keyFile
+}
@@ -0,0 +1,87 @@
+package grpc
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+
+	"go.zoe.im/x/talk"
+)
+
+// healthWatchInterval is how often Watch re-evaluates the serving status.
+var healthWatchInterval = time.Second
+
+// healthServer implements grpc.health.v1 on top of the talk health
+// endpoints. The overall status ("") and the services served are known;
+// other service names are not.
+type healthServer struct {
+	check    talk.EndpointFunc
+	services []string
+}
+
+// newHealthServer returns a health server backed by the readiness endpoint,
+// or the liveness endpoint if there is none. It returns nil if endpoints
+// contains neither.
+func newHealthServer(endpoints map[string]*talk.Endpoint, services []string) *healthServer {
+	var liveness, readiness *talk.Endpoint
+	for _, ep := range endpoints {
+		switch ep.Metadata[talk.HealthMetadataKey] {
+		case "liveness":
+			liveness = ep
+		case "readiness":
+			readiness = ep
+		}
+	}
+	switch {
+	case readiness != nil:
+		return &healthServer{check: readiness.Handler, services: services}
+	case liveness != nil:
+		return &healthServer{check: liveness.Handler, services: services}
+	}
+	return nil
+}
+
+func (h *healthServer) status(ctx context.Context, service string) (healthpb.HealthCheckResponse_ServingStatus, bool) {
+	if service != "" && !slices.Contains(h.services, service) {
+		return healthpb.HealthCheckResponse_SERVICE_UNKNOWN, false
+	}
+	if _, err := h.check(ctx, nil); err != nil {
+		return healthpb.HealthCheckResponse_NOT_SERVING, true
+	}
+	return healthpb.HealthCheckResponse_SERVING, true
+}
+
+func (h *healthServer) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	st, ok := h.status(ctx, req.GetService())
+	if !ok {
+		return nil, status.Error(codes.NotFound, "unknown service")
+	}
+	return &healthpb.HealthCheckResponse{Status: st}, nil
+}
+
+func (h *healthServer) Watch(req *healthpb.HealthCheckRequest, stream healthpb.Health_WatchServer) error {
+	ctx := stream.Context()
+	ticker := time.NewTicker(healthWatchInterval)
+	defer ticker.Stop()
+
+	last := healthpb.HealthCheckResponse_ServingStatus(-1)
+	for {
+		st, _ := h.status(ctx, req.GetService())
+		if st != last {
+			if err := stream.Send(&healthpb.HealthCheckResponse{Status: st}); err != nil {
+				return err
+			}
+			last = st
+		}
+
+		select {
+		case <-ctx.Done():
+			return status.Error(codes.Canceled, ctx.Err().Error())
+		case <-ticker.C:
+		}
+	}
+}
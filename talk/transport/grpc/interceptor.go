@@ -0,0 +1,204 @@
+package grpc
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+
+	"go.zoe.im/x/talk"
+)
+
+// chainUnaryServer returns an interceptor running interceptors in order,
+// the first outermost.
+func chainUnaryServer(interceptors []grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, next := interceptors[i], handler
+			handler = func(ctx context.Context, req any) (any, error) {
+				return interceptor(ctx, req, info, next)
+			}
+		}
+		return handler(ctx, req)
+	}
+}
+
+// chainStreamServer returns an interceptor running interceptors in order,
+// the first outermost.
+func chainStreamServer(interceptors []grpc.StreamServerInterceptor) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, next := interceptors[i], handler
+			handler = func(srv any, ss grpc.ServerStream) error {
+				return interceptor(srv, ss, info, next)
+			}
+		}
+		return handler(srv, ss)
+	}
+}
+
+// serverContext returns the context of a call for talk middleware: calls
+// to services other than talk's lack the talk metadata.
+func serverContext(ctx context.Context) context.Context {
+	if _, ok := talk.FromIncomingContext(ctx); ok {
+		return ctx
+	}
+	return incomingContext(ctx, nil)
+}
+
+// UnaryServerInterceptor runs talk middleware as a gRPC interceptor, so
+// that it also covers services registered next to talk's, such as health
+// and reflection, or plain gRPC servers. Requests and responses are the
+// gRPC messages; errors are *talk.Error to the middleware and gRPC statuses
+// to the caller.
+func UnaryServerInterceptor(mw ...talk.MiddlewareFunc) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		next := talk.EndpointFunc(func(ctx context.Context, req any) (any, error) {
+			resp, err := handler(ctx, req)
+			return resp, fromGRPCError(err)
+		})
+		for i := len(mw) - 1; i >= 0; i-- {
+			next = mw[i](next)
+		}
+		resp, err := next(serverContext(ctx), req)
+		return resp, toGRPCError(err)
+	}
+}
+
+// StreamServerInterceptor runs talk stream middleware as a gRPC
+// interceptor, like UnaryServerInterceptor. The middleware gets no request
+// and a stream of gRPC messages.
+func StreamServerInterceptor(mw ...talk.StreamMiddlewareFunc) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		next := talk.StreamEndpointFunc(func(ctx context.Context, _ any, stream talk.Stream) error {
+			return fromGRPCError(handler(srv, &interceptedServerStream{ServerStream: ss, ctx: ctx, stream: stream}))
+		})
+		for i := len(mw) - 1; i >= 0; i-- {
+			next = mw[i](next)
+		}
+		ctx := serverContext(ss.Context())
+		return toGRPCError(next(ctx, nil, &messageServerStream{ServerStream: ss, ctx: ctx}))
+	}
+}
+
+// UnaryClientInterceptor runs talk client middleware as a gRPC interceptor,
+// for plain gRPC clients or to run it per attempt of a talk client. The
+// call's Endpoint is the full gRPC method, Request and Response the gRPC
+// messages.
+func UnaryClientInterceptor(mw ...talk.ClientMiddlewareFunc) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		next := talk.ClientInvokeFunc(func(ctx context.Context, call *talk.ClientCall) error {
+			return fromGRPCError(invoker(outgoingContext(ctx), method, call.Request, call.Response, cc, opts...))
+		})
+		for i := len(mw) - 1; i >= 0; i-- {
+			next = mw[i](next)
+		}
+		return toGRPCError(next(ctx, &talk.ClientCall{Endpoint: method, Request: req, Response: reply}))
+	}
+}
+
+// StreamClientInterceptor runs talk client middleware as a gRPC
+// interceptor, like UnaryClientInterceptor. The call's Stream passes gRPC
+// messages.
+func StreamClientInterceptor(mw ...talk.ClientMiddlewareFunc) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		var cs grpc.ClientStream
+		next := talk.ClientInvokeFunc(func(ctx context.Context, call *talk.ClientCall) error {
+			var err error
+			if cs, err = streamer(outgoingContext(ctx), desc, cc, method, opts...); err != nil {
+				return fromGRPCError(err)
+			}
+			call.Stream = &messageClientStream{ClientStream: cs}
+			return nil
+		})
+		for i := len(mw) - 1; i >= 0; i-- {
+			next = mw[i](next)
+		}
+
+		call := &talk.ClientCall{Endpoint: method, Streaming: true}
+		if err := next(ctx, call); err != nil {
+			return nil, toGRPCError(err)
+		}
+		if s, ok := call.Stream.(*messageClientStream); ok && s.ClientStream == cs {
+			return cs, nil
+		}
+		return &interceptedClientStream{ClientStream: cs, stream: call.Stream}, nil
+	}
+}
+
+// messageServerStream is a grpc.ServerStream as a talk.Stream.
+type messageServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *messageServerStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *messageServerStream) Send(msg any) error {
+	return s.ServerStream.SendMsg(msg)
+}
+
+func (s *messageServerStream) Recv(msg any) error {
+	return s.ServerStream.RecvMsg(msg)
+}
+
+func (s *messageServerStream) Close() error {
+	return nil
+}
+
+// interceptedServerStream passes the messages of a handler through the
+// stream returned by talk middleware.
+type interceptedServerStream struct {
+	grpc.ServerStream
+	ctx    context.Context
+	stream talk.Stream
+}
+
+func (s *interceptedServerStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *interceptedServerStream) SendMsg(m any) error {
+	return s.stream.Send(m)
+}
+
+func (s *interceptedServerStream) RecvMsg(m any) error {
+	return s.stream.Recv(m)
+}
+
+// messageClientStream is a grpc.ClientStream as a talk.Stream.
+type messageClientStream struct {
+	grpc.ClientStream
+}
+
+func (s *messageClientStream) Send(msg any) error {
+	return s.ClientStream.SendMsg(msg)
+}
+
+func (s *messageClientStream) Recv(msg any) error {
+	return s.ClientStream.RecvMsg(msg)
+}
+
+func (s *messageClientStream) Close() error {
+	return s.ClientStream.CloseSend()
+}
+
+// interceptedClientStream passes the messages of a caller through the
+// stream returned by talk middleware.
+type interceptedClientStream struct {
+	grpc.ClientStream
+	stream talk.Stream
+}
+
+func (s *interceptedClientStream) SendMsg(m any) error {
+	return s.stream.Send(m)
+}
+
+func (s *interceptedClientStream) RecvMsg(m any) error {
+	return s.stream.Recv(m)
+}
+
+func (s *interceptedClientStream) CloseSend() error {
+	return s.stream.Close()
+}
@@ -0,0 +1,589 @@
+package grpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"go.zoe.im/x/talk"
+	"go.zoe.im/x/talk/codec"
+)
+
+// messageCodec converts between talk values and the messages passed to
+// gRPC.
+type messageCodec interface {
+	// newMessage returns a message to receive a value of type t into.
+	newMessage(t reflect.Type) (any, error)
+	// encode converts v to a message of type t, or of the type of v if t
+	// is nil.
+	encode(v any, t reflect.Type) (any, error)
+	// decode converts msg into v, a pointer.
+	decode(msg any, v any) error
+}
+
+// rawMessages passes messages encoded with a talk codec.
+type rawMessages struct {
+	codec codec.Codec
+}
+
+func (m rawMessages) newMessage(t reflect.Type) (any, error) {
+	return new([]byte), nil
+}
+
+func (m rawMessages) encode(v any, t reflect.Type) (any, error) {
+	if v == nil && t != nil {
+		return []byte{}, nil
+	}
+	return m.codec.Marshal(v)
+}
+
+func (m rawMessages) decode(msg any, v any) error {
+	data := *msg.(*[]byte)
+	if len(data) == 0 {
+		return nil
+	}
+	return m.codec.Unmarshal(data, v)
+}
+
+// protoMessages encodes messages with protobuf, as described by
+// FileDescriptor.
+type protoMessages struct{}
+
+func (protoMessages) newMessage(t reflect.Type) (any, error) {
+	return newMessage(t)
+}
+
+func (protoMessages) encode(v any, t reflect.Type) (any, error) {
+	return toMessage(v, t)
+}
+
+func (protoMessages) decode(msg any, v any) error {
+	return fromMessage(msg.(proto.Message), v)
+}
+
+// messageSize returns the encoded size of msg.
+func messageSize(msg any) int {
+	switch m := msg.(type) {
+	case []byte:
+		return len(m)
+	case proto.Message:
+		return proto.Size(m)
+	}
+	return 0
+}
+
+// decodeRequest decodes the request of type t from msg. Values are passed
+// to handlers as is, generated protobuf messages as pointers.
+func decodeRequest(mc messageCodec, msg any, t reflect.Type) (any, error) {
+	if talk.IsRawBody(t) {
+		if data, ok := msg.(*[]byte); ok {
+			return io.NopCloser(bytes.NewReader(*data)), nil
+		}
+	}
+	if t == nil {
+		var req any
+		if err := mc.decode(msg, &req); err != nil {
+			return nil, talk.NewError(talk.InvalidArgument, "failed to decode request")
+		}
+		return req, nil
+	}
+
+	rv := reflect.New(t)
+	if err := mc.decode(msg, rv.Interface()); err != nil {
+		return nil, talk.NewError(talk.InvalidArgument, "failed to decode request")
+	}
+	if isProtoMessage(t) {
+		return rv.Interface(), nil
+	}
+	return rv.Elem().Interface(), nil
+}
+
+// targetType returns the type v, a pointer, points to.
+func targetType(v any) reflect.Type {
+	if v == nil {
+		return nil
+	}
+	t := reflect.TypeOf(v)
+	if t.Kind() == reflect.Ptr {
+		return t.Elem()
+	}
+	return t
+}
+
+var (
+	runtimeTypes sync.Map // reflect.Type -> protoreflect.MessageDescriptor
+	runtimeFiles atomic.Int64
+)
+
+// messageDescriptor returns the descriptor of the message values of type t
+// are encoded as.
+func messageDescriptor(t reflect.Type) (protoreflect.MessageDescriptor, error) {
+	if v, ok := runtimeTypes.Load(t); ok {
+		return v.(protoreflect.MessageDescriptor), nil
+	}
+
+	// Each type gets a file of its own: names only matter on the wire as
+	// far as FileDescriptor would number the fields the same way.
+	const pkg = "talk.runtime"
+	b := newFileBuilder("talk/runtime/"+strconv.FormatInt(runtimeFiles.Add(1), 10)+".proto", pkg)
+	name, err := b.topLevel(t, "Message")
+	if err != nil {
+		return nil, err
+	}
+	fd, err := b.build()
+	if err != nil {
+		return nil, err
+	}
+
+	var md protoreflect.MessageDescriptor
+	full := protoreflect.FullName(name[1:])
+	if full.Parent() == pkg {
+		md = fd.Messages().ByName(full.Name())
+	} else {
+		d, err := protoregistry.GlobalFiles.FindDescriptorByName(full)
+		if err != nil {
+			return nil, err
+		}
+		md = d.(protoreflect.MessageDescriptor)
+	}
+
+	runtimeTypes.Store(t, md)
+	return md, nil
+}
+
+// newMessage returns an empty message for values of type t.
+func newMessage(t reflect.Type) (proto.Message, error) {
+	if t == nil {
+		return &emptypb.Empty{}, nil
+	}
+	t = indirectType(streamElem(t))
+	if isProtoMessage(t) {
+		return reflect.New(t).Interface().(proto.Message), nil
+	}
+	md, err := messageDescriptor(t)
+	if err != nil {
+		return nil, err
+	}
+	return dynamicpb.NewMessage(md), nil
+}
+
+// toMessage converts v to a message of type t, or of the type of v if t is
+// nil.
+func toMessage(v any, t reflect.Type) (proto.Message, error) {
+	if m, ok := v.(proto.Message); ok {
+		return m, nil
+	}
+	if t == nil && v != nil {
+		t = reflect.TypeOf(v)
+	}
+	m, err := newMessage(t)
+	if err != nil || v == nil {
+		return m, err
+	}
+
+	rv := reflect.ValueOf(v)
+	if et := indirectType(streamElem(t)); et.Kind() != reflect.Interface && indirectType(rv.Type()) != et {
+		return nil, fmt.Errorf("cannot encode %T as %s", v, et)
+	}
+	if err := fillMessage(m.ProtoReflect(), rv); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// fromMessage converts m into v, a pointer.
+func fromMessage(m proto.Message, v any) error {
+	if m == v {
+		return nil
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("cannot decode into %T", v)
+	}
+	return readMessage(m.ProtoReflect(), rv.Elem())
+}
+
+// Names of the well-known messages converted from Go types.
+const (
+	emptyName     protoreflect.FullName = "google.protobuf.Empty"
+	timestampName protoreflect.FullName = "google.protobuf.Timestamp"
+	durationName  protoreflect.FullName = "google.protobuf.Duration"
+	valueName     protoreflect.FullName = "google.protobuf.Value"
+)
+
+// asProtoMessage returns v as a generated protobuf message, if it is one.
+func asProtoMessage(v reflect.Value) (proto.Message, bool) {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil, false
+		}
+		m, ok := v.Interface().(proto.Message)
+		return m, ok
+	}
+	if !isProtoMessage(v.Type()) {
+		return nil, false
+	}
+	if v.CanAddr() {
+		return v.Addr().Interface().(proto.Message), true
+	}
+	p := reflect.New(v.Type())
+	p.Elem().Set(v)
+	return p.Interface().(proto.Message), true
+}
+
+// copyMessage copies src into dst, which may be of another implementation
+// of the same message.
+func copyMessage(dst, src proto.Message) error {
+	if dst.ProtoReflect().Descriptor() == src.ProtoReflect().Descriptor() {
+		proto.Merge(dst, src)
+		return nil
+	}
+	data, err := proto.Marshal(src)
+	if err != nil {
+		return err
+	}
+	return proto.Unmarshal(data, dst)
+}
+
+// fillMessage sets the fields of dst from v.
+func fillMessage(dst protoreflect.Message, v reflect.Value) error {
+	if m, ok := asProtoMessage(v); ok {
+		return copyMessage(dst.Interface(), m)
+	}
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+
+	md := dst.Descriptor()
+	switch md.FullName() {
+	case emptyName:
+		return nil
+	case timestampName:
+		t, ok := v.Interface().(time.Time)
+		if !ok {
+			return fmt.Errorf("cannot encode %s as %s", v.Type(), md.FullName())
+		}
+		dst.Set(md.Fields().ByName("seconds"), protoreflect.ValueOfInt64(t.Unix()))
+		dst.Set(md.Fields().ByName("nanos"), protoreflect.ValueOfInt32(int32(t.Nanosecond())))
+		return nil
+	case durationName:
+		if v.Kind() != reflect.Int64 {
+			return fmt.Errorf("cannot encode %s as %s", v.Type(), md.FullName())
+		}
+		d := time.Duration(v.Int())
+		dst.Set(md.Fields().ByName("seconds"), protoreflect.ValueOfInt64(int64(d/time.Second)))
+		dst.Set(md.Fields().ByName("nanos"), protoreflect.ValueOfInt32(int32(d%time.Second)))
+		return nil
+	case valueName:
+		data, err := json.Marshal(v.Interface())
+		if err != nil {
+			return err
+		}
+		return protojson.Unmarshal(data, dst.Interface())
+	}
+
+	if v.Kind() != reflect.Struct {
+		// A wrapper of a non-message type.
+		return setField(dst, md.Fields().ByNumber(1), v)
+	}
+	fields, err := structFields(v.Type())
+	if err != nil {
+		return err
+	}
+	for _, f := range fields {
+		fv, ok := fieldByIndex(v, f.index, false)
+		if !ok {
+			continue
+		}
+		if fd := md.Fields().ByNumber(protoreflect.FieldNumber(f.number)); fd != nil {
+			if err := setField(dst, fd, fv); err != nil {
+				return fmt.Errorf("%s: %w", f.goName, err)
+			}
+		}
+	}
+	return nil
+}
+
+// fieldByIndex returns the struct field of v at index, going through
+// embedded struct pointers, which are allocated if alloc is set.
+func fieldByIndex(v reflect.Value, index []int, alloc bool) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !alloc {
+					return reflect.Value{}, false
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+// setField sets field fd of dst from v.
+func setField(dst protoreflect.Message, fd protoreflect.FieldDescriptor, v reflect.Value) error {
+	if fd == nil {
+		return nil
+	}
+	if fd.Message() == nil || fd.IsList() || fd.IsMap() {
+		for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+			if v.IsNil() {
+				return nil
+			}
+			v = v.Elem()
+		}
+	} else if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil() {
+		return nil
+	}
+
+	switch {
+	case fd.IsMap():
+		if v.Len() == 0 {
+			return nil
+		}
+		mp := dst.Mutable(fd).Map()
+		iter := v.MapRange()
+		for iter.Next() {
+			key := scalarValue(iter.Key(), fd.MapKey()).MapKey()
+			if fd.MapValue().Message() != nil {
+				val := mp.NewValue()
+				if err := fillMessage(val.Message(), iter.Value()); err != nil {
+					return err
+				}
+				mp.Set(key, val)
+			} else {
+				mp.Set(key, scalarValue(iter.Value(), fd.MapValue()))
+			}
+		}
+	case fd.IsList():
+		if v.Len() == 0 {
+			return nil
+		}
+		list := dst.Mutable(fd).List()
+		for i := 0; i < v.Len(); i++ {
+			if fd.Message() != nil {
+				val := list.NewElement()
+				if err := fillMessage(val.Message(), v.Index(i)); err != nil {
+					return err
+				}
+				list.Append(val)
+			} else {
+				list.Append(scalarValue(v.Index(i), fd))
+			}
+		}
+	case fd.Message() != nil:
+		return fillMessage(dst.Mutable(fd).Message(), v)
+	default:
+		dst.Set(fd, scalarValue(v, fd))
+	}
+	return nil
+}
+
+func scalarValue(v reflect.Value, fd protoreflect.FieldDescriptor) protoreflect.Value {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return fd.Default()
+		}
+		v = v.Elem()
+	}
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return protoreflect.ValueOfBool(v.Bool())
+	case protoreflect.Int32Kind:
+		return protoreflect.ValueOfInt32(int32(v.Int()))
+	case protoreflect.Int64Kind:
+		return protoreflect.ValueOfInt64(v.Int())
+	case protoreflect.Uint32Kind:
+		return protoreflect.ValueOfUint32(uint32(v.Uint()))
+	case protoreflect.Uint64Kind:
+		return protoreflect.ValueOfUint64(v.Uint())
+	case protoreflect.FloatKind:
+		return protoreflect.ValueOfFloat32(float32(v.Float()))
+	case protoreflect.DoubleKind:
+		return protoreflect.ValueOfFloat64(v.Float())
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(v.String())
+	case protoreflect.BytesKind:
+		return protoreflect.ValueOfBytes(v.Bytes())
+	}
+	return fd.Default()
+}
+
+// readMessage sets v, which must be settable, from src.
+func readMessage(src protoreflect.Message, v reflect.Value) error {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return readMessage(src, v.Elem())
+	}
+	if m, ok := asProtoMessage(v); ok && v.CanAddr() {
+		return copyMessage(m, src.Interface())
+	}
+
+	md := src.Descriptor()
+	switch md.FullName() {
+	case emptyName:
+		return nil
+	case timestampName:
+		t := time.Unix(src.Get(md.Fields().ByName("seconds")).Int(), src.Get(md.Fields().ByName("nanos")).Int()).UTC()
+		return setValue(v, reflect.ValueOf(t))
+	case durationName:
+		d := time.Duration(src.Get(md.Fields().ByName("seconds")).Int())*time.Second +
+			time.Duration(src.Get(md.Fields().ByName("nanos")).Int())
+		return setValue(v, reflect.ValueOf(d))
+	case valueName:
+		data, err := protojson.Marshal(src.Interface())
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(data, v.Addr().Interface())
+	}
+
+	if v.Kind() != reflect.Struct {
+		fd := md.Fields().ByNumber(1)
+		if fd == nil || !src.Has(fd) {
+			return nil
+		}
+		return getField(src, fd, v)
+	}
+	fields, err := structFields(v.Type())
+	if err != nil {
+		return err
+	}
+	for _, f := range fields {
+		fd := md.Fields().ByNumber(protoreflect.FieldNumber(f.number))
+		if fd == nil || !src.Has(fd) {
+			continue
+		}
+		fv, _ := fieldByIndex(v, f.index, true)
+		if err := getField(src, fd, fv); err != nil {
+			return fmt.Errorf("%s: %w", f.goName, err)
+		}
+	}
+	return nil
+}
+
+// setValue sets v to x, converting between named types of the same kind.
+func setValue(v, x reflect.Value) error {
+	switch {
+	case x.Type().AssignableTo(v.Type()):
+		v.Set(x)
+	case x.Type().ConvertibleTo(v.Type()):
+		v.Set(x.Convert(v.Type()))
+	default:
+		return fmt.Errorf("cannot decode %s into %s", x.Type(), v.Type())
+	}
+	return nil
+}
+
+// getField sets v from field fd of src.
+func getField(src protoreflect.Message, fd protoreflect.FieldDescriptor, v reflect.Value) error {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return getField(src, fd, v.Elem())
+	}
+
+	val := src.Get(fd)
+	switch {
+	case fd.IsMap():
+		if v.Kind() != reflect.Map {
+			return fmt.Errorf("cannot decode map into %s", v.Type())
+		}
+		m := reflect.MakeMapWithSize(v.Type(), val.Map().Len())
+		var err error
+		val.Map().Range(func(k protoreflect.MapKey, e protoreflect.Value) bool {
+			kv := reflect.New(v.Type().Key()).Elem()
+			if err = setScalar(kv, k.Value()); err != nil {
+				return false
+			}
+			ev := reflect.New(v.Type().Elem()).Elem()
+			if fd.MapValue().Message() != nil {
+				err = readMessage(e.Message(), ev)
+			} else {
+				err = setScalar(ev, e)
+			}
+			if err != nil {
+				return false
+			}
+			m.SetMapIndex(kv, ev)
+			return true
+		})
+		if err != nil {
+			return err
+		}
+		v.Set(m)
+	case fd.IsList():
+		list := val.List()
+		n := list.Len()
+		switch v.Kind() {
+		case reflect.Slice:
+			v.Set(reflect.MakeSlice(v.Type(), n, n))
+		case reflect.Array:
+			n = min(n, v.Len())
+		default:
+			return fmt.Errorf("cannot decode list into %s", v.Type())
+		}
+		for i := 0; i < n; i++ {
+			var err error
+			if fd.Message() != nil {
+				err = readMessage(list.Get(i).Message(), v.Index(i))
+			} else {
+				err = setScalar(v.Index(i), list.Get(i))
+			}
+			if err != nil {
+				return err
+			}
+		}
+	case fd.Message() != nil:
+		return readMessage(val.Message(), v)
+	default:
+		return setScalar(v, val)
+	}
+	return nil
+}
+
+func setScalar(v reflect.Value, pv protoreflect.Value) error {
+	switch v.Kind() {
+	case reflect.Bool:
+		v.SetBool(pv.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(pv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		v.SetUint(pv.Uint())
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(pv.Float())
+	case reflect.String:
+		v.SetString(pv.String())
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.Uint8 {
+			return fmt.Errorf("cannot decode bytes into %s", v.Type())
+		}
+		v.SetBytes(append([]byte(nil), pv.Bytes()...))
+	case reflect.Interface:
+		v.Set(reflect.ValueOf(pv.Interface()))
+	default:
+		return fmt.Errorf("cannot decode %v into %s", pv, v.Type())
+	}
+	return nil
+}
@@ -0,0 +1,650 @@
+package grpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	"go.zoe.im/x/talk"
+)
+
+// DefaultPackage is the protobuf package of the services when
+// Config.Package is empty.
+const DefaultPackage = "talk"
+
+// defaultService names the service of endpoints that were not extracted
+// from a named service type.
+const defaultService = "Service"
+
+var (
+	timeType         = reflect.TypeOf(time.Time{})
+	durationType     = reflect.TypeOf(time.Duration(0))
+	rawMessageType   = reflect.TypeOf(json.RawMessage{})
+	protoMessageType = reflect.TypeOf((*proto.Message)(nil)).Elem()
+)
+
+// serviceFullName returns the full name of the gRPC service ep belongs to:
+// "<pkg>.<ServiceType>" in proto mode, "<pkg>.Service" otherwise.
+func serviceFullName(pkg string, ep *talk.Endpoint, perService bool) string {
+	if pkg == "" {
+		pkg = DefaultPackage
+	}
+	name := defaultService
+	if perService {
+		if s, ok := ep.Metadata[talk.ServiceMetadataKey].(string); ok && s != "" {
+			name = s
+		}
+	}
+	return pkg + "." + name
+}
+
+// protoFileName returns the path of the file describing the services of
+// pkg, e.g. "acme/user/v1.proto" for "acme.user.v1".
+func protoFileName(pkg string) string {
+	return strings.ReplaceAll(pkg, ".", "/") + ".proto"
+}
+
+// protoCallable reports whether ep is served in proto mode: raw bodies
+// have no message type.
+func protoCallable(ep *talk.Endpoint) bool {
+	return !talk.IsRawBody(ep.RequestType)
+}
+
+// FileDescriptor describes the endpoints as the protobuf services served
+// in proto mode, one per service type, with request and response messages
+// derived from Endpoint.RequestType and Endpoint.ResponseType:
+//
+//   - structs become messages named after their type, with a field per
+//     JSON-encoded struct field, numbered by position or by a `proto:"N"`
+//     struct tag, and named after the JSON name in snake case
+//   - slices and maps become repeated and map fields
+//   - time.Time, time.Duration and interface types become
+//     google.protobuf.Timestamp, Duration and Value
+//   - generated protobuf messages are used as is
+//   - other request and response types are wrapped in a message with a
+//     single "value" field, a google.protobuf wrapper for scalars, and no
+//     type at all is google.protobuf.Empty
+//
+// Endpoints taking a raw io.Reader body are left out.
+func FileDescriptor(pkg string, endpoints []*talk.Endpoint) (*descriptorpb.FileDescriptorProto, error) {
+	if pkg == "" {
+		pkg = DefaultPackage
+	}
+	b := newFileBuilder(protoFileName(pkg), pkg)
+
+	services := map[string]*descriptorpb.ServiceDescriptorProto{}
+	for _, ep := range endpoints {
+		if !protoCallable(ep) {
+			continue
+		}
+
+		in, err := b.topLevel(streamElem(ep.RequestType), ep.Name+"Request")
+		if err != nil {
+			return nil, fmt.Errorf("%s request: %w", ep.Name, err)
+		}
+		out, err := b.topLevel(streamElem(ep.ResponseType), ep.Name+"Response")
+		if err != nil {
+			return nil, fmt.Errorf("%s response: %w", ep.Name, err)
+		}
+
+		full := serviceFullName(pkg, ep, true)
+		svc, ok := services[full]
+		if !ok {
+			svc = &descriptorpb.ServiceDescriptorProto{Name: proto.String(strings.TrimPrefix(full, pkg+"."))}
+			services[full] = svc
+			b.file.Service = append(b.file.Service, svc)
+		}
+		svc.Method = append(svc.Method, &descriptorpb.MethodDescriptorProto{
+			Name:            proto.String(ep.Name),
+			InputType:       proto.String(in),
+			OutputType:      proto.String(out),
+			ClientStreaming: proto.Bool(ep.StreamMode == talk.StreamClientSide || ep.StreamMode == talk.StreamBidirect),
+			ServerStreaming: proto.Bool(ep.StreamMode == talk.StreamServerSide || ep.StreamMode == talk.StreamBidirect),
+		})
+	}
+
+	if _, err := b.build(); err != nil {
+		return nil, err
+	}
+	return b.file, nil
+}
+
+// streamElem returns the message type of a channel, as the extractor
+// reports for streaming endpoints.
+func streamElem(t reflect.Type) reflect.Type {
+	if t != nil && t.Kind() == reflect.Chan {
+		return t.Elem()
+	}
+	return t
+}
+
+func indirectType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+// isProtoMessage reports whether t is a generated protobuf message struct.
+func isProtoMessage(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct && reflect.PointerTo(t).Implements(protoMessageType)
+}
+
+// fileBuilder builds a file descriptor with messages for Go types.
+type fileBuilder struct {
+	file     *descriptorpb.FileDescriptorProto
+	pkg      string
+	messages map[reflect.Type]string
+	names    map[string]bool
+	deps     map[string]bool
+}
+
+func newFileBuilder(path, pkg string) *fileBuilder {
+	return &fileBuilder{
+		file: &descriptorpb.FileDescriptorProto{
+			Name:    proto.String(path),
+			Package: proto.String(pkg),
+			Syntax:  proto.String("proto3"),
+		},
+		pkg:      pkg,
+		messages: make(map[reflect.Type]string),
+		names:    make(map[string]bool),
+		deps:     make(map[string]bool),
+	}
+}
+
+func (b *fileBuilder) build() (protoreflect.FileDescriptor, error) {
+	fd, err := protodesc.NewFile(b.file, protoregistry.GlobalFiles)
+	if err != nil {
+		return nil, fmt.Errorf("invalid protobuf descriptor: %w", err)
+	}
+	return fd, nil
+}
+
+// dep imports the file of md and returns the full name of md.
+func (b *fileBuilder) dep(md protoreflect.MessageDescriptor) string {
+	path := md.ParentFile().Path()
+	if !b.deps[path] {
+		b.deps[path] = true
+		b.file.Dependency = append(b.file.Dependency, path)
+	}
+	return "." + string(md.FullName())
+}
+
+// reserve returns a message name based on name that is not used yet.
+func (b *fileBuilder) reserve(name string) string {
+	name = identifier(name, true)
+	unique := name
+	for i := 2; b.names[unique]; i++ {
+		unique = name + strconv.Itoa(i)
+	}
+	b.names[unique] = true
+	return unique
+}
+
+func (b *fileBuilder) fullName(name string) string {
+	if b.pkg == "" {
+		return "." + name
+	}
+	return "." + b.pkg + "." + name
+}
+
+// topLevel returns the full name of the message a request or response of
+// type t is encoded as. Wrappers are named after hint.
+func (b *fileBuilder) topLevel(t reflect.Type, hint string) (string, error) {
+	if t == nil {
+		return b.dep((&emptypb.Empty{}).ProtoReflect().Descriptor()), nil
+	}
+	t = indirectType(t)
+	if name, ok := b.messageOf(t, hint); ok {
+		return name()
+	}
+	if md := wrapperOf(t); md != nil {
+		return b.dep(md), nil
+	}
+
+	if name, ok := b.messages[t]; ok {
+		return name, nil
+	}
+	name := b.reserve(hint)
+	full := b.fullName(name)
+	b.messages[t] = full
+	msg := &descriptorpb.DescriptorProto{Name: proto.String(name)}
+	b.file.MessageType = append(b.file.MessageType, msg)
+	if err := b.addField(msg, full, protoField{name: "value", jsonName: "value", number: 1, typ: t}); err != nil {
+		return "", err
+	}
+	return full, nil
+}
+
+// messageOf returns a function building the message of t, if t is encoded
+// as a message.
+func (b *fileBuilder) messageOf(t reflect.Type, hint string) (func() (string, error), bool) {
+	switch {
+	case t == timeType:
+		return b.known(&timestamppb.Timestamp{}), true
+	case t == durationType:
+		return b.known(&durationpb.Duration{}), true
+	case t == rawMessageType || t.Kind() == reflect.Interface:
+		return b.known(&structpb.Value{}), true
+	case isProtoMessage(t):
+		md := reflect.New(t).Interface().(proto.Message).ProtoReflect().Descriptor()
+		return func() (string, error) { return b.dep(md), nil }, true
+	case t.Kind() == reflect.Struct:
+		return func() (string, error) { return b.message(t, hint) }, true
+	}
+	return nil, false
+}
+
+func (b *fileBuilder) known(m proto.Message) func() (string, error) {
+	return func() (string, error) {
+		return b.dep(m.ProtoReflect().Descriptor()), nil
+	}
+}
+
+// wrapperOf returns the google.protobuf wrapper of a scalar type, or nil.
+func wrapperOf(t reflect.Type) protoreflect.MessageDescriptor {
+	var m proto.Message
+	switch t.Kind() {
+	case reflect.Bool:
+		m = &wrapperspb.BoolValue{}
+	case reflect.Int, reflect.Int64:
+		m = &wrapperspb.Int64Value{}
+	case reflect.Int8, reflect.Int16, reflect.Int32:
+		m = &wrapperspb.Int32Value{}
+	case reflect.Uint, reflect.Uint64, reflect.Uintptr:
+		m = &wrapperspb.UInt64Value{}
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		m = &wrapperspb.UInt32Value{}
+	case reflect.Float32:
+		m = &wrapperspb.FloatValue{}
+	case reflect.Float64:
+		m = &wrapperspb.DoubleValue{}
+	case reflect.String:
+		m = &wrapperspb.StringValue{}
+	case reflect.Slice:
+		if t.Elem().Kind() != reflect.Uint8 {
+			return nil
+		}
+		m = &wrapperspb.BytesValue{}
+	default:
+		return nil
+	}
+	return m.ProtoReflect().Descriptor()
+}
+
+// message returns the full name of the message of struct type t.
+func (b *fileBuilder) message(t reflect.Type, hint string) (string, error) {
+	if name, ok := b.messages[t]; ok {
+		return name, nil
+	}
+	if t.Name() != "" {
+		hint = t.Name()
+	}
+	name := b.reserve(hint)
+	full := b.fullName(name)
+	b.messages[t] = full
+	msg := &descriptorpb.DescriptorProto{Name: proto.String(name)}
+	b.file.MessageType = append(b.file.MessageType, msg)
+
+	fields, err := structFields(t)
+	if err != nil {
+		return "", err
+	}
+	for _, f := range fields {
+		if err := b.addField(msg, full, f); err != nil {
+			return "", fmt.Errorf("%s.%s: %w", t, f.goName, err)
+		}
+	}
+	return full, nil
+}
+
+// addField adds field f to msg, whose full name is full.
+func (b *fileBuilder) addField(msg *descriptorpb.DescriptorProto, full string, f protoField) error {
+	fd := &descriptorpb.FieldDescriptorProto{
+		Name:     proto.String(f.name),
+		JsonName: proto.String(f.jsonName),
+		Number:   proto.Int32(f.number),
+		Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+	}
+	hint := strings.TrimPrefix(full, b.fullName("")) + camelCase(f.name)
+
+	t := indirectType(f.typ)
+	switch {
+	case t.Kind() == reflect.Map:
+		entry := &descriptorpb.DescriptorProto{
+			Name:    proto.String(camelCase(f.name) + "Entry"),
+			Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+		}
+		key := &descriptorpb.FieldDescriptorProto{Name: proto.String("key"), JsonName: proto.String("key"), Number: proto.Int32(1),
+			Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()}
+		if err := b.setType(key, t.Key(), hint); err != nil {
+			return err
+		}
+		if key.TypeName != nil || key.GetType() == descriptorpb.FieldDescriptorProto_TYPE_BYTES ||
+			key.GetType() == descriptorpb.FieldDescriptorProto_TYPE_FLOAT || key.GetType() == descriptorpb.FieldDescriptorProto_TYPE_DOUBLE {
+			return fmt.Errorf("unsupported map key type %s", t.Key())
+		}
+		value := &descriptorpb.FieldDescriptorProto{Name: proto.String("value"), JsonName: proto.String("value"), Number: proto.Int32(2),
+			Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()}
+		if err := b.setType(value, t.Elem(), hint+"Value"); err != nil {
+			return err
+		}
+		entry.Field = []*descriptorpb.FieldDescriptorProto{key, value}
+		msg.NestedType = append(msg.NestedType, entry)
+		fd.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+		fd.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+		fd.TypeName = proto.String(full + "." + entry.GetName())
+	case (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) && t.Elem().Kind() != reflect.Uint8 && t != rawMessageType:
+		fd.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+		if err := b.setType(fd, t.Elem(), hint); err != nil {
+			return err
+		}
+	default:
+		if err := b.setType(fd, t, hint); err != nil {
+			return err
+		}
+	}
+	msg.Field = append(msg.Field, fd)
+	return nil
+}
+
+// setType sets the type of a singular field, or of the elements of a
+// repeated one.
+func (b *fileBuilder) setType(fd *descriptorpb.FieldDescriptorProto, t reflect.Type, hint string) error {
+	t = indirectType(t)
+	if name, ok := b.messageOf(t, hint); ok {
+		full, err := name()
+		if err != nil {
+			return err
+		}
+		fd.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+		fd.TypeName = proto.String(full)
+		return nil
+	}
+	typ, ok := scalarType(t)
+	if !ok {
+		return fmt.Errorf("unsupported type %s", t)
+	}
+	fd.Type = typ.Enum()
+	return nil
+}
+
+func scalarType(t reflect.Type) (descriptorpb.FieldDescriptorProto_Type, bool) {
+	switch t.Kind() {
+	case reflect.Bool:
+		return descriptorpb.FieldDescriptorProto_TYPE_BOOL, true
+	case reflect.Int, reflect.Int64:
+		return descriptorpb.FieldDescriptorProto_TYPE_INT64, true
+	case reflect.Int8, reflect.Int16, reflect.Int32:
+		return descriptorpb.FieldDescriptorProto_TYPE_INT32, true
+	case reflect.Uint, reflect.Uint64, reflect.Uintptr:
+		return descriptorpb.FieldDescriptorProto_TYPE_UINT64, true
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return descriptorpb.FieldDescriptorProto_TYPE_UINT32, true
+	case reflect.Float32:
+		return descriptorpb.FieldDescriptorProto_TYPE_FLOAT, true
+	case reflect.Float64:
+		return descriptorpb.FieldDescriptorProto_TYPE_DOUBLE, true
+	case reflect.String:
+		return descriptorpb.FieldDescriptorProto_TYPE_STRING, true
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return descriptorpb.FieldDescriptorProto_TYPE_BYTES, true
+		}
+	}
+	return 0, false
+}
+
+// protoField is a struct field as encoded in proto mode.
+type protoField struct {
+	goName   string
+	name     string
+	jsonName string
+	number   int32
+	index    []int
+	typ      reflect.Type
+}
+
+var structFieldsCache sync.Map // reflect.Type -> []protoField
+
+// structFields returns the fields of struct type t in the order of their
+// numbers, following encoding/json for names and embedded structs.
+func structFields(t reflect.Type) ([]protoField, error) {
+	if v, ok := structFieldsCache.Load(t); ok {
+		return v.([]protoField), nil
+	}
+
+	var fields []protoField
+	if err := collectFields(t, nil, &fields); err != nil {
+		return nil, err
+	}
+	numbers := make(map[int32]string, len(fields))
+	for i := range fields {
+		if fields[i].number == 0 {
+			fields[i].number = int32(i + 1)
+		}
+		if other, ok := numbers[fields[i].number]; ok {
+			return nil, fmt.Errorf("%s: fields %s and %s have the same number %d", t, other, fields[i].goName, fields[i].number)
+		}
+		numbers[fields[i].number] = fields[i].goName
+	}
+
+	structFieldsCache.Store(t, fields)
+	return fields, nil
+}
+
+func collectFields(t reflect.Type, index []int, fields *[]protoField) error {
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		idx := append(append([]int(nil), index...), i)
+
+		if sf.Anonymous && name == "" {
+			et := indirectType(sf.Type)
+			if et.Kind() == reflect.Struct && et != timeType && !isProtoMessage(et) {
+				if err := collectFields(et, idx, fields); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if !sf.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+
+		var number int32
+		if s := sf.Tag.Get("proto"); s != "" {
+			n, err := strconv.ParseInt(s, 10, 32)
+			if err != nil || n <= 0 {
+				return fmt.Errorf("%s.%s: invalid proto tag %q", t, sf.Name, s)
+			}
+			number = int32(n)
+		}
+
+		*fields = append(*fields, protoField{
+			goName:   sf.Name,
+			name:     snakeCase(name),
+			jsonName: name,
+			number:   number,
+			index:    idx,
+			typ:      sf.Type,
+		})
+	}
+	return nil
+}
+
+// identifier turns s into a valid protobuf identifier.
+func identifier(s string, upper bool) string {
+	var b strings.Builder
+	for i, r := range s {
+		switch {
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'):
+			if i == 0 && unicode.IsDigit(r) {
+				b.WriteByte('_')
+			}
+			if i == 0 && upper {
+				r = unicode.ToUpper(r)
+			}
+			b.WriteRune(r)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	if b.Len() == 0 {
+		return "_"
+	}
+	return b.String()
+}
+
+// snakeCase converts a JSON name such as "userID" to a field name such as
+// "user_id".
+func snakeCase(s string) string {
+	runes := []rune(identifier(s, false))
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			prevLower := i > 0 && (unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1]))
+			nextLower := i > 0 && i+1 < len(runes) && unicode.IsUpper(runes[i-1]) && unicode.IsLower(runes[i+1])
+			if prevLower || nextLower {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// camelCase converts a field name to a message name, e.g. "user_id" to
+// "UserId", as protoc names map entries.
+func camelCase(s string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range s {
+		if r == '_' {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// jsonCamelCase returns the default JSON name of a field, as protoc
+// derives it.
+func jsonCamelCase(s string) string {
+	c := camelCase(s)
+	if c == "" {
+		return c
+	}
+	r := []rune(c)
+	if s[0] != '_' {
+		r[0] = unicode.ToLower(r[0])
+	}
+	return string(r)
+}
+
+// WriteProto writes fd as .proto source.
+func WriteProto(w io.Writer, fd *descriptorpb.FileDescriptorProto) error {
+	var b strings.Builder
+	pkg := fd.GetPackage()
+	typeName := func(name string) string {
+		if pkg != "" && strings.HasPrefix(name, "."+pkg+".") {
+			return name[len(pkg)+2:]
+		}
+		return strings.TrimPrefix(name, ".")
+	}
+
+	fmt.Fprintf(&b, "syntax = %q;\n", "proto3")
+	if pkg != "" {
+		fmt.Fprintf(&b, "\npackage %s;\n", pkg)
+	}
+	if len(fd.Dependency) > 0 {
+		b.WriteString("\n")
+		for _, dep := range fd.Dependency {
+			fmt.Fprintf(&b, "import %q;\n", dep)
+		}
+	}
+
+	for _, msg := range fd.MessageType {
+		fmt.Fprintf(&b, "\nmessage %s {\n", msg.GetName())
+		entries := map[string]*descriptorpb.DescriptorProto{}
+		for _, nested := range msg.NestedType {
+			if nested.GetOptions().GetMapEntry() {
+				entries[nested.GetName()] = nested
+			}
+		}
+		for _, f := range msg.Field {
+			var typ string
+			entryName, nested := strings.CutPrefix(typeName(f.GetTypeName()), msg.GetName()+".")
+			if entry, ok := entries[entryName]; ok && nested {
+				typ = fmt.Sprintf("map<%s, %s>", fieldType(entry.Field[0], typeName), fieldType(entry.Field[1], typeName))
+			} else {
+				typ = fieldType(f, typeName)
+				if f.GetLabel() == descriptorpb.FieldDescriptorProto_LABEL_REPEATED {
+					typ = "repeated " + typ
+				}
+			}
+			fmt.Fprintf(&b, "  %s %s = %d", typ, f.GetName(), f.GetNumber())
+			if f.GetJsonName() != jsonCamelCase(f.GetName()) {
+				fmt.Fprintf(&b, " [json_name = %q]", f.GetJsonName())
+			}
+			b.WriteString(";\n")
+		}
+		b.WriteString("}\n")
+	}
+
+	for _, svc := range fd.Service {
+		fmt.Fprintf(&b, "\nservice %s {\n", svc.GetName())
+		for _, m := range svc.Method {
+			in, out := typeName(m.GetInputType()), typeName(m.GetOutputType())
+			if m.GetClientStreaming() {
+				in = "stream " + in
+			}
+			if m.GetServerStreaming() {
+				out = "stream " + out
+			}
+			fmt.Fprintf(&b, "  rpc %s(%s) returns (%s);\n", m.GetName(), in, out)
+		}
+		b.WriteString("}\n")
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func fieldType(f *descriptorpb.FieldDescriptorProto, typeName func(string) string) string {
+	if f.GetTypeName() != "" {
+		return typeName(f.GetTypeName())
+	}
+	return strings.ToLower(strings.TrimPrefix(f.GetType().String(), "TYPE_"))
+}
@@ -0,0 +1,154 @@
+package grpc
+
+import (
+	"io"
+	"sort"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// reflectionServer implements grpc.reflection.v1alpha, which tools such as
+// grpcurl use to discover services, for the file built by FileDescriptor.
+// Other files, such as those of the health service, are looked up in the
+// global registry.
+type reflectionServer struct {
+	server *grpc.Server
+	files  *protoregistry.Files
+}
+
+// newReflectionServer returns a reflection server for fd and its
+// dependencies.
+func newReflectionServer(server *grpc.Server, fd protoreflect.FileDescriptor) (*reflectionServer, error) {
+	files := new(protoregistry.Files)
+	var register func(fd protoreflect.FileDescriptor) error
+	register = func(fd protoreflect.FileDescriptor) error {
+		if _, err := files.FindFileByPath(fd.Path()); err == nil {
+			return nil
+		}
+		imports := fd.Imports()
+		for i := 0; i < imports.Len(); i++ {
+			if err := register(imports.Get(i).FileDescriptor); err != nil {
+				return err
+			}
+		}
+		return files.RegisterFile(fd)
+	}
+	if err := register(fd); err != nil {
+		return nil, err
+	}
+	return &reflectionServer{server: server, files: files}, nil
+}
+
+func (r *reflectionServer) findFile(path string) (protoreflect.FileDescriptor, error) {
+	if fd, err := r.files.FindFileByPath(path); err == nil {
+		return fd, nil
+	}
+	return protoregistry.GlobalFiles.FindFileByPath(path)
+}
+
+func (r *reflectionServer) findSymbol(name string) (protoreflect.FileDescriptor, error) {
+	d, err := r.files.FindDescriptorByName(protoreflect.FullName(name))
+	if err != nil {
+		d, err = protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(name))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return d.ParentFile(), nil
+}
+
+// fileResponse returns fd with the dependencies not sent on the stream
+// yet.
+func fileResponse(fd protoreflect.FileDescriptor, sent map[string]bool) (*rpb.FileDescriptorResponse, error) {
+	resp := &rpb.FileDescriptorResponse{}
+	delete(sent, fd.Path())
+	var add func(fd protoreflect.FileDescriptor) error
+	add = func(fd protoreflect.FileDescriptor) error {
+		if sent[fd.Path()] {
+			return nil
+		}
+		sent[fd.Path()] = true
+		data, err := proto.Marshal(protodesc.ToFileDescriptorProto(fd))
+		if err != nil {
+			return err
+		}
+		resp.FileDescriptorProto = append(resp.FileDescriptorProto, data)
+		imports := fd.Imports()
+		for i := 0; i < imports.Len(); i++ {
+			if err := add(imports.Get(i).FileDescriptor); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := add(fd); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (r *reflectionServer) ServerReflectionInfo(stream rpb.ServerReflection_ServerReflectionInfoServer) error {
+	sent := make(map[string]bool)
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		resp := &rpb.ServerReflectionResponse{
+			ValidHost:       req.Host,
+			OriginalRequest: req,
+		}
+
+		var fd protoreflect.FileDescriptor
+		switch mr := req.MessageRequest.(type) {
+		case *rpb.ServerReflectionRequest_FileByFilename:
+			fd, err = r.findFile(mr.FileByFilename)
+		case *rpb.ServerReflectionRequest_FileContainingSymbol:
+			fd, err = r.findSymbol(mr.FileContainingSymbol)
+		case *rpb.ServerReflectionRequest_AllExtensionNumbersOfType:
+			// proto3 messages have no extensions.
+			resp.MessageResponse = &rpb.ServerReflectionResponse_AllExtensionNumbersResponse{
+				AllExtensionNumbersResponse: &rpb.ExtensionNumberResponse{BaseTypeName: mr.AllExtensionNumbersOfType},
+			}
+		case *rpb.ServerReflectionRequest_ListServices:
+			var names []string
+			for name := range r.server.GetServiceInfo() {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			list := &rpb.ListServiceResponse{}
+			for _, name := range names {
+				list.Service = append(list.Service, &rpb.ServiceResponse{Name: name})
+			}
+			resp.MessageResponse = &rpb.ServerReflectionResponse_ListServicesResponse{ListServicesResponse: list}
+		default:
+			err = protoregistry.NotFound
+		}
+
+		if fd != nil {
+			var files *rpb.FileDescriptorResponse
+			if files, err = fileResponse(fd, sent); err == nil {
+				resp.MessageResponse = &rpb.ServerReflectionResponse_FileDescriptorResponse{FileDescriptorResponse: files}
+			}
+		}
+		if err != nil {
+			resp.MessageResponse = &rpb.ServerReflectionResponse_ErrorResponse{
+				ErrorResponse: &rpb.ErrorResponse{ErrorCode: int32(codes.NotFound), ErrorMessage: err.Error()},
+			}
+		}
+
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+	}
+}
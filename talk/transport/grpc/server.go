@@ -2,13 +2,26 @@ package grpc
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
+	"io"
 	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoregistry"
 
 	"go.zoe.im/x"
 	"go.zoe.im/x/talk"
@@ -19,8 +32,14 @@ import (
 type Server struct {
 	config    ServerConfig
 	codec     codec.Codec
+	tlsConfig *tls.Config
 	server    *grpc.Server
 	endpoints map[string]*talk.Endpoint
+	services  []string
+
+	unaryInterceptors  []grpc.UnaryServerInterceptor
+	streamInterceptors []grpc.StreamServerInterceptor
+	serverOptions      []grpc.ServerOption
 }
 
 // NewServer creates a new gRPC server transport.
@@ -40,6 +59,15 @@ func NewServer(cfg x.TypedLazyConfig, opts ...Option) (*Server, error) {
 	if s.codec == nil {
 		s.codec = codec.MustGet("json")
 	}
+	if !s.config.Proto {
+		if err := checkCodec(s.codec); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := compressorName(s.config.CompressionConfig); err != nil {
+		return nil, err
+	}
 
 	return s, nil
 }
@@ -48,15 +76,12 @@ func (s *Server) SetCodec(c codec.Codec) {
 	s.codec = c
 }
 
-func (s *Server) String() string {
-	return "grpc"
+func (s *Server) setTLSConfig(cfg *tls.Config) {
+	s.tlsConfig = cfg
 }
 
-func (s *Server) Serve(ctx context.Context, endpoints []*talk.Endpoint) error {
-	for _, ep := range endpoints {
-		s.endpoints[ep.Name] = ep
-	}
-
+// buildServerOptions returns the options of the gRPC server.
+func (s *Server) buildServerOptions() ([]grpc.ServerOption, error) {
 	var serverOpts []grpc.ServerOption
 
 	if s.config.MaxRecvMsgSize > 0 {
@@ -65,24 +90,95 @@ func (s *Server) Serve(ctx context.Context, endpoints []*talk.Endpoint) error {
 	if s.config.MaxSendMsgSize > 0 {
 		serverOpts = append(serverOpts, grpc.MaxSendMsgSize(s.config.MaxSendMsgSize))
 	}
+	if s.config.ConnectionTimeout > 0 {
+		serverOpts = append(serverOpts, grpc.ConnectionTimeout(time.Duration(s.config.ConnectionTimeout)))
+	}
 
-	if s.config.TLSCertFile != "" && s.config.TLSKeyFile != "" {
-		creds, err := credentials.NewServerTLSFromFile(s.config.TLSCertFile, s.config.TLSKeyFile)
-		if err != nil {
-			return fmt.Errorf("failed to load TLS credentials: %w", err)
+	tlsConfig := s.tlsConfig
+	if tlsConfig == nil {
+		var err error
+		if tlsConfig, err = s.config.tlsConfig(true); err != nil {
+			return nil, fmt.Errorf("failed to load TLS credentials: %w", err)
 		}
-		serverOpts = append(serverOpts, grpc.Creds(creds))
+	}
+	if tlsConfig != nil {
+		serverOpts = append(serverOpts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+
+	params := keepalive.ServerParameters{
+		MaxConnectionIdle:     time.Duration(s.config.MaxConnectionIdle),
+		MaxConnectionAge:      time.Duration(s.config.MaxConnectionAge),
+		MaxConnectionAgeGrace: time.Duration(s.config.MaxConnectionAgeGrace),
+		Time:                  time.Duration(s.config.KeepaliveTime),
+		Timeout:               time.Duration(s.config.KeepaliveTimeout),
+	}
+	if params != (keepalive.ServerParameters{}) {
+		serverOpts = append(serverOpts, grpc.KeepaliveParams(params))
+	}
+	if s.config.KeepaliveMinTime > 0 || s.config.KeepalivePermitWithoutStream {
+		serverOpts = append(serverOpts, grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             time.Duration(s.config.KeepaliveMinTime),
+			PermitWithoutStream: s.config.KeepalivePermitWithoutStream,
+		}))
+	}
+
+	if len(s.unaryInterceptors) > 0 {
+		serverOpts = append(serverOpts, grpc.UnaryInterceptor(chainUnaryServer(s.unaryInterceptors)))
+	}
+	if len(s.streamInterceptors) > 0 {
+		serverOpts = append(serverOpts, grpc.StreamInterceptor(chainStreamServer(s.streamInterceptors)))
 	}
 
+	return append(serverOpts, s.serverOptions...), nil
+}
+
+func (s *Server) String() string {
+	return "grpc"
+}
+
+func (s *Server) Serve(ctx context.Context, endpoints []*talk.Endpoint) error {
+	for _, ep := range endpoints {
+		s.endpoints[ep.Name] = ep
+	}
+
+	// SetCodec may have replaced the codec checked by NewServer.
+	if !s.config.Proto {
+		if err := checkCodec(s.codec); err != nil {
+			return err
+		}
+	}
+
+	serverOpts, err := s.buildServerOptions()
+	if err != nil {
+		return err
+	}
 	s.server = grpc.NewServer(serverOpts...)
 
-	s.server.RegisterService(&grpc.ServiceDesc{
-		ServiceName: "talk.Service",
-		HandlerType: (*interface{})(nil),
-		Methods:     s.buildUnaryMethods(),
-		Streams:     s.buildStreamMethods(),
-		Metadata:    "talk.proto",
-	}, s)
+	seen := make(map[string]bool)
+	for _, ep := range s.endpoints {
+		if name := s.service(ep); s.serves(ep) && !seen[name] {
+			seen[name] = true
+			s.services = append(s.services, name)
+		}
+	}
+	sort.Strings(s.services)
+	for _, name := range s.services {
+		s.server.RegisterService(&grpc.ServiceDesc{
+			ServiceName: name,
+			HandlerType: (*interface{})(nil),
+			Methods:     s.buildUnaryMethods(name),
+			Streams:     s.buildStreamMethods(name),
+			Metadata:    protoFileName(s.pkg()),
+		}, s)
+	}
+	if s.config.Proto && !s.config.DisableReflection {
+		if err := s.registerReflection(endpoints); err != nil {
+			return err
+		}
+	}
+	if hs := newHealthServer(s.endpoints, s.services); hs != nil {
+		healthpb.RegisterHealthServer(s.server, hs)
+	}
 
 	listener, err := net.Listen("tcp", s.config.Addr)
 	if err != nil {
@@ -125,11 +221,56 @@ func (s *Server) Close() error {
 	return nil
 }
 
-func (s *Server) buildUnaryMethods() []grpc.MethodDesc {
+// pkg returns the protobuf package of the services.
+func (s *Server) pkg() string {
+	if s.config.Package == "" {
+		return DefaultPackage
+	}
+	return s.config.Package
+}
+
+// service returns the full name of the gRPC service ep is served under.
+func (s *Server) service(ep *talk.Endpoint) string {
+	return serviceFullName(s.pkg(), ep, s.config.Proto)
+}
+
+// serves reports whether ep is served over gRPC.
+func (s *Server) serves(ep *talk.Endpoint) bool {
+	return !s.config.Proto || protoCallable(ep)
+}
+
+// messages returns the codec of the messages of the call in ctx.
+func (s *Server) messages(ctx context.Context) messageCodec {
+	if s.config.Proto {
+		return protoMessages{}
+	}
+	return rawMessages{codec: requestCodec(ctx, s.codec)}
+}
+
+// registerReflection registers the server reflection service describing
+// the endpoints.
+func (s *Server) registerReflection(endpoints []*talk.Endpoint) error {
+	fdp, err := FileDescriptor(s.pkg(), endpoints)
+	if err != nil {
+		return fmt.Errorf("failed to describe services: %w", err)
+	}
+	fd, err := protodesc.NewFile(fdp, protoregistry.GlobalFiles)
+	if err != nil {
+		return fmt.Errorf("failed to describe services: %w", err)
+	}
+	rs, err := newReflectionServer(s.server, fd)
+	if err != nil {
+		return fmt.Errorf("failed to describe services: %w", err)
+	}
+	rpb.RegisterServerReflectionServer(s.server, rs)
+	return nil
+}
+
+func (s *Server) buildUnaryMethods(service string) []grpc.MethodDesc {
 	var methods []grpc.MethodDesc
 
 	for name, ep := range s.endpoints {
-		if ep.IsStreaming() {
+		if ep.IsStreaming() || !s.serves(ep) || s.service(ep) != service {
 			continue
 		}
 
@@ -143,11 +284,11 @@ func (s *Server) buildUnaryMethods() []grpc.MethodDesc {
 	return methods
 }
 
-func (s *Server) buildStreamMethods() []grpc.StreamDesc {
+func (s *Server) buildStreamMethods(service string) []grpc.StreamDesc {
 	var streams []grpc.StreamDesc
 
 	for name, ep := range s.endpoints {
-		if !ep.IsStreaming() {
+		if !ep.IsStreaming() || !s.serves(ep) || s.service(ep) != service {
 			continue
 		}
 
@@ -165,29 +306,40 @@ func (s *Server) buildStreamMethods() []grpc.StreamDesc {
 
 func (s *Server) createUnaryHandler(ep *talk.Endpoint) func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
 	return func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
-		var req any
-		if ep.RequestType != nil {
-			req = make(map[string]any)
-			if err := dec(&req); err != nil {
-				return nil, status.Error(codes.InvalidArgument, err.Error())
-			}
+		mc := s.messages(ctx)
+		msg, err := mc.newMessage(ep.RequestType)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		if err := dec(msg); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		req, err := decodeRequest(mc, msg, ep.RequestType)
+		if err != nil {
+			return nil, toGRPCError(err)
 		}
 
 		handler := func(ctx context.Context, req any) (any, error) {
 			resp, err := ep.WrappedHandler()(ctx, req)
 			if err != nil {
-				return nil, s.toGRPCError(err)
+				return nil, toGRPCError(err)
+			}
+			out, err := mc.encode(resp, ep.ResponseType)
+			if err != nil {
+				return nil, status.Error(codes.Internal, "failed to encode response")
 			}
-			return resp, nil
+			return out, nil
 		}
 
+		ctx = incomingContext(ctx, ep)
+
 		if interceptor == nil {
 			return handler(ctx, req)
 		}
 
 		info := &grpc.UnaryServerInfo{
 			Server:     srv,
-			FullMethod: "/talk.Service/" + ep.Name,
+			FullMethod: "/" + s.service(ep) + "/" + ep.Name,
 		}
 		return interceptor(ctx, req, info, handler)
 	}
@@ -195,54 +347,109 @@ func (s *Server) createUnaryHandler(ep *talk.Endpoint) func(srv any, ctx context
 
 func (s *Server) createStreamHandler(ep *talk.Endpoint) func(srv any, stream grpc.ServerStream) error {
 	return func(srv any, stream grpc.ServerStream) error {
+		if ep.StreamHandler == nil {
+			return status.Error(codes.Unimplemented, "no stream handler configured")
+		}
+
 		talkStream := &grpcServerStream{
 			ServerStream: stream,
-			codec:        s.codec,
+			ctx:          incomingContext(stream.Context(), ep),
+			messages:     s.messages(stream.Context()),
+			sendType:     streamElem(ep.ResponseType),
+			recvType:     streamElem(ep.RequestType),
 		}
 
-		if ep.StreamHandler != nil {
-			return ep.StreamHandler(stream.Context(), nil, talkStream)
+		// A server stream is opened with its request as the first message.
+		var req any
+		if ep.StreamMode == talk.StreamServerSide {
+			msg, err := talkStream.messages.newMessage(talkStream.recvType)
+			if err != nil {
+				return status.Error(codes.Internal, err.Error())
+			}
+			if err := stream.RecvMsg(msg); err != nil && err != io.EOF {
+				return err
+			} else if err == nil {
+				if req, err = decodeRequest(talkStream.messages, msg, talkStream.recvType); err != nil {
+					return toGRPCError(err)
+				}
+			}
 		}
 
-		return status.Error(codes.Unimplemented, "no stream handler configured")
+		return toGRPCError(ep.WrappedStreamHandler()(talkStream.ctx, req, talkStream))
 	}
 }
 
-func (s *Server) toGRPCError(err error) error {
+// toGRPCError converts a talk error to a gRPC status error. Status errors
+// are returned as is.
+func toGRPCError(err error) error {
 	if err == nil {
 		return nil
 	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
 
 	if talkErr, ok := err.(*talk.Error); ok {
-		return status.Error(codes.Code(talkErr.GRPCCode()), talkErr.Message)
+		return toStatus(talkErr).Err()
 	}
 
 	return status.Error(codes.Unknown, err.Error())
 }
 
+// incomingContext exposes the gRPC request metadata and peer address to talk
+// and injects the endpoint, if any, for middleware (auth, etc.).
+func incomingContext(ctx context.Context, ep *talk.Endpoint) context.Context {
+	md := talk.Metadata{}
+	if in, ok := metadata.FromIncomingContext(ctx); ok {
+		for k, v := range in {
+			if len(v) > 0 {
+				md.Set(k, strings.Join(v, ", "))
+			}
+		}
+	}
+	ctx = talk.NewIncomingContext(ctx, md)
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		ctx = talk.NewPeerContext(ctx, p.Addr.String())
+	}
+	if ep == nil {
+		return ctx
+	}
+	return talk.WithEndpointContext(ctx, ep)
+}
+
 type grpcServerStream struct {
 	grpc.ServerStream
-	codec codec.Codec
+	ctx      context.Context
+	messages messageCodec
+	sendType reflect.Type
+	recvType reflect.Type
 }
 
 func (s *grpcServerStream) Context() context.Context {
-	return s.ServerStream.Context()
+	return s.ctx
 }
 
 func (s *grpcServerStream) Send(msg any) error {
-	data, err := s.codec.Marshal(msg)
+	m, err := s.messages.encode(msg, s.sendType)
 	if err != nil {
 		return err
 	}
-	return s.ServerStream.SendMsg(data)
+	return s.ServerStream.SendMsg(m)
 }
 
 func (s *grpcServerStream) Recv(msg any) error {
-	var data []byte
-	if err := s.ServerStream.RecvMsg(&data); err != nil {
+	t := s.recvType
+	if t == nil {
+		t = targetType(msg)
+	}
+	m, err := s.messages.newMessage(t)
+	if err != nil {
+		return err
+	}
+	if err := s.ServerStream.RecvMsg(m); err != nil {
 		return err
 	}
-	return s.codec.Unmarshal(data, msg)
+	return s.messages.decode(m, msg)
 }
 
 func (s *grpcServerStream) Close() error {
@@ -0,0 +1,134 @@
+package http
+
+import (
+	"errors"
+	"io"
+	nethttp "net/http"
+
+	"go.zoe.im/x/talk"
+	"go.zoe.im/x/talk/codec"
+)
+
+// RequestBody returns the body of r, decompressed according to its
+// Content-Encoding header. Reading more than maxSize decompressed bytes
+// fails with ResourceExhausted. Closing it closes the decompressor and the
+// original body.
+func RequestBody(r *nethttp.Request, maxSize int) (io.ReadCloser, error) {
+	return decompressBody(r.Header.Get("Content-Encoding"), r.Body, maxSize)
+}
+
+// ResponseBody is RequestBody for client responses, without size limit.
+func ResponseBody(resp *nethttp.Response) (io.ReadCloser, error) {
+	return decompressBody(resp.Header.Get("Content-Encoding"), resp.Body, 0)
+}
+
+func decompressBody(encoding string, body io.ReadCloser, maxSize int) (io.ReadCloser, error) {
+	if body == nil {
+		body = nethttp.NoBody
+	}
+	zr, err := codec.DecompressReader(encoding, body)
+	if err != nil {
+		return nil, err
+	}
+	if zr == io.Reader(body) {
+		return decompressedBody{Reader: body, body: body}, nil
+	}
+	b := decompressedBody{Reader: zr, zr: zr, body: body}
+	if maxSize > 0 {
+		b.Reader = &limitedReader{r: zr, n: int64(maxSize), max: maxSize}
+	}
+	return b, nil
+}
+
+type decompressedBody struct {
+	io.Reader
+	// zr is the decompressor, if any.
+	zr   io.Reader
+	body io.Closer
+}
+
+func (b decompressedBody) Close() error {
+	if closer, ok := b.zr.(io.Closer); ok {
+		closer.Close()
+	}
+	return b.body.Close()
+}
+
+// limitedReader fails with ResourceExhausted once more than max bytes are
+// read from r.
+type limitedReader struct {
+	r   io.Reader
+	n   int64
+	max int
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	if int64(n) <= l.n {
+		l.n -= int64(n)
+		return n, err
+	}
+	n, l.n = int(l.n), 0
+	return n, talk.NewErrorf(talk.ResourceExhausted, "decompressed request body exceeds %d bytes", l.max)
+}
+
+// DecodeError returns the error reported for a request body that failed
+// to decode: ResourceExhausted bodies keep their error, anything else is
+// InvalidArgument.
+func DecodeError(err error) *talk.Error {
+	var te *talk.Error
+	if errors.As(err, &te) && te.Code == talk.ResourceExhausted {
+		return te
+	}
+	return talk.NewError(talk.InvalidArgument, "failed to decode request")
+}
+
+// ResponseCompressor returns comp if the Accept-Encoding header of r allows
+// it, or nil. When comp is set it adds Vary: Accept-Encoding to h, as the
+// response then depends on that header.
+func ResponseCompressor(h nethttp.Header, r *nethttp.Request, comp codec.Compressor) codec.Compressor {
+	if comp == nil {
+		return nil
+	}
+	h.Add("Vary", "Accept-Encoding")
+	if !codec.AcceptsEncoding(r.Header.Get("Accept-Encoding"), comp.Name()) {
+		return nil
+	}
+	return comp
+}
+
+// CompressBody compresses body with comp if comp is not nil and body is at
+// least minSize bytes, setting the Content-Encoding header in h. Otherwise,
+// or if compression fails, it returns body as is.
+func CompressBody(h nethttp.Header, comp codec.Compressor, minSize int, body []byte) []byte {
+	if comp == nil || len(body) < minSize {
+		return body
+	}
+	compressed, err := codec.Compress(comp, body)
+	if err != nil {
+		return body
+	}
+	h.Set("Content-Encoding", comp.Name())
+	return compressed
+}
+
+// CopyBody copies body to w, compressing it with comp if comp is not nil.
+// The Content-Encoding header must be set on w before calling it.
+func CopyBody(w io.Writer, comp codec.Compressor, body io.Reader) error {
+	if comp == nil {
+		_, err := io.Copy(w, body)
+		return err
+	}
+	zw, err := comp.Compress(w)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(zw, body); err != nil {
+		zw.Close()
+		return err
+	}
+	return zw.Close()
+}
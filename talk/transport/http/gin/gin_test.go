@@ -162,6 +162,38 @@ func TestServer_Handler(t *testing.T) {
 	})
 }
 
+func TestServer_ContentNegotiation(t *testing.T) {
+	server, err := NewServer(x.TypedLazyConfig{Config: json.RawMessage(`{"addr": ":0"}`)})
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+	server.registerEndpoint(&talk.Endpoint{
+		Name:        "Echo",
+		Path:        "/echo",
+		Method:      "POST",
+		RequestType: reflect.TypeOf(testResponse{}),
+		Handler: func(ctx context.Context, req any) (any, error) {
+			return req, nil
+		},
+	})
+
+	msgpack := codec.MustGet("msgpack")
+	body, _ := msgpack.Marshal(&testResponse{Message: "hi"})
+	req := httptest.NewRequest(http.MethodPost, "/echo", strings.NewReader(string(body)))
+	req.Header.Set("Content-Type", "application/msgpack")
+	req.Header.Set("Accept", "application/msgpack")
+	w := httptest.NewRecorder()
+	server.engine.ServeHTTP(w, req)
+
+	if ct := w.Header().Get("Content-Type"); ct != "application/msgpack" {
+		t.Fatalf("Content-Type = %q, want application/msgpack", ct)
+	}
+	var result testResponse
+	if err := msgpack.Unmarshal(w.Body.Bytes(), &result); err != nil || result.Message != "hi" {
+		t.Errorf("result = %+v, %v", result, err)
+	}
+}
+
 func TestServer_ServeAndShutdown(t *testing.T) {
 	cfg := x.TypedLazyConfig{
 		Config: json.RawMessage(`{"addr": ":0"}`),
@@ -23,6 +23,7 @@ import (
 type Server struct {
 	config         thttp.ServerConfig
 	codec          codec.Codec
+	compressor     codec.Compressor
 	server         *http.Server
 	engine         *gin.Engine
 	endpoints      []*talk.Endpoint
@@ -46,6 +47,12 @@ func NewServer(cfg x.TypedLazyConfig, opts ...thttp.Option) (*Server, error) {
 		s.codec = codec.MustGet("json")
 	}
 
+	comp, err := s.config.Compressor()
+	if err != nil {
+		return nil, err
+	}
+	s.compressor = comp
+
 	if s.engine == nil {
 		gin.SetMode(gin.ReleaseMode)
 		s.engine = gin.New()
@@ -200,27 +207,36 @@ func (s *Server) createHandler(ep *talk.Endpoint) gin.HandlerFunc {
 	if ep.IsStreaming() && ep.StreamMode == talk.StreamServerSide {
 		return s.createSSEHandler(ep)
 	}
+	if thttp.IsDuplex(ep) {
+		return s.createDuplexHandler(ep)
+	}
 	return s.createJSONHandler(ep)
 }
 
 func (s *Server) createJSONHandler(ep *talk.Endpoint) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		ctx := c.Request.Context()
+		ctx := talk.NewIncomingContext(c.Request.Context(), talk.MetadataFromHeader(c.Request.Header))
+		ctx = talk.NewPeerContext(ctx, c.Request.RemoteAddr)
 
-		var req any
-		if ep.RequestType != nil && c.Request.ContentLength > 0 {
-			body, err := io.ReadAll(c.Request.Body)
-			if err != nil {
-				s.writeError(c, talk.NewError(talk.InvalidArgument, "failed to read body"))
-				return
-			}
+		body, err := thttp.RequestBody(c.Request, s.config.MaxSize())
+		if err != nil {
+			s.writeError(c, talk.NewError(talk.InvalidArgument, err.Error()))
+			return
+		}
+		defer body.Close()
 
+		var req any
+		if talk.IsRawBody(ep.RequestType) {
+			req = body
+		} else if ep.RequestType != nil && c.Request.ContentLength != 0 {
 			reqVal := reflect.New(ep.RequestType).Interface()
-			if err := s.codec.Unmarshal(body, reqVal); err != nil {
-				s.writeError(c, talk.NewError(talk.InvalidArgument, "failed to decode request"))
+			reqCodec := codec.FromContentType(c.GetHeader("Content-Type"), s.codec)
+			if err := codec.Decode(reqCodec, body, reqVal); err == nil {
+				req = reflect.ValueOf(reqVal).Elem().Interface()
+			} else if err != io.EOF {
+				s.writeError(c, thttp.DecodeError(err))
 				return
 			}
-			req = reflect.ValueOf(reqVal).Elem().Interface()
 		}
 
 		// Ensure request struct is instantiated for struct types even without body
@@ -241,21 +257,50 @@ func (s *Server) createJSONHandler(ep *talk.Endpoint) gin.HandlerFunc {
 			return
 		}
 
-		s.writeJSON(c, http.StatusOK, resp)
+		comp := thttp.ResponseCompressor(c.Writer.Header(), c.Request, s.compressor)
+		s.writeResponse(c, codec.Negotiate(c.GetHeader("Accept"), s.codec), comp, http.StatusOK, resp)
+	}
+}
+
+// createDuplexHandler serves client-side and bidirectional streams as a
+// full-duplex exchange of codec frames.
+func (s *Server) createDuplexHandler(ep *talk.Endpoint) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := talk.NewIncomingContext(c.Request.Context(), talk.MetadataFromHeader(c.Request.Header))
+		ctx = talk.NewPeerContext(ctx, c.Request.RemoteAddr)
+		ctx = talk.WithEndpointContext(ctx, ep)
+
+		var req any
+		if ep.RequestType != nil && ep.RequestType.Kind() == reflect.Struct {
+			req = reflect.New(ep.RequestType).Elem().Interface()
+		}
+		req = s.extractParams(c, ep, req)
+
+		thttp.ServeDuplex(ctx, c.Writer, c.Request, ep, req, s.codec, s.config.MaxSize())
 	}
 }
 
 func (s *Server) createSSEHandler(ep *talk.Endpoint) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		ctx := c.Request.Context()
+		ctx := talk.NewIncomingContext(c.Request.Context(), talk.MetadataFromHeader(c.Request.Header))
+		ctx = talk.NewPeerContext(ctx, c.Request.RemoteAddr)
+		ctx = talk.WithEndpointContext(ctx, ep)
+
+		req, err := thttp.StreamRequest(c.Request, ep, s.codec, s.config.MaxSize())
+		if err != nil {
+			s.writeError(c, thttp.DecodeError(err))
+			return
+		}
 
+		// A full-duplex client falls back to SSE with its request body
+		// still open; HTTP/1.x servers would otherwise wait to drain it.
+		http.NewResponseController(c.Writer).EnableFullDuplex()
 		c.Header("Content-Type", "text/event-stream")
 		c.Header("Cache-Control", "no-cache")
 		c.Header("Connection", "keep-alive")
 		c.Header("X-Accel-Buffering", "no")
 
-		var req any
-		if ep.RequestType != nil && ep.RequestType.Kind() == reflect.Struct {
+		if req == nil && ep.RequestType != nil && ep.RequestType.Kind() == reflect.Struct {
 			req = reflect.New(ep.RequestType).Elem().Interface()
 		}
 		req = s.extractParams(c, ep, req)
@@ -267,10 +312,12 @@ func (s *Server) createSSEHandler(ep *talk.Endpoint) gin.HandlerFunc {
 		}
 
 		if ep.StreamHandler != nil {
-			if err := ep.StreamHandler(ctx, req, stream); err != nil {
-				c.SSEvent("error", err.Error())
-			}
+			err = ep.WrappedStreamHandler()(ctx, req, stream)
+		} else {
+			err = talk.NewError(talk.Unimplemented, "no stream handler configured")
 		}
+		thttp.WriteStreamEnd(c.Writer, err)
+		c.Writer.Flush()
 	}
 }
 
@@ -281,6 +328,9 @@ var pathParamRegex = regexp.MustCompile(`\{(\w+)\}`)
 // It supports both struct types (via `path` and `query` struct tags) and simple
 // string types (backward-compatible {id} extraction).
 func (s *Server) extractParams(c *gin.Context, ep *talk.Endpoint, req any) any {
+	if talk.IsRawBody(ep.RequestType) {
+		return req
+	}
 	// extract {id} as a raw value.
 	if ep.RequestType == nil || isSimpleType(ep.RequestType) {
 		if strings.Contains(ep.Path, "{id}") {
@@ -361,19 +411,47 @@ func (s *Server) extractParams(c *gin.Context, ep *talk.Endpoint, req any) any {
 	return req
 }
 
-func (s *Server) writeJSON(c *gin.Context, status int, data any) {
-	c.Header("Content-Type", s.codec.ContentType())
-	if data != nil {
-		body, _ := s.codec.Marshal(data)
-		c.Data(status, s.codec.ContentType(), body)
-	} else {
+// writeResponse writes data encoded with cd, the codec negotiated from the
+// request's Accept header, or copies it as is if it is an io.Reader. If comp
+// is not nil, raw responses are compressed with it, and encoded ones when
+// they reach the configured minimum size.
+func (s *Server) writeResponse(c *gin.Context, cd codec.Codec, comp codec.Compressor, status int, data any) {
+	if raw, ok := data.(io.Reader); ok {
+		if closer, ok := raw.(io.Closer); ok {
+			defer closer.Close()
+		}
+		if comp == nil {
+			c.DataFromReader(status, -1, talk.RawContentType, raw, nil)
+			return
+		}
+		c.Header("Content-Type", talk.RawContentType)
+		c.Header("Content-Encoding", comp.Name())
 		c.Status(status)
+		thttp.CopyBody(c.Writer, comp, raw)
+		return
 	}
+
+	c.Header("Content-Type", cd.ContentType())
+	if data == nil {
+		c.Status(status)
+		return
+	}
+	body, err := cd.Marshal(data)
+	if err != nil {
+		s.writeError(c, talk.NewErrorf(talk.Internal, "failed to encode response as %s", cd.Name()))
+		return
+	}
+	body = thttp.CompressBody(c.Writer.Header(), comp, s.config.MinSize(), body)
+	c.Data(status, cd.ContentType(), body)
 }
 
+// writeError writes err as JSON whatever codec was negotiated, so that
+// clients always get its typed details back.
 func (s *Server) writeError(c *gin.Context, err *talk.Error) {
-	body, _ := s.codec.Marshal(err)
-	c.Data(err.HTTPStatus(), s.codec.ContentType(), body)
+	cd := codec.MustGet("json")
+	thttp.SetErrorHeaders(c.Writer.Header(), err)
+	body, _ := cd.Marshal(err)
+	c.Data(err.HTTPStatus(), cd.ContentType(), body)
 }
 
 func convertPathParams(path string) string {
@@ -475,12 +553,9 @@ func (s *sseServerStream) Send(msg any) error {
 		return io.ErrClosedPipe
 	}
 
-	data, err := s.codec.Marshal(msg)
-	if err != nil {
+	if err := thttp.WriteEvent(s.c.Writer, s.codec, msg); err != nil {
 		return err
 	}
-
-	s.c.SSEvent("message", string(data))
 	s.c.Writer.Flush()
 	return nil
 }
@@ -2,6 +2,10 @@
 package http
 
 import (
+	"math"
+	nethttp "net/http"
+	"strconv"
+
 	"go.zoe.im/x"
 	"go.zoe.im/x/factory"
 	"go.zoe.im/x/talk"
@@ -17,6 +21,8 @@ type Config struct {
 	WriteTimeout   x.Duration     `json:"write_timeout,omitempty" yaml:"write_timeout"`
 	IdleTimeout    x.Duration     `json:"idle_timeout,omitempty" yaml:"idle_timeout"`
 	Swagger        swagger.Config `json:"swagger,omitempty" yaml:"swagger"`
+
+	codec.CompressionConfig `json:",inline" yaml:",inline"`
 }
 
 type ServerConfig struct {
@@ -26,6 +32,13 @@ type ServerConfig struct {
 type ClientConfig struct {
 	Config  `json:",inline" yaml:",inline"`
 	Timeout x.Duration `json:"timeout,omitempty" yaml:"timeout"`
+
+	// Reconnect configures how SSE streams cut by a network error are
+	// reopened with Last-Event-ID. Only the backoff and codes are used;
+	// defaults apply when nil.
+	Reconnect *talk.RetryConfig `json:"reconnect,omitempty" yaml:"reconnect"`
+	// DisableReconnect ends SSE streams on network errors instead.
+	DisableReconnect bool `json:"disable_reconnect,omitempty" yaml:"disable_reconnect"`
 }
 
 type Option func(any)
@@ -97,3 +110,11 @@ func (f *httpTransportFamily) CreateClient(cfg x.TypedLazyConfig, opts ...transp
 func init() {
 	transport.Factory.RegisterFamily("http", &httpTransportFamily{})
 }
+
+// SetErrorHeaders sets response headers derived from err, such as
+// Retry-After when the error carries a retry delay.
+func SetErrorHeaders(h nethttp.Header, err *talk.Error) {
+	if d, ok := err.RetryAfter(); ok {
+		h.Set("Retry-After", strconv.Itoa(int(math.Ceil(d.Seconds()))))
+	}
+}
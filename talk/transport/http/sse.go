@@ -0,0 +1,341 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	nethttp "net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.zoe.im/x"
+	"go.zoe.im/x/talk"
+	"go.zoe.im/x/talk/codec"
+)
+
+// WriteEvent writes msg to w as a server-sent event, its data encoded with
+// c. A talk.Event also sets the id, event and retry fields.
+func WriteEvent(w io.Writer, c codec.Codec, msg any) error {
+	var ev talk.Event
+	switch m := msg.(type) {
+	case talk.Event:
+		ev = m
+	case *talk.Event:
+		ev = *m
+	default:
+		ev.Data = msg
+	}
+	if strings.ContainsAny(ev.ID, "\r\n") || strings.ContainsAny(ev.Type, "\r\n") {
+		return talk.NewError(talk.InvalidArgument, "event id and type must be single lines")
+	}
+
+	data, err := c.Marshal(ev.Data)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if ev.ID != "" {
+		buf.WriteString("id: " + ev.ID + "\n")
+	}
+	if ev.Type != "" {
+		buf.WriteString("event: " + ev.Type + "\n")
+	}
+	if ev.Retry > 0 {
+		buf.WriteString("retry: " + strconv.FormatInt(ev.Retry.Milliseconds(), 10) + "\n")
+	}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		buf.WriteString("data: ")
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	buf.WriteByte('\n')
+
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// WriteErrorEvent writes err to w as an "error" event carrying the
+// JSON-encoded *talk.Error.
+func WriteErrorEvent(w io.Writer, err error) error {
+	data, _ := json.Marshal(talk.ToError(err))
+	_, werr := io.WriteString(w, "event: error\ndata: "+string(data)+"\n\n")
+	return werr
+}
+
+// EndEventType is the type of the event that marks the end of an SSE
+// stream, so that clients can tell a finished stream from a cut one.
+const EndEventType = "end"
+
+// WriteEndEvent writes the event marking the end of the stream to w.
+func WriteEndEvent(w io.Writer) error {
+	_, err := io.WriteString(w, "event: "+EndEventType+"\ndata: {}\n\n")
+	return err
+}
+
+// WriteStreamEnd ends an SSE stream whose handler returned err: with an
+// "error" event if err is not nil, with the end event otherwise.
+func WriteStreamEnd(w io.Writer, err error) error {
+	if err != nil {
+		return WriteErrorEvent(w, err)
+	}
+	return WriteEndEvent(w)
+}
+
+// StreamRequest decodes the request of a server-side stream from the body
+// of r, which clients send with methods other than GET: a single message,
+// or the first frame of a full-duplex stream. It returns nil without body
+// or request type, or for raw bodies. The body is not closed, for the same reason as in
+// ServeDuplex, and may decompress to at most maxSize bytes.
+func StreamRequest(r *nethttp.Request, ep *talk.Endpoint, fallback codec.Codec, maxSize int) (any, error) {
+	if ep.RequestType == nil || talk.IsRawBody(ep.RequestType) || r.Body == nil || r.Body == nethttp.NoBody || r.ContentLength == 0 {
+		return nil, nil
+	}
+	body, err := RequestBody(r, maxSize)
+	if err != nil {
+		return nil, err
+	}
+	v := reflect.New(ep.RequestType)
+	if err := codec.Decode(streamCodec(r.Header.Get("Content-Type"), fallback), body, v.Interface()); err == io.EOF {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return v.Elem().Interface(), nil
+}
+
+// SSEReopenFunc reopens an SSE stream that was cut, sending lastEventID as
+// the Last-Event-ID header if it is not empty.
+type SSEReopenFunc func(ctx context.Context, lastEventID string) (*nethttp.Response, error)
+
+// NewSSEStream returns a receive-only stream reading the server-sent events
+// of resp, their data decoded with c.
+//
+// The stream ends with io.EOF at the end event. If reopen is not nil, a
+// stream cut before it, by a network error, an Unavailable error event or,
+// once an event ID was received, a connection closed early, is reopened
+// with the ID of the last event received. Reconnections wait reconnect's
+// backoff, or the server's retry field if longer, and retry while the
+// errors are retryable. The backoff carries over consecutive reconnections
+// and is only reset by a message; after MaxRetries of them without one the
+// stream fails.
+func NewSSEStream(ctx context.Context, resp *nethttp.Response, c codec.Codec, reopen SSEReopenFunc, reconnect talk.RetryConfig) talk.Stream {
+	return &sseClientStream{
+		ctx:       ctx,
+		resp:      resp,
+		reader:    bufio.NewReader(resp.Body),
+		codec:     c,
+		reopen:    reopen,
+		reconnect: reconnect,
+	}
+}
+
+type sseClientStream struct {
+	ctx       context.Context
+	resp      *nethttp.Response
+	reader    *bufio.Reader
+	codec     codec.Codec
+	reopen    SSEReopenFunc
+	reconnect talk.RetryConfig
+	closed    bool
+
+	// lastEventID and retry persist across events and reconnections.
+	lastEventID string
+	retry       time.Duration
+
+	// backoff and attempts pace the reconnections since the last message.
+	backoff  x.RetryBackoff
+	attempts uint64
+}
+
+func (s *sseClientStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *sseClientStream) Send(msg any) error {
+	return talk.NewError(talk.Unimplemented, "SSE client stream is receive-only")
+}
+
+// Recv receives the data of the next event into msg. An *talk.Event also
+// receives the event fields. Recv returns io.EOF once the server has ended
+// the stream, and the error of an "error" event as a *talk.Error.
+func (s *sseClientStream) Recv(msg any) error {
+	for {
+		if s.closed {
+			return io.EOF
+		}
+
+		ev, data, err := s.next()
+		if err == nil {
+			switch ev.Type {
+			case EndEventType:
+				s.closed = true
+				return io.EOF
+			case "error":
+				err = eventError(data)
+				if talk.ToError(err).Code != talk.Unavailable {
+					return err
+				}
+			default:
+				s.attempts = 0
+				if s.backoff != nil {
+					s.backoff.Reset()
+				}
+				return s.decode(ev, data, msg)
+			}
+		}
+
+		if ctxErr := s.ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		// Without an event ID, a clean EOF is more likely a server that
+		// does not send the end event than a cut stream: resuming it
+		// would replay it from the start.
+		if s.reopen == nil || (err == io.EOF && s.lastEventID == "") {
+			if err == io.EOF {
+				s.closed = true
+			}
+			return err
+		}
+		if err := s.resume(err); err != nil {
+			return err
+		}
+	}
+}
+
+// next reads the next event with data, applying the id and retry fields
+// of all events on the way.
+func (s *sseClientStream) next() (talk.Event, []byte, error) {
+	var (
+		ev      talk.Event
+		data    bytes.Buffer
+		hasData bool
+	)
+	for {
+		line, err := s.reader.ReadString('\n')
+		if err != nil {
+			if err == io.EOF && (line != "" || hasData) {
+				err = io.ErrUnexpectedEOF
+			}
+			return ev, nil, err
+		}
+		line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+
+		if line == "" {
+			if !hasData {
+				ev = talk.Event{}
+				continue
+			}
+			ev.ID = s.lastEventID
+			return ev, data.Bytes(), nil
+		}
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "id":
+			if !strings.ContainsRune(value, 0) {
+				s.lastEventID = value
+			}
+		case "event":
+			ev.Type = value
+		case "retry":
+			if ms, err := strconv.ParseInt(value, 10, 64); err == nil && ms >= 0 {
+				s.retry = time.Duration(ms) * time.Millisecond
+				ev.Retry = s.retry
+			}
+		case "data":
+			if hasData {
+				data.WriteByte('\n')
+			}
+			data.WriteString(value)
+			hasData = true
+		}
+	}
+}
+
+func (s *sseClientStream) decode(ev talk.Event, data []byte, msg any) error {
+	e, ok := msg.(*talk.Event)
+	if !ok {
+		return s.codec.Unmarshal(data, msg)
+	}
+
+	dst := e.Data
+	*e = ev
+	if dst != nil {
+		e.Data = dst
+		return s.codec.Unmarshal(data, dst)
+	}
+	var v any
+	if err := s.codec.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	e.Data = v
+	return nil
+}
+
+// eventError returns the error carried by an "error" event. Servers that do
+// not send a JSON *talk.Error get an Internal error with the raw text.
+func eventError(data []byte) error {
+	var talkErr talk.Error
+	if err := json.Unmarshal(data, &talkErr); err == nil && talkErr.Code != talk.OK {
+		return &talkErr
+	}
+	return talk.NewError(talk.Internal, string(data))
+}
+
+// resume reopens the stream after the last event received, cut with
+// cause. It fails with the last error once the attempts are exhausted.
+func (s *sseClientStream) resume(cause error) error {
+	s.resp.Body.Close()
+	if cause == io.EOF || cause == io.ErrUnexpectedEOF {
+		cause = talk.NewError(talk.Unavailable, "SSE stream closed before its end event")
+	}
+	if s.backoff == nil {
+		s.backoff = s.reconnect.RetryBackoff()
+	}
+	maxAttempts := s.reconnect.MaxRetries
+	if maxAttempts == 0 {
+		maxAttempts = talk.DefaultRetryMaxRetries
+	}
+
+	for {
+		delay, ok := s.backoff.Next()
+		if s.attempts++; !ok || s.attempts > maxAttempts {
+			return cause
+		}
+		timer := time.NewTimer(max(delay, s.retry))
+		select {
+		case <-timer.C:
+		case <-s.ctx.Done():
+			timer.Stop()
+			return s.ctx.Err()
+		}
+
+		resp, err := s.reopen(s.ctx, s.lastEventID)
+		if err == nil && resp.StatusCode >= 400 {
+			err = ResponseError(resp)
+		}
+		if err == nil {
+			s.resp = resp
+			s.reader.Reset(resp.Body)
+			return nil
+		}
+		if !s.reconnect.IsRetryable(err) {
+			return err
+		}
+		cause = err
+	}
+}
+
+func (s *sseClientStream) Close() error {
+	s.closed = true
+	return s.resp.Body.Close()
+}
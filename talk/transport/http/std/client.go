@@ -1,7 +1,6 @@
 package std
 
 import (
-	"bufio"
 	"bytes"
 	"context"
 	"io"
@@ -20,7 +19,9 @@ import (
 type Client struct {
 	config     thttp.ClientConfig
 	codec      codec.Codec
+	compressor codec.Compressor
 	httpClient *http.Client
+	streams    *thttp.StreamOpener
 	baseURL    string
 	pathPrefix string
 }
@@ -41,11 +42,23 @@ func NewClient(cfg x.TypedLazyConfig, opts ...thttp.Option) (*Client, error) {
 		c.codec = codec.MustGet("json")
 	}
 
+	comp, err := c.config.Compressor()
+	if err != nil {
+		return nil, err
+	}
+	c.compressor = comp
+
 	c.baseURL = strings.TrimSuffix(c.config.Addr, "/")
 	c.httpClient = &http.Client{
 		Timeout: time.Duration(c.config.Timeout),
 	}
 
+	c.streams = &thttp.StreamOpener{
+		Client:           c.httpClient,
+		Reconnect:        c.config.Reconnect,
+		DisableReconnect: c.config.DisableReconnect,
+	}
+
 	return c, nil
 }
 
@@ -91,15 +104,28 @@ func (c *Client) Invoke(ctx context.Context, endpoint string, req any, resp any)
 	url := c.baseURL + path
 
 	var body io.Reader
+	contentType := c.codec.ContentType()
+	contentEncoding := ""
 	method := httpMethod
 
-	// For methods with bodies (POST, PUT, PATCH), encode the request
+	// For methods with bodies (POST, PUT, PATCH), encode the request.
+	// An io.Reader is sent as is.
 	if req != nil && (method == http.MethodPost || method == http.MethodPut || method == http.MethodPatch) {
-		data, err := c.codec.Marshal(req)
-		if err != nil {
-			return talk.NewError(talk.InvalidArgument, "failed to encode request")
+		if raw, ok := req.(io.Reader); ok {
+			body, contentType = raw, talk.RawContentType
+		} else {
+			data, err := c.codec.Marshal(req)
+			if err != nil {
+				return talk.NewError(talk.InvalidArgument, "failed to encode request")
+			}
+			if c.compressor != nil && len(data) >= c.config.MinSize() {
+				if data, err = codec.Compress(c.compressor, data); err != nil {
+					return talk.NewError(talk.Internal, "failed to compress request")
+				}
+				contentEncoding = c.compressor.Name()
+			}
+			body = bytes.NewReader(data)
 		}
-		body = bytes.NewReader(data)
 	}
 
 	// For GET/DELETE with a simple ID request, append to path
@@ -114,30 +140,62 @@ func (c *Client) Invoke(ctx context.Context, endpoint string, req any, resp any)
 		return talk.NewError(talk.Internal, err.Error())
 	}
 
-	httpReq.Header.Set("Content-Type", c.codec.ContentType())
+	if md, ok := talk.FromOutgoingContext(ctx); ok {
+		md.SetHeader(httpReq.Header)
+	}
+	httpReq.Header.Set("Content-Type", contentType)
 	httpReq.Header.Set("Accept", c.codec.ContentType())
+	if contentEncoding != "" {
+		httpReq.Header.Set("Content-Encoding", contentEncoding)
+	}
+	if c.compressor != nil {
+		httpReq.Header.Set("Accept-Encoding", c.compressor.Name())
+	}
 
 	httpResp, err := c.httpClient.Do(httpReq)
 	if err != nil {
 		return talk.NewError(talk.Unavailable, err.Error())
 	}
-	defer httpResp.Body.Close()
 
-	respBody, err := io.ReadAll(httpResp.Body)
+	respBody, err := thttp.ResponseBody(httpResp)
 	if err != nil {
-		return talk.NewError(talk.Internal, "failed to read response")
+		httpResp.Body.Close()
+		return talk.NewError(talk.Internal, err.Error())
 	}
 
+	// Decode with the codec the server answered with: errors are always
+	// JSON, and servers may not support the client's codec.
+	respCodec := codec.FromContentType(httpResp.Header.Get("Content-Type"), c.codec)
+
 	if httpResp.StatusCode >= 400 {
+		defer respBody.Close()
+		data, err := io.ReadAll(respBody)
+		if err != nil {
+			return talk.NewError(talk.Internal, "failed to read response")
+		}
 		var talkErr talk.Error
-		if err := c.codec.Unmarshal(respBody, &talkErr); err == nil && talkErr.Code != talk.OK {
+		if err := respCodec.Unmarshal(data, &talkErr); err == nil && talkErr.Code != talk.OK {
 			return &talkErr
 		}
-		return talk.NewError(talk.FromHTTPStatus(httpResp.StatusCode), string(respBody))
+		return talk.NewError(talk.FromHTTPStatus(httpResp.StatusCode), string(data))
+	}
+
+	// A *io.ReadCloser destination takes over the raw body; the caller
+	// must close it.
+	if dst, ok := resp.(*io.ReadCloser); ok {
+		*dst = respBody
+		return nil
 	}
+	defer respBody.Close()
 
-	if resp != nil && len(respBody) > 0 {
-		if err := c.codec.Unmarshal(respBody, resp); err != nil {
+	switch dst := resp.(type) {
+	case nil:
+	case io.Writer:
+		if _, err := io.Copy(dst, respBody); err != nil {
+			return talk.NewError(talk.Internal, "failed to read response")
+		}
+	default:
+		if err := codec.Decode(respCodec, respBody, resp); err != nil && err != io.EOF {
 			return talk.NewError(talk.Internal, "failed to decode response")
 		}
 	}
@@ -145,33 +203,20 @@ func (c *Client) Invoke(ctx context.Context, endpoint string, req any, resp any)
 	return nil
 }
 
+// InvokeStream opens a stream to endpoint. Server-side streams are read
+// over SSE and reopened with Last-Event-ID when a network error cuts them,
+// unless DisableReconnect is set. Client-side and bidirectional streams are
+// opened as a full-duplex exchange, with req sent as its first message if
+// it is not nil; they also implement talk.ClientStream. See
+// thttp.StreamOpener for how the two are told apart.
 func (c *Client) InvokeStream(ctx context.Context, endpoint string, req any) (talk.Stream, error) {
 	url := c.baseURL + "/" + strings.TrimPrefix(endpoint, "/")
 
-	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
-	if err != nil {
-		return nil, talk.NewError(talk.Internal, err.Error())
-	}
-
-	httpReq.Header.Set("Accept", "text/event-stream")
-
-	httpResp, err := c.httpClient.Do(httpReq)
-	if err != nil {
-		return nil, talk.NewError(talk.Unavailable, err.Error())
+	header := http.Header{}
+	if md, ok := talk.FromOutgoingContext(ctx); ok {
+		md.SetHeader(header)
 	}
-
-	if httpResp.StatusCode >= 400 {
-		defer httpResp.Body.Close()
-		body, _ := io.ReadAll(httpResp.Body)
-		return nil, talk.NewError(talk.FromHTTPStatus(httpResp.StatusCode), string(body))
-	}
-
-	return &sseClientStream{
-		ctx:    ctx,
-		resp:   httpResp,
-		reader: bufio.NewReader(httpResp.Body),
-		codec:  c.codec,
-	}, nil
+	return c.streams.Open(ctx, url, header, req, c.codec)
 }
 
 func (c *Client) Close() error {
@@ -179,70 +224,6 @@ func (c *Client) Close() error {
 	return nil
 }
 
-type sseClientStream struct {
-	ctx    context.Context
-	resp   *http.Response
-	reader *bufio.Reader
-	codec  codec.Codec
-	closed bool
-}
-
-func (s *sseClientStream) Context() context.Context {
-	return s.ctx
-}
-
-func (s *sseClientStream) Send(msg any) error {
-	return talk.NewError(talk.Unimplemented, "SSE client stream is receive-only")
-}
-
-func (s *sseClientStream) Recv(msg any) error {
-	if s.closed {
-		return io.EOF
-	}
-
-	for {
-		select {
-		case <-s.ctx.Done():
-			return s.ctx.Err()
-		default:
-		}
-
-		line, err := s.reader.ReadString('\n')
-		if err != nil {
-			if err == io.EOF {
-				s.closed = true
-			}
-			return err
-		}
-
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
-		}
-
-		if strings.HasPrefix(line, "data: ") {
-			data := strings.TrimPrefix(line, "data: ")
-			if err := s.codec.Unmarshal([]byte(data), msg); err != nil {
-				return err
-			}
-			return nil
-		}
-
-		if strings.HasPrefix(line, "event: error") {
-			nextLine, _ := s.reader.ReadString('\n')
-			if strings.HasPrefix(nextLine, "data: ") {
-				errMsg := strings.TrimPrefix(strings.TrimSpace(nextLine), "data: ")
-				return talk.NewError(talk.Internal, errMsg)
-			}
-		}
-	}
-}
-
-func (s *sseClientStream) Close() error {
-	s.closed = true
-	return s.resp.Body.Close()
-}
-
 // deriveClientPath converts a Go method name to an HTTP method + RESTful path,
 // matching the server-side deriveMethodAndPath logic.
 func deriveClientPath(name string) (httpMethod, path string) {
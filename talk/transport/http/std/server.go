@@ -3,7 +3,6 @@ package std
 
 import (
 	"context"
-	"fmt"
 	"io"
 	"net/http"
 	"reflect"
@@ -22,6 +21,7 @@ import (
 type Server struct {
 	config         thttp.ServerConfig
 	codec          codec.Codec
+	compressor     codec.Compressor
 	server         *http.Server
 	mux            *http.ServeMux
 	endpoints      []*talk.Endpoint
@@ -45,6 +45,12 @@ func NewServer(cfg x.TypedLazyConfig, opts ...thttp.Option) (*Server, error) {
 		s.codec = codec.MustGet("json")
 	}
 
+	comp, err := s.config.Compressor()
+	if err != nil {
+		return nil, err
+	}
+	s.compressor = comp
+
 	if s.mux == nil {
 		s.mux = http.NewServeMux()
 	}
@@ -194,29 +200,38 @@ func (s *Server) createHandler(ep *talk.Endpoint) http.HandlerFunc {
 	if ep.IsStreaming() && ep.StreamMode == talk.StreamServerSide {
 		return s.createSSEHandler(ep)
 	}
+	if thttp.IsDuplex(ep) {
+		return s.createDuplexHandler(ep)
+	}
 	return s.createJSONHandler(ep)
 }
 
 func (s *Server) createJSONHandler(ep *talk.Endpoint) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		ctx := r.Context()
+		ctx := talk.NewIncomingContext(r.Context(), talk.MetadataFromHeader(r.Header))
+		ctx = talk.NewPeerContext(ctx, r.RemoteAddr)
 
 		var req any
 
-		// Parse body if present
-		if ep.RequestType != nil && r.ContentLength > 0 {
-			body, err := io.ReadAll(r.Body)
-			if err != nil {
-				s.writeError(w, talk.NewError(talk.InvalidArgument, "failed to read body"))
-				return
-			}
+		body, err := thttp.RequestBody(r, s.config.MaxSize())
+		if err != nil {
+			s.writeError(w, talk.NewError(talk.InvalidArgument, err.Error()))
+			return
+		}
+		defer body.Close()
 
+		// Parse body if present
+		if talk.IsRawBody(ep.RequestType) {
+			req = body
+		} else if ep.RequestType != nil && r.ContentLength != 0 {
 			reqVal := reflect.New(ep.RequestType).Interface()
-			if err := s.codec.Unmarshal(body, reqVal); err != nil {
-				s.writeError(w, talk.NewError(talk.InvalidArgument, "failed to decode request"))
+			reqCodec := codec.FromContentType(r.Header.Get("Content-Type"), s.codec)
+			if err := codec.Decode(reqCodec, body, reqVal); err == nil {
+				req = reflect.ValueOf(reqVal).Elem().Interface()
+			} else if err != io.EOF {
+				s.writeError(w, thttp.DecodeError(err))
 				return
 			}
-			req = reflect.ValueOf(reqVal).Elem().Interface()
 		}
 
 		// Ensure request struct is instantiated for struct types even without body
@@ -237,13 +252,16 @@ func (s *Server) createJSONHandler(ep *talk.Endpoint) http.HandlerFunc {
 			return
 		}
 
-		s.writeJSON(w, http.StatusOK, resp)
+		comp := thttp.ResponseCompressor(w.Header(), r, s.compressor)
+		s.writeResponse(w, codec.Negotiate(r.Header.Get("Accept"), s.codec), comp, http.StatusOK, resp)
 	}
 }
 
 func (s *Server) createSSEHandler(ep *talk.Endpoint) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		ctx := r.Context()
+		ctx := talk.NewIncomingContext(r.Context(), talk.MetadataFromHeader(r.Header))
+		ctx = talk.NewPeerContext(ctx, r.RemoteAddr)
+		ctx = talk.WithEndpointContext(ctx, ep)
 
 		flusher, ok := w.(http.Flusher)
 		if !ok {
@@ -251,6 +269,15 @@ func (s *Server) createSSEHandler(ep *talk.Endpoint) http.HandlerFunc {
 			return
 		}
 
+		req, err := thttp.StreamRequest(r, ep, s.codec, s.config.MaxSize())
+		if err != nil {
+			s.writeError(w, thttp.DecodeError(err))
+			return
+		}
+
+		// A full-duplex client falls back to SSE with its request body
+		// still open; HTTP/1.x servers would otherwise wait to drain it.
+		http.NewResponseController(w).EnableFullDuplex()
 		w.Header().Set("Content-Type", "text/event-stream")
 		w.Header().Set("Cache-Control", "no-cache")
 		w.Header().Set("Connection", "keep-alive")
@@ -258,8 +285,7 @@ func (s *Server) createSSEHandler(ep *talk.Endpoint) http.HandlerFunc {
 		w.WriteHeader(http.StatusOK)
 		flusher.Flush()
 
-		var req any
-		if ep.RequestType != nil && ep.RequestType.Kind() == reflect.Struct {
+		if req == nil && ep.RequestType != nil && ep.RequestType.Kind() == reflect.Struct {
 			req = reflect.New(ep.RequestType).Elem().Interface()
 		}
 		req = s.extractParams(r, ep, req)
@@ -272,11 +298,30 @@ func (s *Server) createSSEHandler(ep *talk.Endpoint) http.HandlerFunc {
 		}
 
 		if ep.StreamHandler != nil {
-			if err := ep.StreamHandler(ctx, req, stream); err != nil {
-				fmt.Fprintf(w, "event: error\ndata: %s\n\n", err.Error())
-				flusher.Flush()
-			}
+			err = ep.WrappedStreamHandler()(ctx, req, stream)
+		} else {
+			err = talk.NewError(talk.Unimplemented, "no stream handler configured")
 		}
+		thttp.WriteStreamEnd(w, err)
+		flusher.Flush()
+	}
+}
+
+// createDuplexHandler serves client-side and bidirectional streams as a
+// full-duplex exchange of codec frames.
+func (s *Server) createDuplexHandler(ep *talk.Endpoint) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := talk.NewIncomingContext(r.Context(), talk.MetadataFromHeader(r.Header))
+		ctx = talk.NewPeerContext(ctx, r.RemoteAddr)
+		ctx = talk.WithEndpointContext(ctx, ep)
+
+		var req any
+		if ep.RequestType != nil && ep.RequestType.Kind() == reflect.Struct {
+			req = reflect.New(ep.RequestType).Elem().Interface()
+		}
+		req = s.extractParams(r, ep, req)
+
+		thttp.ServeDuplex(ctx, w, r, ep, req, s.codec, s.config.MaxSize())
 	}
 }
 
@@ -289,6 +334,9 @@ var pathParamRegex = regexp.MustCompile(`\{(\w+)\}`)
 func (s *Server) extractParams(r *http.Request, ep *talk.Endpoint, req any) any {
 	// For simple types (string, int, etc.), maintain backward compatibility:
 	// extract {id} as a raw value.
+	if talk.IsRawBody(ep.RequestType) {
+		return req
+	}
 	if ep.RequestType == nil || isSimpleType(ep.RequestType) {
 		if strings.Contains(ep.Path, "{id}") {
 			id := r.PathValue("id")
@@ -370,19 +418,47 @@ func (s *Server) extractParams(r *http.Request, ep *talk.Endpoint, req any) any
 	return req
 }
 
-func (s *Server) writeJSON(w http.ResponseWriter, status int, data any) {
-	w.Header().Set("Content-Type", s.codec.ContentType())
-	w.WriteHeader(status)
+// writeResponse writes data encoded with c, the codec negotiated from the
+// request's Accept header, or copies it as is if it is an io.Reader. Other
+// responses are encoded before anything is written so that encoding errors
+// can still be reported. If comp is not nil, raw responses are compressed
+// with it, and encoded ones when they reach the configured minimum size.
+func (s *Server) writeResponse(w http.ResponseWriter, c codec.Codec, comp codec.Compressor, status int, data any) {
+	if raw, ok := data.(io.Reader); ok {
+		if closer, ok := raw.(io.Closer); ok {
+			defer closer.Close()
+		}
+		w.Header().Set("Content-Type", talk.RawContentType)
+		if comp != nil {
+			w.Header().Set("Content-Encoding", comp.Name())
+		}
+		w.WriteHeader(status)
+		thttp.CopyBody(w, comp, raw)
+		return
+	}
+
+	var body []byte
 	if data != nil {
-		body, _ := s.codec.Marshal(data)
-		w.Write(body)
+		var err error
+		if body, err = c.Marshal(data); err != nil {
+			s.writeError(w, talk.NewErrorf(talk.Internal, "failed to encode response as %s", c.Name()))
+			return
+		}
 	}
+	w.Header().Set("Content-Type", c.ContentType())
+	body = thttp.CompressBody(w.Header(), comp, s.config.MinSize(), body)
+	w.WriteHeader(status)
+	w.Write(body)
 }
 
+// writeError writes err as JSON whatever codec was negotiated, so that
+// clients always get its typed details back.
 func (s *Server) writeError(w http.ResponseWriter, err *talk.Error) {
-	w.Header().Set("Content-Type", s.codec.ContentType())
+	c := codec.MustGet("json")
+	w.Header().Set("Content-Type", c.ContentType())
+	thttp.SetErrorHeaders(w.Header(), err)
 	w.WriteHeader(err.HTTPStatus())
-	body, _ := s.codec.Marshal(err)
+	body, _ := c.Marshal(err)
 	w.Write(body)
 }
 
@@ -403,12 +479,9 @@ func (s *sseServerStream) Send(msg any) error {
 		return io.ErrClosedPipe
 	}
 
-	data, err := s.codec.Marshal(msg)
-	if err != nil {
+	if err := thttp.WriteEvent(s.w, s.codec, msg); err != nil {
 		return err
 	}
-
-	fmt.Fprintf(s.w, "data: %s\n\n", data)
 	s.flusher.Flush()
 	return nil
 }
@@ -1,19 +1,24 @@
 package std
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"go.zoe.im/x"
 	"go.zoe.im/x/talk"
 	"go.zoe.im/x/talk/codec"
+	thttp "go.zoe.im/x/talk/transport/http"
 )
 
 type testResponse struct {
@@ -268,6 +273,158 @@ func TestClient_InvokeStream(t *testing.T) {
 	}
 }
 
+func TestClient_InvokeStreamWithoutEndEvent(t *testing.T) {
+	// Servers without the end event nor event IDs are not reconnected to
+	// when they close the stream.
+	var requests atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"message\": \"event1\"}\n\n")
+	}))
+	defer ts.Close()
+
+	client, err := NewClient(x.TypedLazyConfig{Config: json.RawMessage(fmt.Sprintf(`{"addr": %q}`, ts.URL))})
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	defer client.Close()
+
+	stream, err := client.InvokeStream(context.Background(), "/stream", nil)
+	if err != nil {
+		t.Fatalf("InvokeStream failed: %v", err)
+	}
+	defer stream.Close()
+
+	var msg testResponse
+	if err := stream.Recv(&msg); err != nil || msg.Message != "event1" {
+		t.Fatalf("Recv = %+v, %v, want event1", msg, err)
+	}
+	if err := stream.Recv(&msg); err != io.EOF {
+		t.Errorf("Recv at the end = %v, want io.EOF", err)
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("requests = %d, want 1", n)
+	}
+}
+
+func TestClient_InvokeStreamResumeLimit(t *testing.T) {
+	// A server that keeps sending clients away is retried MaxRetries times
+	// with backoff, then the stream fails.
+	var requests atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Content-Type", "text/event-stream")
+		thttp.WriteErrorEvent(w, talk.NewError(talk.Unavailable, "draining"))
+	}))
+	defer ts.Close()
+
+	cfg := fmt.Sprintf(`{"addr": %q, "reconnect": {"initial": "20ms", "max_retries": 2}}`, ts.URL)
+	client, err := NewClient(x.TypedLazyConfig{Config: json.RawMessage(cfg)})
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	defer client.Close()
+
+	stream, err := client.InvokeStream(context.Background(), "/stream", nil)
+	if err != nil {
+		t.Fatalf("InvokeStream failed: %v", err)
+	}
+	defer stream.Close()
+
+	start := time.Now()
+	var msg testResponse
+	err = stream.Recv(&msg)
+	if te, ok := talk.IsError(err); !ok || te.Code != talk.Unavailable {
+		t.Errorf("Recv = %v, want Unavailable", err)
+	}
+	if n := requests.Load(); n != 3 {
+		t.Errorf("requests = %d, want 3", n)
+	}
+	if elapsed := time.Since(start); elapsed < 60*time.Millisecond {
+		t.Errorf("reconnecting took %v, want the backoff waited", elapsed)
+	}
+}
+
+func TestClient_InvokeStreamResume(t *testing.T) {
+	jsonCodec := codec.MustGet("json")
+	var lastEventIDs []string
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lastEventIDs = append(lastEventIDs, r.Header.Get("Last-Event-ID"))
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+
+		switch len(lastEventIDs) {
+		case 1:
+			// Cut the connection after the first event.
+			thttp.WriteEvent(w, jsonCodec, talk.Event{ID: "1", Retry: 10 * time.Millisecond, Data: testResponse{Message: "event1"}})
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		case 2:
+			// Send the client away while the server drains.
+			thttp.WriteErrorEvent(w, talk.NewError(talk.Unavailable, "draining"))
+		case 3:
+			// Close the connection cleanly, but without the end event.
+			thttp.WriteEvent(w, jsonCodec, talk.Event{ID: "2", Type: "update", Data: testResponse{Message: "event2"}})
+		default:
+			thttp.WriteEvent(w, jsonCodec, testResponse{Message: "event3"})
+			thttp.WriteErrorEvent(w, talk.NewError(talk.NotFound, "gone"))
+		}
+	}))
+	defer ts.Close()
+
+	cfg := x.TypedLazyConfig{
+		Config: json.RawMessage(fmt.Sprintf(`{"addr": %q, "reconnect": {"initial": "10ms"}}`, ts.URL)),
+	}
+	client, err := NewClient(cfg)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	defer client.Close()
+
+	stream, err := client.InvokeStream(context.Background(), "/feed", nil)
+	if err != nil {
+		t.Fatalf("InvokeStream failed: %v", err)
+	}
+	defer stream.Close()
+
+	var ev talk.Event
+	ev.Data = &testResponse{}
+	if err := stream.Recv(&ev); err != nil {
+		t.Fatalf("Recv[0] failed: %v", err)
+	}
+	if ev.ID != "1" || ev.Retry != 10*time.Millisecond || ev.Data.(*testResponse).Message != "event1" {
+		t.Errorf("Recv[0] = %+v, want id 1, retry 10ms and event1", ev)
+	}
+
+	ev = talk.Event{Data: &testResponse{}}
+	if err := stream.Recv(&ev); err != nil {
+		t.Fatalf("Recv[1] failed: %v", err)
+	}
+	if ev.ID != "2" || ev.Type != "update" || ev.Data.(*testResponse).Message != "event2" {
+		t.Errorf("Recv[1] = %+v, want id 2, type update and event2", ev)
+	}
+
+	var msg testResponse
+	if err := stream.Recv(&msg); err != nil {
+		t.Fatalf("Recv[2] failed: %v", err)
+	}
+	if msg.Message != "event3" {
+		t.Errorf("Recv[2].Message = %q, want event3", msg.Message)
+	}
+
+	err = stream.Recv(&msg)
+	var talkErr *talk.Error
+	if !errors.As(err, &talkErr) || talkErr.Code != talk.NotFound {
+		t.Errorf("Recv[3] error = %v, want NotFound", err)
+	}
+
+	if want := []string{"", "1", "1", "2"}; !reflect.DeepEqual(lastEventIDs, want) {
+		t.Errorf("Last-Event-ID headers = %q, want %q", lastEventIDs, want)
+	}
+}
+
 func TestCodecIntegration(t *testing.T) {
 	c := codec.MustGet("json")
 	if c.Name() != "json" {
@@ -1375,3 +1532,656 @@ func TestServer_SSEStreamingWithPathParams(t *testing.T) {
 		t.Errorf("roomId = %q, want %q", capturedRoomID, "room-42")
 	}
 }
+
+func TestServer_SSEStreamingWithBody(t *testing.T) {
+	cfg := x.TypedLazyConfig{Config: json.RawMessage(`{"addr": ":0"}`)}
+	server, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+
+	type searchRequest struct {
+		Room  string `json:"room" path:"room"`
+		Query string `json:"query"`
+	}
+	ep := &talk.Endpoint{
+		Name:        "Search",
+		Path:        "/rooms/{room}/search",
+		Method:      "POST",
+		RequestType: reflect.TypeOf(searchRequest{}),
+		StreamMode:  talk.StreamServerSide,
+		StreamHandler: func(ctx context.Context, req any, stream talk.Stream) error {
+			r := req.(searchRequest)
+			return stream.Send(r.Room + ":" + r.Query)
+		},
+	}
+	server.registerEndpoint(ep)
+
+	ts := httptest.NewServer(server.mux)
+	defer ts.Close()
+
+	resp, err := http.Post(ts.URL+"/rooms/r1/search", "application/json", strings.NewReader(`{"query": "hello"}`))
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if !strings.Contains(string(body), `data: "r1:hello"`) {
+		t.Errorf("body = %q, want the request decoded from the body", body)
+	}
+
+	resp, err = http.Post(ts.URL+"/rooms/r1/search", "application/json", strings.NewReader(`{`))
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d for a malformed body", resp.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestClient_MetadataPropagation(t *testing.T) {
+	cfg := x.TypedLazyConfig{Config: json.RawMessage(`{"addr": ":0"}`)}
+	server, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+
+	ep := &talk.Endpoint{
+		Name:     "Whoami",
+		Path:     "/whoami",
+		Method:   "POST",
+		Metadata: map[string]any{"auth": "token"},
+		Handler: func(ctx context.Context, req any) (any, error) {
+			md, _ := talk.FromIncomingContext(ctx)
+			identity, _ := talk.IdentityFromContext(ctx)
+			return &testResponse{Message: identity, ID: md.Get("x-tenant")}, nil
+		},
+		Middleware: []talk.MiddlewareFunc{
+			talk.AuthMiddleware(func(ctx context.Context, req any) (string, error) {
+				token, ok := talk.BearerTokenFromContext(ctx)
+				if !ok {
+					return "", fmt.Errorf("missing token")
+				}
+				return "user-" + token, nil
+			}),
+		},
+	}
+	server.registerEndpoint(ep)
+
+	ts := httptest.NewServer(server.mux)
+	defer ts.Close()
+
+	client, err := NewClient(x.TypedLazyConfig{Config: json.RawMessage(fmt.Sprintf(`{"addr": %q}`, ts.URL))})
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	defer client.Close()
+
+	ctx := talk.NewOutgoingContext(context.Background(), talk.MetadataPairs(
+		"authorization", "Bearer 42",
+		"x-tenant", "acme",
+	))
+
+	var resp testResponse
+	if err := client.Invoke(ctx, "/whoami", nil, &resp); err != nil {
+		t.Fatalf("Invoke failed: %v", err)
+	}
+	if resp.Message != "user-42" || resp.ID != "acme" {
+		t.Errorf("resp = %+v, want identity user-42 and tenant acme", resp)
+	}
+
+	err = client.Invoke(context.Background(), "/whoami", nil, &resp)
+	if talkErr := talk.ToError(err); talkErr.Code != talk.Unauthenticated {
+		t.Errorf("error = %v, want Unauthenticated", err)
+	}
+}
+
+func TestServer_RateLimitRetryAfter(t *testing.T) {
+	server, err := NewServer(x.TypedLazyConfig{Config: json.RawMessage(`{"addr": ":0"}`)})
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+
+	server.registerEndpoint(&talk.Endpoint{
+		Name:       "Ping",
+		Path:       "/ping",
+		Method:     "GET",
+		Metadata:   map[string]any{"ratelimit": "1/m"},
+		Middleware: []talk.MiddlewareFunc{talk.RateLimitMiddleware()},
+		Handler: func(ctx context.Context, req any) (any, error) {
+			return &testResponse{Message: "pong"}, nil
+		},
+	})
+
+	ts := httptest.NewServer(server.mux)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/ping")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("first status = %d, want 200", resp.StatusCode)
+	}
+
+	resp, err = http.Get(ts.URL + "/ping")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("status = %d, want 429", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Retry-After"); got != "60" {
+		t.Errorf("Retry-After = %q, want 60", got)
+	}
+}
+
+func TestClient_ErrorDetails(t *testing.T) {
+	cfg := x.TypedLazyConfig{Config: json.RawMessage(`{"addr": ":0"}`)}
+	server, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+
+	server.registerEndpoint(&talk.Endpoint{
+		Name:   "CreateUser",
+		Path:   "/users",
+		Method: "POST",
+		Handler: func(ctx context.Context, req any) (any, error) {
+			return nil, talk.NewErrorWithTypedDetails(talk.ResourceExhausted, "quota exceeded",
+				talk.QuotaFailure{Violations: []talk.QuotaViolation{{Subject: "tenant:acme", Description: "10 users max"}}})
+		},
+	})
+
+	ts := httptest.NewServer(server.mux)
+	defer ts.Close()
+
+	client, err := NewClient(x.TypedLazyConfig{Config: json.RawMessage(fmt.Sprintf(`{"addr": %q}`, ts.URL))})
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	defer client.Close()
+
+	err = client.Invoke(context.Background(), "/users", nil, nil)
+	var qf talk.QuotaFailure
+	if !errors.As(err, &qf) {
+		t.Fatalf("err = %v, want QuotaFailure details", err)
+	}
+	if len(qf.Violations) != 1 || qf.Violations[0].Subject != "tenant:acme" {
+		t.Errorf("QuotaFailure = %+v", qf)
+	}
+}
+
+func TestServer_ContentNegotiation(t *testing.T) {
+	cfg := x.TypedLazyConfig{Config: json.RawMessage(`{"addr": ":0"}`)}
+	server, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+
+	server.registerEndpoint(&talk.Endpoint{
+		Name:        "Echo",
+		Path:        "/echo",
+		Method:      "POST",
+		RequestType: reflect.TypeOf(testResponse{}),
+		Handler: func(ctx context.Context, req any) (any, error) {
+			r := req.(testResponse)
+			if r.ID == "" {
+				return nil, talk.NewErrorWithTypedDetails(talk.InvalidArgument, "id is required",
+					talk.BadRequest{FieldViolations: []talk.FieldViolation{{Field: "id", Description: "is required"}}})
+			}
+			return r, nil
+		},
+	})
+
+	ts := httptest.NewServer(server.mux)
+	defer ts.Close()
+
+	// A browser keeps getting JSON.
+	httpResp, err := http.Post(ts.URL+"/echo", "application/json", strings.NewReader(`{"message":"hi","id":"1"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpResp.Body.Close()
+	if ct := httpResp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+
+	httpReq, _ := http.NewRequest("POST", ts.URL+"/echo", strings.NewReader(`{"message":"hi","id":"1"}`))
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Accept", "application/cbor, application/json;q=0.5")
+	if httpResp, err = http.DefaultClient.Do(httpReq); err != nil {
+		t.Fatal(err)
+	}
+	httpResp.Body.Close()
+	if ct := httpResp.Header.Get("Content-Type"); ct != "application/cbor" {
+		t.Errorf("Content-Type = %q, want application/cbor", ct)
+	}
+
+	// A client with a binary codec gets its own encoding back.
+	for _, name := range []string{"msgpack", "cbor"} {
+		client, err := NewClient(x.TypedLazyConfig{Config: json.RawMessage(fmt.Sprintf(`{"addr": %q}`, ts.URL))})
+		if err != nil {
+			t.Fatalf("NewClient failed: %v", err)
+		}
+		client.SetCodec(codec.MustGet(name))
+
+		var resp testResponse
+		if err := client.Invoke(context.Background(), "/echo", &testResponse{Message: "hi", ID: "1"}, &resp); err != nil {
+			t.Fatalf("%s: Invoke failed: %v", name, err)
+		}
+		if resp.Message != "hi" || resp.ID != "1" {
+			t.Errorf("%s: resp = %+v", name, resp)
+		}
+
+		// Errors are JSON, so their details survive.
+		err = client.Invoke(context.Background(), "/echo", &testResponse{Message: "hi"}, &resp)
+		var br talk.BadRequest
+		if !errors.As(err, &br) || br.FieldViolations[0].Field != "id" {
+			t.Errorf("%s: err = %v, want BadRequest details", name, err)
+		}
+	}
+}
+
+func TestServer_RawBody(t *testing.T) {
+	server, err := NewServer(x.TypedLazyConfig{Config: json.RawMessage(`{"addr": ":0"}`)})
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+
+	server.registerEndpoint(&talk.Endpoint{
+		Name:        "Upload",
+		Path:        "/upload",
+		Method:      "POST",
+		RequestType: reflect.TypeOf((*io.Reader)(nil)).Elem(),
+		Handler: func(ctx context.Context, req any) (any, error) {
+			n, err := io.Copy(io.Discard, req.(io.Reader))
+			return map[string]int64{"size": n}, err
+		},
+	})
+	server.registerEndpoint(&talk.Endpoint{
+		Name:   "Download",
+		Path:   "/download",
+		Method: "POST",
+		Handler: func(ctx context.Context, req any) (any, error) {
+			return io.NopCloser(strings.NewReader("raw bytes")), nil
+		},
+	})
+
+	ts := httptest.NewServer(server.mux)
+	defer ts.Close()
+
+	client, err := NewClient(x.TypedLazyConfig{Config: json.RawMessage(fmt.Sprintf(`{"addr": %q}`, ts.URL))})
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	defer client.Close()
+
+	// The body is streamed to the handler, not decoded.
+	var size map[string]int64
+	body := io.LimitReader(neverEnding('x'), 8<<20)
+	if err := client.Invoke(context.Background(), "/upload", body, &size); err != nil {
+		t.Fatalf("upload failed: %v", err)
+	}
+	if size["size"] != 8<<20 {
+		t.Errorf("size = %d, want %d", size["size"], 8<<20)
+	}
+
+	var rc io.ReadCloser
+	if err := client.Invoke(context.Background(), "/download", nil, &rc); err != nil {
+		t.Fatalf("download failed: %v", err)
+	}
+	data, _ := io.ReadAll(rc)
+	rc.Close()
+	if string(data) != "raw bytes" {
+		t.Errorf("download = %q", data)
+	}
+
+	var buf strings.Builder
+	if err := client.Invoke(context.Background(), "/download", nil, &buf); err != nil || buf.String() != "raw bytes" {
+		t.Errorf("download into writer = %q, %v", buf.String(), err)
+	}
+}
+
+type neverEnding byte
+
+func (b neverEnding) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = byte(b)
+	}
+	return len(p), nil
+}
+
+func TestServer_Compression(t *testing.T) {
+	cfg := x.TypedLazyConfig{Config: json.RawMessage(`{"addr": ":0", "compression": "zstd", "compression_min_size": 64}`)}
+	server, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+	server.registerEndpoint(&talk.Endpoint{
+		Name:        "Echo",
+		Path:        "/echo",
+		Method:      "POST",
+		RequestType: reflect.TypeOf(testResponse{}),
+		Handler: func(ctx context.Context, req any) (any, error) {
+			return req, nil
+		},
+	})
+
+	var reqEncoding string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqEncoding = r.Header.Get("Content-Encoding")
+		server.mux.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	client, err := NewClient(x.TypedLazyConfig{Config: json.RawMessage(fmt.Sprintf(
+		`{"addr": %q, "compression": "zstd", "compression_min_size": 64}`, ts.URL))})
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	for _, tc := range []struct {
+		message  string
+		encoding string
+	}{
+		{"hi", ""},
+		{strings.Repeat("hello ", 100), "zstd"},
+	} {
+		var resp testResponse
+		if err := client.Invoke(context.Background(), "/echo", &testResponse{Message: tc.message}, &resp); err != nil {
+			t.Fatalf("Invoke failed: %v", err)
+		}
+		if resp.Message != tc.message {
+			t.Errorf("resp = %+v", resp)
+		}
+		if reqEncoding != tc.encoding {
+			t.Errorf("request Content-Encoding = %q, want %q", reqEncoding, tc.encoding)
+		}
+	}
+
+	body := fmt.Sprintf(`{"message": %q}`, strings.Repeat("hello ", 100))
+	for accept, want := range map[string]string{"zstd, gzip": "zstd", "gzip": "", "zstd;q=0": ""} {
+		httpReq, _ := http.NewRequest("POST", ts.URL+"/echo", strings.NewReader(body))
+		httpReq.Header.Set("Accept-Encoding", accept)
+		httpResp, err := http.DefaultClient.Do(httpReq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(httpResp.Body)
+		httpResp.Body.Close()
+		if got := httpResp.Header.Get("Content-Encoding"); got != want {
+			t.Errorf("Accept-Encoding %q: Content-Encoding = %q, want %q", accept, got, want)
+		}
+		if want != "" {
+			comp, _ := codec.GetCompressor(want)
+			if data, err = codec.Decompress(comp, data); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if !strings.Contains(string(data), "hello hello") {
+			t.Errorf("Accept-Encoding %q: body = %q", accept, data)
+		}
+	}
+
+	httpReq, _ := http.NewRequest("POST", ts.URL+"/echo", strings.NewReader(body))
+	httpReq.Header.Set("Content-Encoding", "br")
+	httpResp, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusBadRequest {
+		t.Errorf("unknown Content-Encoding: status = %d, want 400", httpResp.StatusCode)
+	}
+}
+
+func TestServer_CompressionMaxSize(t *testing.T) {
+	cfg := x.TypedLazyConfig{Config: json.RawMessage(`{"addr": ":0", "compression_max_size": 256}`)}
+	server, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+	server.registerEndpoint(&talk.Endpoint{
+		Name:        "Echo",
+		Path:        "/echo",
+		Method:      "POST",
+		RequestType: reflect.TypeOf(testResponse{}),
+		Handler: func(ctx context.Context, req any) (any, error) {
+			return req, nil
+		},
+	})
+	ts := httptest.NewServer(server.mux)
+	defer ts.Close()
+
+	gz, _ := codec.GetCompressor("gzip")
+	for size, want := range map[int]int{100: http.StatusOK, 1000: http.StatusTooManyRequests} {
+		body, _ := codec.Compress(gz, []byte(fmt.Sprintf(`{"message": %q}`, strings.Repeat("a", size))))
+		httpReq, _ := http.NewRequest("POST", ts.URL+"/echo", bytes.NewReader(body))
+		httpReq.Header.Set("Content-Encoding", "gzip")
+		httpResp, err := http.DefaultClient.Do(httpReq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		httpResp.Body.Close()
+		if httpResp.StatusCode != want {
+			t.Errorf("%d bytes: status = %d, want %d", size, httpResp.StatusCode, want)
+		}
+	}
+}
+
+func TestServer_DuplexStream(t *testing.T) {
+	server, err := NewServer(x.TypedLazyConfig{Config: json.RawMessage(`{"addr": ":0"}`)})
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+	server.registerEndpoint(&talk.Endpoint{
+		Name:       "Chat",
+		Path:       "/chat",
+		Method:     "POST",
+		StreamMode: talk.StreamBidirect,
+		StreamHandler: func(ctx context.Context, req any, stream talk.Stream) error {
+			for {
+				var msg testResponse
+				if err := stream.Recv(&msg); err == io.EOF {
+					return nil
+				} else if err != nil {
+					return err
+				}
+				if msg.Message == "bye" {
+					return talk.NewError(talk.Aborted, "chat ended")
+				}
+				if err := stream.Send(testResponse{Message: "echo: " + msg.Message}); err != nil {
+					return err
+				}
+			}
+		},
+	})
+	server.registerEndpoint(&talk.Endpoint{
+		Name:       "Sum",
+		Path:       "/sum",
+		Method:     "POST",
+		StreamMode: talk.StreamClientSide,
+		StreamHandler: func(ctx context.Context, req any, stream talk.Stream) error {
+			total := 0
+			for {
+				var n int
+				if err := stream.Recv(&n); err == io.EOF {
+					return stream.Send(total)
+				} else if err != nil {
+					return err
+				}
+				total += n
+			}
+		},
+	})
+	server.registerEndpoint(&talk.Endpoint{
+		Name:       "Ticks",
+		Path:       "/ticks",
+		Method:     "POST",
+		StreamMode: talk.StreamServerSide,
+		StreamHandler: func(ctx context.Context, req any, stream talk.Stream) error {
+			return stream.Send(testResponse{Message: "tick"})
+		},
+	})
+
+	ts := httptest.NewServer(server.mux)
+	defer ts.Close()
+	client, err := NewClient(x.TypedLazyConfig{Config: json.RawMessage(fmt.Sprintf(`{"addr": %q}`, ts.URL))})
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	defer client.Close()
+
+	// Messages flow both ways before either side closes.
+	stream, err := client.InvokeStream(context.Background(), "/chat", nil)
+	if err != nil {
+		t.Fatalf("InvokeStream failed: %v", err)
+	}
+	for _, msg := range []string{"hi", "there"} {
+		if err := stream.Send(testResponse{Message: msg}); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+		var resp testResponse
+		if err := stream.Recv(&resp); err != nil || resp.Message != "echo: "+msg {
+			t.Fatalf("Recv = %+v, %v", resp, err)
+		}
+	}
+	stream.Send(testResponse{Message: "bye"})
+	var resp testResponse
+	if err := stream.Recv(&resp); talk.ToError(err).Code != talk.Aborted {
+		t.Errorf("Recv after handler error = %v, want Aborted", err)
+	}
+	stream.Close()
+
+	// Client-side streaming with the initial request as the first message.
+	stream, err = client.InvokeStream(context.Background(), "/sum", 1)
+	if err != nil {
+		t.Fatalf("InvokeStream failed: %v", err)
+	}
+	defer stream.Close()
+	stream.Send(2)
+	stream.Send(3)
+	var total int
+	if err := stream.(talk.ClientStream).CloseAndRecv(&total); err != nil || total != 6 {
+		t.Errorf("CloseAndRecv = %d, %v, want 6", total, err)
+	}
+	if err := stream.Recv(&total); err != io.EOF {
+		t.Errorf("Recv after the last message = %v, want io.EOF", err)
+	}
+
+	// Server-only streams that do not answer GET still fall back to SSE.
+	stream, err = client.InvokeStream(context.Background(), "/ticks", nil)
+	if err != nil {
+		t.Fatalf("InvokeStream failed: %v", err)
+	}
+	defer stream.Close()
+	if err := stream.Recv(&resp); err != nil || resp.Message != "tick" {
+		t.Errorf("Recv = %+v, %v, want tick over SSE", resp, err)
+	}
+}
+
+func TestClient_DuplexStreamMode(t *testing.T) {
+	server, err := NewServer(x.TypedLazyConfig{Config: json.RawMessage(`{"addr": ":0"}`)})
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+	server.registerEndpoint(&talk.Endpoint{
+		Name:       "Sum",
+		Path:       "/sum",
+		Method:     "POST",
+		StreamMode: talk.StreamClientSide,
+		StreamHandler: func(ctx context.Context, req any, stream talk.Stream) error {
+			total := 0
+			for {
+				var n int
+				if err := stream.Recv(&n); err == io.EOF {
+					return stream.Send(total)
+				} else if err != nil {
+					return err
+				}
+				total += n
+			}
+		},
+	})
+
+	var gets atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			gets.Add(1)
+		}
+		server.mux.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+	client, err := NewClient(x.TypedLazyConfig{Config: json.RawMessage(fmt.Sprintf(`{"addr": %q}`, ts.URL))})
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	defer client.Close()
+
+	// Only the first stream probes the endpoint with GET.
+	for i := 0; i < 2; i++ {
+		stream, err := client.InvokeStream(context.Background(), "/sum", 1)
+		if err != nil {
+			t.Fatalf("InvokeStream failed: %v", err)
+		}
+		var total int
+		if err := stream.(talk.ClientStream).CloseAndRecv(&total); err != nil || total != 1 {
+			t.Errorf("CloseAndRecv = %d, %v, want 1", total, err)
+		}
+		stream.Close()
+	}
+	if n := gets.Load(); n != 1 {
+		t.Errorf("GET requests = %d, want 1", n)
+	}
+
+	// A GET answered successfully but not with SSE falls back to duplex.
+	var okGets atomic.Int32
+	okServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			okGets.Add(1)
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{}`))
+			return
+		}
+		server.mux.ServeHTTP(w, r)
+	}))
+	defer okServer.Close()
+	okClient, err := NewClient(x.TypedLazyConfig{Config: json.RawMessage(fmt.Sprintf(`{"addr": %q}`, okServer.URL))})
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	defer okClient.Close()
+	for i := 0; i < 2; i++ {
+		stream, err := okClient.InvokeStream(context.Background(), "/sum", 2)
+		if err != nil {
+			t.Fatalf("InvokeStream failed: %v", err)
+		}
+		var total int
+		if err := stream.(talk.ClientStream).CloseAndRecv(&total); err != nil || total != 2 {
+			t.Errorf("non-SSE GET: CloseAndRecv = %d, %v, want 2", total, err)
+		}
+		stream.Close()
+	}
+	if n := okGets.Load(); n != 1 {
+		t.Errorf("non-SSE GET: GET requests = %d, want 1", n)
+	}
+
+	// Compressed request bodies are decompressed.
+	gz, _ := codec.GetCompressor("gzip")
+	body, _ := codec.Compress(gz, []byte("1\n2\n"))
+	httpReq, _ := http.NewRequest(http.MethodPost, ts.URL+"/sum", strings.NewReader(string(body)))
+	httpReq.Header.Set("Content-Type", thttp.StreamContentType)
+	httpReq.Header.Set("Content-Encoding", "gzip")
+	httpResp, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer httpResp.Body.Close()
+	data, _ := io.ReadAll(httpResp.Body)
+	if strings.TrimSpace(string(data)) != "3" {
+		t.Errorf("response = %q, want 3", data)
+	}
+}
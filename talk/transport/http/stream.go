@@ -0,0 +1,346 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"mime"
+	nethttp "net/http"
+	"sync"
+	"time"
+
+	"go.zoe.im/x/talk"
+	"go.zoe.im/x/talk/codec"
+)
+
+// StreamContentType is the Content-Type of full-duplex streams framed with
+// JSON: one JSON document per line. Streams framed with other codecs use
+// the codec's own Content-Type.
+const StreamContentType = "application/x-ndjson"
+
+// StreamErrorTrailer is the trailer that carries the JSON-encoded
+// *talk.Error a full-duplex stream handler returned, as the response status
+// is sent before the handler runs.
+const StreamErrorTrailer = "Talk-Error"
+
+// eventStreamContentType is the Content-Type of SSE responses.
+const eventStreamContentType = "text/event-stream"
+
+// IsDuplex reports whether ep is a client-side or bidirectional stream,
+// which is served as a full-duplex exchange rather than over SSE.
+func IsDuplex(ep *talk.Endpoint) bool {
+	return ep.IsStreaming() && (ep.StreamMode == talk.StreamClientSide || ep.StreamMode == talk.StreamBidirect)
+}
+
+// streamCodec returns the codec framing a stream of the given Content-Type.
+// Frames need a codec.StreamCodec to be delimited, so it falls back to
+// fallback, then to JSON, when the named codec cannot stream.
+func streamCodec(contentType string, fallback codec.Codec) codec.StreamCodec {
+	if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType == StreamContentType {
+		return codec.MustGet("json").(codec.StreamCodec)
+	}
+	if sc, ok := codec.FromContentType(contentType, fallback).(codec.StreamCodec); ok {
+		return sc
+	}
+	return codec.MustGet("json").(codec.StreamCodec)
+}
+
+func streamContentType(c codec.StreamCodec) string {
+	if c.Name() == "json" {
+		return StreamContentType
+	}
+	return c.ContentType()
+}
+
+// ServeDuplex runs the stream handler of ep as a full-duplex exchange:
+// messages are decoded from the request body as the handler receives them
+// and written to the response as it sends them. The handler error, if any,
+// is reported in the StreamErrorTrailer trailer. A compressed request body
+// may decompress to at most maxSize bytes.
+func ServeDuplex(ctx context.Context, w nethttp.ResponseWriter, r *nethttp.Request, ep *talk.Endpoint, req any, fallback codec.Codec, maxSize int) {
+	// body is not closed here: closing drains the request body, which the
+	// client may still be sending. The server closes it after we return.
+	body, err := RequestBody(r, maxSize)
+	if err != nil {
+		writeError(w, talk.NewError(talk.InvalidArgument, err.Error()))
+		return
+	}
+
+	c := streamCodec(r.Header.Get("Content-Type"), fallback)
+	rc := nethttp.NewResponseController(w)
+	// HTTP/1.x servers stop reading the request body once the response
+	// has started unless full duplex is enabled; HTTP/2 always is.
+	rc.EnableFullDuplex()
+
+	w.Header().Set("Content-Type", streamContentType(c))
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.Header().Set("Trailer", StreamErrorTrailer)
+	w.WriteHeader(nethttp.StatusOK)
+	rc.Flush()
+
+	stream := &duplexServerStream{
+		ctx: ctx,
+		rc:  rc,
+		enc: c.NewEncoder(w),
+		dec: c.NewDecoder(body),
+	}
+	if ep.StreamHandler != nil {
+		err = ep.WrappedStreamHandler()(ctx, req, stream)
+	} else {
+		err = talk.NewError(talk.Unimplemented, "no stream handler configured")
+	}
+	if err != nil {
+		data, _ := json.Marshal(talk.ToError(err))
+		w.Header().Set(StreamErrorTrailer, string(data))
+	}
+}
+
+// writeError writes err as a JSON response.
+func writeError(w nethttp.ResponseWriter, err *talk.Error) {
+	data, _ := json.Marshal(err)
+	w.Header().Set("Content-Type", "application/json")
+	SetErrorHeaders(w.Header(), err)
+	w.WriteHeader(err.HTTPStatus())
+	w.Write(data)
+}
+
+type duplexServerStream struct {
+	ctx context.Context
+	rc  *nethttp.ResponseController
+	mu  sync.Mutex
+	enc codec.Encoder
+	dec codec.Decoder
+}
+
+func (s *duplexServerStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *duplexServerStream) Send(msg any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.enc.Encode(msg); err != nil {
+		return err
+	}
+	return s.rc.Flush()
+}
+
+// Recv returns io.EOF once the client has closed its send side.
+func (s *duplexServerStream) Recv(msg any) error {
+	return s.dec.Decode(msg)
+}
+
+// SendHeader is a no-op: the response headers are sent when the stream
+// opens.
+func (s *duplexServerStream) SendHeader(metadata map[string]string) error {
+	return nil
+}
+
+// Cancel ends a pending Recv by expiring the request body's read deadline.
+func (s *duplexServerStream) Cancel() {
+	s.rc.SetReadDeadline(time.Now())
+}
+
+func (s *duplexServerStream) Close() error {
+	return nil
+}
+
+// OpenDuplex opens a full-duplex stream to url with client, sending req as
+// its first message if it is not nil. Messages are framed with c if it is a
+// codec.StreamCodec, JSON otherwise.
+//
+// If the server answers with SSE because the endpoint only streams from the
+// server, the stream is nil and the response is returned for the caller to
+// read as SSE.
+func OpenDuplex(ctx context.Context, client *nethttp.Client, url string, header nethttp.Header, req any, c codec.Codec) (talk.ClientStream, *nethttp.Response, error) {
+	sc := streamCodec("", c)
+	pr, pw := io.Pipe()
+	httpReq, err := nethttp.NewRequestWithContext(ctx, nethttp.MethodPost, url, pr)
+	if err != nil {
+		return nil, nil, talk.NewError(talk.Internal, err.Error())
+	}
+	for k, v := range header {
+		httpReq.Header[k] = v
+	}
+	httpReq.Header.Set("Content-Type", streamContentType(sc))
+	httpReq.Header.Set("Accept", streamContentType(sc)+", "+eventStreamContentType)
+
+	httpResp, err := client.Do(httpReq)
+	if err != nil {
+		pw.Close()
+		return nil, nil, talk.NewError(talk.Unavailable, err.Error())
+	}
+
+	if httpResp.StatusCode >= 400 {
+		pw.Close()
+		return nil, nil, ResponseError(httpResp)
+	}
+	if isEventStream(httpResp) {
+		pw.Close()
+		return nil, httpResp, nil
+	}
+
+	stream := &duplexClientStream{
+		ctx:  ctx,
+		resp: httpResp,
+		pw:   pw,
+		enc:  sc.NewEncoder(pw),
+		dec:  sc.NewDecoder(httpResp.Body),
+	}
+	if req != nil {
+		if err := stream.Send(req); err != nil {
+			stream.Close()
+			return nil, nil, err
+		}
+	}
+	return stream, nil, nil
+}
+
+// StreamOpener opens client streams over HTTP. Server-side streams are read
+// over SSE; client-side and bidirectional streams are opened as full-duplex
+// exchanges. Clients do not know the stream mode of an endpoint, so the
+// first stream to a URL asks for SSE with GET and falls back to a
+// full-duplex POST if the server answers 405, or successfully but not with
+// SSE. Such URLs are remembered, so later streams to them skip the GET.
+type StreamOpener struct {
+	Client *nethttp.Client
+	// Reconnect and DisableReconnect configure how SSE streams are
+	// reopened, see NewSSEStream.
+	Reconnect        *talk.RetryConfig
+	DisableReconnect bool
+
+	duplex sync.Map // URL -> true
+}
+
+// Open opens a stream to url with header. A full-duplex stream sends req as
+// its first message if it is not nil and also implements talk.ClientStream;
+// its messages are framed with c if it is a codec.StreamCodec.
+func (o *StreamOpener) Open(ctx context.Context, url string, header nethttp.Header, req any, c codec.Codec) (talk.Stream, error) {
+	get := func(ctx context.Context, lastEventID string) (*nethttp.Response, error) {
+		httpReq, err := nethttp.NewRequestWithContext(ctx, nethttp.MethodGet, url, nil)
+		if err != nil {
+			return nil, talk.NewError(talk.Internal, err.Error())
+		}
+		httpReq.Header = header.Clone()
+		httpReq.Header.Set("Accept", eventStreamContentType)
+		if lastEventID != "" {
+			httpReq.Header.Set("Last-Event-ID", lastEventID)
+		}
+		httpResp, err := o.Client.Do(httpReq)
+		if err != nil {
+			return nil, talk.NewError(talk.Unavailable, err.Error())
+		}
+		return httpResp, nil
+	}
+	reopen := SSEReopenFunc(get)
+	if o.DisableReconnect {
+		reopen = nil
+	}
+
+	var httpResp *nethttp.Response
+	if _, duplex := o.duplex.Load(url); !duplex {
+		resp, err := get(ctx, "")
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == nethttp.StatusMethodNotAllowed || (resp.StatusCode < 400 && !isEventStream(resp)) {
+			resp.Body.Close()
+			o.duplex.Store(url, true)
+		} else {
+			httpResp = resp
+		}
+	}
+
+	if httpResp == nil {
+		stream, sseResp, err := OpenDuplex(ctx, o.Client, url, header, req, c)
+		if err != nil || stream != nil {
+			return stream, err
+		}
+		// The request was sent with the first message; it cannot be
+		// reopened with GET.
+		httpResp, reopen = sseResp, nil
+	}
+
+	if httpResp.StatusCode >= 400 {
+		return nil, ResponseError(httpResp)
+	}
+
+	var reconnect talk.RetryConfig
+	if o.Reconnect != nil {
+		reconnect = *o.Reconnect
+	}
+	return NewSSEStream(ctx, httpResp, c, reopen, reconnect), nil
+}
+
+// isEventStream reports whether resp is an SSE response.
+func isEventStream(resp *nethttp.Response) bool {
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return mediaType == eventStreamContentType
+}
+
+// ResponseError reads and closes the body of an error response and returns
+// the *talk.Error it carries, or one built from the status code.
+func ResponseError(resp *nethttp.Response) error {
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	var talkErr talk.Error
+	if err := json.Unmarshal(data, &talkErr); err == nil && talkErr.Code != talk.OK {
+		return &talkErr
+	}
+	return talk.NewError(talk.FromHTTPStatus(resp.StatusCode), string(data))
+}
+
+type duplexClientStream struct {
+	ctx  context.Context
+	resp *nethttp.Response
+	pw   *io.PipeWriter
+	mu   sync.Mutex
+	enc  codec.Encoder
+	dec  codec.Decoder
+}
+
+func (s *duplexClientStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *duplexClientStream) Send(msg any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.enc.Encode(msg)
+}
+
+// Recv receives a message from the server. Once the server handler has
+// returned and all messages are drained, Recv reports the handler's error,
+// or io.EOF if it succeeded.
+func (s *duplexClientStream) Recv(msg any) error {
+	err := s.dec.Decode(msg)
+	if err != io.EOF {
+		return err
+	}
+	if data := s.resp.Trailer.Get(StreamErrorTrailer); data != "" {
+		var talkErr talk.Error
+		if json.Unmarshal([]byte(data), &talkErr) == nil {
+			return &talkErr
+		}
+	}
+	return io.EOF
+}
+
+// CloseSend ends the request body, so that the server's Recv returns
+// io.EOF.
+func (s *duplexClientStream) CloseSend() error {
+	return s.pw.Close()
+}
+
+func (s *duplexClientStream) CloseAndRecv(resp any) error {
+	if err := s.CloseSend(); err != nil {
+		return err
+	}
+	return s.Recv(resp)
+}
+
+func (s *duplexClientStream) Close() error {
+	s.pw.Close()
+	return s.resp.Body.Close()
+}
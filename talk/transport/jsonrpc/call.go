@@ -0,0 +1,124 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"sync/atomic"
+
+	"go.zoe.im/x/talk"
+)
+
+// Call is one call of a batch. Notifications get no response, so their
+// Error and Result are never set.
+type Call struct {
+	Method string
+	// Params is the request; values that do not encode to a JSON object
+	// or array are sent as the only positional param.
+	Params any
+	// Result, if not nil, receives the decoded result.
+	Result any
+	// Notification marks a call without response.
+	Notification bool
+
+	// Error is the error of the call, set by Batch.
+	Error error
+}
+
+// idGenerator numbers the calls of a client.
+type idGenerator struct {
+	last atomic.Uint64
+}
+
+func (g *idGenerator) next() json.RawMessage {
+	return json.RawMessage(strconv.FormatUint(g.last.Add(1), 10))
+}
+
+// encodeCalls encodes calls as a request, or as a batch if there are
+// several, and returns the IDs of the calls expecting a response.
+func encodeCalls(ids *idGenerator, calls []*Call) ([]byte, map[string]*Call, error) {
+	reqs := make([]Request, len(calls))
+	pending := make(map[string]*Call, len(calls))
+	for i, call := range calls {
+		req := Request{JSONRPC: Version, Method: call.Method}
+		if call.Params != nil {
+			params, err := json.Marshal(call.Params)
+			if err != nil {
+				return nil, nil, talk.NewError(talk.InvalidArgument, "failed to encode request")
+			}
+			switch params = bytes.TrimSpace(params); {
+			case bytes.Equal(params, []byte("null")):
+			case params[0] == '{' || params[0] == '[':
+				req.Params = params
+			default:
+				req.Params = append(append([]byte{'['}, params...), ']')
+			}
+		}
+		if !call.Notification {
+			req.ID = ids.next()
+			pending[string(req.ID)] = call
+		}
+		reqs[i] = req
+	}
+
+	var data []byte
+	var err error
+	if len(reqs) == 1 {
+		data, err = json.Marshal(reqs[0])
+	} else {
+		data, err = json.Marshal(reqs)
+	}
+	if err != nil {
+		return nil, nil, talk.NewError(talk.InvalidArgument, "failed to encode request")
+	}
+	return data, pending, nil
+}
+
+// decodeResponses decodes a response or batch of responses.
+func decodeResponses(data []byte) ([]*Response, error) {
+	if !isBatch(data) {
+		var resp Response
+		if err := json.Unmarshal(data, &resp); err != nil {
+			return nil, err
+		}
+		return []*Response{&resp}, nil
+	}
+	var resps []*Response
+	if err := json.Unmarshal(data, &resps); err != nil {
+		return nil, err
+	}
+	return resps, nil
+}
+
+// setResponse sets the result or error of call from resp.
+func (call *Call) setResponse(resp *Response) {
+	if resp.Error != nil {
+		call.Error = resp.Error.talkError()
+		return
+	}
+	if call.Result != nil && len(resp.Result) > 0 {
+		if err := json.Unmarshal(resp.Result, call.Result); err != nil {
+			call.Error = talk.NewError(talk.Internal, "failed to decode response")
+		}
+	}
+}
+
+// resolveCalls sets the response of every pending call. Calls without
+// response fail, and so do all of them if the server answered with an
+// error without ID, e.g. because the batch was rejected.
+func resolveCalls(pending map[string]*Call, resps []*Response) {
+	for _, resp := range resps {
+		if call, ok := pending[string(resp.ID)]; ok {
+			call.setResponse(resp)
+			delete(pending, string(resp.ID))
+		} else if resp.Error != nil && (resp.ID == nil || string(resp.ID) == "null") {
+			for id, call := range pending {
+				call.Error = resp.Error.talkError()
+				delete(pending, id)
+			}
+		}
+	}
+	for _, call := range pending {
+		call.Error = talk.NewError(talk.Internal, "no response to call "+call.Method)
+	}
+}
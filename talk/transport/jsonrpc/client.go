@@ -0,0 +1,155 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.zoe.im/x"
+	"go.zoe.im/x/talk"
+)
+
+// Client implements talk.Transport as a JSON-RPC client over HTTP POST.
+type Client struct {
+	config     ClientConfig
+	url        string
+	httpClient *http.Client
+	ids        idGenerator
+}
+
+// NewClient creates a new JSON-RPC client transport over HTTP. The address
+// is a host and port, or a URL for servers behind a prefix or TLS.
+func NewClient(cfg x.TypedLazyConfig, opts ...Option) (*Client, error) {
+	c := &Client{}
+
+	if err := cfg.Unmarshal(&c.config); err != nil {
+		return nil, err
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	c.url = serviceURL("http", c.config.Config)
+	c.httpClient = &http.Client{
+		Timeout: time.Duration(c.config.Timeout),
+	}
+
+	return c, nil
+}
+
+// serviceURL returns the URL of the service at cfg.Addr, using scheme if
+// the address has none.
+func serviceURL(scheme string, cfg Config) string {
+	if strings.Contains(cfg.Addr, "://") {
+		if cfg.Path == "" {
+			return cfg.Addr
+		}
+		return strings.TrimSuffix(cfg.Addr, "/") + cfg.Path
+	}
+	path := cfg.Path
+	if path == "" {
+		path = DefaultPath
+	}
+	return scheme + "://" + cfg.Addr + path
+}
+
+func (c *Client) String() string {
+	return "jsonrpc/client"
+}
+
+func (c *Client) Serve(ctx context.Context, endpoints []*talk.Endpoint) error {
+	return talk.NewError(talk.Unimplemented, "client does not support Serve")
+}
+
+func (c *Client) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+// Invoke calls the method named endpoint with req as its params.
+func (c *Client) Invoke(ctx context.Context, endpoint string, req any, resp any) error {
+	call := &Call{Method: endpoint, Params: req, Result: resp}
+	if err := c.Batch(ctx, call); err != nil {
+		return err
+	}
+	return call.Error
+}
+
+func (c *Client) InvokeStream(ctx context.Context, endpoint string, req any) (talk.Stream, error) {
+	return nil, talk.NewError(talk.Unimplemented, "JSON-RPC does not support streams")
+}
+
+// Notify sends a notification: the method is called without waiting for,
+// or learning about, its outcome.
+func (c *Client) Notify(ctx context.Context, method string, params any) error {
+	return c.Batch(ctx, &Call{Method: method, Params: params, Notification: true})
+}
+
+// Batch sends calls in a single request and sets the result or error of
+// each. The returned error reports a failure of the request as a whole.
+func (c *Client) Batch(ctx context.Context, calls ...*Call) error {
+	if len(calls) == 0 {
+		return nil
+	}
+	data, pending, err := encodeCalls(&c.ids, calls)
+	if err != nil {
+		return err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(data))
+	if err != nil {
+		return talk.NewError(talk.Internal, err.Error())
+	}
+	if md, ok := talk.FromOutgoingContext(ctx); ok {
+		md.SetHeader(httpReq.Header)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Accept", "application/json")
+
+	httpResp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return talk.NewError(talk.Unavailable, err.Error())
+	}
+	defer httpResp.Body.Close()
+
+	body, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return talk.NewError(talk.Unavailable, err.Error())
+	}
+	if httpResp.StatusCode >= 400 {
+		return talk.NewError(talk.FromHTTPStatus(httpResp.StatusCode), strings.TrimSpace(string(body)))
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	resps, err := decodeResponses(body)
+	if err != nil {
+		return talk.NewError(talk.Internal, "failed to decode response")
+	}
+	resolveCalls(pending, resps)
+	return nil
+}
+
+func (c *Client) Close() error {
+	c.httpClient.CloseIdleConnections()
+	return nil
+}
+
+func init() {
+	ClientFactory.Register("http", func(cfg x.TypedLazyConfig, opts ...Option) (ClientTransport, error) {
+		return NewClient(cfg, opts...)
+	}, "default")
+
+	talk.RegisterTransport("jsonrpc", &talk.TransportCreators{
+		Server: func(cfg x.TypedLazyConfig) (talk.Transport, error) {
+			return NewServer(cfg)
+		},
+		Client: func(cfg x.TypedLazyConfig) (talk.Transport, error) {
+			return NewClient(cfg)
+		},
+	}, "jsonrpc/http")
+}
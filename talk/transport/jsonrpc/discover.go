@@ -0,0 +1,98 @@
+package jsonrpc
+
+import (
+	"sort"
+
+	"go.zoe.im/x/talk"
+)
+
+// DiscoverMethod is the method returning the DiscoverDocument of a server.
+const DiscoverMethod = "rpc.discover"
+
+// OpenRPCVersion is the OpenRPC specification version of DiscoverDocument.
+const OpenRPCVersion = "1.2.6"
+
+// DiscoverDocument is the OpenRPC description of a service, returned by
+// rpc.discover.
+type DiscoverDocument struct {
+	OpenRPC string             `json:"openrpc"`
+	Info    DiscoverInfo       `json:"info"`
+	Methods []MethodDescriptor `json:"methods"`
+}
+
+// DiscoverInfo is the service metadata of a DiscoverDocument.
+type DiscoverInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+// MethodDescriptor describes a callable method.
+type MethodDescriptor struct {
+	Name string `json:"name"`
+	// ParamStructure is "by-name" when the request is a struct, whose
+	// fields are the params, and "by-position" otherwise, when the request
+	// is the only param.
+	ParamStructure string              `json:"paramStructure"`
+	Params         []ContentDescriptor `json:"params"`
+	Result         *ContentDescriptor  `json:"result,omitempty"`
+}
+
+// ContentDescriptor describes a param or result.
+type ContentDescriptor struct {
+	Name   string           `json:"name"`
+	Schema *talk.TypeSchema `json:"schema"`
+}
+
+// Describe returns the DiscoverDocument of the unary endpoints, sorted by
+// name. Streaming endpoints cannot be called over JSON-RPC and are left
+// out, as are those taking a raw body.
+func Describe(title, version string, endpoints []*talk.Endpoint) *DiscoverDocument {
+	if title == "" {
+		title = "talk"
+	}
+	if version == "" {
+		version = "1.0.0"
+	}
+
+	doc := &DiscoverDocument{
+		OpenRPC: OpenRPCVersion,
+		Info:    DiscoverInfo{Title: title, Version: version},
+		Methods: []MethodDescriptor{},
+	}
+	for _, ep := range endpoints {
+		if !callable(ep) {
+			continue
+		}
+
+		m := MethodDescriptor{
+			Name:           ep.Name,
+			ParamStructure: "by-position",
+			Params:         []ContentDescriptor{},
+		}
+		if schema := talk.DescribeType(ep.RequestType); schema != nil && schema.Properties != nil {
+			m.ParamStructure = "by-name"
+			for name, prop := range schema.Properties {
+				m.Params = append(m.Params, ContentDescriptor{Name: name, Schema: prop})
+			}
+			sort.Slice(m.Params, func(i, j int) bool {
+				return m.Params[i].Name < m.Params[j].Name
+			})
+		} else if schema != nil {
+			m.Params = append(m.Params, ContentDescriptor{Name: "request", Schema: schema})
+		}
+		if ep.ResponseType != nil {
+			m.Result = &ContentDescriptor{Name: "response", Schema: talk.DescribeType(ep.ResponseType)}
+		}
+		doc.Methods = append(doc.Methods, m)
+	}
+
+	sort.Slice(doc.Methods, func(i, j int) bool {
+		return doc.Methods[i].Name < doc.Methods[j].Name
+	})
+	return doc
+}
+
+// callable reports whether ep can be called over JSON-RPC.
+func callable(ep *talk.Endpoint) bool {
+	return !ep.IsStreaming() && !talk.IsRawBody(ep.RequestType)
+}
@@ -0,0 +1,88 @@
+package jsonrpc
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"go.zoe.im/x/talk"
+)
+
+// Error codes defined by the JSON-RPC 2.0 specification.
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+)
+
+// codeServerError is the base of the implementation-defined server error
+// range; talk codes without a standard equivalent map below it.
+const codeServerError = -32000
+
+// Error is a JSON-RPC error object. Errors returned by talk servers carry
+// the *talk.Error in Data, so that talk clients get it back unchanged.
+type Error struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("jsonrpc error %d: %s", e.Code, e.Message)
+}
+
+// ToCode returns the JSON-RPC error code of a talk error code:
+// InvalidArgument, Unimplemented and Internal map to the standard invalid
+// params, method not found and internal error codes, and the others to
+// -32000 minus the talk code.
+func ToCode(code talk.ErrorCode) int {
+	switch code {
+	case talk.InvalidArgument:
+		return CodeInvalidParams
+	case talk.Unimplemented:
+		return CodeMethodNotFound
+	case talk.Internal:
+		return CodeInternalError
+	default:
+		return codeServerError - int(code)
+	}
+}
+
+// FromCode returns the talk error code of a JSON-RPC error code.
+func FromCode(code int) talk.ErrorCode {
+	switch code {
+	case CodeParseError, CodeInvalidRequest, CodeInvalidParams:
+		return talk.InvalidArgument
+	case CodeMethodNotFound:
+		return talk.Unimplemented
+	case CodeInternalError:
+		return talk.Internal
+	}
+	if c := codeServerError - code; c > 0 && c <= int(talk.Unauthenticated) {
+		return talk.ErrorCode(c)
+	}
+	return talk.Unknown
+}
+
+// toError converts err to a JSON-RPC error object.
+func toError(err error) *Error {
+	te := talk.ToError(err)
+	e := &Error{Code: ToCode(te.Code), Message: te.Message}
+	if e.Message == "" {
+		e.Message = te.Code.String()
+	}
+	if data, err := json.Marshal(te); err == nil {
+		e.Data = data
+	}
+	return e
+}
+
+// talkError converts a JSON-RPC error object back to a *talk.Error.
+func (e *Error) talkError() *talk.Error {
+	var te talk.Error
+	if len(e.Data) > 0 && json.Unmarshal(e.Data, &te) == nil && te.Code != talk.OK {
+		return &te
+	}
+	return talk.NewError(FromCode(e.Code), e.Message)
+}
@@ -0,0 +1,134 @@
+// Package jsonrpc provides a JSON-RPC 2.0 transport for talk, over HTTP POST
+// and over WebSocket. Endpoints are called by name, with their request as
+// the call params and their response as the result. Batches, notifications
+// and the rpc.discover method are supported, so that any JSON-RPC client can
+// call talk services.
+package jsonrpc
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"go.zoe.im/x"
+	"go.zoe.im/x/factory"
+	"go.zoe.im/x/talk"
+	"go.zoe.im/x/talk/transport"
+)
+
+// Version is the JSON-RPC protocol version.
+const Version = "2.0"
+
+// DefaultPath is the HTTP path served when none is configured.
+const DefaultPath = "/rpc"
+
+// DefaultMaxBatchSize is the batch size limit used when none is configured.
+const DefaultMaxBatchSize = 100
+
+type Config struct {
+	Addr string `json:"addr" yaml:"addr"`
+	// Path is the HTTP path of the service; WebSocket connections are
+	// upgraded on the same path. Defaults to DefaultPath.
+	Path string `json:"path,omitempty" yaml:"path"`
+}
+
+type ServerConfig struct {
+	Config `json:",inline" yaml:",inline"`
+	// MaxBatchSize limits the number of calls in a batch request.
+	MaxBatchSize int `json:"max_batch_size,omitempty" yaml:"max_batch_size"`
+	// Title and Version describe the service in rpc.discover.
+	Title   string `json:"title,omitempty" yaml:"title"`
+	Version string `json:"version,omitempty" yaml:"version"`
+}
+
+type ClientConfig struct {
+	Config  `json:",inline" yaml:",inline"`
+	Timeout x.Duration `json:"timeout,omitempty" yaml:"timeout"`
+}
+
+// Option configures a server or client. JSON-RPC is always encoded as
+// JSON, so there is no codec option.
+type Option func(any)
+
+var serverFactory = factory.NewFactory[ServerTransport, Option]()
+
+var ServerFactory = struct {
+	Create   func(cfg x.TypedLazyConfig, opts ...Option) (ServerTransport, error)
+	Register func(typeName string, creator factory.Creator[ServerTransport, Option], alias ...string) error
+}{
+	Create:   serverFactory.Create,
+	Register: serverFactory.Register,
+}
+
+var clientFactory = factory.NewFactory[ClientTransport, Option]()
+
+var ClientFactory = struct {
+	Create   func(cfg x.TypedLazyConfig, opts ...Option) (ClientTransport, error)
+	Register func(typeName string, creator factory.Creator[ClientTransport, Option], alias ...string) error
+}{
+	Create:   clientFactory.Create,
+	Register: clientFactory.Register,
+}
+
+type ServerTransport interface {
+	String() string
+}
+
+type ClientTransport interface {
+	String() string
+}
+
+// Request is a JSON-RPC request. A request without ID is a notification,
+// which gets no response.
+type Request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// Response is a JSON-RPC response, carrying either Result or Error. ID is
+// null if the request ID could not be read.
+type Response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+// isBatch reports whether data is a JSON array.
+func isBatch(data []byte) bool {
+	data = bytes.TrimSpace(data)
+	return len(data) > 0 && data[0] == '['
+}
+
+type jsonrpcTransportFamily struct{}
+
+func (f *jsonrpcTransportFamily) CreateServer(cfg x.TypedLazyConfig, opts ...transport.TransportOption) (transport.ServerTransport, error) {
+	server, err := serverFactory.Create(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if full, ok := server.(transport.ServerTransport); ok {
+		return full, nil
+	}
+
+	return nil, talk.NewError(talk.Internal, "JSON-RPC server does not implement transport.ServerTransport")
+}
+
+func (f *jsonrpcTransportFamily) CreateClient(cfg x.TypedLazyConfig, opts ...transport.TransportOption) (transport.ClientTransport, error) {
+	client, err := clientFactory.Create(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if full, ok := client.(transport.ClientTransport); ok {
+		return full, nil
+	}
+
+	return nil, talk.NewError(talk.Internal, "JSON-RPC client does not implement transport.ClientTransport")
+}
+
+func init() {
+	transport.Factory.RegisterFamily("jsonrpc", &jsonrpcTransportFamily{})
+}
@@ -0,0 +1,254 @@
+package jsonrpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"go.zoe.im/x"
+	"go.zoe.im/x/talk"
+)
+
+type addRequest struct {
+	A int `json:"a"`
+	B int `json:"b"`
+}
+
+type addResponse struct {
+	Sum int `json:"sum"`
+}
+
+func newTestServer(t *testing.T, notified *atomic.Int32) *httptest.Server {
+	t.Helper()
+
+	add := talk.NewEndpoint("Add", func(ctx context.Context, req any) (any, error) {
+		r := req.(addRequest)
+		return &addResponse{Sum: r.A + r.B}, nil
+	})
+	add.RequestType = reflect.TypeOf(addRequest{})
+	add.ResponseType = reflect.TypeOf(addResponse{})
+
+	echo := talk.NewEndpoint("Echo", func(ctx context.Context, req any) (any, error) {
+		md, _ := talk.FromIncomingContext(ctx)
+		return req.(string) + md.Get("x-suffix"), nil
+	})
+	echo.RequestType = reflect.TypeOf("")
+	echo.ResponseType = reflect.TypeOf("")
+
+	fail := talk.NewEndpoint("Fail", func(ctx context.Context, req any) (any, error) {
+		return nil, talk.NewError(talk.NotFound, "no such thing")
+	})
+
+	notify := talk.NewEndpoint("Ping", func(ctx context.Context, req any) (any, error) {
+		notified.Add(1)
+		return nil, nil
+	})
+
+	watch := talk.NewStreamEndpoint("Watch", func(ctx context.Context, req any, stream talk.Stream) error {
+		return nil
+	}, talk.StreamServerSide)
+
+	server, err := NewServer(x.TypedLazyConfig{Config: json.RawMessage(`{"title": "calc"}`)})
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+	server.RegisterEndpoints([]*talk.Endpoint{add, echo, fail, notify, watch})
+
+	ts := httptest.NewServer(server)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func testConfig(ts *httptest.Server) x.TypedLazyConfig {
+	addr := strings.TrimPrefix(ts.URL, "http://")
+	return x.TypedLazyConfig{Config: json.RawMessage(`{"addr": "` + addr + `", "path": "/"}`)}
+}
+
+func post(t *testing.T, url, body string) (int, string) {
+	t.Helper()
+	resp, err := http.Post(url, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("POST failed: %v", err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(data)
+}
+
+func TestServer_Protocol(t *testing.T) {
+	var notified atomic.Int32
+	ts := newTestServer(t, &notified)
+
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"named params", `{"jsonrpc":"2.0","id":1,"method":"Add","params":{"a":1,"b":2}}`,
+			`{"jsonrpc":"2.0","id":1,"result":{"sum":3}}`},
+		{"positional params", `{"jsonrpc":"2.0","id":"x","method":"Echo","params":["hi"]}`,
+			`{"jsonrpc":"2.0","id":"x","result":"hi"}`},
+		{"parse error", `{"jsonrpc":`,
+			`{"jsonrpc":"2.0","id":null,"error":{"code":-32700,"message":"parse error"}}`},
+		{"method not found", `{"jsonrpc":"2.0","id":2,"method":"Watch"}`,
+			`{"jsonrpc":"2.0","id":2,"error":{"code":-32601,"message":"method not found: Watch","data":{"code":12,"message":"method not found: Watch"}}}`},
+		{"invalid params", `{"jsonrpc":"2.0","id":3,"method":"Echo","params":[1,2]}`,
+			`{"jsonrpc":"2.0","id":3,"error":{"code":-32602,"message":"invalid params: Echo takes one positional param","data":{"code":3,"message":"invalid params: Echo takes one positional param"}}}`},
+		{"handler error", `{"jsonrpc":"2.0","id":4,"method":"Fail"}`,
+			`{"jsonrpc":"2.0","id":4,"error":{"code":-32005,"message":"no such thing","data":{"code":5,"message":"no such thing"}}}`},
+		{"batch", `[{"jsonrpc":"2.0","id":1,"method":"Add","params":{"a":2,"b":2}},{"jsonrpc":"2.0","method":"Ping"},{"jsonrpc":"1.0","id":5,"method":"Add"}]`,
+			`[{"jsonrpc":"2.0","id":1,"result":{"sum":4}},{"jsonrpc":"2.0","id":5,"error":{"code":-32600,"message":"invalid request: jsonrpc must be \"2.0\" and method set"}}]`},
+		{"empty batch", `[]`,
+			`{"jsonrpc":"2.0","id":null,"error":{"code":-32600,"message":"invalid request: empty batch"}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := post(t, ts.URL, tt.body)
+			if status != http.StatusOK {
+				t.Fatalf("status = %d, want 200", status)
+			}
+			if body != tt.want {
+				t.Errorf("response = %s\nwant %s", body, tt.want)
+			}
+		})
+	}
+
+	status, body := post(t, ts.URL, `[{"jsonrpc":"2.0","method":"Ping"},{"jsonrpc":"2.0","method":"Ping","params":{}}]`)
+	if status != http.StatusNoContent || body != "" {
+		t.Errorf("notifications got %d %q, want 204 without body", status, body)
+	}
+	if n := notified.Load(); n != 3 {
+		t.Errorf("Ping called %d times, want 3", n)
+	}
+}
+
+func TestServer_Discover(t *testing.T) {
+	var notified atomic.Int32
+	ts := newTestServer(t, &notified)
+
+	client, err := NewClient(testConfig(ts))
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	defer client.Close()
+
+	var doc DiscoverDocument
+	if err := client.Invoke(context.Background(), DiscoverMethod, nil, &doc); err != nil {
+		t.Fatalf("rpc.discover failed: %v", err)
+	}
+	if doc.OpenRPC != OpenRPCVersion || doc.Info.Title != "calc" {
+		t.Errorf("document header = %s %+v", doc.OpenRPC, doc.Info)
+	}
+
+	var names []string
+	for _, m := range doc.Methods {
+		names = append(names, m.Name)
+	}
+	if want := []string{"Add", "Echo", "Fail", "Ping"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("methods = %v, want %v", names, want)
+	}
+
+	add := doc.Methods[0]
+	if add.ParamStructure != "by-name" || len(add.Params) != 2 || add.Params[0].Name != "a" || add.Result.Schema.Type != "object" {
+		t.Errorf("Add = %+v", add)
+	}
+	echo := doc.Methods[1]
+	if echo.ParamStructure != "by-position" || len(echo.Params) != 1 || echo.Params[0].Schema.Type != "string" {
+		t.Errorf("Echo = %+v", echo)
+	}
+}
+
+func TestClients(t *testing.T) {
+	var notified atomic.Int32
+	ts := newTestServer(t, &notified)
+
+	httpClient, err := NewClient(testConfig(ts))
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	defer httpClient.Close()
+
+	ctx := talk.AppendToOutgoingContext(context.Background(), "x-suffix", "!")
+	wsClient, err := NewWSClientContext(ctx, testConfig(ts))
+	if err != nil {
+		t.Fatalf("NewWSClient failed: %v", err)
+	}
+	defer wsClient.Close()
+
+	clients := map[string]interface {
+		talk.Transport
+		Notify(ctx context.Context, method string, params any) error
+		Batch(ctx context.Context, calls ...*Call) error
+	}{
+		"http": httpClient,
+		"ws":   wsClient,
+	}
+
+	for name, client := range clients {
+		t.Run(name, func(t *testing.T) {
+			var resp addResponse
+			if err := client.Invoke(ctx, "Add", &addRequest{A: 20, B: 22}, &resp); err != nil {
+				t.Fatalf("Invoke failed: %v", err)
+			}
+			if resp.Sum != 42 {
+				t.Errorf("Sum = %d, want 42", resp.Sum)
+			}
+
+			var echoed string
+			if err := client.Invoke(ctx, "Echo", "hello", &echoed); err != nil {
+				t.Fatalf("Invoke failed: %v", err)
+			}
+			if echoed != "hello!" {
+				t.Errorf("Echo = %q, want %q", echoed, "hello!")
+			}
+
+			err := client.Invoke(ctx, "Fail", nil, nil)
+			var talkErr *talk.Error
+			if !errors.As(err, &talkErr) || talkErr.Code != talk.NotFound {
+				t.Errorf("Invoke(Fail) error = %v, want NotFound", err)
+			}
+
+			var sum addResponse
+			calls := []*Call{
+				{Method: "Add", Params: addRequest{A: 1, B: 1}, Result: &sum},
+				{Method: "Ping", Notification: true},
+				{Method: "Missing"},
+			}
+			if err := client.Batch(ctx, calls...); err != nil {
+				t.Fatalf("Batch failed: %v", err)
+			}
+			if calls[0].Error != nil || sum.Sum != 2 {
+				t.Errorf("Add call = %v, %+v", calls[0].Error, sum)
+			}
+			if !errors.As(calls[2].Error, &talkErr) || talkErr.Code != talk.Unimplemented {
+				t.Errorf("Missing call error = %v, want Unimplemented", calls[2].Error)
+			}
+
+			if err := client.Notify(ctx, "Ping", nil); err != nil {
+				t.Errorf("Notify failed: %v", err)
+			}
+		})
+	}
+}
+
+func TestErrorCodes(t *testing.T) {
+	for code := talk.Cancelled; code <= talk.Unauthenticated; code++ {
+		if got := FromCode(ToCode(code)); got != code {
+			t.Errorf("FromCode(ToCode(%s)) = %s", code, got)
+		}
+	}
+	if got := FromCode(CodeParseError); got != talk.InvalidArgument {
+		t.Errorf("FromCode(CodeParseError) = %s, want INVALID_ARGUMENT", got)
+	}
+	if got := FromCode(-1); got != talk.Unknown {
+		t.Errorf("FromCode(-1) = %s, want UNKNOWN", got)
+	}
+}
@@ -0,0 +1,381 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/websocket"
+
+	"go.zoe.im/x"
+	"go.zoe.im/x/talk"
+)
+
+// Server implements talk.Transport as a JSON-RPC 2.0 service. It answers
+// HTTP POST requests on its path and upgrades WebSocket connections on the
+// same path, where every text message is a request or batch.
+//
+// Server is also an http.Handler, to mount the service on an existing
+// server after RegisterEndpoints.
+type Server struct {
+	config ServerConfig
+	server *http.Server
+	conns  sync.Map
+
+	mu        sync.RWMutex
+	endpoints map[string]*talk.Endpoint
+	discover  *DiscoverDocument
+}
+
+// NewServer creates a new JSON-RPC server transport.
+func NewServer(cfg x.TypedLazyConfig, opts ...Option) (*Server, error) {
+	s := &Server{
+		endpoints: make(map[string]*talk.Endpoint),
+	}
+
+	if err := cfg.Unmarshal(&s.config); err != nil {
+		return nil, err
+	}
+
+	if s.config.Path == "" {
+		s.config.Path = DefaultPath
+	}
+	if s.config.MaxBatchSize <= 0 {
+		s.config.MaxBatchSize = DefaultMaxBatchSize
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s, nil
+}
+
+func (s *Server) String() string {
+	return "jsonrpc"
+}
+
+// RegisterEndpoints makes the endpoints callable by their names. Unlike
+// Serve it does not listen, for use of the Server as an http.Handler.
+func (s *Server) RegisterEndpoints(endpoints []*talk.Endpoint) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, ep := range endpoints {
+		s.endpoints[ep.Name] = ep
+	}
+	all := make([]*talk.Endpoint, 0, len(s.endpoints))
+	for _, ep := range s.endpoints {
+		all = append(all, ep)
+	}
+	s.discover = Describe(s.config.Title, s.config.Version, all)
+}
+
+func (s *Server) Serve(ctx context.Context, endpoints []*talk.Endpoint) error {
+	s.RegisterEndpoints(endpoints)
+
+	mux := http.NewServeMux()
+	mux.Handle(s.config.Path, s)
+
+	s.server = &http.Server{
+		Addr:    s.config.Addr,
+		Handler: mux,
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		if err := s.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			errCh <- err
+		}
+		close(errCh)
+	}()
+
+	select {
+	case <-ctx.Done():
+		return s.Shutdown(context.Background())
+	case err := <-errCh:
+		return err
+	}
+}
+
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.conns.Range(func(key, value any) bool {
+		if conn, ok := value.(*websocket.Conn); ok {
+			conn.Close()
+		}
+		return true
+	})
+
+	if s.server != nil {
+		return s.server.Shutdown(ctx)
+	}
+	return nil
+}
+
+func (s *Server) Invoke(ctx context.Context, endpoint string, req any, resp any) error {
+	return talk.NewError(talk.Unimplemented, "server does not support Invoke")
+}
+
+func (s *Server) InvokeStream(ctx context.Context, endpoint string, req any) (talk.Stream, error) {
+	return nil, talk.NewError(talk.Unimplemented, "server does not support InvokeStream")
+}
+
+func (s *Server) Close() error {
+	return nil
+}
+
+// ServeHTTP serves a JSON-RPC request, or a WebSocket upgrade.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		// Unlike websocket.Handler, accept clients without an Origin header,
+		// such as command-line tools.
+		ws := websocket.Server{Handler: s.handleConnection}
+		ws.ServeHTTP(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "JSON-RPC requests must be POSTed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := talk.NewIncomingContext(r.Context(), talk.MetadataFromHeader(r.Header))
+	ctx = talk.NewPeerContext(ctx, r.RemoteAddr)
+
+	out := s.handle(ctx, data)
+	if out == nil {
+		// Only notifications: there is nothing to answer.
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(out)
+}
+
+// handleConnection serves the requests of a WebSocket connection
+// concurrently. The handshake headers are the metadata of every call.
+func (s *Server) handleConnection(conn *websocket.Conn) {
+	connID := fmt.Sprintf("%p", conn)
+	s.conns.Store(connID, conn)
+	defer func() {
+		s.conns.Delete(connID)
+		conn.Close()
+	}()
+
+	ctx := context.Background()
+	if r := conn.Request(); r != nil {
+		ctx = talk.NewIncomingContext(r.Context(), talk.MetadataFromHeader(r.Header))
+		ctx = talk.NewPeerContext(ctx, r.RemoteAddr)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var mu sync.Mutex
+	for {
+		var data []byte
+		if err := websocket.Message.Receive(conn, &data); err != nil {
+			return
+		}
+		go func() {
+			out := s.handle(ctx, data)
+			if out == nil {
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			websocket.Message.Send(conn, string(out))
+		}()
+	}
+}
+
+// handle runs a request or batch and returns the encoded response, or nil
+// if it only contained notifications.
+func (s *Server) handle(ctx context.Context, data []byte) []byte {
+	if !json.Valid(data) {
+		return s.encode(errorResponse(nil, &Error{Code: CodeParseError, Message: "parse error"}))
+	}
+	if !isBatch(data) {
+		resp := s.call(ctx, data)
+		if resp == nil {
+			return nil
+		}
+		return s.encode(resp)
+	}
+
+	var batch []json.RawMessage
+	if err := json.Unmarshal(data, &batch); err != nil || len(batch) == 0 {
+		return s.encode(errorResponse(nil, &Error{Code: CodeInvalidRequest, Message: "invalid request: empty batch"}))
+	}
+	if len(batch) > s.config.MaxBatchSize {
+		return s.encode(errorResponse(nil, &Error{
+			Code:    CodeInvalidRequest,
+			Message: fmt.Sprintf("invalid request: batch of %d calls exceeds %d", len(batch), s.config.MaxBatchSize),
+		}))
+	}
+
+	resps := make([]*Response, len(batch))
+	var wg sync.WaitGroup
+	for i, raw := range batch {
+		wg.Add(1)
+		go func(i int, raw json.RawMessage) {
+			defer wg.Done()
+			resps[i] = s.call(ctx, raw)
+		}(i, raw)
+	}
+	wg.Wait()
+
+	out := make([]*Response, 0, len(resps))
+	for _, resp := range resps {
+		if resp != nil {
+			out = append(out, resp)
+		}
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return s.encode(out)
+}
+
+// call runs a single request and returns its response, or nil for a
+// notification.
+func (s *Server) call(ctx context.Context, data []byte) *Response {
+	var req Request
+	if err := json.Unmarshal(data, &req); err != nil {
+		return errorResponse(nil, &Error{Code: CodeInvalidRequest, Message: "invalid request: " + err.Error()})
+	}
+	if !validID(req.ID) {
+		return errorResponse(nil, &Error{Code: CodeInvalidRequest, Message: "invalid request: id must be a string, number or null"})
+	}
+	if req.JSONRPC != Version || req.Method == "" {
+		return errorResponse(req.ID, &Error{Code: CodeInvalidRequest, Message: `invalid request: jsonrpc must be "2.0" and method set`})
+	}
+
+	result, err := s.invoke(ctx, &req)
+	if req.ID == nil {
+		return nil
+	}
+	if err != nil {
+		return errorResponse(req.ID, toError(err))
+	}
+
+	data, err = json.Marshal(result)
+	if err != nil {
+		return errorResponse(req.ID, toError(talk.NewError(talk.Internal, "failed to encode result")))
+	}
+	return &Response{JSONRPC: Version, ID: req.ID, Result: data}
+}
+
+func (s *Server) invoke(ctx context.Context, req *Request) (any, error) {
+	s.mu.RLock()
+	ep, ok := s.endpoints[req.Method]
+	discover := s.discover
+	s.mu.RUnlock()
+
+	if req.Method == DiscoverMethod {
+		return discover, nil
+	}
+	if !ok || !callable(ep) {
+		return nil, talk.NewError(talk.Unimplemented, "method not found: "+req.Method)
+	}
+	if ep.Handler == nil {
+		return nil, talk.NewError(talk.Unimplemented, "no handler configured")
+	}
+
+	params, err := decodeParams(ep, req.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	return ep.WrappedHandler()(talk.WithEndpointContext(ctx, ep), params)
+}
+
+// decodeParams decodes the params of a call into the request type of ep.
+// Named params are the request itself; positional params hold it as their
+// only element, unless the request type is itself a list.
+func decodeParams(ep *talk.Endpoint, params json.RawMessage) (any, error) {
+	params = bytes.TrimSpace(params)
+	if len(params) == 0 || bytes.Equal(params, []byte("null")) {
+		if ep.RequestType != nil && ep.RequestType.Kind() == reflect.Struct {
+			return reflect.New(ep.RequestType).Elem().Interface(), nil
+		}
+		return nil, nil
+	}
+
+	if params[0] == '[' && !isListType(ep.RequestType) {
+		var list []json.RawMessage
+		if err := json.Unmarshal(params, &list); err != nil {
+			return nil, talk.NewError(talk.InvalidArgument, "invalid params")
+		}
+		switch len(list) {
+		case 0:
+			return decodeParams(ep, nil)
+		case 1:
+			params = list[0]
+		default:
+			return nil, talk.NewErrorf(talk.InvalidArgument, "invalid params: %s takes one positional param", ep.Name)
+		}
+	} else if params[0] != '{' && params[0] != '[' {
+		return nil, talk.NewError(talk.InvalidArgument, "invalid params: must be an object or an array")
+	}
+
+	if ep.RequestType == nil {
+		var req any
+		if err := json.Unmarshal(params, &req); err != nil {
+			return nil, talk.NewError(talk.InvalidArgument, "invalid params")
+		}
+		return req, nil
+	}
+	reqVal := reflect.New(ep.RequestType)
+	if err := json.Unmarshal(params, reqVal.Interface()); err != nil {
+		return nil, talk.NewErrorf(talk.InvalidArgument, "invalid params: %v", err)
+	}
+	return reqVal.Elem().Interface(), nil
+}
+
+func isListType(t reflect.Type) bool {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t != nil && (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) && t.Elem().Kind() != reflect.Uint8
+}
+
+// validID reports whether id is absent, a string, a number or null.
+func validID(id json.RawMessage) bool {
+	if id == nil {
+		return true
+	}
+	switch id[0] {
+	case '"', 'n', '-', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+		return true
+	}
+	return false
+}
+
+func errorResponse(id json.RawMessage, err *Error) *Response {
+	return &Response{JSONRPC: Version, ID: id, Error: err}
+}
+
+func (s *Server) encode(v any) []byte {
+	data, err := json.Marshal(v)
+	if err != nil {
+		data, _ = json.Marshal(errorResponse(nil, &Error{Code: CodeInternalError, Message: err.Error()}))
+	}
+	return data
+}
+
+func init() {
+	ServerFactory.Register("default", func(cfg x.TypedLazyConfig, opts ...Option) (ServerTransport, error) {
+		return NewServer(cfg, opts...)
+	})
+}
@@ -0,0 +1,225 @@
+package jsonrpc
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	"go.zoe.im/x"
+	"go.zoe.im/x/talk"
+)
+
+// WSClient implements talk.Transport as a JSON-RPC client over a WebSocket
+// connection. Calls are multiplexed on the connection by ID. The metadata
+// of the context passed to NewWSClientContext is sent with the handshake
+// and applies to every call.
+type WSClient struct {
+	config ClientConfig
+	conn   *websocket.Conn
+	ids    idGenerator
+	sendMu sync.Mutex
+
+	mu      sync.Mutex
+	pending map[string]chan *Response
+	err     error
+	done    chan struct{}
+}
+
+// NewWSClient creates a new JSON-RPC client transport over WebSocket.
+func NewWSClient(cfg x.TypedLazyConfig, opts ...Option) (*WSClient, error) {
+	return NewWSClientContext(context.Background(), cfg, opts...)
+}
+
+// NewWSClientContext is NewWSClient with the outgoing metadata of ctx sent
+// as handshake headers.
+func NewWSClientContext(ctx context.Context, cfg x.TypedLazyConfig, opts ...Option) (*WSClient, error) {
+	c := &WSClient{
+		pending: make(map[string]chan *Response),
+		done:    make(chan struct{}),
+	}
+
+	if err := cfg.Unmarshal(&c.config); err != nil {
+		return nil, err
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	wsConfig, err := websocket.NewConfig(serviceURL("ws", c.config.Config), "http://localhost")
+	if err != nil {
+		return nil, err
+	}
+	if md, ok := talk.FromOutgoingContext(ctx); ok {
+		md.SetHeader(wsConfig.Header)
+	}
+
+	conn, err := wsConfig.DialContext(ctx)
+	if err != nil {
+		return nil, talk.NewError(talk.Unavailable, err.Error())
+	}
+	c.conn = conn
+
+	go c.readLoop()
+
+	return c, nil
+}
+
+func (c *WSClient) String() string {
+	return "jsonrpc/ws/client"
+}
+
+func (c *WSClient) Serve(ctx context.Context, endpoints []*talk.Endpoint) error {
+	return talk.NewError(talk.Unimplemented, "client does not support Serve")
+}
+
+func (c *WSClient) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+// Invoke calls the method named endpoint with req as its params.
+func (c *WSClient) Invoke(ctx context.Context, endpoint string, req any, resp any) error {
+	call := &Call{Method: endpoint, Params: req, Result: resp}
+	if err := c.Batch(ctx, call); err != nil {
+		return err
+	}
+	return call.Error
+}
+
+func (c *WSClient) InvokeStream(ctx context.Context, endpoint string, req any) (talk.Stream, error) {
+	return nil, talk.NewError(talk.Unimplemented, "JSON-RPC does not support streams")
+}
+
+// Notify sends a notification: the method is called without waiting for,
+// or learning about, its outcome.
+func (c *WSClient) Notify(ctx context.Context, method string, params any) error {
+	return c.Batch(ctx, &Call{Method: method, Params: params, Notification: true})
+}
+
+// Batch sends calls in a single message and sets the result or error of
+// each once all responses arrived. The returned error reports a failure of
+// the connection or ctx.
+func (c *WSClient) Batch(ctx context.Context, calls ...*Call) error {
+	if len(calls) == 0 {
+		return nil
+	}
+	if c.config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(c.config.Timeout))
+		defer cancel()
+	}
+
+	data, pending, err := encodeCalls(&c.ids, calls)
+	if err != nil {
+		return err
+	}
+
+	// All responses of a batch arrive in one message; a rejected batch is
+	// answered with a single error without ID.
+	ch := make(chan *Response, len(pending)+1)
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return c.err
+	}
+	for id := range pending {
+		c.pending[id] = ch
+	}
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		for id := range pending {
+			delete(c.pending, id)
+		}
+		c.mu.Unlock()
+	}()
+
+	c.sendMu.Lock()
+	err = websocket.Message.Send(c.conn, string(data))
+	c.sendMu.Unlock()
+	if err != nil {
+		return talk.NewError(talk.Unavailable, err.Error())
+	}
+
+	var resps []*Response
+	for len(resps) < len(pending) {
+		select {
+		case resp := <-ch:
+			resps = append(resps, resp)
+			if resp.ID == nil || string(resp.ID) == "null" {
+				resolveCalls(pending, resps)
+				return nil
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-c.done:
+			return c.err
+		}
+	}
+	resolveCalls(pending, resps)
+	return nil
+}
+
+func (c *WSClient) Close() error {
+	return c.conn.Close()
+}
+
+// readLoop dispatches responses to the calls waiting for them, until the
+// connection fails.
+func (c *WSClient) readLoop() {
+	var err error
+	for {
+		var data []byte
+		if err = websocket.Message.Receive(c.conn, &data); err != nil {
+			break
+		}
+		resps, decodeErr := decodeResponses(data)
+		if decodeErr != nil {
+			continue
+		}
+
+		c.mu.Lock()
+		for _, resp := range resps {
+			if resp.ID == nil || string(resp.ID) == "null" {
+				// An error without ID cannot be matched to a call; hand it
+				// to every caller.
+				for _, ch := range c.pending {
+					select {
+					case ch <- resp:
+					default:
+					}
+				}
+				continue
+			}
+			if ch, ok := c.pending[string(resp.ID)]; ok {
+				select {
+				case ch <- resp:
+				default:
+				}
+			}
+		}
+		c.mu.Unlock()
+	}
+
+	c.mu.Lock()
+	c.err = talk.NewError(talk.Unavailable, "connection closed: "+err.Error())
+	c.mu.Unlock()
+	close(c.done)
+}
+
+func init() {
+	ClientFactory.Register("ws", func(cfg x.TypedLazyConfig, opts ...Option) (ClientTransport, error) {
+		return NewWSClient(cfg, opts...)
+	}, "websocket")
+
+	talk.RegisterTransport("jsonrpc/ws", &talk.TransportCreators{
+		Server: func(cfg x.TypedLazyConfig) (talk.Transport, error) {
+			return NewServer(cfg)
+		},
+		Client: func(cfg x.TypedLazyConfig) (talk.Transport, error) {
+			return NewWSClient(cfg)
+		},
+	}, "jsonrpc/websocket")
+}
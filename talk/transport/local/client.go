@@ -0,0 +1,195 @@
+package local
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"go.zoe.im/x"
+	"go.zoe.im/x/talk"
+	"go.zoe.im/x/talk/codec"
+)
+
+// Client implements talk.Transport by calling endpoints of an in-process server.
+// The server is resolved by address on every call, so a client may be created
+// before the server starts serving.
+type Client struct {
+	config ClientConfig
+	codec  codec.Codec
+}
+
+// NewClient creates a new in-process client transport.
+func NewClient(cfg x.TypedLazyConfig, opts ...Option) (*Client, error) {
+	c := &Client{}
+
+	if err := cfg.Unmarshal(&c.config); err != nil {
+		return nil, err
+	}
+
+	if c.config.Addr == "" {
+		c.config.Addr = DefaultAddr
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	if c.codec == nil {
+		c.codec = codec.MustGet("json")
+	}
+
+	return c, nil
+}
+
+func (c *Client) SetCodec(cd codec.Codec) {
+	c.codec = cd
+}
+
+func (c *Client) String() string {
+	return "local/client"
+}
+
+func (c *Client) Serve(ctx context.Context, endpoints []*talk.Endpoint) error {
+	return talk.NewError(talk.Unimplemented, "client does not support Serve")
+}
+
+func (c *Client) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+// Invoke calls a unary endpoint by name or by path.
+func (c *Client) Invoke(ctx context.Context, endpoint string, req any, resp any) error {
+	if c.config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(c.config.Timeout))
+		defer cancel()
+	}
+
+	server, ep, err := c.resolve(endpoint)
+	if err != nil {
+		return err
+	}
+	if ep.IsStreaming() {
+		return talk.NewError(talk.FailedPrecondition, "endpoint is streaming: "+endpoint)
+	}
+
+	reqData, err := c.encode(req)
+	if err != nil {
+		return err
+	}
+
+	respData, err := server.handle(ctx, ep, reqData)
+	if err != nil {
+		return err
+	}
+
+	if resp != nil && len(respData) > 0 {
+		if err := c.codec.Unmarshal(respData, resp); err != nil {
+			return talk.NewError(talk.Internal, "failed to decode response")
+		}
+	}
+
+	return nil
+}
+
+// InvokeStream opens a stream to an endpoint by name or by path. The returned
+// stream also implements talk.ClientStream for client-side streaming.
+func (c *Client) InvokeStream(ctx context.Context, endpoint string, req any) (talk.Stream, error) {
+	server, ep, err := c.resolve(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if !ep.IsStreaming() {
+		return nil, talk.NewError(talk.FailedPrecondition, "endpoint is not streaming: "+endpoint)
+	}
+
+	reqData, err := c.encode(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return server.openStream(ctx, ep, reqData, c.codec)
+}
+
+func (c *Client) Close() error {
+	return nil
+}
+
+func (c *Client) resolve(endpoint string) (*Server, *talk.Endpoint, error) {
+	server, err := lookupServer(c.config.Addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	ep, err := server.lookup(endpoint)
+	if err != nil {
+		return nil, nil, err
+	}
+	return server, ep, nil
+}
+
+func (c *Client) encode(req any) ([]byte, error) {
+	if req == nil {
+		return nil, nil
+	}
+	data, err := c.codec.Marshal(req)
+	if err != nil {
+		return nil, talk.NewError(talk.InvalidArgument, "failed to encode request")
+	}
+	return data, nil
+}
+
+type clientStream struct {
+	codecStream
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error // error returned by the server handler, valid after done
+	closed bool
+}
+
+// Recv receives a message from the server. Once the server handler has
+// returned and all messages are drained, Recv reports the handler's error,
+// or io.EOF if it succeeded.
+func (s *clientStream) Recv(msg any) error {
+	err := s.codecStream.Recv(msg)
+	if err != io.EOF || s.closed {
+		return err
+	}
+	<-s.done
+	if s.err != nil {
+		return talk.ToError(s.err)
+	}
+	return io.EOF
+}
+
+func (s *clientStream) Close() error {
+	s.closed = true
+	err := s.ChanStream.Close()
+	s.cancel()
+	return err
+}
+
+func (s *clientStream) CloseSend() error {
+	return s.ChanStream.CloseSend()
+}
+
+func (s *clientStream) CloseAndRecv(resp any) error {
+	if err := s.CloseSend(); err != nil {
+		return err
+	}
+	return s.Recv(resp)
+}
+
+func init() {
+	ClientFactory.Register("default", func(cfg x.TypedLazyConfig, opts ...Option) (ClientTransport, error) {
+		return NewClient(cfg, opts...)
+	})
+
+	talk.RegisterTransport("local", &talk.TransportCreators{
+		Server: func(cfg x.TypedLazyConfig) (talk.Transport, error) {
+			return NewServer(cfg)
+		},
+		Client: func(cfg x.TypedLazyConfig) (talk.Transport, error) {
+			return NewClient(cfg)
+		},
+	}, "inproc")
+}
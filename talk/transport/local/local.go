@@ -0,0 +1,122 @@
+// Package local provides an in-process transport implementation for talk.
+// Servers and clients sharing the same address within one binary are wired
+// together directly, without touching the network. Requests and responses
+// still go through the codec, so local calls behave like remote ones.
+package local
+
+import (
+	"sync"
+
+	"go.zoe.im/x"
+	"go.zoe.im/x/factory"
+	"go.zoe.im/x/talk"
+	"go.zoe.im/x/talk/codec"
+	"go.zoe.im/x/talk/transport"
+)
+
+// DefaultAddr is used when no address is configured.
+const DefaultAddr = "default"
+
+type Config struct {
+	// Addr is the process-wide name a server listens on and a client dials.
+	Addr string `json:"addr" yaml:"addr"`
+	// BufferSize is the number of messages buffered per stream direction.
+	BufferSize int `json:"buffer_size,omitempty" yaml:"buffer_size"`
+}
+
+type ServerConfig struct {
+	Config `json:",inline" yaml:",inline"`
+}
+
+type ClientConfig struct {
+	Config  `json:",inline" yaml:",inline"`
+	Timeout x.Duration `json:"timeout,omitempty" yaml:"timeout"`
+}
+
+type Option func(any)
+
+func WithCodec(c codec.Codec) Option {
+	return func(v any) {
+		if s, ok := v.(interface{ SetCodec(codec.Codec) }); ok {
+			s.SetCodec(c)
+		}
+	}
+}
+
+var serverFactory = factory.NewFactory[ServerTransport, Option]()
+
+var ServerFactory = struct {
+	Create   func(cfg x.TypedLazyConfig, opts ...Option) (ServerTransport, error)
+	Register func(typeName string, creator factory.Creator[ServerTransport, Option], alias ...string) error
+}{
+	Create:   serverFactory.Create,
+	Register: serverFactory.Register,
+}
+
+var clientFactory = factory.NewFactory[ClientTransport, Option]()
+
+var ClientFactory = struct {
+	Create   func(cfg x.TypedLazyConfig, opts ...Option) (ClientTransport, error)
+	Register func(typeName string, creator factory.Creator[ClientTransport, Option], alias ...string) error
+}{
+	Create:   clientFactory.Create,
+	Register: clientFactory.Register,
+}
+
+type ServerTransport interface {
+	SetCodec(codec.Codec)
+}
+
+type ClientTransport interface {
+	SetCodec(codec.Codec)
+}
+
+// registry maps listening addresses to servers.
+var registry = struct {
+	sync.RWMutex
+	servers map[string]*Server
+}{
+	servers: make(map[string]*Server),
+}
+
+func lookupServer(addr string) (*Server, error) {
+	registry.RLock()
+	s, ok := registry.servers[addr]
+	registry.RUnlock()
+	if !ok {
+		return nil, talk.NewErrorf(talk.Unavailable, "no local server listening on %q", addr)
+	}
+	return s, nil
+}
+
+type localTransportFamily struct{}
+
+func (f *localTransportFamily) CreateServer(cfg x.TypedLazyConfig, opts ...transport.TransportOption) (transport.ServerTransport, error) {
+	server, err := serverFactory.Create(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if full, ok := server.(transport.ServerTransport); ok {
+		return full, nil
+	}
+
+	return nil, talk.NewError(talk.Internal, "local server does not implement transport.ServerTransport")
+}
+
+func (f *localTransportFamily) CreateClient(cfg x.TypedLazyConfig, opts ...transport.TransportOption) (transport.ClientTransport, error) {
+	client, err := clientFactory.Create(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if full, ok := client.(transport.ClientTransport); ok {
+		return full, nil
+	}
+
+	return nil, talk.NewError(talk.Internal, "local client does not implement transport.ClientTransport")
+}
+
+func init() {
+	transport.Factory.RegisterFamily("local", &localTransportFamily{}, "inproc")
+}
@@ -0,0 +1,480 @@
+package local
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.zoe.im/x"
+	"go.zoe.im/x/stat"
+	"go.zoe.im/x/talk"
+	"go.zoe.im/x/talk/transport"
+)
+
+type echoRequest struct {
+	Text string `json:"text"`
+}
+
+type echoResponse struct {
+	Text  string `json:"text"`
+	Count int    `json:"count"`
+}
+
+func newPair(t *testing.T, addr string, endpoints ...*talk.Endpoint) (*Server, *Client) {
+	t.Helper()
+
+	cfg := x.TypedLazyConfig{
+		Config: json.RawMessage(`{"addr": "` + addr + `"}`),
+	}
+
+	server, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+	if err := server.RegisterEndpoints(endpoints); err != nil {
+		t.Fatalf("RegisterEndpoints failed: %v", err)
+	}
+	t.Cleanup(func() { server.Shutdown(context.Background()) })
+
+	client, err := NewClient(cfg)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	return server, client
+}
+
+func TestNewServer(t *testing.T) {
+	server, err := NewServer(x.TypedLazyConfig{Config: json.RawMessage(`{}`)})
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+	if server.String() != "local" {
+		t.Errorf("String() = %q, want %q", server.String(), "local")
+	}
+	if server.Addr() != DefaultAddr {
+		t.Errorf("Addr() = %q, want %q", server.Addr(), DefaultAddr)
+	}
+}
+
+func TestInvoke(t *testing.T) {
+	var gotReq echoRequest
+	ep := talk.NewEndpoint("Echo", func(ctx context.Context, req any) (any, error) {
+		gotReq = req.(echoRequest)
+		return &echoResponse{Text: gotReq.Text, Count: 1}, nil
+	}, talk.WithPath("/echo"))
+	ep.RequestType = reflect.TypeOf(echoRequest{})
+
+	_, client := newPair(t, "test-invoke", ep)
+
+	for _, name := range []string{"Echo", "/echo"} {
+		var resp echoResponse
+		if err := client.Invoke(context.Background(), name, &echoRequest{Text: "hi"}, &resp); err != nil {
+			t.Fatalf("Invoke(%q) failed: %v", name, err)
+		}
+		if resp.Text != "hi" || resp.Count != 1 {
+			t.Errorf("Invoke(%q) resp = %+v", name, resp)
+		}
+		if gotReq.Text != "hi" {
+			t.Errorf("handler got %+v, want decoded request", gotReq)
+		}
+	}
+}
+
+func TestInvoke_MiddlewareAndEndpointContext(t *testing.T) {
+	var order []string
+	var gotEndpoint *talk.Endpoint
+
+	mw := func(next talk.EndpointFunc) talk.EndpointFunc {
+		return func(ctx context.Context, req any) (any, error) {
+			order = append(order, "mw")
+			gotEndpoint = talk.EndpointFromContext(ctx)
+			return next(ctx, req)
+		}
+	}
+
+	ep := talk.NewEndpoint("Ping", func(ctx context.Context, req any) (any, error) {
+		order = append(order, "handler")
+		return "pong", nil
+	}, talk.WithMiddleware(mw))
+
+	_, client := newPair(t, "test-middleware", ep)
+
+	var resp string
+	if err := client.Invoke(context.Background(), "Ping", nil, &resp); err != nil {
+		t.Fatalf("Invoke failed: %v", err)
+	}
+	if resp != "pong" {
+		t.Errorf("resp = %q, want %q", resp, "pong")
+	}
+	if !reflect.DeepEqual(order, []string{"mw", "handler"}) {
+		t.Errorf("order = %v, want [mw handler]", order)
+	}
+	if gotEndpoint != ep {
+		t.Error("endpoint not injected into context")
+	}
+}
+
+func TestInvoke_Metadata(t *testing.T) {
+	ep := talk.NewEndpoint("Whoami", func(ctx context.Context, req any) (any, error) {
+		if _, ok := talk.FromOutgoingContext(ctx); ok {
+			return nil, talk.NewError(talk.Internal, "outgoing metadata leaked into handler")
+		}
+		md, _ := talk.FromIncomingContext(ctx)
+		return &echoResponse{Text: md.Get("x-tenant")}, nil
+	})
+
+	_, client := newPair(t, "test-metadata", ep)
+
+	ctx := talk.AppendToOutgoingContext(context.Background(), "X-Tenant", "acme")
+	var resp echoResponse
+	if err := client.Invoke(ctx, "Whoami", nil, &resp); err != nil {
+		t.Fatalf("Invoke failed: %v", err)
+	}
+	if resp.Text != "acme" {
+		t.Errorf("tenant = %q, want acme", resp.Text)
+	}
+}
+
+func TestInvoke_Tracing(t *testing.T) {
+	var spans []*talk.Span
+	obs := talk.NewObserver(
+		talk.WithMetricsRegistry(stat.NewRegistry()),
+		talk.WithSpanExporter(func(s *talk.Span) { spans = append(spans, s) }),
+	)
+
+	ep := talk.NewEndpoint("Ping", func(ctx context.Context, req any) (any, error) {
+		return &echoResponse{Text: "pong"}, nil
+	}, talk.WithMiddleware(obs.Middleware()))
+
+	_, transportClient := newPair(t, "test-tracing", ep)
+	client := talk.NewClient(transportClient, talk.WithClientObserver(obs))
+
+	var resp echoResponse
+	if err := client.Call(context.Background(), "Ping", nil, &resp); err != nil {
+		t.Fatalf("Call failed: %v", err)
+	}
+
+	if len(spans) != 2 {
+		t.Fatalf("exported %d spans, want 2", len(spans))
+	}
+	server, caller := spans[0], spans[1]
+	if server.Kind != talk.SpanKindServer || caller.Kind != talk.SpanKindClient {
+		t.Fatalf("span kinds = %v, %v", server.Kind, caller.Kind)
+	}
+	if server.Context.TraceID != caller.Context.TraceID || server.Parent != caller.Context {
+		t.Errorf("server span %+v is not a child of client span %+v", server.Context, caller.Context)
+	}
+}
+
+func TestInvoke_Errors(t *testing.T) {
+	ep := talk.NewEndpoint("Fail", func(ctx context.Context, req any) (any, error) {
+		return nil, talk.NewError(talk.NotFound, "missing")
+	})
+
+	_, client := newPair(t, "test-errors", ep)
+
+	err := client.Invoke(context.Background(), "Fail", nil, nil)
+	if talkErr, ok := talk.IsError(err); !ok || talkErr.Code != talk.NotFound {
+		t.Errorf("expected NotFound, got %v", err)
+	}
+
+	err = client.Invoke(context.Background(), "Unknown", nil, nil)
+	if talkErr, ok := talk.IsError(err); !ok || talkErr.Code != talk.NotFound {
+		t.Errorf("expected NotFound for unknown endpoint, got %v", err)
+	}
+
+	other, _ := NewClient(x.TypedLazyConfig{Config: json.RawMessage(`{"addr": "nobody"}`)})
+	err = other.Invoke(context.Background(), "Fail", nil, nil)
+	if talkErr, ok := talk.IsError(err); !ok || talkErr.Code != talk.Unavailable {
+		t.Errorf("expected Unavailable without server, got %v", err)
+	}
+}
+
+func TestRegisterEndpoints_AddrInUse(t *testing.T) {
+	newPair(t, "test-in-use")
+
+	other, _ := NewServer(x.TypedLazyConfig{Config: json.RawMessage(`{"addr": "test-in-use"}`)})
+	ep := talk.NewEndpoint("Echo", func(ctx context.Context, req any) (any, error) {
+		return req, nil
+	}, talk.WithPath("/echo"))
+	err := other.RegisterEndpoints([]*talk.Endpoint{ep})
+	if talkErr, ok := talk.IsError(err); !ok || talkErr.Code != talk.AlreadyExists {
+		t.Errorf("expected AlreadyExists, got %v", err)
+	}
+	if _, err := other.lookup("Echo"); err == nil {
+		t.Error("failed RegisterEndpoints left endpoints behind")
+	}
+}
+
+func TestServe_Shutdown(t *testing.T) {
+	server, _ := NewServer(x.TypedLazyConfig{Config: json.RawMessage(`{"addr": "test-serve"}`)})
+
+	errc := make(chan error, 1)
+	go func() { errc <- server.Serve(context.Background(), nil) }()
+	time.Sleep(10 * time.Millisecond)
+
+	if err := server.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Errorf("Serve returned %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Serve did not return after Shutdown")
+	}
+}
+
+func TestStream_ServerSide(t *testing.T) {
+	ep := talk.NewStreamEndpoint("Count", func(ctx context.Context, req any, stream talk.Stream) error {
+		r := req.(echoRequest)
+		for i := 0; i < 3; i++ {
+			if err := stream.Send(&echoResponse{Text: r.Text, Count: i}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, talk.StreamServerSide)
+	ep.RequestType = reflect.TypeOf(echoRequest{})
+
+	_, client := newPair(t, "test-server-stream", ep)
+
+	stream, err := client.InvokeStream(context.Background(), "Count", &echoRequest{Text: "tick"})
+	if err != nil {
+		t.Fatalf("InvokeStream failed: %v", err)
+	}
+	defer stream.Close()
+
+	for i := 0; i < 3; i++ {
+		var resp echoResponse
+		if err := stream.Recv(&resp); err != nil {
+			t.Fatalf("Recv %d failed: %v", i, err)
+		}
+		if resp.Text != "tick" || resp.Count != i {
+			t.Errorf("Recv %d = %+v", i, resp)
+		}
+	}
+
+	var resp echoResponse
+	if err := stream.Recv(&resp); err != io.EOF {
+		t.Errorf("expected io.EOF after stream end, got %v", err)
+	}
+}
+
+func TestStream_ClientSide(t *testing.T) {
+	ep := talk.NewStreamEndpoint("Sum", func(ctx context.Context, req any, stream talk.Stream) error {
+		total := 0
+		for {
+			var n int
+			err := stream.Recv(&n)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			total += n
+		}
+		return stream.Send(total)
+	}, talk.StreamClientSide)
+
+	_, client := newPair(t, "test-client-stream", ep)
+
+	stream, err := client.InvokeStream(context.Background(), "Sum", nil)
+	if err != nil {
+		t.Fatalf("InvokeStream failed: %v", err)
+	}
+	defer stream.Close()
+
+	for i := 1; i <= 4; i++ {
+		if err := stream.Send(i); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+	}
+
+	cs, ok := stream.(talk.ClientStream)
+	if !ok {
+		t.Fatal("stream does not implement talk.ClientStream")
+	}
+
+	var total int
+	if err := cs.CloseAndRecv(&total); err != nil {
+		t.Fatalf("CloseAndRecv failed: %v", err)
+	}
+	if total != 10 {
+		t.Errorf("total = %d, want 10", total)
+	}
+}
+
+func TestStream_Bidirect(t *testing.T) {
+	ep := talk.NewStreamEndpoint("Chat", func(ctx context.Context, req any, stream talk.Stream) error {
+		for {
+			var msg echoRequest
+			err := stream.Recv(&msg)
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err := stream.Send(&echoResponse{Text: "re: " + msg.Text}); err != nil {
+				return err
+			}
+		}
+	}, talk.StreamBidirect)
+
+	_, client := newPair(t, "test-bidi-stream", ep)
+
+	stream, err := client.InvokeStream(context.Background(), "Chat", nil)
+	if err != nil {
+		t.Fatalf("InvokeStream failed: %v", err)
+	}
+	defer stream.Close()
+
+	for _, text := range []string{"a", "b", "c"} {
+		if err := stream.Send(&echoRequest{Text: text}); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+		var resp echoResponse
+		if err := stream.Recv(&resp); err != nil {
+			t.Fatalf("Recv failed: %v", err)
+		}
+		if resp.Text != "re: "+text {
+			t.Errorf("resp = %q, want %q", resp.Text, "re: "+text)
+		}
+	}
+
+	stream.(talk.ClientStream).CloseSend()
+
+	var resp echoResponse
+	if err := stream.Recv(&resp); err != io.EOF {
+		t.Errorf("expected io.EOF after CloseSend, got %v", err)
+	}
+}
+
+func TestStream_HandlerError(t *testing.T) {
+	ep := talk.NewStreamEndpoint("Broken", func(ctx context.Context, req any, stream talk.Stream) error {
+		stream.Send("first")
+		return talk.NewError(talk.Internal, "boom")
+	}, talk.StreamServerSide)
+
+	_, client := newPair(t, "test-stream-error", ep)
+
+	stream, err := client.InvokeStream(context.Background(), "Broken", nil)
+	if err != nil {
+		t.Fatalf("InvokeStream failed: %v", err)
+	}
+	defer stream.Close()
+
+	var msg string
+	if err := stream.Recv(&msg); err != nil || msg != "first" {
+		t.Fatalf("Recv = %q, %v", msg, err)
+	}
+
+	err = stream.Recv(&msg)
+	if talkErr, ok := talk.IsError(err); !ok || talkErr.Code != talk.Internal {
+		t.Errorf("expected Internal error from handler, got %v", err)
+	}
+}
+
+func TestStream_CloseCancelsHandler(t *testing.T) {
+	handlerDone := make(chan error, 1)
+	ep := talk.NewStreamEndpoint("Forever", func(ctx context.Context, req any, stream talk.Stream) error {
+		<-ctx.Done()
+		handlerDone <- ctx.Err()
+		return ctx.Err()
+	}, talk.StreamServerSide)
+
+	_, client := newPair(t, "test-stream-cancel", ep)
+
+	stream, err := client.InvokeStream(context.Background(), "Forever", nil)
+	if err != nil {
+		t.Fatalf("InvokeStream failed: %v", err)
+	}
+	stream.Close()
+
+	if err := <-handlerDone; err != context.Canceled {
+		t.Errorf("handler ctx err = %v, want context.Canceled", err)
+	}
+}
+
+func TestTalkClientServer(t *testing.T) {
+	cfg := x.TypedLazyConfig{
+		Type:   "local",
+		Config: json.RawMessage(`{"addr": "test-talk"}`),
+	}
+
+	server, err := talk.NewServerFromConfig(cfg)
+	if err != nil {
+		t.Fatalf("NewServerFromConfig failed: %v", err)
+	}
+	server.RegisterEndpoints(talk.NewEndpoint("Hello", func(ctx context.Context, req any) (any, error) {
+		return "world", nil
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	serveDone := make(chan error, 1)
+	go func() { serveDone <- server.Serve(ctx) }()
+
+	client, err := talk.NewClientFromConfig(x.TypedLazyConfig{
+		Type:   "inproc",
+		Config: json.RawMessage(`{"addr": "test-talk"}`),
+	})
+	if err != nil {
+		t.Fatalf("NewClientFromConfig failed: %v", err)
+	}
+	defer client.Close()
+
+	// Serve registers asynchronously; poll until reachable
+	var resp string
+	for i := 0; i < 100; i++ {
+		err = client.Call(ctx, "Hello", nil, &resp)
+		if talkErr, ok := talk.IsError(err); !ok || talkErr.Code != talk.Unavailable {
+			break
+		}
+		<-time.After(time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("Call failed: %v", err)
+	}
+	if resp != "world" {
+		t.Errorf("resp = %q, want %q", resp, "world")
+	}
+
+	cancel()
+	if err := <-serveDone; err != nil {
+		t.Errorf("Serve returned %v", err)
+	}
+}
+
+func TestFactoryRegistration(t *testing.T) {
+	cfg := x.TypedLazyConfig{
+		Type:   "local",
+		Config: json.RawMessage(`{"addr": "test-factory"}`),
+	}
+
+	server, err := transport.Factory.CreateServer(cfg)
+	if err != nil {
+		t.Fatalf("CreateServer failed: %v", err)
+	}
+	if server.String() != "local" {
+		t.Errorf("server String() = %q, want %q", server.String(), "local")
+	}
+
+	cfg.Type = "inproc"
+	client, err := transport.Factory.CreateClient(cfg)
+	if err != nil {
+		t.Fatalf("CreateClient failed: %v", err)
+	}
+	if client.String() != "local/client" {
+		t.Errorf("client String() = %q, want %q", client.String(), "local/client")
+	}
+}
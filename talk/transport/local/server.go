@@ -0,0 +1,286 @@
+package local
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"sync"
+
+	"go.zoe.im/x"
+	"go.zoe.im/x/talk"
+	"go.zoe.im/x/talk/codec"
+)
+
+const defaultBufferSize = 16
+
+// Server implements talk.Transport by exposing endpoints to in-process clients.
+type Server struct {
+	config ServerConfig
+	codec  codec.Codec
+
+	mu        sync.RWMutex
+	endpoints map[string]*talk.Endpoint
+	paths     map[string]*talk.Endpoint
+
+	// done is closed by Shutdown to end Serve.
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+// NewServer creates a new in-process server transport.
+func NewServer(cfg x.TypedLazyConfig, opts ...Option) (*Server, error) {
+	s := &Server{
+		endpoints: make(map[string]*talk.Endpoint),
+		paths:     make(map[string]*talk.Endpoint),
+		done:      make(chan struct{}),
+	}
+
+	if err := cfg.Unmarshal(&s.config); err != nil {
+		return nil, err
+	}
+
+	if s.config.Addr == "" {
+		s.config.Addr = DefaultAddr
+	}
+	if s.config.BufferSize <= 0 {
+		s.config.BufferSize = defaultBufferSize
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if s.codec == nil {
+		s.codec = codec.MustGet("json")
+	}
+
+	return s, nil
+}
+
+func (s *Server) SetCodec(c codec.Codec) {
+	s.codec = c
+}
+
+func (s *Server) String() string {
+	return "local"
+}
+
+// Addr returns the address the server listens on.
+func (s *Server) Addr() string {
+	return s.config.Addr
+}
+
+// RegisterEndpoints makes the endpoints reachable by clients dialing the
+// server's address. Unlike Serve it does not block, which lets tests wire a
+// client and server without any synchronization.
+func (s *Server) RegisterEndpoints(endpoints []*talk.Endpoint) error {
+	registry.Lock()
+	defer registry.Unlock()
+	if other, ok := registry.servers[s.config.Addr]; ok && other != s {
+		return talk.NewErrorf(talk.AlreadyExists, "local address %q already in use", s.config.Addr)
+	}
+
+	s.mu.Lock()
+	for _, ep := range endpoints {
+		s.endpoints[ep.Name] = ep
+		if ep.Path != "" {
+			if _, exists := s.paths[ep.Path]; !exists {
+				s.paths[ep.Path] = ep
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	registry.servers[s.config.Addr] = s
+	return nil
+}
+
+func (s *Server) Serve(ctx context.Context, endpoints []*talk.Endpoint) error {
+	if err := s.RegisterEndpoints(endpoints); err != nil {
+		return err
+	}
+
+	select {
+	case <-ctx.Done():
+		return s.Shutdown(context.Background())
+	case <-s.done:
+		return nil
+	}
+}
+
+// Shutdown makes the server unreachable and ends Serve.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.doneOnce.Do(func() { close(s.done) })
+
+	registry.Lock()
+	defer registry.Unlock()
+	if registry.servers[s.config.Addr] == s {
+		delete(registry.servers, s.config.Addr)
+	}
+	return nil
+}
+
+func (s *Server) Invoke(ctx context.Context, endpoint string, req any, resp any) error {
+	return talk.NewError(talk.Unimplemented, "server does not support Invoke")
+}
+
+func (s *Server) InvokeStream(ctx context.Context, endpoint string, req any) (talk.Stream, error) {
+	return nil, talk.NewError(talk.Unimplemented, "server does not support InvokeStream")
+}
+
+func (s *Server) Close() error {
+	return nil
+}
+
+// lookup resolves an endpoint by path (when it starts with "/") or by name.
+func (s *Server) lookup(endpoint string) (*talk.Endpoint, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var ep *talk.Endpoint
+	if strings.HasPrefix(endpoint, "/") {
+		ep = s.paths[endpoint]
+	} else {
+		ep = s.endpoints[endpoint]
+	}
+	if ep == nil {
+		return nil, talk.NewError(talk.NotFound, "endpoint not found: "+endpoint)
+	}
+	return ep, nil
+}
+
+// handle runs a unary endpoint on encoded request data and returns the
+// encoded response.
+func (s *Server) handle(ctx context.Context, ep *talk.Endpoint, data []byte) ([]byte, error) {
+	if ep.Handler == nil {
+		return nil, talk.NewError(talk.Unimplemented, "no handler configured")
+	}
+
+	req, err := s.decodeRequest(ep, data)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := ep.WrappedHandler()(incomingContext(ctx, ep), req)
+	if err != nil {
+		return nil, talk.ToError(err)
+	}
+
+	if resp == nil {
+		return nil, nil
+	}
+
+	out, err := s.codec.Marshal(resp)
+	if err != nil {
+		return nil, talk.NewError(talk.Internal, "failed to encode response")
+	}
+	return out, nil
+}
+
+// openStream starts the stream handler of ep and returns the client side of
+// the stream. The handler runs until it returns or the stream is closed.
+func (s *Server) openStream(ctx context.Context, ep *talk.Endpoint, data []byte, clientCodec codec.Codec) (*clientStream, error) {
+	if ep.StreamHandler == nil {
+		return nil, talk.NewError(talk.Unimplemented, "no stream handler configured")
+	}
+
+	req, err := s.decodeRequest(ep, data)
+	if err != nil {
+		return nil, err
+	}
+
+	// Closing the client side cancels the handler's context as well.
+	ctx, cancel := context.WithCancel(incomingContext(ctx, ep))
+	clientSide, serverSide := talk.NewChanStreamPair[[]byte](ctx, s.config.BufferSize)
+
+	cs := &clientStream{
+		codecStream: codecStream{ChanStream: clientSide, codec: clientCodec},
+		cancel:      cancel,
+		done:        make(chan struct{}),
+	}
+	ss := &serverStream{
+		codecStream: codecStream{ChanStream: serverSide, codec: s.codec},
+	}
+
+	go func() {
+		err := ep.WrappedStreamHandler()(serverSide.Context(), req, ss)
+		cs.err = err
+		close(cs.done)
+		serverSide.Close()
+	}()
+
+	return cs, nil
+}
+
+// incomingContext turns the caller's outgoing metadata into the handler's
+// incoming metadata, as a network round trip would, and injects the endpoint.
+func incomingContext(ctx context.Context, ep *talk.Endpoint) context.Context {
+	md, _ := talk.FromOutgoingContext(ctx)
+	ctx = talk.NewOutgoingContext(ctx, nil)
+	ctx = talk.NewIncomingContext(ctx, md.Clone())
+	ctx = talk.NewPeerContext(ctx, "local")
+	return talk.WithEndpointContext(ctx, ep)
+}
+
+func (s *Server) decodeRequest(ep *talk.Endpoint, data []byte) (any, error) {
+	if ep.RequestType == nil {
+		if len(data) == 0 {
+			return nil, nil
+		}
+		var req any
+		if err := s.codec.Unmarshal(data, &req); err != nil {
+			return nil, talk.NewError(talk.InvalidArgument, "failed to decode request")
+		}
+		return req, nil
+	}
+
+	if len(data) == 0 {
+		// Ensure request struct is instantiated for struct types even without body
+		if ep.RequestType.Kind() == reflect.Struct {
+			return reflect.New(ep.RequestType).Elem().Interface(), nil
+		}
+		return nil, nil
+	}
+
+	reqVal := reflect.New(ep.RequestType).Interface()
+	if err := s.codec.Unmarshal(data, reqVal); err != nil {
+		return nil, talk.NewError(talk.InvalidArgument, "failed to decode request")
+	}
+	return reflect.ValueOf(reqVal).Elem().Interface(), nil
+}
+
+// codecStream carries encoded messages over a channel-based stream.
+type codecStream struct {
+	*talk.ChanStream[[]byte]
+	codec codec.Codec
+}
+
+func (s *codecStream) Send(msg any) error {
+	data, err := s.codec.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return s.ChanStream.Send(data)
+}
+
+func (s *codecStream) Recv(msg any) error {
+	var data []byte
+	if err := s.ChanStream.Recv(&data); err != nil {
+		return err
+	}
+	return s.codec.Unmarshal(data, msg)
+}
+
+type serverStream struct {
+	codecStream
+}
+
+func (s *serverStream) SendHeader(metadata map[string]string) error {
+	return nil
+}
+
+func init() {
+	ServerFactory.Register("default", func(cfg x.TypedLazyConfig, opts ...Option) (ServerTransport, error) {
+		return NewServer(cfg, opts...)
+	})
+}
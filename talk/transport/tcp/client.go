@@ -0,0 +1,291 @@
+package tcp
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"go.zoe.im/x"
+	"go.zoe.im/x/talk"
+	"go.zoe.im/x/talk/codec"
+)
+
+// Client implements talk.Transport over a single TCP connection, which is
+// dialed on first use and redialed once it breaks or the server drains it.
+type Client struct {
+	config    ClientConfig
+	codec     codec.Codec
+	tlsConfig *tls.Config
+
+	mu     sync.Mutex
+	sess   *session
+	closed bool
+}
+
+// NewClient creates a new TCP client transport.
+func NewClient(cfg x.TypedLazyConfig, opts ...Option) (*Client, error) {
+	c := &Client{}
+
+	if err := cfg.Unmarshal(&c.config); err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := c.config.tlsConfig(false)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig == nil && c.config.TLS {
+		tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	if tlsConfig != nil && c.config.TLSServerName != "" {
+		tlsConfig.ServerName = c.config.TLSServerName
+	}
+	c.tlsConfig = tlsConfig
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	if c.codec == nil {
+		c.codec = codec.MustGet("json")
+	}
+
+	return c, nil
+}
+
+func (c *Client) SetCodec(cd codec.Codec) {
+	c.codec = cd
+}
+
+func (c *Client) setTLSConfig(cfg *tls.Config) {
+	c.tlsConfig = cfg
+}
+
+func (c *Client) String() string {
+	return "tcp/client"
+}
+
+func (c *Client) Serve(ctx context.Context, endpoints []*talk.Endpoint) error {
+	return talk.NewError(talk.Unimplemented, "client does not support Serve")
+}
+
+func (c *Client) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+// session returns the connection to open streams on, dialing it if there
+// is none or the current one is closed or draining.
+func (c *Client) session(ctx context.Context) (*session, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil, talk.NewError(talk.Unavailable, "client is closed")
+	}
+	if c.sess != nil && c.sess.usable() {
+		return c.sess, nil
+	}
+
+	if c.config.DialTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(c.config.DialTimeout))
+		defer cancel()
+	}
+
+	var conn net.Conn
+	var err error
+	if c.tlsConfig != nil {
+		d := &tls.Dialer{Config: c.tlsConfig}
+		conn, err = d.DialContext(ctx, "tcp", c.config.Addr)
+	} else {
+		var d net.Dialer
+		conn, err = d.DialContext(ctx, "tcp", c.config.Addr)
+	}
+	if err != nil {
+		return nil, talk.NewError(talk.Unavailable, err.Error())
+	}
+
+	c.sess = newSession(conn, c.config.Config, nil)
+	return c.sess, nil
+}
+
+// open opens a stream, retrying once on a new connection if the current
+// one became unusable in between.
+func (c *Client) open(ctx context.Context, endpoint string, endStream bool) (*stream, error) {
+	hdr := openHeader{Endpoint: endpoint, ContentType: c.codec.ContentType()}
+	if md, ok := talk.FromOutgoingContext(ctx); ok {
+		hdr.Metadata = md
+	}
+
+	for attempt := 0; ; attempt++ {
+		sess, err := c.session(ctx)
+		if err != nil {
+			return nil, err
+		}
+		st, err := sess.open(ctx, hdr, endStream)
+		if err == errSessionUnusable && attempt == 0 {
+			continue
+		}
+		return st, err
+	}
+}
+
+// Invoke calls a unary endpoint by name or by path.
+func (c *Client) Invoke(ctx context.Context, endpoint string, req any, resp any) error {
+	if c.config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(c.config.Timeout))
+		defer cancel()
+	}
+
+	reqData, err := c.encode(req)
+	if err != nil {
+		return err
+	}
+
+	st, err := c.open(ctx, endpoint, reqData == nil)
+	if err != nil {
+		return err
+	}
+	defer st.abort(talk.NewError(talk.Cancelled, "call cancelled"))
+
+	if reqData != nil {
+		if err := st.send(reqData, true); err != nil {
+			return c.streamError(ctx, err)
+		}
+	}
+
+	data, err := st.recv()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return c.streamError(ctx, err)
+	}
+	// Wait for the trailer, which may still report an error.
+	if _, err := st.recv(); err != io.EOF {
+		if err == nil {
+			return talk.NewError(talk.Internal, "unexpected message after response")
+		}
+		return c.streamError(ctx, err)
+	}
+
+	if resp != nil && len(data) > 0 {
+		if err := c.codec.Unmarshal(data, resp); err != nil {
+			return talk.NewError(talk.Internal, "failed to decode response")
+		}
+	}
+	return nil
+}
+
+// streamError maps the error of a stream operation, reporting the
+// caller's context error rather than the cancelled stream.
+func (c *Client) streamError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		if ctxErr == context.DeadlineExceeded {
+			return talk.NewError(talk.DeadlineExceeded, "call timed out")
+		}
+		return talk.NewError(talk.Cancelled, "call cancelled")
+	}
+	return talk.ToError(err)
+}
+
+// InvokeStream opens a stream to an endpoint by name or by path and sends
+// req as first message if it is not nil. The returned stream also
+// implements talk.ClientStream for client-side streaming.
+func (c *Client) InvokeStream(ctx context.Context, endpoint string, req any) (talk.Stream, error) {
+	reqData, err := c.encode(req)
+	if err != nil {
+		return nil, err
+	}
+
+	st, err := c.open(ctx, endpoint, false)
+	if err != nil {
+		return nil, err
+	}
+	if reqData != nil {
+		if err := st.send(reqData, false); err != nil {
+			st.abort(talk.NewError(talk.Cancelled, "stream closed"))
+			return nil, talk.ToError(err)
+		}
+	}
+
+	cs := &clientStream{codecStream{stream: st, codec: c.codec}}
+	go func() {
+		// Reset the stream when the caller's context ends first.
+		<-st.ctx.Done()
+		if ctx.Err() != nil {
+			st.abort(talk.NewError(talk.Cancelled, "stream cancelled"))
+		}
+	}()
+	return cs, nil
+}
+
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	if c.sess != nil {
+		c.sess.close(talk.NewError(talk.Unavailable, "client closed"))
+		c.sess = nil
+	}
+	return nil
+}
+
+func (c *Client) encode(req any) ([]byte, error) {
+	if req == nil {
+		return nil, nil
+	}
+	data, err := c.codec.Marshal(req)
+	if err != nil {
+		return nil, talk.NewError(talk.InvalidArgument, "failed to encode request")
+	}
+	return data, nil
+}
+
+type clientStream struct {
+	codecStream
+}
+
+func (s *clientStream) Recv(msg any) error {
+	err := s.codecStream.Recv(msg)
+	if err != nil && err != io.EOF {
+		return talk.ToError(err)
+	}
+	return err
+}
+
+// Close abandons the stream, telling the server to stop the handler.
+func (s *clientStream) Close() error {
+	s.abort(talk.NewError(talk.Cancelled, "stream closed"))
+	return nil
+}
+
+func (s *clientStream) CloseSend() error {
+	return s.closeSend()
+}
+
+func (s *clientStream) CloseAndRecv(resp any) error {
+	if err := s.CloseSend(); err != nil {
+		return err
+	}
+	return s.Recv(resp)
+}
+
+func init() {
+	ClientFactory.Register("default", func(cfg x.TypedLazyConfig, opts ...Option) (ClientTransport, error) {
+		return NewClient(cfg, opts...)
+	})
+
+	talk.RegisterTransport("tcp", &talk.TransportCreators{
+		Server: func(cfg x.TypedLazyConfig) (talk.Transport, error) {
+			return NewServer(cfg)
+		},
+		Client: func(cfg x.TypedLazyConfig) (talk.Transport, error) {
+			return NewClient(cfg)
+		},
+	})
+}
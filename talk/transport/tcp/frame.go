@@ -0,0 +1,128 @@
+package tcp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"go.zoe.im/x/talk"
+)
+
+// Frames start with a fixed header:
+//
+//	length    uint32  payload length
+//	stream    uint32  stream ID, 0 for connection frames
+//	type      uint8
+//	flags     uint8
+//
+// followed by the payload. Integers are big-endian.
+const frameHeaderSize = 10
+
+type frameType uint8
+
+const (
+	// frameOpen opens a stream; its payload is the JSON-encoded
+	// openHeader.
+	frameOpen frameType = iota + 1
+	// frameData carries one codec-encoded message.
+	frameData
+	// frameTrailer ends a stream from the server; its payload is the
+	// JSON-encoded *talk.Error of a failed call, or empty.
+	frameTrailer
+	// frameReset abandons a stream, e.g. on client cancellation.
+	frameReset
+	// frameWindow grants the peer the number of bytes in its payload to
+	// send on the stream.
+	frameWindow
+	// framePing is a keepalive; it is answered with flagAck set.
+	framePing
+	// frameGoAway announces the server is shutting down: no new stream
+	// will be accepted on the connection.
+	frameGoAway
+)
+
+func (t frameType) String() string {
+	switch t {
+	case frameOpen:
+		return "OPEN"
+	case frameData:
+		return "DATA"
+	case frameTrailer:
+		return "TRAILER"
+	case frameReset:
+		return "RESET"
+	case frameWindow:
+		return "WINDOW"
+	case framePing:
+		return "PING"
+	case frameGoAway:
+		return "GOAWAY"
+	default:
+		return fmt.Sprintf("FRAME(%d)", t)
+	}
+}
+
+const (
+	// flagEndStream on an open or data frame closes the sender's side of
+	// the stream.
+	flagEndStream uint8 = 1 << iota
+	// flagAck marks the answer to a ping.
+	flagAck
+)
+
+type frame struct {
+	stream  uint32
+	typ     frameType
+	flags   uint8
+	payload []byte
+}
+
+// openHeader is the payload of an open frame.
+type openHeader struct {
+	// Endpoint is the endpoint name, or its path if it starts with "/".
+	Endpoint    string        `json:"endpoint"`
+	ContentType string        `json:"content_type,omitempty"`
+	Metadata    talk.Metadata `json:"metadata,omitempty"`
+}
+
+// readFrame reads a frame from r, rejecting payloads over maxSize bytes.
+func readFrame(r io.Reader, maxSize int) (frame, error) {
+	var hdr [frameHeaderSize]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return frame{}, err
+	}
+	f := frame{
+		stream: binary.BigEndian.Uint32(hdr[4:8]),
+		typ:    frameType(hdr[8]),
+		flags:  hdr[9],
+	}
+	size := binary.BigEndian.Uint32(hdr[0:4])
+	if int64(size) > int64(maxSize) {
+		return frame{}, talk.NewErrorf(talk.ResourceExhausted, "%s frame of %d bytes exceeds %d", f.typ, size, maxSize)
+	}
+	if size > 0 {
+		f.payload = make([]byte, size)
+		if _, err := io.ReadFull(r, f.payload); err != nil {
+			return frame{}, err
+		}
+	}
+	return f, nil
+}
+
+// writeFrame writes f to w.
+func writeFrame(w io.Writer, f frame) error {
+	var hdr [frameHeaderSize]byte
+	binary.BigEndian.PutUint32(hdr[0:4], uint32(len(f.payload)))
+	binary.BigEndian.PutUint32(hdr[4:8], f.stream)
+	hdr[8] = byte(f.typ)
+	hdr[9] = f.flags
+	if _, err := w.Write(hdr[:]); err != nil {
+		return err
+	}
+	if len(f.payload) > 0 {
+		if _, err := w.Write(f.payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
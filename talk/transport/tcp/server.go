@@ -0,0 +1,346 @@
+package tcp
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"go.zoe.im/x"
+	"go.zoe.im/x/talk"
+	"go.zoe.im/x/talk/codec"
+)
+
+// Server implements talk.Transport over TCP connections.
+type Server struct {
+	config    ServerConfig
+	codec     codec.Codec
+	tlsConfig *tls.Config
+
+	mu        sync.Mutex
+	listener  net.Listener
+	sessions  map[*session]struct{}
+	endpoints map[string]*talk.Endpoint
+	paths     map[string]*talk.Endpoint
+	closing   bool
+}
+
+// NewServer creates a new TCP server transport.
+func NewServer(cfg x.TypedLazyConfig, opts ...Option) (*Server, error) {
+	s := &Server{
+		sessions:  make(map[*session]struct{}),
+		endpoints: make(map[string]*talk.Endpoint),
+		paths:     make(map[string]*talk.Endpoint),
+	}
+
+	if err := cfg.Unmarshal(&s.config); err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := s.config.tlsConfig(true)
+	if err != nil {
+		return nil, err
+	}
+	s.tlsConfig = tlsConfig
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if s.codec == nil {
+		s.codec = codec.MustGet("json")
+	}
+
+	return s, nil
+}
+
+func (s *Server) SetCodec(c codec.Codec) {
+	s.codec = c
+}
+
+func (s *Server) setTLSConfig(cfg *tls.Config) {
+	s.tlsConfig = cfg
+}
+
+func (s *Server) String() string {
+	return "tcp"
+}
+
+// Addr returns the address the server listens on, once serving.
+func (s *Server) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+func (s *Server) Serve(ctx context.Context, endpoints []*talk.Endpoint) error {
+	ln, err := net.Listen("tcp", s.config.Addr)
+	if err != nil {
+		return err
+	}
+	if s.tlsConfig != nil {
+		ln = tls.NewListener(ln, s.tlsConfig)
+	}
+
+	s.mu.Lock()
+	for _, ep := range endpoints {
+		s.endpoints[ep.Name] = ep
+		if ep.Path != "" {
+			if _, exists := s.paths[ep.Path]; !exists {
+				s.paths[ep.Path] = ep
+			}
+		}
+	}
+	s.listener = ln
+	s.mu.Unlock()
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- s.acceptLoop(ln)
+		close(errCh)
+	}()
+
+	select {
+	case <-ctx.Done():
+		return s.Shutdown(context.Background())
+	case err := <-errCh:
+		return err
+	}
+}
+
+func (s *Server) acceptLoop(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closing := s.closing
+			s.mu.Unlock()
+			if closing {
+				return nil
+			}
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+
+		sess := newSession(conn, s.config.Config, s.serveStream)
+		s.mu.Lock()
+		if s.closing {
+			s.mu.Unlock()
+			sess.close(talk.NewError(talk.Unavailable, "server is shutting down"))
+			continue
+		}
+		s.sessions[sess] = struct{}{}
+		s.mu.Unlock()
+
+		go func() {
+			<-sess.ctx.Done()
+			s.mu.Lock()
+			delete(s.sessions, sess)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+// Shutdown stops accepting connections and tells clients to open no more
+// streams, then waits for the active ones to finish before closing the
+// connections, or until ctx is done.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closing = true
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	sessions := make([]*session, 0, len(s.sessions))
+	for sess := range s.sessions {
+		sessions = append(sessions, sess)
+	}
+	s.mu.Unlock()
+
+	for _, sess := range sessions {
+		sess.drain()
+	}
+
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	var err error
+wait:
+	for {
+		active := 0
+		for _, sess := range sessions {
+			active += sess.active()
+		}
+		if active == 0 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			break wait
+		case <-ticker.C:
+		}
+	}
+
+	for _, sess := range sessions {
+		sess.close(talk.NewError(talk.Unavailable, "server shut down"))
+	}
+	return err
+}
+
+func (s *Server) Invoke(ctx context.Context, endpoint string, req any, resp any) error {
+	return talk.NewError(talk.Unimplemented, "server does not support Invoke")
+}
+
+func (s *Server) InvokeStream(ctx context.Context, endpoint string, req any) (talk.Stream, error) {
+	return nil, talk.NewError(talk.Unimplemented, "server does not support InvokeStream")
+}
+
+func (s *Server) Close() error {
+	return nil
+}
+
+// lookup resolves an endpoint by path (when it starts with "/") or by name.
+func (s *Server) lookup(endpoint string) (*talk.Endpoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var ep *talk.Endpoint
+	if strings.HasPrefix(endpoint, "/") {
+		ep = s.paths[endpoint]
+	} else {
+		ep = s.endpoints[endpoint]
+	}
+	if ep == nil {
+		return nil, talk.NewError(talk.NotFound, "endpoint not found: "+endpoint)
+	}
+	return ep, nil
+}
+
+// serveStream runs the endpoint a stream was opened for, and ends the
+// stream with its error.
+func (s *Server) serveStream(st *stream, hdr openHeader) {
+	ep, err := s.lookup(hdr.Endpoint)
+	if err != nil {
+		st.finish(err)
+		return
+	}
+
+	ctx := talk.NewIncomingContext(st.ctx, hdr.Metadata)
+	ctx = talk.NewPeerContext(ctx, st.sess.conn.RemoteAddr().String())
+	ctx = talk.WithEndpointContext(ctx, ep)
+	cs := &serverStream{codecStream{stream: st, codec: codec.FromContentType(hdr.ContentType, s.codec)}}
+
+	if ep.IsStreaming() {
+		st.finish(s.runStream(ctx, ep, cs))
+		return
+	}
+	st.finish(s.runUnary(ctx, ep, cs))
+}
+
+func (s *Server) runUnary(ctx context.Context, ep *talk.Endpoint, cs *serverStream) error {
+	if ep.Handler == nil {
+		return talk.NewError(talk.Unimplemented, "no handler configured")
+	}
+
+	data, err := cs.recv()
+	if err != nil && err != io.EOF {
+		return err
+	}
+	req, err := decodeRequest(cs.codec, ep, data)
+	if err != nil {
+		return err
+	}
+
+	resp, err := ep.WrappedHandler()(ctx, req)
+	if err != nil {
+		return err
+	}
+	if resp == nil {
+		return nil
+	}
+	out, err := cs.codec.Marshal(resp)
+	if err != nil {
+		return talk.NewError(talk.Internal, "failed to encode response")
+	}
+	return cs.send(out, true)
+}
+
+// runStream runs a stream handler. Server-side streams get the client's
+// first message as request; client-side and bidirectional handlers
+// receive all messages themselves.
+func (s *Server) runStream(ctx context.Context, ep *talk.Endpoint, cs *serverStream) error {
+	if ep.StreamHandler == nil {
+		return talk.NewError(talk.Unimplemented, "no stream handler configured")
+	}
+
+	var req any
+	if ep.StreamMode == talk.StreamServerSide {
+		data, err := cs.recv()
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if req, err = decodeRequest(cs.codec, ep, data); err != nil {
+			return err
+		}
+	}
+	return ep.WrappedStreamHandler()(ctx, req, cs)
+}
+
+func decodeRequest(c codec.Codec, ep *talk.Endpoint, data []byte) (any, error) {
+	if talk.IsRawBody(ep.RequestType) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+	if ep.RequestType == nil {
+		if len(data) == 0 {
+			return nil, nil
+		}
+		var req any
+		if err := c.Unmarshal(data, &req); err != nil {
+			return nil, talk.NewError(talk.InvalidArgument, "failed to decode request")
+		}
+		return req, nil
+	}
+
+	if len(data) == 0 {
+		// Ensure request struct is instantiated for struct types even without body
+		if ep.RequestType.Kind() == reflect.Struct {
+			return reflect.New(ep.RequestType).Elem().Interface(), nil
+		}
+		return nil, nil
+	}
+
+	reqVal := reflect.New(ep.RequestType).Interface()
+	if err := c.Unmarshal(data, reqVal); err != nil {
+		return nil, talk.NewError(talk.InvalidArgument, "failed to decode request")
+	}
+	return reflect.ValueOf(reqVal).Elem().Interface(), nil
+}
+
+type serverStream struct {
+	codecStream
+}
+
+// SendHeader is a no-op: metadata only travels from client to server.
+func (s *serverStream) SendHeader(metadata map[string]string) error {
+	return nil
+}
+
+// Close is a no-op: the stream ends when the handler returns.
+func (s *serverStream) Close() error {
+	return nil
+}
+
+func init() {
+	ServerFactory.Register("default", func(cfg x.TypedLazyConfig, opts ...Option) (ServerTransport, error) {
+		return NewServer(cfg, opts...)
+	})
+}
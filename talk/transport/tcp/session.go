@@ -0,0 +1,302 @@
+package tcp
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.zoe.im/x/talk"
+)
+
+// session multiplexes the streams of one connection. Clients open streams,
+// servers accept them through onOpen.
+type session struct {
+	conn   net.Conn
+	config Config
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	wmu sync.Mutex
+	w   *bufio.Writer
+
+	// control queues the frames written on behalf of the read loop, so
+	// that it never blocks on a peer that stopped reading.
+	control chan frame
+
+	mu      sync.Mutex
+	streams map[uint32]*stream
+	nextID  uint32
+	goAway  bool
+	err     error
+
+	// lastRead is the time of the last frame read, in Unix nanoseconds.
+	lastRead atomic.Int64
+
+	// onOpen serves a stream opened by the peer; nil on clients, which
+	// reject them.
+	onOpen func(st *stream, hdr openHeader)
+}
+
+func newSession(conn net.Conn, cfg Config, onOpen func(*stream, openHeader)) *session {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &session{
+		conn:    conn,
+		config:  cfg,
+		ctx:     ctx,
+		cancel:  cancel,
+		w:       bufio.NewWriter(conn),
+		control: make(chan frame, controlQueueSize),
+		streams: make(map[uint32]*stream),
+		onOpen:  onOpen,
+	}
+	s.lastRead.Store(time.Now().UnixNano())
+	go s.readLoop()
+	go s.writeLoop()
+	go s.keepalive()
+	return s
+}
+
+// controlQueueSize bounds the control frames waiting to be written. A peer
+// that lets it fill up is not reading, and its connection is closed.
+const controlQueueSize = 256
+
+// write writes a frame. A write that does not complete within
+// KeepaliveTimeout closes the session, as does any other write error.
+func (s *session) write(f frame) error {
+	s.wmu.Lock()
+	s.conn.SetWriteDeadline(time.Now().Add(s.config.keepaliveTimeout()))
+	err := writeFrame(s.w, f)
+	if err == nil {
+		err = s.w.Flush()
+	}
+	s.wmu.Unlock()
+	if err != nil {
+		s.close(err)
+	}
+	return err
+}
+
+// writeControl queues a control frame without blocking.
+func (s *session) writeControl(f frame) {
+	select {
+	case s.control <- f:
+	case <-s.ctx.Done():
+	default:
+		s.close(talk.NewError(talk.Unavailable, "peer is not reading"))
+	}
+}
+
+// writeLoop writes the queued control frames.
+func (s *session) writeLoop() {
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case f := <-s.control:
+			if s.write(f) != nil {
+				return
+			}
+		}
+	}
+}
+
+// open opens a stream to hdr.Endpoint. endStream closes the client side
+// at once, for calls without request.
+func (s *session) open(ctx context.Context, hdr openHeader, endStream bool) (*stream, error) {
+	payload, err := json.Marshal(hdr)
+	if err != nil {
+		return nil, talk.NewError(talk.Internal, err.Error())
+	}
+
+	s.mu.Lock()
+	if s.err != nil || s.goAway {
+		s.mu.Unlock()
+		return nil, errSessionUnusable
+	}
+	s.nextID++
+	st := newStream(ctx, s, s.nextID)
+	st.localClosed = endStream
+	s.streams[st.id] = st
+	s.mu.Unlock()
+
+	f := frame{stream: st.id, typ: frameOpen, payload: payload}
+	if endStream {
+		f.flags = flagEndStream
+	}
+	if err := s.write(f); err != nil {
+		s.remove(st.id)
+		return nil, talk.NewError(talk.Unavailable, err.Error())
+	}
+	st.grantInitialWindow()
+	return st, nil
+}
+
+// errSessionUnusable is returned by open on a closed or draining session.
+var errSessionUnusable = talk.NewError(talk.Unavailable, "connection is closing")
+
+// usable reports whether new streams can be opened on the session.
+func (s *session) usable() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err == nil && !s.goAway
+}
+
+func (s *session) lookup(id uint32) *stream {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.streams[id]
+}
+
+// remove forgets stream id. A drained session is closed once its last
+// stream ends.
+func (s *session) remove(id uint32) {
+	s.mu.Lock()
+	delete(s.streams, id)
+	done := s.goAway && len(s.streams) == 0
+	s.mu.Unlock()
+	if done {
+		s.close(talk.NewError(talk.Unavailable, "server went away"))
+	}
+}
+
+// active returns the number of open streams.
+func (s *session) active() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.streams)
+}
+
+// drain sends a goaway frame: the peer opens no more streams.
+func (s *session) drain() {
+	s.write(frame{typ: frameGoAway})
+}
+
+// close closes the connection and fails all its streams with err.
+func (s *session) close(err error) {
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return
+	}
+	s.err = err
+	streams := s.streams
+	s.streams = make(map[uint32]*stream)
+	s.mu.Unlock()
+
+	s.cancel()
+	s.conn.Close()
+	for _, st := range streams {
+		st.fail(talk.NewError(talk.Unavailable, "connection closed: "+err.Error()))
+	}
+}
+
+func (s *session) readLoop() {
+	r := bufio.NewReader(s.conn)
+	for {
+		f, err := readFrame(r, s.config.maxFrameSize())
+		if err != nil {
+			if err != io.EOF {
+				// Tell the peer why, if it still listens.
+				if te, ok := talk.IsError(err); ok {
+					data, _ := json.Marshal(te)
+					s.write(frame{typ: frameReset, payload: data})
+				}
+			}
+			s.close(err)
+			return
+		}
+		s.lastRead.Store(time.Now().UnixNano())
+
+		switch f.typ {
+		case framePing:
+			if f.flags&flagAck == 0 {
+				s.writeControl(frame{typ: framePing, flags: flagAck, payload: f.payload})
+			}
+		case frameGoAway:
+			s.mu.Lock()
+			s.goAway = true
+			done := len(s.streams) == 0
+			s.mu.Unlock()
+			if done {
+				s.close(talk.NewError(talk.Unavailable, "server went away"))
+			}
+		case frameOpen:
+			s.accept(f)
+		default:
+			if st := s.lookup(f.stream); st != nil {
+				st.handle(f)
+			}
+		}
+	}
+}
+
+// accept registers a stream opened by the peer and serves it.
+func (s *session) accept(f frame) {
+	var hdr openHeader
+	if s.onOpen == nil || json.Unmarshal(f.payload, &hdr) != nil {
+		s.writeControl(resetFrame(f.stream, talk.NewError(talk.InvalidArgument, "invalid stream open")))
+		return
+	}
+
+	s.mu.Lock()
+	if s.err != nil || s.streams[f.stream] != nil {
+		s.mu.Unlock()
+		return
+	}
+	st := newStream(s.ctx, s, f.stream)
+	st.remoteClosed = f.flags&flagEndStream != 0
+	s.streams[st.id] = st
+	s.mu.Unlock()
+
+	st.grantInitialWindow()
+	go s.onOpen(st, hdr)
+}
+
+// reset abandons stream id, telling the peer why.
+func (s *session) reset(id uint32, err *talk.Error) {
+	s.write(resetFrame(id, err))
+}
+
+func resetFrame(id uint32, err *talk.Error) frame {
+	var payload []byte
+	if err != nil {
+		payload, _ = json.Marshal(err)
+	}
+	return frame{stream: id, typ: frameReset, payload: payload}
+}
+
+// keepalive pings the peer every KeepaliveInterval and closes the
+// connection when nothing was read for KeepaliveInterval plus
+// KeepaliveTimeout.
+func (s *session) keepalive() {
+	interval := s.config.keepaliveInterval()
+	if interval <= 0 {
+		return
+	}
+	timeout := s.config.keepaliveTimeout()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case now := <-ticker.C:
+			if now.Sub(time.Unix(0, s.lastRead.Load())) > interval+timeout {
+				s.close(talk.NewError(talk.Unavailable, "keepalive timeout"))
+				return
+			}
+			var payload [8]byte
+			binary.BigEndian.PutUint64(payload[:], uint64(now.UnixNano()))
+			if s.write(frame{typ: framePing, payload: payload[:]}) != nil {
+				return
+			}
+		}
+	}
+}
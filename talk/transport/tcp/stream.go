@@ -0,0 +1,307 @@
+package tcp
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"sync"
+
+	"go.zoe.im/x/talk"
+	"go.zoe.im/x/talk/codec"
+)
+
+// minWindowSize is the number of bytes either side may send on a new
+// stream before the receiver grants more. Receivers configured with a
+// larger window grant the difference when the stream opens.
+const minWindowSize = 16 << 10
+
+// stream is one side of a multiplexed call or stream.
+type stream struct {
+	id     uint32
+	sess   *session
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// recvReady and sendReady are signalled when Recv and Send may make
+	// progress.
+	recvReady chan struct{}
+	sendReady chan struct{}
+
+	mu           sync.Mutex
+	queue        [][]byte
+	remoteClosed bool
+	localClosed  bool
+	err          error
+	sendWindow   int64
+	unacked      int64
+	// recvWindow is the number of bytes the peer may still send.
+	recvWindow int64
+}
+
+func newStream(ctx context.Context, sess *session, id uint32) *stream {
+	ctx, cancel := context.WithCancel(ctx)
+	return &stream{
+		id:         id,
+		sess:       sess,
+		ctx:        ctx,
+		cancel:     cancel,
+		recvReady:  make(chan struct{}, 1),
+		sendReady:  make(chan struct{}, 1),
+		sendWindow: minWindowSize,
+		recvWindow: minWindowSize,
+	}
+}
+
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// grantInitialWindow raises the peer's window to the configured size.
+func (st *stream) grantInitialWindow() {
+	if n := st.sess.config.windowSize() - minWindowSize; n > 0 {
+		st.grant(n)
+	}
+}
+
+func (st *stream) grant(n int) {
+	st.mu.Lock()
+	st.recvWindow += int64(n)
+	st.mu.Unlock()
+
+	var payload [4]byte
+	binary.BigEndian.PutUint32(payload[:], uint32(n))
+	st.sess.writeControl(frame{stream: st.id, typ: frameWindow, payload: payload[:]})
+}
+
+// handle applies a frame received for the stream.
+func (st *stream) handle(f frame) {
+	switch f.typ {
+	case frameData:
+		st.mu.Lock()
+		// Like send, allow one message to overrun the window.
+		if len(f.payload) > 0 && st.recvWindow <= 0 {
+			st.mu.Unlock()
+			err := talk.NewError(talk.ResourceExhausted, "stream window exceeded")
+			st.sess.writeControl(resetFrame(st.id, err))
+			st.fail(err)
+			st.sess.remove(st.id)
+			return
+		}
+		st.recvWindow -= int64(len(f.payload))
+		if len(f.payload) > 0 || f.flags&flagEndStream == 0 {
+			st.queue = append(st.queue, f.payload)
+		}
+		if f.flags&flagEndStream != 0 {
+			st.remoteClosed = true
+		}
+		st.mu.Unlock()
+		signal(st.recvReady)
+	case frameTrailer:
+		st.mu.Lock()
+		st.remoteClosed = true
+		if len(f.payload) > 0 {
+			st.err = decodeError(f.payload)
+		}
+		st.mu.Unlock()
+		signal(st.recvReady)
+		st.cancel()
+		st.sess.remove(st.id)
+	case frameReset:
+		err := talk.NewError(talk.Cancelled, "stream reset by peer")
+		if len(f.payload) > 0 {
+			err = decodeError(f.payload)
+		}
+		st.fail(err)
+		st.sess.remove(st.id)
+	case frameWindow:
+		if len(f.payload) == 4 {
+			st.mu.Lock()
+			st.sendWindow += int64(binary.BigEndian.Uint32(f.payload))
+			st.mu.Unlock()
+			signal(st.sendReady)
+		}
+	}
+}
+
+func decodeError(data []byte) *talk.Error {
+	var te talk.Error
+	if err := json.Unmarshal(data, &te); err != nil || te.Code == talk.OK {
+		return talk.NewError(talk.Internal, string(data))
+	}
+	return &te
+}
+
+// fail ends the stream with err, waking up Send and Recv.
+func (st *stream) fail(err error) {
+	st.mu.Lock()
+	if st.err == nil {
+		st.err = err
+	}
+	st.mu.Unlock()
+	st.cancel()
+	signal(st.recvReady)
+	signal(st.sendReady)
+}
+
+// recv returns the next message. Once the peer closed its side and all
+// messages are read, it returns the error the stream ended with, or
+// io.EOF.
+func (st *stream) recv() ([]byte, error) {
+	for {
+		st.mu.Lock()
+		if len(st.queue) > 0 {
+			data := st.queue[0]
+			st.queue = st.queue[1:]
+			st.unacked += int64(len(data))
+			var grant int64
+			if st.unacked >= int64(st.sess.config.windowSize())/4 {
+				grant, st.unacked = st.unacked, 0
+			}
+			st.mu.Unlock()
+			if grant > 0 {
+				st.grant(int(grant))
+			}
+			return data, nil
+		}
+		if st.err != nil {
+			err := st.err
+			st.mu.Unlock()
+			return nil, err
+		}
+		if st.remoteClosed {
+			st.mu.Unlock()
+			return nil, io.EOF
+		}
+		st.mu.Unlock()
+
+		select {
+		case <-st.recvReady:
+		case <-st.ctx.Done():
+			st.mu.Lock()
+			err := st.err
+			st.mu.Unlock()
+			if err != nil {
+				return nil, err
+			}
+			return nil, st.ctx.Err()
+		}
+	}
+}
+
+// send sends a message, waiting for the peer to grant window if needed. A
+// message may overrun the window, so that messages larger than the window
+// can be sent at all.
+func (st *stream) send(data []byte, endStream bool) error {
+	if len(data) > st.sess.config.maxFrameSize() {
+		return talk.NewErrorf(talk.ResourceExhausted, "message of %d bytes exceeds the frame size limit", len(data))
+	}
+	for {
+		st.mu.Lock()
+		if st.err != nil {
+			err := st.err
+			st.mu.Unlock()
+			return err
+		}
+		if st.localClosed {
+			st.mu.Unlock()
+			return io.ErrClosedPipe
+		}
+		if st.sendWindow > 0 || len(data) == 0 {
+			st.sendWindow -= int64(len(data))
+			st.localClosed = endStream
+			st.mu.Unlock()
+			break
+		}
+		st.mu.Unlock()
+
+		select {
+		case <-st.sendReady:
+		case <-st.ctx.Done():
+			return st.ctx.Err()
+		}
+	}
+
+	f := frame{stream: st.id, typ: frameData, payload: data}
+	if endStream {
+		f.flags = flagEndStream
+	}
+	if err := st.sess.write(f); err != nil {
+		return talk.NewError(talk.Unavailable, err.Error())
+	}
+	return nil
+}
+
+// closeSend closes the local side of the stream.
+func (st *stream) closeSend() error {
+	st.mu.Lock()
+	closed := st.localClosed
+	st.mu.Unlock()
+	if closed {
+		return nil
+	}
+	return st.send(nil, true)
+}
+
+// finish ends a server stream with the handler's error. Streams that were
+// reset already get no trailer.
+func (st *stream) finish(err error) {
+	st.mu.Lock()
+	failed := st.err != nil
+	st.mu.Unlock()
+	if !failed {
+		var payload []byte
+		if err != nil {
+			payload, _ = json.Marshal(talk.ToError(err))
+		}
+		st.sess.write(frame{stream: st.id, typ: frameTrailer, payload: payload})
+	}
+	st.cancel()
+	st.sess.remove(st.id)
+}
+
+// abort resets the stream unless it already ended.
+func (st *stream) abort(err *talk.Error) {
+	st.mu.Lock()
+	done := st.err != nil || (st.remoteClosed && st.localClosed)
+	st.mu.Unlock()
+	if !done {
+		st.sess.reset(st.id, err)
+	}
+	st.fail(talk.NewError(talk.Cancelled, "stream closed"))
+	st.sess.remove(st.id)
+}
+
+// codecStream encodes messages with a codec over a stream.
+type codecStream struct {
+	*stream
+	codec codec.Codec
+}
+
+func (s *codecStream) Context() context.Context {
+	return s.ctx
+}
+
+// Cancel ends pending Send and Recv calls with the stream's context.
+func (s *codecStream) Cancel() {
+	s.cancel()
+}
+
+func (s *codecStream) Send(msg any) error {
+	data, err := s.codec.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return s.send(data, false)
+}
+
+func (s *codecStream) Recv(msg any) error {
+	data, err := s.recv()
+	if err != nil {
+		return err
+	}
+	return s.codec.Unmarshal(data, msg)
+}
@@ -0,0 +1,213 @@
+// Package tcp provides a raw TCP transport for talk. Calls and streams are
+// multiplexed over a single connection per client as length-prefixed
+// frames tagged with a stream ID, with per-stream flow control, keepalive
+// pings and optional TLS. Messages are encoded with the client's codec and
+// errors travel as *talk.Error, so the transport can replace http by
+// configuration alone.
+package tcp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"time"
+
+	"go.zoe.im/x"
+	"go.zoe.im/x/factory"
+	"go.zoe.im/x/talk"
+	"go.zoe.im/x/talk/codec"
+	"go.zoe.im/x/talk/transport"
+)
+
+// Defaults used when the corresponding config field is zero.
+const (
+	DefaultWindowSize        = 1 << 20
+	DefaultMaxFrameSize      = 16 << 20
+	DefaultKeepaliveInterval = 30 * time.Second
+	DefaultKeepaliveTimeout  = 20 * time.Second
+)
+
+type Config struct {
+	Addr string `json:"addr" yaml:"addr"`
+
+	// TLSCertFile and TLSKeyFile are the certificate presented to the
+	// peer: required on servers for TLS, and used by clients for mutual
+	// TLS.
+	TLSCertFile string `json:"tls_cert_file,omitempty" yaml:"tls_cert_file"`
+	TLSKeyFile  string `json:"tls_key_file,omitempty" yaml:"tls_key_file"`
+	// TLSCAFile verifies the peer certificate: servers then require client
+	// certificates, and clients use it instead of the system roots.
+	TLSCAFile string `json:"tls_ca_file,omitempty" yaml:"tls_ca_file"`
+
+	// WindowSize is the number of bytes the peer may send on a stream
+	// before this side reads them, at least 16KiB.
+	WindowSize int `json:"window_size,omitempty" yaml:"window_size"`
+	// MaxFrameSize limits the size of a single message.
+	MaxFrameSize int `json:"max_frame_size,omitempty" yaml:"max_frame_size"`
+	// KeepaliveInterval is the interval of pings; a negative value
+	// disables them. The connection is closed when nothing was received
+	// for KeepaliveInterval plus KeepaliveTimeout, or when a write does
+	// not complete within KeepaliveTimeout.
+	KeepaliveInterval x.Duration `json:"keepalive_interval,omitempty" yaml:"keepalive_interval"`
+	KeepaliveTimeout  x.Duration `json:"keepalive_timeout,omitempty" yaml:"keepalive_timeout"`
+}
+
+func (c Config) windowSize() int {
+	if c.WindowSize <= 0 {
+		return DefaultWindowSize
+	}
+	return max(c.WindowSize, minWindowSize)
+}
+
+func (c Config) maxFrameSize() int {
+	if c.MaxFrameSize > 0 {
+		return c.MaxFrameSize
+	}
+	return DefaultMaxFrameSize
+}
+
+func (c Config) keepaliveInterval() time.Duration {
+	if c.KeepaliveInterval == 0 {
+		return DefaultKeepaliveInterval
+	}
+	return time.Duration(c.KeepaliveInterval)
+}
+
+func (c Config) keepaliveTimeout() time.Duration {
+	if c.KeepaliveTimeout <= 0 {
+		return DefaultKeepaliveTimeout
+	}
+	return time.Duration(c.KeepaliveTimeout)
+}
+
+type ServerConfig struct {
+	Config `json:",inline" yaml:",inline"`
+}
+
+type ClientConfig struct {
+	Config  `json:",inline" yaml:",inline"`
+	Timeout x.Duration `json:"timeout,omitempty" yaml:"timeout"`
+	// DialTimeout bounds connecting, including the TLS handshake.
+	DialTimeout x.Duration `json:"dial_timeout,omitempty" yaml:"dial_timeout"`
+	// TLS enables TLS without client certificate or custom CA.
+	TLS bool `json:"tls,omitempty" yaml:"tls"`
+	// TLSServerName overrides the name verified in the server certificate.
+	TLSServerName string `json:"tls_server_name,omitempty" yaml:"tls_server_name"`
+}
+
+// tlsConfig returns the TLS config of a server or client, or nil if TLS is
+// not configured.
+func (c Config) tlsConfig(server bool) (*tls.Config, error) {
+	if c.TLSCertFile == "" && c.TLSCAFile == "" {
+		return nil, nil
+	}
+
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if c.TLSCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.TLSCertFile, c.TLSKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	if c.TLSCAFile != "" {
+		data, err := os.ReadFile(c.TLSCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, talk.NewError(talk.InvalidArgument, "no certificate found in "+c.TLSCAFile)
+		}
+		if server {
+			cfg.ClientCAs = pool
+			cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		} else {
+			cfg.RootCAs = pool
+		}
+	}
+	if server && len(cfg.Certificates) == 0 {
+		return nil, talk.NewError(talk.InvalidArgument, "TLS server requires tls_cert_file and tls_key_file")
+	}
+	return cfg, nil
+}
+
+type Option func(any)
+
+func WithCodec(c codec.Codec) Option {
+	return func(v any) {
+		if s, ok := v.(interface{ SetCodec(codec.Codec) }); ok {
+			s.SetCodec(c)
+		}
+	}
+}
+
+// WithTLSConfig sets the TLS config, replacing the one built from the
+// config files.
+func WithTLSConfig(cfg *tls.Config) Option {
+	return func(v any) {
+		if s, ok := v.(interface{ setTLSConfig(*tls.Config) }); ok {
+			s.setTLSConfig(cfg)
+		}
+	}
+}
+
+var serverFactory = factory.NewFactory[ServerTransport, Option]()
+
+var ServerFactory = struct {
+	Create   func(cfg x.TypedLazyConfig, opts ...Option) (ServerTransport, error)
+	Register func(typeName string, creator factory.Creator[ServerTransport, Option], alias ...string) error
+}{
+	Create:   serverFactory.Create,
+	Register: serverFactory.Register,
+}
+
+var clientFactory = factory.NewFactory[ClientTransport, Option]()
+
+var ClientFactory = struct {
+	Create   func(cfg x.TypedLazyConfig, opts ...Option) (ClientTransport, error)
+	Register func(typeName string, creator factory.Creator[ClientTransport, Option], alias ...string) error
+}{
+	Create:   clientFactory.Create,
+	Register: clientFactory.Register,
+}
+
+type ServerTransport interface {
+	SetCodec(codec.Codec)
+}
+
+type ClientTransport interface {
+	SetCodec(codec.Codec)
+}
+
+type tcpTransportFamily struct{}
+
+func (f *tcpTransportFamily) CreateServer(cfg x.TypedLazyConfig, opts ...transport.TransportOption) (transport.ServerTransport, error) {
+	server, err := serverFactory.Create(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if full, ok := server.(transport.ServerTransport); ok {
+		return full, nil
+	}
+
+	return nil, talk.NewError(talk.Internal, "TCP server does not implement transport.ServerTransport")
+}
+
+func (f *tcpTransportFamily) CreateClient(cfg x.TypedLazyConfig, opts ...transport.TransportOption) (transport.ClientTransport, error) {
+	client, err := clientFactory.Create(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if full, ok := client.(transport.ClientTransport); ok {
+		return full, nil
+	}
+
+	return nil, talk.NewError(talk.Internal, "TCP client does not implement transport.ClientTransport")
+}
+
+func init() {
+	transport.Factory.RegisterFamily("tcp", &tcpTransportFamily{})
+}
@@ -0,0 +1,439 @@
+package tcp
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.zoe.im/x"
+	"go.zoe.im/x/talk"
+)
+
+type echoRequest struct {
+	Message string `json:"message"`
+}
+
+type echoResponse struct {
+	Message string `json:"message"`
+}
+
+func testEndpoints(blocked chan struct{}) []*talk.Endpoint {
+	echo := talk.NewEndpoint("Echo", func(ctx context.Context, req any) (any, error) {
+		md, _ := talk.FromIncomingContext(ctx)
+		return &echoResponse{Message: req.(echoRequest).Message + md.Get("x-suffix")}, nil
+	})
+	echo.Path = "/echo"
+	echo.RequestType = reflect.TypeOf(echoRequest{})
+	echo.ResponseType = reflect.TypeOf(echoResponse{})
+
+	fail := talk.NewEndpoint("Fail", func(ctx context.Context, req any) (any, error) {
+		return nil, talk.NewError(talk.NotFound, "no such thing")
+	})
+
+	block := talk.NewEndpoint("Block", func(ctx context.Context, req any) (any, error) {
+		<-ctx.Done()
+		close(blocked)
+		return nil, ctx.Err()
+	})
+
+	chat := talk.NewStreamEndpoint("Chat", func(ctx context.Context, req any, stream talk.Stream) error {
+		for {
+			var msg string
+			if err := stream.Recv(&msg); err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			if err := stream.Send(strings.ToUpper(msg)); err != nil {
+				return err
+			}
+		}
+	}, talk.StreamBidirect)
+
+	count := talk.NewStreamEndpoint("Count", func(ctx context.Context, req any, stream talk.Stream) error {
+		n := int(req.(float64))
+		for i := 0; i < n; i++ {
+			if err := stream.Send(i); err != nil {
+				return err
+			}
+		}
+		return talk.NewError(talk.ResourceExhausted, "done counting")
+	}, talk.StreamServerSide)
+
+	return []*talk.Endpoint{echo, fail, block, chat, count}
+}
+
+// serve starts a server on a free port and returns its address.
+func serve(t *testing.T, config string, endpoints []*talk.Endpoint, opts ...Option) (*Server, string) {
+	t.Helper()
+
+	server, err := NewServer(x.TypedLazyConfig{Config: json.RawMessage(config)}, opts...)
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		server.Serve(ctx, endpoints)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	for i := 0; i < 100; i++ {
+		if addr := server.Addr(); addr != nil {
+			return server, addr.String()
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("server did not start")
+	return nil, ""
+}
+
+func dial(t *testing.T, config string, opts ...Option) *Client {
+	t.Helper()
+	client, err := NewClient(x.TypedLazyConfig{Config: json.RawMessage(config)}, opts...)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestInvoke(t *testing.T) {
+	_, addr := serve(t, `{"addr": "127.0.0.1:0"}`, testEndpoints(nil))
+	client := dial(t, `{"addr": "`+addr+`"}`)
+
+	ctx := talk.NewOutgoingContext(context.Background(), talk.MetadataPairs("x-suffix", "!"))
+	var resp echoResponse
+	if err := client.Invoke(ctx, "/echo", echoRequest{Message: "hello"}, &resp); err != nil {
+		t.Fatalf("Invoke failed: %v", err)
+	}
+	if resp.Message != "hello!" {
+		t.Errorf("resp = %q, want hello!", resp.Message)
+	}
+
+	err := client.Invoke(context.Background(), "Fail", nil, nil)
+	if te, ok := talk.IsError(err); !ok || te.Code != talk.NotFound || te.Message != "no such thing" {
+		t.Errorf("Fail error = %v, want NotFound", err)
+	}
+
+	err = client.Invoke(context.Background(), "Missing", nil, nil)
+	if te, ok := talk.IsError(err); !ok || te.Code != talk.NotFound {
+		t.Errorf("Missing error = %v, want NotFound", err)
+	}
+}
+
+func TestInvoke_Concurrent(t *testing.T) {
+	_, addr := serve(t, `{"addr": "127.0.0.1:0"}`, testEndpoints(nil))
+	client := dial(t, `{"addr": "`+addr+`"}`)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 50)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			msg := strings.Repeat("x", i)
+			var resp echoResponse
+			if err := client.Invoke(context.Background(), "Echo", echoRequest{Message: msg}, &resp); err != nil {
+				errs <- err
+			} else if resp.Message != msg {
+				errs <- errors.New("got response of another call")
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if client.sess == nil || client.sess.nextID != 50 {
+		t.Errorf("calls were not multiplexed over one connection")
+	}
+}
+
+func TestInvoke_Cancel(t *testing.T) {
+	blocked := make(chan struct{})
+	_, addr := serve(t, `{"addr": "127.0.0.1:0"}`, testEndpoints(blocked))
+	client := dial(t, `{"addr": "`+addr+`", "timeout": "50ms"}`)
+
+	err := client.Invoke(context.Background(), "Block", nil, nil)
+	if te, ok := talk.IsError(err); !ok || te.Code != talk.DeadlineExceeded {
+		t.Errorf("error = %v, want DeadlineExceeded", err)
+	}
+
+	select {
+	case <-blocked:
+	case <-time.After(time.Second):
+		t.Fatal("handler was not cancelled")
+	}
+}
+
+func TestInvokeStream(t *testing.T) {
+	_, addr := serve(t, `{"addr": "127.0.0.1:0"}`, testEndpoints(nil))
+	client := dial(t, `{"addr": "`+addr+`"}`)
+
+	t.Run("bidirectional", func(t *testing.T) {
+		stream, err := client.InvokeStream(context.Background(), "Chat", nil)
+		if err != nil {
+			t.Fatalf("InvokeStream failed: %v", err)
+		}
+		defer stream.Close()
+
+		for _, msg := range []string{"a", "b", "c"} {
+			if err := stream.Send(msg); err != nil {
+				t.Fatalf("Send failed: %v", err)
+			}
+			var got string
+			if err := stream.Recv(&got); err != nil {
+				t.Fatalf("Recv failed: %v", err)
+			}
+			if got != strings.ToUpper(msg) {
+				t.Errorf("got %q, want %q", got, strings.ToUpper(msg))
+			}
+		}
+		if err := stream.(talk.ClientStream).CloseSend(); err != nil {
+			t.Fatalf("CloseSend failed: %v", err)
+		}
+		var got string
+		if err := stream.Recv(&got); err != io.EOF {
+			t.Errorf("Recv after CloseSend = %v, want io.EOF", err)
+		}
+	})
+
+	t.Run("server side with error", func(t *testing.T) {
+		stream, err := client.InvokeStream(context.Background(), "Count", 3)
+		if err != nil {
+			t.Fatalf("InvokeStream failed: %v", err)
+		}
+		defer stream.Close()
+
+		for i := 0; i < 3; i++ {
+			var got int
+			if err := stream.Recv(&got); err != nil || got != i {
+				t.Fatalf("Recv = %d, %v; want %d", got, err, i)
+			}
+		}
+		var got int
+		err = stream.Recv(&got)
+		if te, ok := talk.IsError(err); !ok || te.Code != talk.ResourceExhausted {
+			t.Errorf("final Recv = %v, want ResourceExhausted", err)
+		}
+	})
+}
+
+func TestFlowControl(t *testing.T) {
+	// With the smallest window, sends wait for the receiver to grant more
+	// every few messages.
+	config := `{"addr": "127.0.0.1:0", "window_size": 1024}`
+	_, addr := serve(t, config, testEndpoints(nil))
+	client := dial(t, `{"addr": "`+addr+`", "window_size": 1024}`)
+
+	stream, err := client.InvokeStream(context.Background(), "Chat", nil)
+	if err != nil {
+		t.Fatalf("InvokeStream failed: %v", err)
+	}
+	defer stream.Close()
+
+	msg := strings.Repeat("x", 4096)
+	const count = 20
+	go func() {
+		for i := 0; i < count; i++ {
+			if err := stream.Send(msg); err != nil {
+				return
+			}
+		}
+		stream.(talk.ClientStream).CloseSend()
+	}()
+
+	for i := 0; i < count; i++ {
+		var got string
+		if err := stream.Recv(&got); err != nil {
+			t.Fatalf("Recv %d failed: %v", i, err)
+		}
+		if len(got) != len(msg) {
+			t.Fatalf("Recv %d got %d bytes, want %d", i, len(got), len(msg))
+		}
+	}
+
+	small := dial(t, `{"addr": "`+addr+`", "max_frame_size": 1024}`)
+	big := strings.Repeat("x", 2048)
+	err = small.Invoke(context.Background(), "Echo", echoRequest{Message: big}, nil)
+	if te, ok := talk.IsError(err); !ok || te.Code != talk.ResourceExhausted {
+		t.Errorf("oversized message error = %v, want ResourceExhausted", err)
+	}
+}
+
+func TestFlowControl_Overrun(t *testing.T) {
+	// A peer that ignores the window gets its stream reset.
+	blocked := make(chan struct{})
+	_, addr := serve(t, `{"addr": "127.0.0.1:0", "window_size": 16384}`, testEndpoints(blocked))
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	sess := newSession(conn, Config{}, nil)
+	defer sess.close(io.EOF)
+
+	st, err := sess.open(context.Background(), openHeader{Endpoint: "Block"}, false)
+	if err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	data, _ := json.Marshal(strings.Repeat("x", 4096))
+	for i := 0; i < 8; i++ {
+		if err := sess.write(frame{stream: st.id, typ: frameData, payload: data}); err != nil {
+			t.Fatalf("write %d failed: %v", i, err)
+		}
+	}
+
+	_, err = st.recv()
+	if te, ok := talk.IsError(err); !ok || te.Code != talk.ResourceExhausted {
+		t.Errorf("recv error = %v, want ResourceExhausted", err)
+	}
+	select {
+	case <-blocked:
+	case <-time.After(time.Second):
+		t.Error("handler was not cancelled")
+	}
+}
+
+func TestKeepalive(t *testing.T) {
+	// The server never answers: the client closes the connection once
+	// nothing was read for interval plus timeout.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			defer conn.Close()
+			io.Copy(io.Discard, conn)
+		}
+	}()
+
+	client := dial(t, `{"addr": "`+ln.Addr().String()+`", "keepalive_interval": "20ms", "keepalive_timeout": "20ms"}`)
+	start := time.Now()
+	err = client.Invoke(context.Background(), "Echo", echoRequest{}, nil)
+	if te, ok := talk.IsError(err); !ok || te.Code != talk.Unavailable {
+		t.Errorf("error = %v, want Unavailable", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("keepalive took %v to detect the dead peer", elapsed)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	server, addr := serve(t, `{"addr": "127.0.0.1:0"}`, testEndpoints(nil))
+	client := dial(t, `{"addr": "`+addr+`"}`)
+
+	stream, err := client.InvokeStream(context.Background(), "Chat", nil)
+	if err != nil {
+		t.Fatalf("InvokeStream failed: %v", err)
+	}
+	var got string
+	if err := stream.Send("hello"); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if err := stream.Recv(&got); err != nil {
+		t.Fatalf("Recv failed: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		done <- server.Shutdown(ctx)
+	}()
+
+	// The open stream keeps working while the server drains.
+	time.Sleep(50 * time.Millisecond)
+	if err := stream.Send("still here"); err != nil {
+		t.Fatalf("Send during shutdown failed: %v", err)
+	}
+	if err := stream.Recv(&got); err != nil || got != "STILL HERE" {
+		t.Fatalf("Recv during shutdown = %q, %v", got, err)
+	}
+	stream.(talk.ClientStream).CloseSend()
+	if err := stream.Recv(&got); err != io.EOF {
+		t.Errorf("Recv after CloseSend = %v, want io.EOF", err)
+	}
+
+	if err := <-done; err != nil {
+		t.Errorf("Shutdown failed: %v", err)
+	}
+}
+
+func TestTLS(t *testing.T) {
+	cert, pool := selfSignedCert(t)
+	serverTLS := &tls.Config{Certificates: []tls.Certificate{cert}}
+	clientTLS := &tls.Config{RootCAs: pool, ServerName: "localhost"}
+
+	_, addr := serve(t, `{"addr": "127.0.0.1:0"}`, testEndpoints(nil), WithTLSConfig(serverTLS))
+
+	client := dial(t, `{"addr": "`+addr+`"}`, WithTLSConfig(clientTLS))
+	var resp echoResponse
+	if err := client.Invoke(context.Background(), "Echo", echoRequest{Message: "secure"}, &resp); err != nil {
+		t.Fatalf("Invoke over TLS failed: %v", err)
+	}
+	if resp.Message != "secure" {
+		t.Errorf("resp = %q, want secure", resp.Message)
+	}
+
+	plain := dial(t, `{"addr": "`+addr+`", "timeout": "1s"}`)
+	if err := plain.Invoke(context.Background(), "Echo", echoRequest{}, nil); err == nil {
+		t.Error("plain client should fail against a TLS server")
+	}
+}
+
+func selfSignedCert(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate failed: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("ParseCertificate failed: %v", err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, pool
+}
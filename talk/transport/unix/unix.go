@@ -15,6 +15,8 @@ type Config struct {
 	ReadTimeout  x.Duration `json:"read_timeout,omitempty" yaml:"read_timeout"`
 	WriteTimeout x.Duration `json:"write_timeout,omitempty" yaml:"write_timeout"`
 	IdleTimeout  x.Duration `json:"idle_timeout,omitempty" yaml:"idle_timeout"`
+
+	codec.CompressionConfig `json:",inline" yaml:",inline"`
 }
 
 type ServerConfig struct {
@@ -24,6 +26,13 @@ type ServerConfig struct {
 type ClientConfig struct {
 	Config  `json:",inline" yaml:",inline"`
 	Timeout x.Duration `json:"timeout,omitempty" yaml:"timeout"`
+
+	// Reconnect configures how SSE streams cut by a network error are
+	// reopened with Last-Event-ID. Only the backoff and codes are used;
+	// defaults apply when nil.
+	Reconnect *talk.RetryConfig `json:"reconnect,omitempty" yaml:"reconnect"`
+	// DisableReconnect ends SSE streams on network errors instead.
+	DisableReconnect bool `json:"disable_reconnect,omitempty" yaml:"disable_reconnect"`
 }
 
 type Option func(any)
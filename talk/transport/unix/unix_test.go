@@ -3,13 +3,16 @@ package unix
 import (
 	"context"
 	"encoding/json"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"go.zoe.im/x"
 	"go.zoe.im/x/talk"
+	"go.zoe.im/x/talk/codec"
 )
 
 func TestUnixSocket(t *testing.T) {
@@ -67,6 +70,22 @@ func TestUnixSocket(t *testing.T) {
 		t.Errorf("expected status 'pong', got %q", pingResp["status"])
 	}
 
+	// A client with a binary codec negotiates it with the server.
+	cborClient, err := NewClient(clientCfg)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	defer cborClient.Close()
+	cborClient.SetCodec(codec.MustGet("cbor"))
+
+	pingResp = nil
+	if err := cborClient.Invoke(ctx, "/ping", nil, &pingResp); err != nil {
+		t.Fatalf("Invoke /ping with cbor failed: %v", err)
+	}
+	if pingResp["status"] != "pong" {
+		t.Errorf("expected status 'pong' with cbor, got %q", pingResp["status"])
+	}
+
 	cancel()
 	time.Sleep(100 * time.Millisecond)
 }
@@ -94,6 +113,20 @@ func TestUnixSocketStreaming(t *testing.T) {
 			}
 			return nil
 		}, talk.StreamServerSide, talk.WithPath("/events"), talk.WithMethod("GET")),
+		talk.NewStreamEndpoint("echo", func(ctx context.Context, req any, stream talk.Stream) error {
+			for {
+				var msg string
+				if err := stream.Recv(&msg); err != nil {
+					if err == io.EOF {
+						return nil
+					}
+					return err
+				}
+				if err := stream.Send(strings.ToUpper(msg)); err != nil {
+					return err
+				}
+			}
+		}, talk.StreamBidirect, talk.WithPath("/echo"), talk.WithMethod("POST")),
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -131,4 +164,22 @@ func TestUnixSocketStreaming(t *testing.T) {
 			t.Errorf("expected count %d, got %d", i, event["count"])
 		}
 	}
+
+	echo, err := client.InvokeStream(ctx, "/echo", "hello")
+	if err != nil {
+		t.Fatalf("InvokeStream /echo failed: %v", err)
+	}
+	defer echo.Close()
+	echo.Send("world")
+	for _, want := range []string{"HELLO", "WORLD"} {
+		var msg string
+		if err := echo.Recv(&msg); err != nil || msg != want {
+			t.Fatalf("Recv = %q, %v, want %q", msg, err, want)
+		}
+	}
+	echo.(talk.ClientStream).CloseSend()
+	var msg string
+	if err := echo.Recv(&msg); err != io.EOF {
+		t.Errorf("Recv after CloseSend = %v, want io.EOF", err)
+	}
 }
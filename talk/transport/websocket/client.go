@@ -4,6 +4,8 @@ import (
 	"context"
 	"encoding/json"
 	"io"
+	"net"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -15,20 +17,76 @@ import (
 	"go.zoe.im/x/talk/codec"
 )
 
-// Client implements talk.Transport for WebSocket client operations.
+// ConnState is the state of the connection of a Client.
+type ConnState int
+
+const (
+	StateConnected    ConnState = iota // Connected to the server
+	StateReconnecting                  // Connection dropped, redialing
+	StateClosed                        // Closed, or reconnecting gave up
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case StateConnected:
+		return "connected"
+	case StateReconnecting:
+		return "reconnecting"
+	case StateClosed:
+		return "closed"
+	default:
+		return "unknown"
+	}
+}
+
+// ErrConnectionLost fails the calls in flight when the connection of a
+// Client drops, except the idempotent ones, which are re-sent once
+// reconnected. It also ends the streams and subscriptions: open them again
+// to resume, e.g. once StateConnected is reported.
+var ErrConnectionLost = talk.NewError(talk.Unavailable, "websocket connection lost")
+
+var errClientClosed = talk.NewError(talk.Unavailable, "websocket client is closed")
+
+// Client implements talk.Transport for WebSocket client operations. It
+// redials a dropped connection unless DisableReconnect is set.
 type Client struct {
-	config  ClientConfig
-	codec   codec.Codec
-	conn    *websocket.Conn
-	mu      sync.Mutex
-	reqID   uint64
-	pending sync.Map
-	closed  bool
+	config     ClientConfig
+	codec      codec.Codec
+	compressor codec.Compressor
+	wsConfig   *websocket.Config
+	reqID      uint64
+	pending    sync.Map // request ID -> *pendingCall
+	idempotent map[string]bool
+	stateFunc  func(from, to ConnState)
+	ctx        context.Context
+	cancel     context.CancelFunc
+
+	// mu guards the connection and its state. It is not held while
+	// writing, which the connection serializes itself.
+	mu    sync.Mutex
+	conn  *websocket.Conn
+	state ConnState
+
+	subMu sync.Mutex
+	subs  map[string][]*subscription
+}
+
+// pendingCall is a request waiting for its response, or a message sent on
+// a stream, which receives every response with its ID.
+type pendingCall struct {
+	msg    wsMessage
+	ch     chan *wsResponse
+	stream *wsClientStream
+	// replay re-sends the request after reconnecting.
+	replay bool
 }
 
 // NewClient creates a new WebSocket client transport.
 func NewClient(cfg x.TypedLazyConfig, opts ...Option) (*Client, error) {
-	c := &Client{}
+	c := &Client{
+		idempotent: make(map[string]bool),
+		subs:       make(map[string][]*subscription),
+	}
 
 	if err := cfg.Unmarshal(&c.config); err != nil {
 		return nil, err
@@ -46,18 +104,32 @@ func NewClient(cfg x.TypedLazyConfig, opts ...Option) (*Client, error) {
 		c.codec = codec.MustGet("json")
 	}
 
+	comp, err := c.config.Compressor()
+	if err != nil {
+		return nil, err
+	}
+	c.compressor = comp
+
 	wsConfig, err := websocket.NewConfig("ws://"+c.config.Addr+c.config.Path, "http://localhost")
 	if err != nil {
 		return nil, err
 	}
+	if c.compressor != nil {
+		wsConfig.Header.Set("Accept-Encoding", c.compressor.Name())
+	}
+	if c.config.HandshakeTimeout > 0 {
+		wsConfig.Dialer = &net.Dialer{Timeout: time.Duration(c.config.HandshakeTimeout)}
+	}
+	c.wsConfig = wsConfig
 
-	conn, err := websocket.DialConfig(wsConfig)
+	conn, err := c.dial()
 	if err != nil {
-		return nil, talk.NewError(talk.Unavailable, err.Error())
+		return nil, err
 	}
 	c.conn = conn
+	c.ctx, c.cancel = context.WithCancel(context.Background())
 
-	go c.readLoop()
+	go c.readLoop(conn)
 
 	return c, nil
 }
@@ -78,63 +150,145 @@ func (c *Client) Shutdown(ctx context.Context) error {
 	return nil
 }
 
-func (c *Client) Invoke(ctx context.Context, endpoint string, req any, resp any) error {
-	id := c.nextID()
+// State returns the state of the connection.
+func (c *Client) State() ConnState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
 
+func (c *Client) Invoke(ctx context.Context, endpoint string, req any, resp any) error {
 	reqData, err := json.Marshal(req)
 	if err != nil {
 		return talk.NewError(talk.InvalidArgument, "failed to encode request")
 	}
+	params, encoding, err := encodePayload(c.compressor, c.config.MinSize(), reqData)
+	if err != nil {
+		return talk.NewError(talk.Internal, "failed to compress request")
+	}
 
 	msg := wsMessage{
-		ID:     id,
-		Method: endpoint,
-		Params: reqData,
+		Method:   endpoint,
+		Params:   params,
+		Encoding: encoding,
+	}
+	if md, ok := talk.FromOutgoingContext(ctx); ok {
+		msg.Metadata = md
 	}
 
-	respCh := make(chan *wsResponse, 1)
-	c.pending.Store(id, respCh)
-	defer c.pending.Delete(id)
-
-	c.mu.Lock()
-	err = websocket.JSON.Send(c.conn, msg)
-	c.mu.Unlock()
-
+	response, err := c.call(ctx, msg, c.idempotent[endpoint])
 	if err != nil {
-		return talk.NewError(talk.Unavailable, err.Error())
+		return err
+	}
+	if resp != nil && response.Result != nil {
+		if err := c.decodeResult(response, resp); err != nil {
+			return talk.NewError(talk.Internal, "failed to decode response")
+		}
 	}
+	return nil
+}
+
+// call sends msg with a new ID and waits for its response. With replay,
+// msg is re-sent after reconnecting instead of failing.
+func (c *Client) call(ctx context.Context, msg wsMessage, replay bool) (*wsResponse, error) {
+	msg.ID = c.nextID()
+	pc := &pendingCall{msg: msg, ch: make(chan *wsResponse, 1), replay: replay}
+	if err := c.start(pc); err != nil {
+		return nil, err
+	}
+	defer c.pending.Delete(msg.ID)
 
 	select {
 	case <-ctx.Done():
-		return ctx.Err()
-	case response := <-respCh:
+		return nil, ctx.Err()
+	case response := <-pc.ch:
 		if response.Error != nil {
-			return talk.NewError(talk.ErrorCode(response.Error.Code), response.Error.Message)
+			return nil, response.Error
 		}
-		if resp != nil && response.Result != nil {
-			data, err := json.Marshal(response.Result)
-			if err != nil {
-				return talk.NewError(talk.Internal, "failed to encode result")
-			}
-			if err := c.codec.Unmarshal(data, resp); err != nil {
-				return talk.NewError(talk.Internal, "failed to decode response")
-			}
+		return response, nil
+	}
+}
+
+// start registers pc and sends its message. While reconnecting, the
+// messages to replay are only registered, to be sent once reconnected.
+func (c *Client) start(pc *pendingCall) error {
+	c.mu.Lock()
+	switch c.state {
+	case StateClosed:
+		c.mu.Unlock()
+		return errClientClosed
+	case StateReconnecting:
+		c.mu.Unlock()
+		if !pc.replay {
+			return ErrConnectionLost
 		}
+		c.pending.Store(pc.msg.ID, pc)
 		return nil
 	}
+	c.pending.Store(pc.msg.ID, pc)
+	conn := c.conn
+	c.mu.Unlock()
+
+	if err := websocket.JSON.Send(conn, pc.msg); err != nil && !pc.replay {
+		c.pending.Delete(pc.msg.ID)
+		return talk.NewError(talk.Unavailable, err.Error())
+	}
+	return nil
+}
+
+// send sends msg without waiting for a response.
+func (c *Client) send(msg wsMessage) error {
+	c.mu.Lock()
+	state, conn := c.state, c.conn
+	c.mu.Unlock()
+
+	switch state {
+	case StateClosed:
+		return errClientClosed
+	case StateReconnecting:
+		return ErrConnectionLost
+	}
+	return websocket.JSON.Send(conn, msg)
+}
+
+// decodeResult decodes the result of response into v.
+func (c *Client) decodeResult(response *wsResponse, v any) error {
+	data, err := json.Marshal(response.Result)
+	if err != nil {
+		return err
+	}
+	if data, err = decodePayload(response.Encoding, data, c.config.MaxSize()); err != nil {
+		return err
+	}
+	return c.codec.Unmarshal(data, v)
 }
 
 func (c *Client) InvokeStream(ctx context.Context, endpoint string, req any) (talk.Stream, error) {
 	return &wsClientStream{
-		client:   c,
-		endpoint: endpoint,
-		ctx:      ctx,
+		client:    c,
+		endpoint:  endpoint,
+		ctx:       ctx,
+		responses: make(chan *wsResponse, streamBuffer),
+		done:      make(chan struct{}),
 	}, nil
 }
 
 func (c *Client) Close() error {
-	c.closed = true
-	if c.conn != nil {
+	c.cancel()
+
+	c.mu.Lock()
+	from := c.state
+	if from == StateClosed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.state = StateClosed
+	c.abort(errClientClosed, true)
+	c.mu.Unlock()
+
+	c.notify(from, StateClosed)
+	c.stopSubscriptions(io.EOF)
+	if from == StateConnected {
 		return c.conn.Close()
 	}
 	return nil
@@ -142,34 +296,171 @@ func (c *Client) Close() error {
 
 func (c *Client) nextID() string {
 	id := atomic.AddUint64(&c.reqID, 1)
-	return string(rune('a' + (id % 26)))
+	return strconv.FormatUint(id, 36)
 }
 
-func (c *Client) readLoop() {
-	for !c.closed {
-		var response wsResponse
-		if err := websocket.JSON.Receive(c.conn, &response); err != nil {
-			if err == io.EOF || c.closed {
-				return
+func (c *Client) dial() (*websocket.Conn, error) {
+	conn, err := websocket.DialConfig(c.wsConfig)
+	if err != nil {
+		return nil, talk.NewError(talk.Unavailable, err.Error())
+	}
+	return conn, nil
+}
+
+func (c *Client) notify(from, to ConnState) {
+	if c.stateFunc != nil && from != to {
+		c.stateFunc(from, to)
+	}
+}
+
+// abort ends the pending requests with err, except the ones to replay
+// unless all is set. c.mu must be held.
+func (c *Client) abort(err *talk.Error, all bool) {
+	c.pending.Range(func(id, v any) bool {
+		pc := v.(*pendingCall)
+		switch {
+		case pc.stream != nil:
+			pc.stream.stop(err)
+		case all || !pc.replay:
+			select {
+			case pc.ch <- &wsResponse{ID: pc.msg.ID, Error: err}:
+			default:
 			}
+		default:
+			return true
+		}
+		c.pending.Delete(id)
+		return true
+	})
+}
+
+func (c *Client) readLoop(conn *websocket.Conn) {
+	for {
+		var data []byte
+		if err := websocket.Message.Receive(conn, &data); err != nil {
+			c.disconnected(conn)
+			return
+		}
+		var response wsResponse
+		if err := json.Unmarshal(data, &response); err != nil {
 			continue
 		}
 
-		if ch, ok := c.pending.Load(response.ID); ok {
-			if respCh, ok := ch.(chan *wsResponse); ok {
-				select {
-				case respCh <- &response:
-				default:
-				}
+		if response.Type == TypeEvent {
+			c.dispatch(&response)
+			continue
+		}
+		v, ok := c.pending.Load(response.ID)
+		if !ok {
+			continue
+		}
+		if pc := v.(*pendingCall); pc.stream != nil {
+			pc.stream.deliver(&response)
+		} else {
+			select {
+			case pc.ch <- &response:
+			default:
 			}
 		}
 	}
 }
 
+// disconnected handles the drop of conn: the requests that cannot survive
+// it fail and the client reconnects, unless it is closed.
+func (c *Client) disconnected(conn *websocket.Conn) {
+	c.mu.Lock()
+	if c.state != StateConnected || c.conn != conn {
+		c.mu.Unlock()
+		return
+	}
+	conn.Close()
+	to := StateReconnecting
+	if c.config.DisableReconnect {
+		to = StateClosed
+	}
+	c.state = to
+	c.abort(ErrConnectionLost, to == StateClosed)
+	c.mu.Unlock()
+
+	c.notify(StateConnected, to)
+	c.stopSubscriptions(ErrConnectionLost)
+	if to == StateReconnecting {
+		go c.reconnect()
+	}
+}
+
+// reconnect redials with the reconnect backoff, and closes the client once
+// the retries are exhausted.
+func (c *Client) reconnect() {
+	var cfg talk.RetryConfig
+	if c.config.Reconnect != nil {
+		cfg = *c.config.Reconnect
+	}
+	err := x.Retry(c.ctx, cfg.RetryBackoff(), func(ctx context.Context) error {
+		conn, err := c.dial()
+		if err != nil {
+			return x.RetryableError(err)
+		}
+		c.resume(conn)
+		return nil
+	})
+	if err == nil {
+		return
+	}
+
+	c.mu.Lock()
+	if c.state != StateReconnecting {
+		c.mu.Unlock()
+		return
+	}
+	c.state = StateClosed
+	c.abort(ErrConnectionLost, true)
+	c.mu.Unlock()
+	c.notify(StateReconnecting, StateClosed)
+}
+
+// resume switches to conn and re-sends the requests to replay on it.
+func (c *Client) resume(conn *websocket.Conn) {
+	c.mu.Lock()
+	if c.state != StateReconnecting {
+		c.mu.Unlock()
+		conn.Close()
+		return
+	}
+	c.conn = conn
+	c.state = StateConnected
+	var replay []wsMessage
+	c.pending.Range(func(_, v any) bool {
+		replay = append(replay, v.(*pendingCall).msg)
+		return true
+	})
+	c.mu.Unlock()
+
+	go c.readLoop(conn)
+	for _, msg := range replay {
+		// A failure drops conn again, which the read loop handles.
+		if websocket.JSON.Send(conn, msg) != nil {
+			break
+		}
+	}
+	c.notify(StateReconnecting, StateConnected)
+}
+
+// streamBuffer is the number of responses a stream buffers before the read
+// loop waits for Recv.
+const streamBuffer = 16
+
 type wsClientStream struct {
-	client   *Client
-	endpoint string
-	ctx      context.Context
+	client    *Client
+	endpoint  string
+	ctx       context.Context
+	responses chan *wsResponse
+	done      chan struct{}
+	once      sync.Once
+	err       error
+
+	mu  sync.Mutex
+	ids []string
 }
 
 func (s *wsClientStream) Context() context.Context {
@@ -177,44 +468,208 @@ func (s *wsClientStream) Context() context.Context {
 }
 
 func (s *wsClientStream) Send(msg any) error {
+	select {
+	case <-s.done:
+		return s.err
+	default:
+	}
+
 	reqData, err := json.Marshal(msg)
 	if err != nil {
 		return err
 	}
+	params, encoding, err := encodePayload(s.client.compressor, s.client.config.MinSize(), reqData)
+	if err != nil {
+		return err
+	}
 
 	wsMsg := wsMessage{
-		ID:     s.client.nextID(),
-		Method: s.endpoint,
-		Params: reqData,
+		ID:       s.client.nextID(),
+		Method:   s.endpoint,
+		Params:   params,
+		Encoding: encoding,
+	}
+	if md, ok := talk.FromOutgoingContext(s.ctx); ok {
+		wsMsg.Metadata = md
 	}
 
-	s.client.mu.Lock()
-	defer s.client.mu.Unlock()
-	return websocket.JSON.Send(s.client.conn, wsMsg)
+	s.mu.Lock()
+	s.ids = append(s.ids, wsMsg.ID)
+	s.mu.Unlock()
+	return s.client.start(&pendingCall{msg: wsMsg, stream: s})
 }
 
 func (s *wsClientStream) Recv(msg any) error {
-	var response wsResponse
-	if err := websocket.JSON.Receive(s.client.conn, &response); err != nil {
-		return err
+	var response *wsResponse
+	select {
+	case response = <-s.responses:
+	case <-s.done:
+		return s.err
+	case <-s.ctx.Done():
+		return s.ctx.Err()
 	}
 
 	if response.Error != nil {
-		return talk.NewError(talk.ErrorCode(response.Error.Code), response.Error.Message)
+		return response.Error
 	}
 
 	if response.Result != nil {
-		data, err := json.Marshal(response.Result)
-		if err != nil {
-			return err
-		}
-		return s.client.codec.Unmarshal(data, msg)
+		return s.client.decodeResult(response, msg)
 	}
 
 	return nil
 }
 
+// deliver passes a response to Recv, waiting for room unless the stream
+// ends.
+func (s *wsClientStream) deliver(response *wsResponse) {
+	select {
+	case s.responses <- response:
+	case <-s.done:
+	case <-s.ctx.Done():
+	}
+}
+
+func (s *wsClientStream) stop(err error) {
+	s.once.Do(func() {
+		s.err = err
+		close(s.done)
+	})
+}
+
 func (s *wsClientStream) Close() error {
+	s.stop(io.EOF)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range s.ids {
+		s.client.pending.Delete(id)
+	}
+	return nil
+}
+
+// SubscriptionBuffer is the number of events a subscription buffers;
+// events arriving while it is full are dropped.
+const SubscriptionBuffer = 64
+
+// Subscribe subscribes to the events the server pushes on topic, whether
+// published to the room or sent to this connection. The returned stream
+// receives them until it is closed, which unsubscribes; it does not
+// support Send. Events on topics without subscription are dropped.
+func (c *Client) Subscribe(ctx context.Context, topic string) (talk.Stream, error) {
+	sub := &subscription{
+		client: c,
+		topic:  topic,
+		ctx:    ctx,
+		events: make(chan *wsResponse, SubscriptionBuffer),
+		done:   make(chan struct{}),
+	}
+	// Listen before the server acknowledges, so that no event is missed.
+	c.subMu.Lock()
+	c.subs[topic] = append(c.subs[topic], sub)
+	c.subMu.Unlock()
+
+	// Subscribing twice is harmless, so the request survives reconnecting.
+	if _, err := c.call(ctx, wsMessage{Type: TypeSubscribe, Topic: topic}, true); err != nil {
+		c.unsubscribe(sub, false)
+		return nil, err
+	}
+	return sub, nil
+}
+
+// stopSubscriptions ends all subscriptions with err.
+func (c *Client) stopSubscriptions(err error) {
+	c.subMu.Lock()
+	defer c.subMu.Unlock()
+	for _, subs := range c.subs {
+		for _, sub := range subs {
+			sub.stop(err)
+		}
+	}
+	c.subs = make(map[string][]*subscription)
+}
+
+// unsubscribe removes sub, and unsubscribes from the server with the last
+// subscription of the topic if notify is set.
+func (c *Client) unsubscribe(sub *subscription, notify bool) {
+	sub.stop(io.EOF)
+
+	c.subMu.Lock()
+	subs := c.subs[sub.topic]
+	for i, s := range subs {
+		if s == sub {
+			subs = append(subs[:i:i], subs[i+1:]...)
+			break
+		}
+	}
+	if len(subs) > 0 {
+		c.subs[sub.topic] = subs
+		notify = false
+	} else {
+		delete(c.subs, sub.topic)
+	}
+	c.subMu.Unlock()
+
+	if notify {
+		// The acknowledgement is not waited for.
+		c.send(wsMessage{ID: c.nextID(), Type: TypeUnsubscribe, Topic: sub.topic})
+	}
+}
+
+// dispatch delivers an event to the subscriptions of its topic.
+func (c *Client) dispatch(event *wsResponse) {
+	c.subMu.Lock()
+	subs := c.subs[event.Topic]
+	c.subMu.Unlock()
+
+	for _, sub := range subs {
+		select {
+		case sub.events <- event:
+		default:
+		}
+	}
+}
+
+type subscription struct {
+	client *Client
+	topic  string
+	ctx    context.Context
+	events chan *wsResponse
+	done   chan struct{}
+	once   sync.Once
+	err    error
+}
+
+func (s *subscription) stop(err error) {
+	s.once.Do(func() {
+		s.err = err
+		close(s.done)
+	})
+}
+
+func (s *subscription) Context() context.Context {
+	return s.ctx
+}
+
+func (s *subscription) Send(msg any) error {
+	return talk.NewError(talk.Unimplemented, "subscriptions do not support Send")
+}
+
+func (s *subscription) Recv(msg any) error {
+	select {
+	case event := <-s.events:
+		if event.Result == nil {
+			return nil
+		}
+		return s.client.decodeResult(event, msg)
+	case <-s.done:
+		return s.err
+	case <-s.ctx.Done():
+		return s.ctx.Err()
+	}
+}
+
+func (s *subscription) Close() error {
+	s.client.unsubscribe(s, true)
 	return nil
 }
 
@@ -232,5 +687,3 @@ func init() {
 		},
 	}, "ws")
 }
-
-var _ = time.Second
@@ -0,0 +1,44 @@
+package websocket
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"go.zoe.im/x/talk/codec"
+)
+
+// encodePayload compresses data with comp if comp is not nil and data is at
+// least minSize bytes. A compressed payload is sent as a base64 JSON string
+// along with the encoding that produced it; otherwise data is sent as is
+// and the encoding is empty.
+func encodePayload(comp codec.Compressor, minSize int, data []byte) (json.RawMessage, string, error) {
+	if comp == nil || len(data) < minSize {
+		return data, "", nil
+	}
+	compressed, err := codec.Compress(comp, data)
+	if err != nil {
+		return nil, "", err
+	}
+	payload, err := json.Marshal(compressed)
+	if err != nil {
+		return nil, "", err
+	}
+	return payload, comp.Name(), nil
+}
+
+// decodePayload reverses encodePayload for a payload sent with encoding.
+// Compressed payloads may expand to at most maxSize bytes.
+func decodePayload(encoding string, payload json.RawMessage, maxSize int) (json.RawMessage, error) {
+	if encoding == "" {
+		return payload, nil
+	}
+	var compressed []byte
+	if err := json.Unmarshal(payload, &compressed); err != nil {
+		return nil, err
+	}
+	comp, err := codec.GetCompressor(encoding)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+	}
+	return codec.DecompressLimit(comp, compressed, maxSize)
+}
@@ -0,0 +1,336 @@
+package websocket
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"go.zoe.im/x/talk"
+)
+
+// Types of control messages and of the events pushed by the server.
+// Requests and their responses have no type.
+const (
+	TypeSubscribe   = "subscribe"
+	TypeUnsubscribe = "unsubscribe"
+	TypeEvent       = "event"
+)
+
+// SubscribeFunc authorizes a client to subscribe to a topic; a returned
+// error is sent to the client, as *talk.Error or PermissionDenied.
+type SubscribeFunc func(conn *Conn, topic string) error
+
+// Hub tracks the connections of a Server and pushes events to them: to one
+// connection, to the members of a room or to everyone. A room is the set
+// of connections subscribed to a topic, by the client or by server code
+// with Join, and events published to it carry the room name as topic.
+type Hub struct {
+	server *Server
+
+	mu    sync.RWMutex
+	conns map[string]*Conn
+	rooms map[string]map[string]*Conn
+}
+
+func newHub(s *Server) *Hub {
+	return &Hub{
+		server: s,
+		conns:  make(map[string]*Conn),
+		rooms:  make(map[string]map[string]*Conn),
+	}
+}
+
+func (h *Hub) add(c *Conn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.conns[c.id] = c
+}
+
+func (h *Hub) remove(c *Conn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.conns, c.id)
+	for room := range c.rooms {
+		h.leave(c, room)
+	}
+}
+
+// Conns returns the open connections, ordered by ID.
+func (h *Hub) Conns() []*Conn {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return sortedConns(h.conns)
+}
+
+// Conn returns the connection with the given ID.
+func (h *Hub) Conn(id string) (*Conn, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	c, ok := h.conns[id]
+	return c, ok
+}
+
+// Rooms returns the names of the rooms with members.
+func (h *Hub) Rooms() []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	rooms := make([]string, 0, len(h.rooms))
+	for room := range h.rooms {
+		rooms = append(rooms, room)
+	}
+	sort.Strings(rooms)
+	return rooms
+}
+
+// Members returns the connections in room, ordered by ID.
+func (h *Hub) Members(room string) []*Conn {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return sortedConns(h.rooms[room])
+}
+
+// Join adds the connection with the given ID to room.
+func (h *Hub) Join(id, room string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	c, ok := h.conns[id]
+	if !ok {
+		return talk.NewError(talk.NotFound, "connection not found: "+id)
+	}
+	h.join(c, room)
+	return nil
+}
+
+// Leave removes the connection with the given ID from room.
+func (h *Hub) Leave(id, room string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	c, ok := h.conns[id]
+	if !ok {
+		return talk.NewError(talk.NotFound, "connection not found: "+id)
+	}
+	h.leave(c, room)
+	return nil
+}
+
+func (h *Hub) join(c *Conn, room string) {
+	members, ok := h.rooms[room]
+	if !ok {
+		members = make(map[string]*Conn)
+		h.rooms[room] = members
+	}
+	members[c.id] = c
+	c.rooms[room] = true
+}
+
+func (h *Hub) leave(c *Conn, room string) {
+	delete(c.rooms, room)
+	if members, ok := h.rooms[room]; ok {
+		delete(members, c.id)
+		if len(members) == 0 {
+			delete(h.rooms, room)
+		}
+	}
+}
+
+// Send pushes msg as an event of topic to the connection with the given
+// ID.
+func (h *Hub) Send(id, topic string, msg any) error {
+	c, ok := h.Conn(id)
+	if !ok {
+		return talk.NewError(talk.NotFound, "connection not found: "+id)
+	}
+	return c.Send(topic, msg)
+}
+
+// Publish pushes msg to the members of room. Connections whose event queue
+// is full are closed and skipped.
+func (h *Hub) Publish(room string, msg any) error {
+	return h.push(h.Members(room), room, msg)
+}
+
+// Broadcast pushes msg as an event of topic to every connection, like
+// Publish.
+func (h *Hub) Broadcast(topic string, msg any) error {
+	return h.push(h.Conns(), topic, msg)
+}
+
+func (h *Hub) push(conns []*Conn, topic string, msg any) error {
+	data, err := h.server.codec.Marshal(msg)
+	if err != nil {
+		return talk.NewError(talk.InvalidArgument, "failed to encode event")
+	}
+	for _, c := range conns {
+		c.push(topic, data)
+	}
+	return nil
+}
+
+func sortedConns(m map[string]*Conn) []*Conn {
+	conns := make([]*Conn, 0, len(m))
+	for _, c := range m {
+		conns = append(conns, c)
+	}
+	sort.Slice(conns, func(i, j int) bool { return conns[i].id < conns[j].id })
+	return conns
+}
+
+// Conn is a client connection of a Server.
+type Conn struct {
+	id     string
+	peer   string
+	hub    *Hub
+	stream *wsStream
+
+	// rooms is guarded by hub.mu.
+	rooms map[string]bool
+
+	// events queues the events pushed to the connection, written by
+	// writeEvents so that a slow client does not hold up the others. done
+	// is closed when the connection ends.
+	events chan event
+	done   chan struct{}
+
+	mu       sync.Mutex
+	identity string
+}
+
+// ID returns the unique ID of the connection.
+func (c *Conn) ID() string {
+	return c.id
+}
+
+// Peer returns the remote address of the connection.
+func (c *Conn) Peer() string {
+	return c.peer
+}
+
+// Hub returns the hub of the connection.
+func (c *Conn) Hub() *Hub {
+	return c.hub
+}
+
+// Identity returns the identity attached to the connection: set by
+// SetIdentity, or the last one AuthMiddleware authenticated a request of
+// the connection with.
+func (c *Conn) Identity() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.identity
+}
+
+// SetIdentity attaches identity to the connection.
+func (c *Conn) SetIdentity(identity string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.identity = identity
+}
+
+// Rooms returns the rooms the connection is in.
+func (c *Conn) Rooms() []string {
+	c.hub.mu.RLock()
+	defer c.hub.mu.RUnlock()
+	rooms := make([]string, 0, len(c.rooms))
+	for room := range c.rooms {
+		rooms = append(rooms, room)
+	}
+	sort.Strings(rooms)
+	return rooms
+}
+
+// Join adds the connection to room.
+func (c *Conn) Join(room string) {
+	c.hub.mu.Lock()
+	defer c.hub.mu.Unlock()
+	c.hub.join(c, room)
+}
+
+// Leave removes the connection from room.
+func (c *Conn) Leave(room string) {
+	c.hub.mu.Lock()
+	defer c.hub.mu.Unlock()
+	c.hub.leave(c, room)
+}
+
+// Send pushes msg as an event of topic to the connection. The event is
+// queued; the connection is closed if its queue is full.
+func (c *Conn) Send(topic string, msg any) error {
+	data, err := c.hub.server.codec.Marshal(msg)
+	if err != nil {
+		return talk.NewError(talk.InvalidArgument, "failed to encode event")
+	}
+	return c.push(topic, data)
+}
+
+// event is an encoded event waiting to be written.
+type event struct {
+	topic string
+	data  []byte
+}
+
+// push queues an event without blocking.
+func (c *Conn) push(topic string, data []byte) error {
+	select {
+	case <-c.done:
+		return errConnClosed
+	default:
+	}
+	select {
+	case c.events <- event{topic: topic, data: data}:
+		return nil
+	default:
+		c.stream.abort()
+		return talk.NewError(talk.Unavailable, "connection is too slow, closed")
+	}
+}
+
+var errConnClosed = talk.NewError(talk.Unavailable, "connection closed")
+
+// writeEvents writes the queued events until the connection ends.
+func (c *Conn) writeEvents() {
+	for {
+		select {
+		case <-c.done:
+			return
+		case ev := <-c.events:
+			if c.stream.sendEvent(ev.topic, ev.data) != nil {
+				return
+			}
+		}
+	}
+}
+
+// Close closes the connection.
+func (c *Conn) Close() error {
+	return c.stream.conn.Close()
+}
+
+type connKey struct{}
+
+// ConnFromContext returns the connection a request was received on, so
+// that handlers can reach the Hub.
+func ConnFromContext(ctx context.Context) (*Conn, bool) {
+	c, ok := ctx.Value(connKey{}).(*Conn)
+	return c, ok
+}
+
+// subscribe handles a subscribe or unsubscribe control message.
+func (c *Conn) subscribe(msg *wsMessage) error {
+	if msg.Topic == "" {
+		return talk.NewError(talk.InvalidArgument, "topic is required")
+	}
+	if msg.Type == TypeUnsubscribe {
+		c.Leave(msg.Topic)
+		return nil
+	}
+	if fn := c.hub.server.subscribeFunc; fn != nil {
+		if err := fn(c, msg.Topic); err != nil {
+			if talkErr, ok := talk.IsError(err); ok {
+				return talkErr
+			}
+			return talk.NewError(talk.PermissionDenied, err.Error())
+		}
+	}
+	c.Join(msg.Topic)
+	return nil
+}
@@ -3,10 +3,12 @@ package websocket
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	"io"
 	"net/http"
+	"slices"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/net/websocket"
@@ -18,11 +20,15 @@ import (
 
 // Server implements talk.Transport using WebSocket.
 type Server struct {
-	config    ServerConfig
-	codec     codec.Codec
-	server    *http.Server
-	endpoints map[string]*talk.Endpoint
-	conns     sync.Map
+	config     ServerConfig
+	codec      codec.Codec
+	compressor codec.Compressor
+	server     *http.Server
+	endpoints  map[string]*talk.Endpoint
+	hub        *Hub
+	connID     atomic.Uint64
+
+	subscribeFunc SubscribeFunc
 }
 
 // NewServer creates a new WebSocket server transport.
@@ -30,6 +36,7 @@ func NewServer(cfg x.TypedLazyConfig, opts ...Option) (*Server, error) {
 	s := &Server{
 		endpoints: make(map[string]*talk.Endpoint),
 	}
+	s.hub = newHub(s)
 
 	if err := cfg.Unmarshal(&s.config); err != nil {
 		return nil, err
@@ -47,6 +54,15 @@ func NewServer(cfg x.TypedLazyConfig, opts ...Option) (*Server, error) {
 		s.codec = codec.MustGet("json")
 	}
 
+	if s.config.EnableCompression && s.config.Compression == "" {
+		s.config.Compression = "gzip"
+	}
+	comp, err := s.config.Compressor()
+	if err != nil {
+		return nil, err
+	}
+	s.compressor = comp
+
 	return s, nil
 }
 
@@ -58,8 +74,16 @@ func (s *Server) String() string {
 	return "websocket"
 }
 
+// Hub returns the hub of the connections to the server.
+func (s *Server) Hub() *Hub {
+	return s.hub
+}
+
 func (s *Server) Serve(ctx context.Context, endpoints []*talk.Endpoint) error {
 	for _, ep := range endpoints {
+		ep = ep.Clone()
+		ep.Middleware = append(slices.Clip(ep.Middleware), captureIdentity)
+		ep.StreamMiddleware = append(slices.Clip(ep.StreamMiddleware), captureStreamIdentity)
 		s.endpoints[ep.Name] = ep
 	}
 
@@ -88,12 +112,9 @@ func (s *Server) Serve(ctx context.Context, endpoints []*talk.Endpoint) error {
 }
 
 func (s *Server) Shutdown(ctx context.Context) error {
-	s.conns.Range(func(key, value any) bool {
-		if conn, ok := value.(*websocket.Conn); ok {
-			conn.Close()
-		}
-		return true
-	})
+	for _, c := range s.hub.Conns() {
+		c.Close()
+	}
 
 	if s.server != nil {
 		return s.server.Shutdown(ctx)
@@ -114,102 +135,178 @@ func (s *Server) Close() error {
 }
 
 func (s *Server) handleConnection(conn *websocket.Conn) {
-	connID := fmt.Sprintf("%p", conn)
-	s.conns.Store(connID, conn)
+	stream := &wsStream{
+		conn:         conn,
+		codec:        s.codec,
+		minSize:      s.config.MinSize(),
+		maxSize:      s.config.MaxSize(),
+		writeTimeout: s.config.writeTimeout(),
+	}
+	c := &Conn{
+		id:     strconv.FormatUint(s.connID.Add(1), 10),
+		hub:    s.hub,
+		stream: stream,
+		rooms:  make(map[string]bool),
+		events: make(chan event, s.config.eventQueueSize()),
+		done:   make(chan struct{}),
+	}
+
+	// Handshake headers apply to every request on the connection.
+	var connMD talk.Metadata
+	if r := conn.Request(); r != nil {
+		connMD = talk.MetadataFromHeader(r.Header)
+		c.peer = r.RemoteAddr
+		if s.compressor != nil && codec.AcceptsEncoding(r.Header.Get("Accept-Encoding"), s.compressor.Name()) {
+			stream.compressor = s.compressor
+		}
+	}
+
+	s.hub.add(c)
+	go c.writeEvents()
 	defer func() {
-		s.conns.Delete(connID)
+		s.hub.remove(c)
+		close(c.done)
 		conn.Close()
 	}()
 
-	stream := &wsStream{
-		conn:  conn,
-		codec: s.codec,
-	}
-
 	for {
 		var msg wsMessage
 		if err := websocket.JSON.Receive(conn, &msg); err != nil {
-			if err == io.EOF {
+			// Malformed messages are reported; the connection is gone
+			// when even that fails.
+			if err == io.EOF || stream.sendError("", talk.NewError(talk.InvalidArgument, err.Error())) != nil {
 				return
 			}
-			s.sendError(conn, "", talk.NewError(talk.InvalidArgument, err.Error()))
+			continue
+		}
+
+		switch msg.Type {
+		case "":
+		case TypeSubscribe, TypeUnsubscribe:
+			if err := c.subscribe(&msg); err != nil {
+				stream.sendError(msg.ID, talk.ToError(err))
+			} else {
+				stream.sendResult(msg.ID, nil)
+			}
+			continue
+		default:
+			stream.sendError(msg.ID, talk.NewError(talk.InvalidArgument, "unknown message type: "+msg.Type))
 			continue
 		}
 
 		ep, ok := s.endpoints[msg.Method]
 		if !ok {
-			s.sendError(conn, msg.ID, talk.NewError(talk.NotFound, "method not found: "+msg.Method))
+			stream.sendError(msg.ID, talk.NewError(talk.NotFound, "method not found: "+msg.Method))
 			continue
 		}
 
-		go s.handleRequest(conn, stream, ep, &msg)
+		params, err := decodePayload(msg.Encoding, msg.Params, s.config.MaxSize())
+		if err != nil {
+			stream.sendError(msg.ID, talk.NewError(talk.InvalidArgument, err.Error()))
+			continue
+		}
+		msg.Params = params
+
+		go s.handleRequest(c, ep, connMD, &msg)
 	}
 }
 
-func (s *Server) handleRequest(conn *websocket.Conn, stream *wsStream, ep *talk.Endpoint, msg *wsMessage) {
-	ctx := context.Background()
+func (s *Server) handleRequest(c *Conn, ep *talk.Endpoint, connMD talk.Metadata, msg *wsMessage) {
+	stream := c.stream
+	md := connMD.Clone()
+	for k, v := range msg.Metadata {
+		md.Set(k, v)
+	}
+	ctx := talk.NewIncomingContext(context.Background(), md)
+	ctx = talk.NewPeerContext(ctx, c.peer)
+	ctx = talk.WithEndpointContext(ctx, ep)
+	ctx = context.WithValue(ctx, connKey{}, c)
 
 	if ep.IsStreaming() && ep.StreamHandler != nil {
-		if err := ep.StreamHandler(ctx, msg.Params, stream); err != nil {
-			s.sendError(conn, msg.ID, talk.ToError(err))
+		if err := ep.WrappedStreamHandler()(ctx, msg.Params, &requestStream{wsStream: stream, id: msg.ID}); err != nil {
+			stream.sendError(msg.ID, talk.ToError(err))
 		}
 		return
 	}
 
 	if ep.Handler == nil {
-		s.sendError(conn, msg.ID, talk.NewError(talk.Unimplemented, "no handler configured"))
+		stream.sendError(msg.ID, talk.NewError(talk.Unimplemented, "no handler configured"))
 		return
 	}
 
 	resp, err := ep.WrappedHandler()(ctx, msg.Params)
 	if err != nil {
-		s.sendError(conn, msg.ID, talk.ToError(err))
+		stream.sendError(msg.ID, talk.ToError(err))
 		return
 	}
 
-	s.sendResponse(conn, msg.ID, resp)
+	data, err := json.Marshal(resp)
+	if err != nil {
+		stream.sendError(msg.ID, talk.NewError(talk.Internal, "failed to encode response"))
+		return
+	}
+	stream.sendResult(msg.ID, data)
 }
 
-func (s *Server) sendResponse(conn *websocket.Conn, id string, result any) {
-	response := wsResponse{
-		ID:     id,
-		Result: result,
+// captureIdentity is the innermost middleware of every endpoint: it
+// attaches to the connection the identity AuthMiddleware authenticated the
+// request with.
+func captureIdentity(next talk.EndpointFunc) talk.EndpointFunc {
+	return func(ctx context.Context, req any) (any, error) {
+		setIdentity(ctx)
+		return next(ctx, req)
 	}
-	websocket.JSON.Send(conn, response)
 }
 
-func (s *Server) sendError(conn *websocket.Conn, id string, err *talk.Error) {
-	response := wsResponse{
-		ID: id,
-		Error: &wsError{
-			Code:    int(err.Code),
-			Message: err.Message,
-		},
+// captureStreamIdentity is the streaming counterpart of captureIdentity.
+func captureStreamIdentity(next talk.StreamEndpointFunc) talk.StreamEndpointFunc {
+	return func(ctx context.Context, req any, stream talk.Stream) error {
+		setIdentity(ctx)
+		return next(ctx, req, stream)
 	}
-	websocket.JSON.Send(conn, response)
 }
 
-type wsMessage struct {
-	ID     string          `json:"id"`
-	Method string          `json:"method"`
-	Params json.RawMessage `json:"params,omitempty"`
+func setIdentity(ctx context.Context) {
+	if identity, ok := talk.IdentityFromContext(ctx); ok {
+		if c, ok := ConnFromContext(ctx); ok {
+			c.SetIdentity(identity)
+		}
+	}
 }
 
-type wsResponse struct {
-	ID     string   `json:"id"`
-	Result any      `json:"result,omitempty"`
-	Error  *wsError `json:"error,omitempty"`
+// wsMessage is a request, or a control message of Type. Encoding names
+// the compressor of Params, in which case Params is a base64 JSON string.
+type wsMessage struct {
+	ID       string          `json:"id"`
+	Type     string          `json:"type,omitempty"`
+	Method   string          `json:"method,omitempty"`
+	Topic    string          `json:"topic,omitempty"`
+	Params   json.RawMessage `json:"params,omitempty"`
+	Encoding string          `json:"encoding,omitempty"`
+	Metadata talk.Metadata   `json:"metadata,omitempty"`
 }
 
-type wsError struct {
-	Code    int    `json:"code"`
-	Message string `json:"message"`
+// wsResponse is a response, a stream message or, with Type TypeEvent, an
+// event pushed on Topic. Encoding names the compressor of Result, in which
+// case Result is a base64 JSON string.
+type wsResponse struct {
+	ID       string      `json:"id"`
+	Type     string      `json:"type,omitempty"`
+	Topic    string      `json:"topic,omitempty"`
+	Result   any         `json:"result,omitempty"`
+	Encoding string      `json:"encoding,omitempty"`
+	Error    *talk.Error `json:"error,omitempty"`
 }
 
 type wsStream struct {
-	conn  *websocket.Conn
-	codec codec.Codec
-	mu    sync.Mutex
+	conn         *websocket.Conn
+	codec        codec.Codec
+	compressor   codec.Compressor
+	minSize      int
+	maxSize      int
+	writeTimeout time.Duration
+	mu           sync.Mutex
+	aborted      atomic.Bool
 }
 
 func (s *wsStream) Context() context.Context {
@@ -217,18 +314,66 @@ func (s *wsStream) Context() context.Context {
 }
 
 func (s *wsStream) Send(msg any) error {
+	data, err := s.codec.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return s.sendResult("", data)
+}
+
+// sendResult sends the encoded result of request id, compressed if the
+// client accepts it.
+func (s *wsStream) sendResult(id string, data []byte) error {
+	return s.send(wsResponse{ID: id}, data)
+}
+
+// sendEvent pushes an encoded event of topic.
+func (s *wsStream) sendEvent(topic string, data []byte) error {
+	return s.send(wsResponse{Type: TypeEvent, Topic: topic}, data)
+}
+
+func (s *wsStream) sendError(id string, err *talk.Error) error {
+	return s.send(wsResponse{ID: id, Error: err}, nil)
+}
+
+// send sends resp with data as result, compressed if the client accepts
+// it. A write that does not complete within writeTimeout closes the
+// connection.
+func (s *wsStream) send(resp wsResponse, data []byte) error {
+	if data != nil {
+		result, encoding, err := encodePayload(s.compressor, s.minSize, data)
+		if err != nil {
+			return err
+		}
+		resp.Result, resp.Encoding = result, encoding
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	if s.aborted.Load() {
+		return errConnClosed
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(s.writeTimeout))
+	if err := websocket.JSON.Send(s.conn, resp); err != nil {
+		s.conn.Close()
+		return err
+	}
+	return nil
+}
+
+// requestStream sends the messages of a stream handler with the ID of the
+// request that opened the stream, so that the client can route them.
+type requestStream struct {
+	*wsStream
+	id string
+}
 
+func (s *requestStream) Send(msg any) error {
 	data, err := s.codec.Marshal(msg)
 	if err != nil {
 		return err
 	}
-
-	response := wsResponse{
-		Result: json.RawMessage(data),
-	}
-	return websocket.JSON.Send(s.conn, response)
+	return s.sendResult(s.id, data)
 }
 
 func (s *wsStream) Recv(msg any) error {
@@ -236,17 +381,27 @@ func (s *wsStream) Recv(msg any) error {
 	if err := websocket.JSON.Receive(s.conn, &wsMsg); err != nil {
 		return err
 	}
-	return s.codec.Unmarshal(wsMsg.Params, msg)
+	params, err := decodePayload(wsMsg.Encoding, wsMsg.Params, s.maxSize)
+	if err != nil {
+		return err
+	}
+	return s.codec.Unmarshal(params, msg)
 }
 
 func (s *wsStream) Close() error {
 	return s.conn.Close()
 }
 
+// abort closes the connection without waiting for a pending write, which
+// Close does to send the close frame.
+func (s *wsStream) abort() {
+	s.aborted.Store(true)
+	s.conn.SetWriteDeadline(time.Now())
+	go s.conn.Close()
+}
+
 func init() {
 	ServerFactory.Register("default", func(cfg x.TypedLazyConfig, opts ...Option) (ServerTransport, error) {
 		return NewServer(cfg, opts...)
 	})
 }
-
-var _ = time.Second
@@ -2,6 +2,8 @@
 package websocket
 
 import (
+	"time"
+
 	"go.zoe.im/x"
 	"go.zoe.im/x/factory"
 	"go.zoe.im/x/talk"
@@ -16,17 +18,58 @@ type Config struct {
 	WriteBufferSize int        `json:"write_buffer_size,omitempty" yaml:"write_buffer_size"`
 	PingInterval    x.Duration `json:"ping_interval,omitempty" yaml:"ping_interval"`
 	PongTimeout     x.Duration `json:"pong_timeout,omitempty" yaml:"pong_timeout"`
+
+	// Compression compresses message payloads of at least
+	// CompressionMinSize bytes. Clients announce it in the handshake and
+	// servers only compress for clients that did.
+	codec.CompressionConfig `json:",inline" yaml:",inline"`
 }
 
 type ServerConfig struct {
-	Config            `json:",inline" yaml:",inline"`
-	CheckOrigin       bool `json:"check_origin,omitempty" yaml:"check_origin"`
+	Config      `json:",inline" yaml:",inline"`
+	CheckOrigin bool `json:"check_origin,omitempty" yaml:"check_origin"`
+	// EnableCompression is a shorthand for "compression": "gzip".
 	EnableCompression bool `json:"enable_compression,omitempty" yaml:"enable_compression"`
+
+	// WriteTimeout bounds each write to a client; a client that does not
+	// read within it is disconnected. Defaults to DefaultWriteTimeout.
+	WriteTimeout x.Duration `json:"write_timeout,omitempty" yaml:"write_timeout"`
+	// EventQueueSize is the number of pushed events a connection may have
+	// waiting to be written; a client that lets it fill up is disconnected.
+	// Defaults to DefaultEventQueueSize.
+	EventQueueSize int `json:"event_queue_size,omitempty" yaml:"event_queue_size"`
+}
+
+// Defaults used when the corresponding ServerConfig field is zero.
+const (
+	DefaultWriteTimeout   = 10 * time.Second
+	DefaultEventQueueSize = 64
+)
+
+func (c ServerConfig) writeTimeout() time.Duration {
+	if c.WriteTimeout <= 0 {
+		return DefaultWriteTimeout
+	}
+	return time.Duration(c.WriteTimeout)
+}
+
+func (c ServerConfig) eventQueueSize() int {
+	if c.EventQueueSize <= 0 {
+		return DefaultEventQueueSize
+	}
+	return c.EventQueueSize
 }
 
 type ClientConfig struct {
 	Config           `json:",inline" yaml:",inline"`
 	HandshakeTimeout x.Duration `json:"handshake_timeout,omitempty" yaml:"handshake_timeout"`
+
+	// Reconnect configures how a dropped connection is redialed. Only the
+	// backoff is used; defaults apply when nil, and the client closes once
+	// the retries are exhausted.
+	Reconnect *talk.RetryConfig `json:"reconnect,omitempty" yaml:"reconnect"`
+	// DisableReconnect closes the client when its connection drops instead.
+	DisableReconnect bool `json:"disable_reconnect,omitempty" yaml:"disable_reconnect"`
 }
 
 type Option func(any)
@@ -39,6 +82,50 @@ func WithCodec(c codec.Codec) Option {
 	}
 }
 
+// WithSubscribeFunc sets the function authorizing the subscriptions of
+// clients; without it, clients may subscribe to any topic.
+func WithSubscribeFunc(fn SubscribeFunc) Option {
+	return func(v any) {
+		if s, ok := v.(*Server); ok {
+			s.subscribeFunc = fn
+		}
+	}
+}
+
+// WithReconnect sets how the client redials a dropped connection, e.g. to
+// use a custom x.RetryBackoff through NewBackoff.
+func WithReconnect(cfg talk.RetryConfig) Option {
+	return func(v any) {
+		if c, ok := v.(*Client); ok {
+			c.config.Reconnect = &cfg
+		}
+	}
+}
+
+// WithEndpoints tells the client the endpoints it calls; the calls to those
+// marked idempotent are re-sent after reconnecting instead of failing.
+func WithEndpoints(endpoints ...*talk.Endpoint) Option {
+	return func(v any) {
+		if c, ok := v.(*Client); ok {
+			for _, ep := range endpoints {
+				if ep.IsIdempotent() {
+					c.idempotent[ep.Name] = true
+				}
+			}
+		}
+	}
+}
+
+// WithStateFunc sets a function called with every change of the client's
+// connection state, e.g. to show it in a UI. It must not block.
+func WithStateFunc(fn func(from, to ConnState)) Option {
+	return func(v any) {
+		if c, ok := v.(*Client); ok {
+			c.stateFunc = fn
+		}
+	}
+}
+
 var serverFactory = factory.NewFactory[ServerTransport, Option]()
 
 var ServerFactory = struct {
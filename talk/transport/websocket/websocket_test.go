@@ -3,9 +3,16 @@ package websocket
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"golang.org/x/net/websocket"
+
 	"go.zoe.im/x"
 	"go.zoe.im/x/talk"
 	"go.zoe.im/x/talk/codec"
@@ -287,11 +294,8 @@ func TestWSResponseFormat(t *testing.T) {
 		{
 			name: "error response",
 			response: wsResponse{
-				ID: "test-456",
-				Error: &wsError{
-					Code:    int(talk.NotFound),
-					Message: "user not found",
-				},
+				ID:    "test-456",
+				Error: talk.NewError(talk.NotFound, "user not found"),
 			},
 		},
 	}
@@ -408,3 +412,463 @@ func TestIntegration(t *testing.T) {
 
 	cancel()
 }
+
+func TestIntegration_Metadata(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	server, err := NewServer(x.TypedLazyConfig{
+		Config: json.RawMessage(`{"addr": ":18091", "path": "/ws"}`),
+	})
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+
+	endpoints := []*talk.Endpoint{
+		{
+			Name: "Whoami",
+			Handler: func(ctx context.Context, req any) (any, error) {
+				md, _ := talk.FromIncomingContext(ctx)
+				token, _ := talk.BearerTokenFromContext(ctx)
+				return map[string]string{"tenant": md.Get("x-tenant"), "token": token}, nil
+			},
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.Serve(ctx, endpoints)
+	time.Sleep(100 * time.Millisecond)
+
+	client, err := NewClient(x.TypedLazyConfig{
+		Config: json.RawMessage(`{"addr": "localhost:18091", "path": "/ws"}`),
+	})
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	defer client.Close()
+
+	callCtx := talk.NewOutgoingContext(context.Background(), talk.MetadataPairs(
+		"authorization", "Bearer abc",
+		"x-tenant", "acme",
+	))
+
+	var result map[string]string
+	if err := client.Invoke(callCtx, "Whoami", nil, &result); err != nil {
+		t.Fatalf("Invoke failed: %v", err)
+	}
+	if result["tenant"] != "acme" || result["token"] != "abc" {
+		t.Errorf("result = %v, want tenant acme and token abc", result)
+	}
+}
+
+func TestIntegration_ErrorDetails(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	server, err := NewServer(x.TypedLazyConfig{
+		Config: json.RawMessage(`{"addr": ":18092", "path": "/ws"}`),
+	})
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+
+	endpoints := []*talk.Endpoint{
+		{
+			Name: "CreateUser",
+			Handler: func(ctx context.Context, req any) (any, error) {
+				return nil, talk.NewErrorWithTypedDetails(talk.InvalidArgument, "invalid request",
+					talk.BadRequest{FieldViolations: []talk.FieldViolation{{Field: "email", Rule: "required", Description: "is required"}}})
+			},
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.Serve(ctx, endpoints)
+	time.Sleep(100 * time.Millisecond)
+
+	client, err := NewClient(x.TypedLazyConfig{
+		Config: json.RawMessage(`{"addr": "localhost:18092", "path": "/ws"}`),
+	})
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	defer client.Close()
+
+	err = client.Invoke(context.Background(), "CreateUser", nil, nil)
+	if e, ok := talk.IsError(err); !ok || e.Code != talk.InvalidArgument {
+		t.Fatalf("err = %v, want InvalidArgument", err)
+	}
+	var br talk.BadRequest
+	if !errors.As(err, &br) || len(br.FieldViolations) != 1 || br.FieldViolations[0].Field != "email" {
+		t.Errorf("BadRequest = %+v", br)
+	}
+}
+
+func TestIntegration_Compression(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	server, err := NewServer(x.TypedLazyConfig{
+		Config: json.RawMessage(`{"addr": ":18093", "path": "/ws", "compression": "snappy", "compression_min_size": 64}`),
+	})
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+
+	endpoints := []*talk.Endpoint{
+		{
+			Name: "Echo",
+			Handler: func(ctx context.Context, req any) (any, error) {
+				var s string
+				err := json.Unmarshal(req.(json.RawMessage), &s)
+				return s, err
+			},
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.Serve(ctx, endpoints)
+	time.Sleep(100 * time.Millisecond)
+
+	// Clients without compression keep getting plain payloads.
+	for _, cfg := range []string{
+		`{"addr": "localhost:18093", "path": "/ws", "compression": "snappy", "compression_min_size": 64}`,
+		`{"addr": "localhost:18093", "path": "/ws"}`,
+	} {
+		client, err := NewClient(x.TypedLazyConfig{Config: json.RawMessage(cfg)})
+		if err != nil {
+			t.Fatalf("NewClient failed: %v", err)
+		}
+		for _, msg := range []string{"hi", strings.Repeat("hello ", 100)} {
+			var result string
+			if err := client.Invoke(context.Background(), "Echo", msg, &result); err != nil {
+				t.Fatalf("Invoke failed: %v", err)
+			}
+			if result != msg {
+				t.Errorf("result = %q, want %q", result, msg)
+			}
+		}
+		client.Close()
+	}
+}
+
+func TestPayloadCompression(t *testing.T) {
+	comp, err := codec.GetCompressor("gzip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte(`"` + strings.Repeat("hello ", 100) + `"`)
+
+	payload, encoding, err := encodePayload(comp, 64, data)
+	if err != nil || encoding != "gzip" || len(payload) >= len(data) {
+		t.Fatalf("encodePayload = %d bytes, %q, %v; want smaller gzip payload", len(payload), encoding, err)
+	}
+	got, err := decodePayload(encoding, payload, len(data))
+	if err != nil || string(got) != string(data) {
+		t.Errorf("decodePayload = %q, %v", got, err)
+	}
+	if _, err := decodePayload(encoding, payload, len(data)-1); err != codec.ErrMessageTooLarge {
+		t.Errorf("decodePayload over the size limit = %v, want ErrMessageTooLarge", err)
+	}
+
+	if _, encoding, _ := encodePayload(comp, 64, []byte(`"hi"`)); encoding != "" {
+		t.Errorf("small payload encoding = %q, want none", encoding)
+	}
+	if _, err := decodePayload("br", payload, len(data)); err == nil {
+		t.Error("decodePayload with an unknown encoding should fail")
+	}
+}
+
+func TestHub(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	server, err := NewServer(x.TypedLazyConfig{
+		Config: json.RawMessage(`{"addr": ":18094", "path": "/ws"}`),
+	}, WithSubscribeFunc(func(conn *Conn, topic string) error {
+		if topic == "admin" && conn.Identity() != "alice" {
+			return errors.New("admins only")
+		}
+		return nil
+	}))
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+	hub := server.Hub()
+
+	endpoints := []*talk.Endpoint{
+		{
+			Name: "Say",
+			Handler: func(ctx context.Context, req any) (any, error) {
+				var text string
+				if err := json.Unmarshal(req.(json.RawMessage), &text); err != nil {
+					return nil, err
+				}
+				conn, _ := ConnFromContext(ctx)
+				return nil, conn.Hub().Publish("chat", text)
+			},
+		},
+		{
+			Name:       "Login",
+			Metadata:   map[string]any{"auth": "token"},
+			Middleware: []talk.MiddlewareFunc{talk.AuthMiddleware(func(ctx context.Context, req any) (string, error) { return "alice", nil })},
+			Handler: func(ctx context.Context, req any) (any, error) {
+				conn, _ := ConnFromContext(ctx)
+				return conn.ID(), nil
+			},
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.Serve(ctx, endpoints)
+	time.Sleep(100 * time.Millisecond)
+
+	dial := func() *Client {
+		client, err := NewClient(x.TypedLazyConfig{Config: json.RawMessage(`{"addr": "localhost:18094", "path": "/ws"}`)})
+		if err != nil {
+			t.Fatalf("NewClient failed: %v", err)
+		}
+		t.Cleanup(func() { client.Close() })
+		return client
+	}
+	subscribe := func(client *Client, topic string) talk.Stream {
+		sub, err := client.Subscribe(ctx, topic)
+		if err != nil {
+			t.Fatalf("Subscribe(%q) failed: %v", topic, err)
+		}
+		return sub
+	}
+	recv := func(sub talk.Stream, want string) {
+		t.Helper()
+		recvCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+		defer cancel()
+		done := make(chan error, 1)
+		var got string
+		go func() { done <- sub.Recv(&got) }()
+		select {
+		case err := <-done:
+			if err != nil || got != want {
+				t.Errorf("Recv = %q, %v, want %q", got, err, want)
+			}
+		case <-recvCtx.Done():
+			t.Errorf("Recv timed out, want %q", want)
+		}
+	}
+
+	alice, bob := dial(), dial()
+	aliceChat, bobChat := subscribe(alice, "chat"), subscribe(bob, "chat")
+	if err := alice.Invoke(ctx, "Say", "hi", nil); err != nil {
+		t.Fatalf("Invoke(Say) failed: %v", err)
+	}
+	recv(aliceChat, "hi")
+	recv(bobChat, "hi")
+
+	// Only alice may subscribe to admin once authenticated.
+	if _, err := bob.Subscribe(ctx, "admin"); err == nil {
+		t.Error("Subscribe(admin) should fail without identity")
+	} else if e, ok := talk.IsError(err); !ok || e.Code != talk.PermissionDenied {
+		t.Errorf("Subscribe(admin) = %v, want PermissionDenied", err)
+	}
+	var aliceID string
+	if err := alice.Invoke(ctx, "Login", nil, &aliceID); err != nil {
+		t.Fatalf("Invoke(Login) failed: %v", err)
+	}
+	aliceAdmin := subscribe(alice, "admin")
+
+	if conns := hub.Conns(); len(conns) != 2 {
+		t.Fatalf("Conns = %d, want 2", len(conns))
+	}
+	conn, ok := hub.Conn(aliceID)
+	if !ok || conn.Identity() != "alice" {
+		t.Fatalf("Conn(%s) = %v, %v, want identity alice", aliceID, conn, ok)
+	}
+	if rooms := conn.Rooms(); !reflect.DeepEqual(rooms, []string{"admin", "chat"}) {
+		t.Errorf("Rooms = %v, want [admin chat]", rooms)
+	}
+
+	if err := hub.Send(aliceID, "admin", "direct"); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	recv(aliceAdmin, "direct")
+	if err := hub.Broadcast("chat", "everyone"); err != nil {
+		t.Fatalf("Broadcast failed: %v", err)
+	}
+	recv(aliceChat, "everyone")
+	recv(bobChat, "everyone")
+
+	// Unsubscribing and disconnecting leave the rooms.
+	bobChat.Close()
+	waitFor(t, func() bool { return len(hub.Members("chat")) == 1 })
+	alice.Close()
+	waitFor(t, func() bool { return len(hub.Conns()) == 1 && len(hub.Rooms()) == 0 })
+}
+
+func TestHub_SlowConn(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	server, err := NewServer(x.TypedLazyConfig{
+		Config: json.RawMessage(`{"addr": ":18096", "path": "/ws", "event_queue_size": 4}`),
+	})
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+	hub := server.Hub()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.Serve(ctx, nil)
+	time.Sleep(100 * time.Millisecond)
+
+	// A client that never reads is disconnected instead of holding up
+	// the broadcasts.
+	stalled, err := websocket.Dial("ws://localhost:18096/ws", "", "http://localhost/")
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer stalled.Close()
+	waitFor(t, func() bool { return len(hub.Conns()) == 1 })
+
+	big := strings.Repeat("x", 64<<10)
+	start := time.Now()
+	for i := 0; i < 512; i++ {
+		if err := hub.Broadcast("news", big); err != nil {
+			t.Fatalf("Broadcast failed: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Broadcast took %v", elapsed)
+	}
+	waitFor(t, func() bool { return len(hub.Conns()) == 0 })
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	server, err := NewServer(x.TypedLazyConfig{
+		Config: json.RawMessage(`{"addr": ":18095", "path": "/ws"}`),
+	})
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+
+	// Both drop the connection the first time they are called.
+	var gets, puts atomic.Int32
+	dropOnce := func(calls *atomic.Int32) talk.EndpointFunc {
+		return func(ctx context.Context, req any) (any, error) {
+			if calls.Add(1) == 1 {
+				conn, _ := ConnFromContext(ctx)
+				conn.Close()
+			}
+			return "ok", nil
+		}
+	}
+	endpoints := []*talk.Endpoint{
+		{Name: "Get", Handler: dropOnce(&gets), Metadata: map[string]any{talk.IdempotentMetadataKey: "true"}},
+		{Name: "Put", Handler: dropOnce(&puts)},
+		{
+			Name:       "Watch",
+			StreamMode: talk.StreamServerSide,
+			StreamHandler: func(ctx context.Context, req any, stream talk.Stream) error {
+				for _, v := range []string{"a", "b"} {
+					if err := stream.Send(v); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.Serve(ctx, endpoints)
+	time.Sleep(100 * time.Millisecond)
+
+	var mu sync.Mutex
+	var states []string
+	client, err := NewClient(x.TypedLazyConfig{Config: json.RawMessage(`{"addr": "localhost:18095", "path": "/ws"}`)},
+		WithEndpoints(endpoints...),
+		WithReconnect(talk.RetryConfig{Backoff: "constant", Initial: x.Duration(10 * time.Millisecond), MaxRetries: 100}),
+		WithStateFunc(func(from, to ConnState) {
+			mu.Lock()
+			defer mu.Unlock()
+			states = append(states, to.String())
+		}))
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	defer client.Close()
+
+	sub, err := client.Subscribe(ctx, "news")
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+
+	// The idempotent call is re-sent on the new connection.
+	var got string
+	if err := client.Invoke(ctx, "Get", nil, &got); err != nil || got != "ok" {
+		t.Fatalf("Invoke(Get) = %q, %v, want ok", got, err)
+	}
+	if n := gets.Load(); n != 2 {
+		t.Errorf("Get called %d times, want 2", n)
+	}
+	if err := sub.Recv(&got); !errors.Is(err, ErrConnectionLost) {
+		t.Errorf("subscription Recv = %v, want ErrConnectionLost", err)
+	}
+
+	waitFor(t, func() bool { return client.State() == StateConnected })
+	if err := client.Invoke(ctx, "Put", nil, nil); !errors.Is(err, ErrConnectionLost) {
+		t.Errorf("Invoke(Put) = %v, want ErrConnectionLost", err)
+	}
+	if n := puts.Load(); n != 1 {
+		t.Errorf("Put called %d times, want 1", n)
+	}
+
+	waitFor(t, func() bool { return client.State() == StateConnected })
+	stream, err := client.InvokeStream(ctx, "Watch", nil)
+	if err != nil {
+		t.Fatalf("InvokeStream failed: %v", err)
+	}
+	if err := stream.Send(nil); err != nil {
+		t.Fatalf("stream Send failed: %v", err)
+	}
+	for _, want := range []string{"a", "b"} {
+		if err := stream.Recv(&got); err != nil || got != want {
+			t.Errorf("stream Recv = %q, %v, want %q", got, err, want)
+		}
+	}
+	stream.Close()
+
+	client.Close()
+	if err := client.Invoke(ctx, "Get", nil, nil); err == nil {
+		t.Error("Invoke after Close should fail")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"reconnecting", "connected", "reconnecting", "connected", "closed"}
+	if !reflect.DeepEqual(states, want) {
+		t.Errorf("states = %v, want %v", states, want)
+	}
+}
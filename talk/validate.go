@@ -0,0 +1,286 @@
+package talk
+
+import (
+	"context"
+	"fmt"
+	"net/mail"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// ValidationRule is a single rule of a `validate` struct tag.
+type ValidationRule struct {
+	Name  string // e.g. "max"
+	Param string // e.g. "64"; empty for rules without a parameter
+}
+
+// Validator can be implemented by request types for checks that struct tags
+// cannot express. Validate runs after the tag rules have passed.
+type Validator interface {
+	Validate() error
+}
+
+// ParseValidationTag parses a `validate` struct tag such as
+// "required,min=1,max=64,email,oneof=a b" into its rules.
+func ParseValidationTag(tag string) []ValidationRule {
+	var rules []ValidationRule
+	for _, part := range strings.Split(tag, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, param, _ := strings.Cut(part, "=")
+		rules = append(rules, ValidationRule{Name: name, Param: param})
+	}
+	return rules
+}
+
+// ValidationMiddleware creates a MiddlewareFunc that validates decoded
+// requests against their `validate` struct tags before calling the handler.
+// Supported rules are required, omitempty, min, max, len, email and oneof;
+// min, max and len count characters for strings, elements for slices and
+// maps, and compare the value for numbers. Nested structs and slices of
+// structs are validated as well.
+//
+// Invalid requests fail with InvalidArgument and BadRequest details.
+//
+// Usage:
+//
+//	type CreateUserRequest struct {
+//	    Name  string `json:"name" validate:"required,max=64"`
+//	    Email string `json:"email" validate:"required,email"`
+//	    Role  string `json:"role,omitempty" validate:"omitempty,oneof=admin member"`
+//	}
+//
+//	server := talk.NewServer(transport, talk.WithServerMiddleware(talk.ValidationMiddleware()))
+func ValidationMiddleware() MiddlewareFunc {
+	return func(next EndpointFunc) EndpointFunc {
+		return func(ctx context.Context, req any) (any, error) {
+			if err := Validate(req); err != nil {
+				return nil, err
+			}
+			return next(ctx, req)
+		}
+	}
+}
+
+// Validate checks v against its `validate` struct tags and, if v implements
+// Validator, its Validate method. It returns nil for valid values and for
+// values that are not structs.
+func Validate(v any) error {
+	if v == nil {
+		return nil
+	}
+
+	var violations []FieldViolation
+	if err := validateValue(reflect.ValueOf(v), "", &violations); err != nil {
+		return err
+	}
+	if len(violations) > 0 {
+		br := BadRequest{FieldViolations: violations}
+		return NewErrorWithTypedDetails(InvalidArgument, br.Error(), br)
+	}
+
+	if val, ok := asValidator(v); ok {
+		if err := val.Validate(); err != nil {
+			if e, ok := err.(*Error); ok {
+				return e
+			}
+			return NewError(InvalidArgument, err.Error())
+		}
+	}
+	return nil
+}
+
+// asValidator returns v as a Validator, also when only *T implements it.
+func asValidator(v any) (Validator, bool) {
+	if val, ok := v.(Validator); ok {
+		return val, true
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr {
+		return nil, false
+	}
+	ptr := reflect.New(rv.Type())
+	ptr.Elem().Set(rv)
+	val, ok := ptr.Interface().(Validator)
+	return val, ok
+}
+
+type fieldRules struct {
+	index     int
+	name      string
+	rules     []ValidationRule
+	omitempty bool
+}
+
+var validationCache sync.Map // reflect.Type -> []fieldRules
+
+func structRules(t reflect.Type) ([]fieldRules, error) {
+	if cached, ok := validationCache.Load(t); ok {
+		return cached.([]fieldRules), nil
+	}
+
+	var fields []fieldRules
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+
+		name := f.Name
+		if tag := f.Tag.Get("json"); tag != "" {
+			tagName, _, _ := strings.Cut(tag, ",")
+			if tagName == "-" {
+				continue
+			}
+			if tagName != "" {
+				name = tagName
+			}
+		}
+
+		fr := fieldRules{index: i, name: name}
+		for _, r := range ParseValidationTag(f.Tag.Get("validate")) {
+			switch r.Name {
+			case "omitempty":
+				fr.omitempty = true
+			case "required", "email":
+			case "min", "max", "len":
+				if _, err := strconv.ParseFloat(r.Param, 64); err != nil {
+					return nil, NewErrorf(Internal, "invalid validate tag on %s.%s: %s=%q", t.Name(), f.Name, r.Name, r.Param)
+				}
+			case "oneof":
+				if r.Param == "" {
+					return nil, NewErrorf(Internal, "invalid validate tag on %s.%s: empty oneof", t.Name(), f.Name)
+				}
+			default:
+				return nil, NewErrorf(Internal, "unknown validation rule %q on %s.%s", r.Name, t.Name(), f.Name)
+			}
+			if r.Name != "omitempty" {
+				fr.rules = append(fr.rules, r)
+			}
+		}
+		fields = append(fields, fr)
+	}
+
+	validationCache.Store(t, fields)
+	return fields, nil
+}
+
+func validateValue(v reflect.Value, path string, violations *[]FieldViolation) error {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		fields, err := structRules(v.Type())
+		if err != nil {
+			return err
+		}
+		for _, fr := range fields {
+			fv := v.Field(fr.index)
+			fieldPath := fr.name
+			if path != "" {
+				fieldPath = path + "." + fr.name
+			}
+
+			if fr.omitempty && fv.IsZero() {
+				continue
+			}
+			for _, r := range fr.rules {
+				if desc, ok := checkRule(fv, r); !ok {
+					*violations = append(*violations, FieldViolation{Field: fieldPath, Rule: r.Name, Description: desc})
+					break
+				}
+			}
+			if err := validateValue(fv, fieldPath, violations); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		switch v.Type().Elem().Kind() {
+		case reflect.Struct, reflect.Ptr, reflect.Interface, reflect.Slice, reflect.Array:
+		default:
+			return nil // nothing to validate in scalar elements
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := validateValue(v.Index(i), fmt.Sprintf("%s[%d]", path, i), violations); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkRule reports whether v satisfies r, with a description if it does not.
+func checkRule(v reflect.Value, r ValidationRule) (string, bool) {
+	if r.Name != "required" && v.Kind() == reflect.Ptr && v.IsNil() {
+		return "", true
+	}
+	switch r.Name {
+	case "required":
+		return "is required", !v.IsZero()
+	case "email":
+		s := indirect(v)
+		if s.Kind() != reflect.String {
+			return "", true
+		}
+		addr, err := mail.ParseAddress(s.String())
+		return "must be a valid email address", err == nil && addr.Address == s.String()
+	case "oneof":
+		s := indirect(v)
+		str := fmt.Sprint(s.Interface())
+		for _, opt := range strings.Fields(r.Param) {
+			if str == opt {
+				return "", true
+			}
+		}
+		return "must be one of [" + r.Param + "]", false
+	case "min", "max", "len":
+		limit, _ := strconv.ParseFloat(r.Param, 64)
+		n, unit, ok := measure(indirect(v))
+		if !ok {
+			return "", true
+		}
+		switch r.Name {
+		case "min":
+			return "must be at least " + r.Param + unit, n >= limit
+		case "max":
+			return "must be at most " + r.Param + unit, n <= limit
+		default:
+			return "must be exactly " + r.Param + unit, n == limit
+		}
+	}
+	return "", true
+}
+
+// measure returns the size of v compared by min, max and len.
+func measure(v reflect.Value) (float64, string, bool) {
+	switch v.Kind() {
+	case reflect.String:
+		return float64(utf8.RuneCountInString(v.String())), " characters", true
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return float64(v.Len()), " items", true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), "", true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), "", true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), "", true
+	}
+	return 0, "", false
+}
+
+func indirect(v reflect.Value) reflect.Value {
+	for v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	return v
+}
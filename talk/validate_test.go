@@ -0,0 +1,168 @@
+package talk
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+type validateAddress struct {
+	City string `json:"city" validate:"required"`
+}
+
+type validateRequest struct {
+	Name    string            `json:"name" validate:"required,min=1,max=8"`
+	Email   string            `json:"email,omitempty" validate:"omitempty,email"`
+	Role    string            `json:"role" validate:"oneof=admin member"`
+	Age     int               `json:"age" validate:"min=18"`
+	Tags    []string          `json:"tags" validate:"max=2"`
+	Nick    *string           `json:"nick" validate:"min=2"`
+	Address *validateAddress  `json:"address"`
+	Items   []validateAddress `json:"items"`
+	Ignored string            `json:"-" validate:"required"`
+}
+
+func validViolations(t *testing.T, err error) []FieldViolation {
+	t.Helper()
+	e := ToError(err)
+	if e == nil || e.Code != InvalidArgument {
+		t.Fatalf("error = %v, want InvalidArgument", err)
+	}
+	var br BadRequest
+	if !errors.As(err, &br) {
+		t.Fatalf("details = %v, want BadRequest", e.TypedDetails)
+	}
+	return br.FieldViolations
+}
+
+func TestValidate_Valid(t *testing.T) {
+	req := validateRequest{
+		Name:    "alice",
+		Email:   "alice@example.com",
+		Role:    "admin",
+		Age:     30,
+		Tags:    []string{"a"},
+		Address: &validateAddress{City: "Paris"},
+	}
+	if err := Validate(req); err != nil {
+		t.Errorf("Validate = %v, want nil", err)
+	}
+	if err := Validate(&req); err != nil {
+		t.Errorf("Validate(ptr) = %v, want nil", err)
+	}
+	if err := Validate("not a struct"); err != nil {
+		t.Errorf("Validate(string) = %v, want nil", err)
+	}
+}
+
+func TestValidate_Violations(t *testing.T) {
+	nick := "x"
+	req := validateRequest{
+		Name:    "much-too-long",
+		Email:   "not-an-email",
+		Role:    "guest",
+		Age:     12,
+		Tags:    []string{"a", "b", "c"},
+		Nick:    &nick,
+		Address: &validateAddress{},
+		Items:   []validateAddress{{City: "Rome"}, {}},
+	}
+
+	got := map[string]string{}
+	for _, fv := range validViolations(t, Validate(req)) {
+		got[fv.Field] = fv.Rule
+	}
+
+	want := map[string]string{
+		"name":          "max",
+		"email":         "email",
+		"role":          "oneof",
+		"age":           "min",
+		"tags":          "max",
+		"nick":          "min",
+		"address.city":  "required",
+		"items[1].city": "required",
+	}
+	if len(got) != len(want) {
+		t.Errorf("violations = %v, want %v", got, want)
+	}
+	for field, rule := range want {
+		if got[field] != rule {
+			t.Errorf("violation %q = %q, want %q", field, got[field], rule)
+		}
+	}
+}
+
+func TestValidate_Required(t *testing.T) {
+	violations := validViolations(t, Validate(validateRequest{Role: "admin", Age: 20}))
+	if len(violations) != 1 || violations[0].Field != "name" || violations[0].Rule != "required" {
+		t.Errorf("violations = %+v, want only name required", violations)
+	}
+}
+
+func TestValidate_UnknownRule(t *testing.T) {
+	type badRequest struct {
+		ID string `validate:"uuid"`
+	}
+	if err := Validate(badRequest{}); ToError(err).Code != Internal {
+		t.Errorf("error = %v, want Internal", err)
+	}
+}
+
+type selfValidating struct {
+	From int `json:"from"`
+	To   int `json:"to"`
+}
+
+func (r *selfValidating) Validate() error {
+	if r.From > r.To {
+		return errors.New("from must not exceed to")
+	}
+	return nil
+}
+
+func TestValidate_Validator(t *testing.T) {
+	err := Validate(selfValidating{From: 2, To: 1})
+	if e := ToError(err); e == nil || e.Code != InvalidArgument || !strings.Contains(e.Message, "from must not exceed to") {
+		t.Errorf("error = %v, want InvalidArgument from Validate", err)
+	}
+	if err := Validate(selfValidating{From: 1, To: 2}); err != nil {
+		t.Errorf("error = %v, want nil", err)
+	}
+}
+
+func TestValidationMiddleware(t *testing.T) {
+	called := false
+	handler := ValidationMiddleware()(func(ctx context.Context, req any) (any, error) {
+		called = true
+		return "ok", nil
+	})
+
+	if _, err := handler(context.Background(), validateRequest{Role: "admin", Age: 20}); err == nil {
+		t.Fatal("expected validation error")
+	}
+	if called {
+		t.Error("handler called for invalid request")
+	}
+
+	if _, err := handler(context.Background(), validateRequest{Name: "bob", Role: "member", Age: 20}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !called {
+		t.Error("handler not called for valid request")
+	}
+}
+
+func TestParseValidationTag(t *testing.T) {
+	rules := ParseValidationTag("required, max=64,oneof=a b")
+	want := []ValidationRule{{"required", ""}, {"max", "64"}, {"oneof", "a b"}}
+	if len(rules) != len(want) {
+		t.Fatalf("rules = %v, want %v", rules, want)
+	}
+	for i := range want {
+		if rules[i] != want[i] {
+			t.Errorf("rules[%d] = %v, want %v", i, rules[i], want[i])
+		}
+	}
+}